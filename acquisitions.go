@@ -0,0 +1,836 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrAcquisitionNotFound is returned for unknown vendors, funds, purchase
+// orders and books.
+var ErrAcquisitionNotFound = errors.New("acquisition record not found")
+
+// ErrInvalidAcquisition is returned for malformed vendors, funds, orders
+// and receipts.
+var ErrInvalidAcquisition = errors.New("invalid acquisition")
+
+// ErrOrderState is returned when a purchase order cannot be changed in its
+// current state, such as receiving against a draft.
+var ErrOrderState = errors.New("purchase order cannot be changed in its current state")
+
+// ErrOverBudget is returned when placing an order would spend more than
+// the remaining budget of its fund.
+var ErrOverBudget = errors.New("fund budget exceeded")
+
+// Vendor is a supplier books are ordered from. PublisherID links vendors
+// that are publishers themselves; books first received from such a vendor
+// are added to the catalogue under that publisher.
+type Vendor struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	TenantID    uint      `gorm:"index;not null;default:0" json:"-"`
+	Name        string    `gorm:"size:200;not null" json:"name"`
+	PublisherID *uint     `gorm:"index" json:"publisher_id,omitempty"`
+	Email       string    `gorm:"size:254" json:"email,omitempty"`
+	Address     string    `gorm:"type:text" json:"address,omitempty"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// Fund is a budget purchase orders are charged to, such as "Children's
+// books 2026". Amounts are in cents.
+type Fund struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	TenantID    uint      `gorm:"uniqueIndex:idx_funds_tenant_code;not null;default:0" json:"-"`
+	Code        string    `gorm:"uniqueIndex:idx_funds_tenant_code;size:20;not null" json:"code"`
+	Name        string    `gorm:"size:200;not null" json:"name"`
+	BudgetCents int64     `gorm:"not null;default:0" json:"budget_cents"`
+	CreatedAt   time.Time `gorm:"autoCreateTime" json:"created_at"`
+}
+
+// FundSummary is a fund with its committed and spent amounts. Committed
+// is the cost of copies ordered but not yet received; spent is the cost
+// of copies received.
+type FundSummary struct {
+	Fund
+	CommittedCents int64 `json:"committed_cents"`
+	SpentCents     int64 `json:"spent_cents"`
+	RemainingCents int64 `json:"remaining_cents"`
+}
+
+// PurchaseOrderStatus is the state of a purchase order.
+type PurchaseOrderStatus string
+
+// Purchase order states. Orders are drafted, placed with the vendor and
+// then received, possibly over several shipments. Orders that are not
+// fully received can be cancelled.
+const (
+	OrderDraft             PurchaseOrderStatus = "draft"
+	OrderPlaced            PurchaseOrderStatus = "ordered"
+	OrderPartiallyReceived PurchaseOrderStatus = "partially_received"
+	OrderReceived          PurchaseOrderStatus = "received"
+	OrderCancelled         PurchaseOrderStatus = "cancelled"
+)
+
+// PurchaseOrder is an order of books from a vendor, charged to a fund.
+type PurchaseOrder struct {
+	ID        uint                `gorm:"primaryKey" json:"id"`
+	TenantID  uint                `gorm:"index;not null;default:0" json:"-"`
+	VendorID  uint                `gorm:"index;not null" json:"vendor_id"`
+	FundID    uint                `gorm:"index;not null" json:"fund_id"`
+	Status    PurchaseOrderStatus `gorm:"type:varchar(20);not null;default:'draft'" json:"status"`
+	Reference string              `gorm:"size:100" json:"reference,omitempty"`
+	CreatedBy uint                `json:"created_by"`
+	CreatedAt time.Time           `gorm:"autoCreateTime" json:"created_at"`
+	OrderedAt *time.Time          `json:"ordered_at,omitempty"`
+	Lines     []PurchaseOrderLine `gorm:"foreignKey:OrderID;constraint:OnDelete:CASCADE" json:"lines"`
+}
+
+// PurchaseOrderLine is the quantity of one ISBN on an order. Title names
+// books that are not yet in the catalogue.
+type PurchaseOrderLine struct {
+	ID            uint   `gorm:"primaryKey" json:"id"`
+	TenantID      uint   `gorm:"index;not null;default:0" json:"-"`
+	OrderID       uint   `gorm:"uniqueIndex:idx_order_line_isbn;not null" json:"-"`
+	ISBN          string `gorm:"uniqueIndex:idx_order_line_isbn;size:13;not null" json:"isbn"`
+	Title         string `gorm:"size:200" json:"title,omitempty"`
+	Quantity      int    `gorm:"not null" json:"quantity"`
+	Received      int    `gorm:"not null;default:0" json:"received"`
+	UnitCostCents int64  `gorm:"not null;default:0" json:"unit_cost_cents"`
+}
+
+// PurchaseReceipt records copies of an order line received in a shipment,
+// and is the cost history of the book.
+type PurchaseReceipt struct {
+	ID            uint      `gorm:"primaryKey" json:"id"`
+	TenantID      uint      `gorm:"index;not null;default:0" json:"-"`
+	OrderID       uint      `gorm:"index;not null" json:"order_id"`
+	LineID        uint      `gorm:"index;not null" json:"line_id"`
+	BookID        uint      `gorm:"index;not null" json:"book_id"`
+	BranchID      uint      `json:"branch_id,omitempty"`
+	Quantity      int       `gorm:"not null" json:"quantity"`
+	UnitCostCents int64     `gorm:"not null" json:"unit_cost_cents"`
+	ReceivedBy    uint      `json:"received_by"`
+	ReceivedAt    time.Time `gorm:"autoCreateTime" json:"received_at"`
+}
+
+// ReceiptItem is the quantity of an order line in a shipment.
+type ReceiptItem struct {
+	LineID   uint `json:"line_id"`
+	Quantity int  `json:"quantity"`
+}
+
+// BookCost is the acquisition cost of a book's copies.
+type BookCost struct {
+	BookID           uint              `json:"book_id"`
+	Acquired         int               `json:"acquired"`
+	TotalCents       int64             `json:"total_cents"`
+	AverageUnitCents int64             `json:"average_unit_cents"`
+	Receipts         []PurchaseReceipt `json:"receipts"`
+}
+
+// AcquisitionService manages vendors, funds and purchase orders, and adds
+// received copies to the catalogue. All methods require
+// PermManageAcquisitions.
+type AcquisitionService struct {
+	db           *gorm.DB
+	queryTimeout time.Duration
+	cache        *bookCache
+}
+
+// CreateVendor adds a vendor, checking that its publisher exists.
+func (s *AcquisitionService) CreateVendor(ctx context.Context, vendor *Vendor) (err error) {
+	defer observeOperation("create_vendor", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageAcquisitions); err != nil {
+		return err
+	}
+	if vendor.Name == "" {
+		return fmt.Errorf("%w: vendor name is required", ErrInvalidAcquisition)
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	db := s.db.WithContext(ctx)
+	if vendor.PublisherID != nil {
+		if err := db.Select("id").First(&Publisher{}, *vendor.PublisherID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("%w: unknown publisher %d", ErrInvalidAcquisition, *vendor.PublisherID)
+			}
+			return fmt.Errorf("error finding publisher: %w", err)
+		}
+	}
+	if err := db.Create(vendor).Error; err != nil {
+		return fmt.Errorf("failed to create vendor: %w", err)
+	}
+	return nil
+}
+
+// ListVendors returns all vendors by name.
+func (s *AcquisitionService) ListVendors(ctx context.Context) (_ []Vendor, err error) {
+	defer observeOperation("list_vendors", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageAcquisitions); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var vendors []Vendor
+	if err := s.db.WithContext(ctx).Order("name, id").Find(&vendors).Error; err != nil {
+		return nil, fmt.Errorf("failed to list vendors: %w", err)
+	}
+	return vendors, nil
+}
+
+// CreateFund adds a fund. Fund codes are unique.
+func (s *AcquisitionService) CreateFund(ctx context.Context, fund *Fund) (err error) {
+	defer observeOperation("create_fund", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageAcquisitions); err != nil {
+		return err
+	}
+	if fund.Code == "" || len(fund.Code) > 20 || fund.Name == "" {
+		return fmt.Errorf("%w: fund code of up to 20 characters and name are required", ErrInvalidAcquisition)
+	}
+	if fund.BudgetCents < 0 {
+		return fmt.Errorf("%w: budget cannot be negative", ErrInvalidAcquisition)
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	db := s.db.WithContext(ctx)
+	var existing int64
+	if err := db.Model(&Fund{}).Where("code = ?", fund.Code).Count(&existing).Error; err != nil {
+		return fmt.Errorf("error finding fund: %w", err)
+	}
+	if existing > 0 {
+		return fmt.Errorf("%w: fund %s already exists", ErrInvalidAcquisition, fund.Code)
+	}
+	if err := db.Create(fund).Error; err != nil {
+		return fmt.Errorf("failed to create fund: %w", err)
+	}
+	return nil
+}
+
+// FundSummaries returns all funds by code with their committed, spent and
+// remaining amounts.
+func (s *AcquisitionService) FundSummaries(ctx context.Context) (_ []FundSummary, err error) {
+	defer observeOperation("fund_summaries", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageAcquisitions); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	db := s.db.WithContext(ctx)
+	var funds []Fund
+	if err := db.Order("code").Find(&funds).Error; err != nil {
+		return nil, fmt.Errorf("failed to list funds: %w", err)
+	}
+	summaries := make([]FundSummary, len(funds))
+	for i, fund := range funds {
+		committed, spent, err := fundUsage(db, fund.ID)
+		if err != nil {
+			return nil, err
+		}
+		summaries[i] = FundSummary{
+			Fund:           fund,
+			CommittedCents: committed,
+			SpentCents:     spent,
+			RemainingCents: fund.BudgetCents - committed - spent,
+		}
+	}
+	return summaries, nil
+}
+
+// fundUsage returns the committed and spent amounts of a fund.
+func fundUsage(db *gorm.DB, fundID uint) (committed, spent int64, err error) {
+	if err := db.Model(&PurchaseOrderLine{}).
+		Joins("JOIN purchase_orders ON purchase_orders.id = purchase_order_lines.order_id").
+		Where("purchase_orders.fund_id = ? AND purchase_orders.status IN ?", fundID, []PurchaseOrderStatus{OrderPlaced, OrderPartiallyReceived}).
+		Select("COALESCE(SUM((purchase_order_lines.quantity - purchase_order_lines.received) * purchase_order_lines.unit_cost_cents), 0)::bigint").
+		Scan(&committed).Error; err != nil {
+		return 0, 0, fmt.Errorf("failed to sum committed amounts: %w", err)
+	}
+	if err := db.Model(&PurchaseReceipt{}).
+		Joins("JOIN purchase_orders ON purchase_orders.id = purchase_receipts.order_id").
+		Where("purchase_orders.fund_id = ?", fundID).
+		Select("COALESCE(SUM(purchase_receipts.quantity * purchase_receipts.unit_cost_cents), 0)::bigint").
+		Scan(&spent).Error; err != nil {
+		return 0, 0, fmt.Errorf("failed to sum spent amounts: %w", err)
+	}
+	return committed, spent, nil
+}
+
+// validateOrderLines normalizes the ISBNs of lines and checks that each
+// orders a positive quantity of a distinct 13-digit ISBN at a non-negative
+// cost.
+func validateOrderLines(lines []PurchaseOrderLine) error {
+	if len(lines) == 0 {
+		return fmt.Errorf("%w: an order needs at least one line", ErrInvalidAcquisition)
+	}
+	seen := make(map[string]bool, len(lines))
+	for i := range lines {
+		line := &lines[i]
+		isbn, err := parseISBN(line.ISBN)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidAcquisition, err)
+		}
+		line.ISBN = isbn
+		if seen[line.ISBN] {
+			return fmt.Errorf("%w: ISBN %s is ordered twice", ErrInvalidAcquisition, line.ISBN)
+		}
+		seen[line.ISBN] = true
+		if line.Quantity <= 0 {
+			return fmt.Errorf("%w: quantity of %s must be positive", ErrInvalidAcquisition, line.ISBN)
+		}
+		if line.UnitCostCents < 0 {
+			return fmt.Errorf("%w: cost of %s cannot be negative", ErrInvalidAcquisition, line.ISBN)
+		}
+	}
+	return nil
+}
+
+// orderTotal returns the cost of all copies on an order.
+func orderTotal(lines []PurchaseOrderLine) int64 {
+	var total int64
+	for _, line := range lines {
+		total += int64(line.Quantity) * line.UnitCostCents
+	}
+	return total
+}
+
+// CreateOrder drafts a purchase order. Drafts do not count against the
+// budget of their fund until they are placed.
+func (s *AcquisitionService) CreateOrder(ctx context.Context, order *PurchaseOrder) (err error) {
+	defer observeOperation("create_purchase_order", time.Now(), &err)
+	u, err := authorize(ctx, PermManageAcquisitions)
+	if err != nil {
+		return err
+	}
+	if err := validateOrderLines(order.Lines); err != nil {
+		return err
+	}
+	for i := range order.Lines {
+		order.Lines[i].ID, order.Lines[i].Received = 0, 0
+	}
+	order.ID, order.Status, order.CreatedBy, order.OrderedAt = 0, OrderDraft, u.ID, nil
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	db := s.db.WithContext(ctx)
+	if err := db.Select("id").First(&Vendor{}, order.VendorID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: unknown vendor %d", ErrInvalidAcquisition, order.VendorID)
+		}
+		return fmt.Errorf("error finding vendor: %w", err)
+	}
+	if err := db.Select("id").First(&Fund{}, order.FundID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: unknown fund %d", ErrInvalidAcquisition, order.FundID)
+		}
+		return fmt.Errorf("error finding fund: %w", err)
+	}
+	if err := db.Create(order).Error; err != nil {
+		return fmt.Errorf("failed to create purchase order: %w", err)
+	}
+	return nil
+}
+
+// GetOrder returns the purchase order with the given ID and its lines.
+func (s *AcquisitionService) GetOrder(ctx context.Context, id uint) (_ *PurchaseOrder, err error) {
+	defer observeOperation("get_purchase_order", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageAcquisitions); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+	return findPurchaseOrder(s.db.WithContext(ctx), id, "")
+}
+
+// ListOrders returns purchase orders, newest first, optionally only those
+// in the given status.
+func (s *AcquisitionService) ListOrders(ctx context.Context, status PurchaseOrderStatus) (_ []PurchaseOrder, err error) {
+	defer observeOperation("list_purchase_orders", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageAcquisitions); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	db := s.db.WithContext(ctx).Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") })
+	if status != "" {
+		db = db.Where("status = ?", status)
+	}
+	var orders []PurchaseOrder
+	if err := db.Order("id DESC").Find(&orders).Error; err != nil {
+		return nil, fmt.Errorf("failed to list purchase orders: %w", err)
+	}
+	return orders, nil
+}
+
+// PlaceOrder sends a draft to its vendor, committing its total to the
+// fund. It fails with ErrOverBudget when the fund cannot cover it.
+func (s *AcquisitionService) PlaceOrder(ctx context.Context, id uint) (_ *PurchaseOrder, err error) {
+	defer observeOperation("place_purchase_order", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageAcquisitions); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var order *PurchaseOrder
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if order, err = findPurchaseOrder(tx, id, "UPDATE"); err != nil {
+			return err
+		}
+		if order.Status != OrderDraft {
+			return fmt.Errorf("%w: order %d is %s", ErrOrderState, id, order.Status)
+		}
+		// Locking the fund serializes orders placed against it, so two
+		// orders cannot both fit into the same remaining budget.
+		var fund Fund
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&fund, order.FundID).Error; err != nil {
+			return fmt.Errorf("error finding fund: %w", err)
+		}
+		committed, spent, err := fundUsage(tx, fund.ID)
+		if err != nil {
+			return err
+		}
+		total, remaining := orderTotal(order.Lines), fund.BudgetCents-committed-spent
+		if total > remaining {
+			return fmt.Errorf("%w: order costs %d cents, fund %s has %d left", ErrOverBudget, total, fund.Code, remaining)
+		}
+		now := time.Now()
+		order.Status, order.OrderedAt = OrderPlaced, &now
+		return tx.Model(order).Updates(map[string]interface{}{"status": OrderPlaced, "ordered_at": now}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// CancelOrder cancels an order that is not fully received, releasing the
+// budget committed to the copies still outstanding. Copies already
+// received stay in the catalogue and charged to the fund.
+func (s *AcquisitionService) CancelOrder(ctx context.Context, id uint) (_ *PurchaseOrder, err error) {
+	defer observeOperation("cancel_purchase_order", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageAcquisitions); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var order *PurchaseOrder
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if order, err = findPurchaseOrder(tx, id, "UPDATE"); err != nil {
+			return err
+		}
+		if order.Status == OrderReceived || order.Status == OrderCancelled {
+			return fmt.Errorf("%w: order %d is %s", ErrOrderState, id, order.Status)
+		}
+		order.Status = OrderCancelled
+		return tx.Model(order).Update("status", OrderCancelled).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return order, nil
+}
+
+// ReceiveShipment records copies received against a placed order. The
+// copies are added to the stock of their books, and of the branch unless
+// branchID is zero. Books not yet in the catalogue are added under the
+// vendor's publisher, so they can only be received from vendors linked
+// to one. The order is received once every line is complete.
+func (s *AcquisitionService) ReceiveShipment(ctx context.Context, id, branchID uint, items []ReceiptItem) (_ *PurchaseOrder, err error) {
+	defer observeOperation("receive_shipment", time.Now(), &err)
+	u, err := authorize(ctx, PermManageAcquisitions)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("%w: a shipment needs at least one item", ErrInvalidAcquisition)
+	}
+	quantities := make(map[uint]int, len(items))
+	for _, item := range items {
+		if item.Quantity <= 0 {
+			return nil, fmt.Errorf("%w: quantity of line %d must be positive", ErrInvalidAcquisition, item.LineID)
+		}
+		if _, ok := quantities[item.LineID]; ok {
+			return nil, fmt.Errorf("%w: line %d is listed twice", ErrInvalidAcquisition, item.LineID)
+		}
+		quantities[item.LineID] = item.Quantity
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var order *PurchaseOrder
+	var changed []string
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if order, err = findPurchaseOrder(tx, id, "UPDATE"); err != nil {
+			return err
+		}
+		if order.Status != OrderPlaced && order.Status != OrderPartiallyReceived {
+			return fmt.Errorf("%w: order %d is %s", ErrOrderState, id, order.Status)
+		}
+		if branchID != 0 {
+			if err := tx.Select("id").First(&Branch{}, branchID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					return fmt.Errorf("%w: unknown branch %d", ErrInvalidAcquisition, branchID)
+				}
+				return fmt.Errorf("error finding branch: %w", err)
+			}
+		}
+		var vendor Vendor
+		if err := tx.First(&vendor, order.VendorID).Error; err != nil {
+			return fmt.Errorf("error finding vendor: %w", err)
+		}
+
+		outstanding := make(map[uint]int, len(order.Lines))
+		for _, line := range order.Lines {
+			outstanding[line.ID] = line.Quantity - line.Received
+		}
+		for _, item := range items {
+			left, ok := outstanding[item.LineID]
+			if !ok {
+				return fmt.Errorf("%w: line %d is not on order %d", ErrInvalidAcquisition, item.LineID, id)
+			}
+			if item.Quantity > left {
+				return fmt.Errorf("%w: line %d has %d copies outstanding", ErrInvalidAcquisition, item.LineID, left)
+			}
+		}
+
+		complete := true
+		for i := range order.Lines {
+			line := &order.Lines[i]
+			if quantity, ok := quantities[line.ID]; ok {
+				if err := receiveLine(tx, order, line, &vendor, branchID, quantity, u.ID); err != nil {
+					return err
+				}
+				changed = append(changed, line.ISBN)
+			}
+			complete = complete && line.Received == line.Quantity
+		}
+
+		order.Status = OrderPartiallyReceived
+		if complete {
+			order.Status = OrderReceived
+		}
+		return tx.Model(order).Update("status", order.Status).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.cache.invalidate(ctx, changed...)
+	return order, nil
+}
+
+// receiveLine adds quantity copies of an order line to the catalogue and
+// records the receipt.
+func receiveLine(tx *gorm.DB, order *PurchaseOrder, line *PurchaseOrderLine, vendor *Vendor, branchID uint, quantity int, userID uint) error {
+	var book Book
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("isbn = ?", line.ISBN).First(&book).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if vendor.PublisherID == nil || line.Title == "" {
+			return fmt.Errorf("%w: %s is not in the catalogue; it needs a title and a vendor linked to a publisher", ErrInvalidAcquisition, line.ISBN)
+		}
+		book = Book{ISBN: line.ISBN, Title: line.Title, Copies: quantity, PublisherID: *vendor.PublisherID}
+		if err := tx.Create(&book).Error; err != nil {
+			return fmt.Errorf("failed to add book: %w", err)
+		}
+		if err := emitEvent(tx, EventBookAdded, book.ID, newBookEvent(&book)); err != nil {
+			return err
+		}
+	case err != nil:
+		return fmt.Errorf("error finding book: %w", err)
+	case book.Format != FormatPrint:
+		return fmt.Errorf("%w: %s is a digital book; add license pools instead", ErrInvalidAcquisition, line.ISBN)
+	default:
+		if err := tx.Model(&book).Clauses(clause.Returning{}).
+			UpdateColumns(map[string]interface{}{
+				"copies":    gorm.Expr("copies + ?", quantity),
+				"available": gorm.Expr("available + ?", quantity),
+			}).Error; err != nil {
+			return fmt.Errorf("failed to add copies: %w", err)
+		}
+		if err := emitEvent(tx, EventBookCopiesChanged, book.ID, newBookEvent(&book)); err != nil {
+			return err
+		}
+	}
+
+	if branchID != 0 {
+		inv := BranchInventory{BranchID: branchID, BookID: book.ID}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(BranchInventory{BranchID: branchID, BookID: book.ID}).
+			FirstOrCreate(&inv).Error; err != nil {
+			return fmt.Errorf("failed to load branch inventory: %w", err)
+		}
+		if err := tx.Model(&inv).Updates(map[string]interface{}{
+			"copies":    gorm.Expr("copies + ?", quantity),
+			"available": gorm.Expr("available + ?", quantity),
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update branch inventory: %w", err)
+		}
+	}
+
+	receipt := &PurchaseReceipt{
+		OrderID:       order.ID,
+		LineID:        line.ID,
+		BookID:        book.ID,
+		BranchID:      branchID,
+		Quantity:      quantity,
+		UnitCostCents: line.UnitCostCents,
+		ReceivedBy:    userID,
+	}
+	if err := tx.Create(receipt).Error; err != nil {
+		return fmt.Errorf("failed to record receipt: %w", err)
+	}
+	line.Received += quantity
+	return tx.Model(line).UpdateColumn("received", line.Received).Error
+}
+
+// BookCosts returns the acquisition cost of a book: the copies received,
+// their total and average unit cost and the receipts, oldest first.
+func (s *AcquisitionService) BookCosts(ctx context.Context, bookID uint) (_ *BookCost, err error) {
+	defer observeOperation("book_costs", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageAcquisitions); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	db := s.db.WithContext(ctx)
+	if err := db.Select("id").First(&Book{}, bookID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: book %d", ErrAcquisitionNotFound, bookID)
+		}
+		return nil, fmt.Errorf("error finding book: %w", err)
+	}
+	cost := &BookCost{BookID: bookID}
+	if err := db.Where("book_id = ?", bookID).Order("received_at, id").Find(&cost.Receipts).Error; err != nil {
+		return nil, fmt.Errorf("failed to list receipts: %w", err)
+	}
+	for _, r := range cost.Receipts {
+		cost.Acquired += r.Quantity
+		cost.TotalCents += int64(r.Quantity) * r.UnitCostCents
+	}
+	if cost.Acquired > 0 {
+		cost.AverageUnitCents = cost.TotalCents / int64(cost.Acquired)
+	}
+	return cost, nil
+}
+
+// findPurchaseOrder loads an order with its lines, locking the order with
+// the given strength unless lock is empty.
+func findPurchaseOrder(db *gorm.DB, id uint, lock string) (*PurchaseOrder, error) {
+	if lock != "" {
+		db = db.Clauses(clause.Locking{Strength: lock})
+	}
+	var order PurchaseOrder
+	err := db.Preload("Lines", func(db *gorm.DB) *gorm.DB { return db.Order("id") }).First(&order, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: purchase order %d", ErrAcquisitionNotFound, id)
+		}
+		return nil, fmt.Errorf("error finding purchase order: %w", err)
+	}
+	return &order, nil
+}
+
+// writeAcquisitionError maps acquisition errors to HTTP statuses.
+func writeAcquisitionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrAcquisitionNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrOrderState), errors.Is(err, ErrOverBudget):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrInvalidAcquisition):
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	default:
+		writeError(w, err)
+	}
+}
+
+// acquisitionID parses the {id} path value, writing 400 when it is
+// invalid.
+func acquisitionID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// handleCreateVendor serves POST /vendors.
+func (s *apiServer) handleCreateVendor(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name        string `json:"name"`
+		PublisherID *uint  `json:"publisher_id"`
+		Email       string `json:"email"`
+		Address     string `json:"address"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid vendor"})
+		return
+	}
+	vendor := &Vendor{Name: req.Name, PublisherID: req.PublisherID, Email: req.Email, Address: req.Address}
+	if err := s.acquisitions.CreateVendor(r.Context(), vendor); err != nil {
+		writeAcquisitionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, vendor)
+}
+
+// handleListVendors serves GET /vendors.
+func (s *apiServer) handleListVendors(w http.ResponseWriter, r *http.Request) {
+	vendors, err := s.acquisitions.ListVendors(r.Context())
+	if err != nil {
+		writeAcquisitionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, vendors)
+}
+
+// handleCreateFund serves POST /funds.
+func (s *apiServer) handleCreateFund(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code        string `json:"code"`
+		Name        string `json:"name"`
+		BudgetCents int64  `json:"budget_cents"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid fund"})
+		return
+	}
+	fund := &Fund{Code: req.Code, Name: req.Name, BudgetCents: req.BudgetCents}
+	if err := s.acquisitions.CreateFund(r.Context(), fund); err != nil {
+		writeAcquisitionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, fund)
+}
+
+// handleListFunds serves GET /funds.
+func (s *apiServer) handleListFunds(w http.ResponseWriter, r *http.Request) {
+	funds, err := s.acquisitions.FundSummaries(r.Context())
+	if err != nil {
+		writeAcquisitionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, funds)
+}
+
+// handleCreatePurchaseOrder serves POST /purchase-orders.
+func (s *apiServer) handleCreatePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		VendorID  uint                `json:"vendor_id"`
+		FundID    uint                `json:"fund_id"`
+		Reference string              `json:"reference"`
+		Lines     []PurchaseOrderLine `json:"lines"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid purchase order"})
+		return
+	}
+	order := &PurchaseOrder{VendorID: req.VendorID, FundID: req.FundID, Reference: req.Reference, Lines: req.Lines}
+	if err := s.acquisitions.CreateOrder(r.Context(), order); err != nil {
+		writeAcquisitionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, order)
+}
+
+// handleListPurchaseOrders serves GET /purchase-orders?status=.
+func (s *apiServer) handleListPurchaseOrders(w http.ResponseWriter, r *http.Request) {
+	orders, err := s.acquisitions.ListOrders(r.Context(), PurchaseOrderStatus(r.URL.Query().Get("status")))
+	if err != nil {
+		writeAcquisitionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, orders)
+}
+
+// handleGetPurchaseOrder serves GET /purchase-orders/{id}.
+func (s *apiServer) handleGetPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, ok := acquisitionID(w, r)
+	if !ok {
+		return
+	}
+	order, err := s.acquisitions.GetOrder(r.Context(), id)
+	if err != nil {
+		writeAcquisitionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, order)
+}
+
+// handlePlacePurchaseOrder serves POST /purchase-orders/{id}/place.
+func (s *apiServer) handlePlacePurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, ok := acquisitionID(w, r)
+	if !ok {
+		return
+	}
+	order, err := s.acquisitions.PlaceOrder(r.Context(), id)
+	if err != nil {
+		writeAcquisitionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, order)
+}
+
+// handleCancelPurchaseOrder serves POST /purchase-orders/{id}/cancel.
+func (s *apiServer) handleCancelPurchaseOrder(w http.ResponseWriter, r *http.Request) {
+	id, ok := acquisitionID(w, r)
+	if !ok {
+		return
+	}
+	order, err := s.acquisitions.CancelOrder(r.Context(), id)
+	if err != nil {
+		writeAcquisitionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, order)
+}
+
+// handleReceiveShipment serves POST /purchase-orders/{id}/receipts.
+func (s *apiServer) handleReceiveShipment(w http.ResponseWriter, r *http.Request) {
+	id, ok := acquisitionID(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		BranchID uint          `json:"branch_id"`
+		Items    []ReceiptItem `json:"items"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid shipment"})
+		return
+	}
+	order, err := s.acquisitions.ReceiveShipment(r.Context(), id, req.BranchID, req.Items)
+	if err != nil {
+		writeAcquisitionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, order)
+}
+
+// handleBookCosts serves GET /books/{id}/costs.
+func (s *apiServer) handleBookCosts(w http.ResponseWriter, r *http.Request) {
+	id, ok := acquisitionID(w, r)
+	if !ok {
+		return
+	}
+	cost, err := s.acquisitions.BookCosts(r.Context(), id)
+	if err != nil {
+		writeAcquisitionError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, cost)
+}
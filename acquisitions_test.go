@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestValidateOrderLines tests the checks made on order lines and the
+// normalization of their ISBNs.
+func TestValidateOrderLines(t *testing.T) {
+	lines := []PurchaseOrderLine{{ISBN: "978-0-306-40615-7", Quantity: 2, UnitCostCents: 1250}}
+	if err := validateOrderLines(lines); err != nil || lines[0].ISBN != "9780306406157" {
+		t.Errorf("expected a valid, normalized line, got %q, %v", lines[0].ISBN, err)
+	}
+	if total := orderTotal([]PurchaseOrderLine{{Quantity: 2, UnitCostCents: 1250}, {Quantity: 3, UnitCostCents: 999}}); total != 5497 {
+		t.Errorf("orderTotal = %d, want 5497", total)
+	}
+
+	invalid := map[string][]PurchaseOrderLine{
+		"no lines":      nil,
+		"short ISBN":    {{ISBN: "978030640615", Quantity: 1}},
+		"letters":       {{ISBN: "978030640615X", Quantity: 1}},
+		"zero quantity": {{ISBN: "9780306406157"}},
+		"negative cost": {{ISBN: "9780306406157", Quantity: 1, UnitCostCents: -1}},
+		"duplicate":     {{ISBN: "9780306406157", Quantity: 1}, {ISBN: "978 0306406157", Quantity: 1}},
+	}
+	for name, lines := range invalid {
+		if err := validateOrderLines(lines); !errors.Is(err, ErrInvalidAcquisition) {
+			t.Errorf("%s: expected ErrInvalidAcquisition, got %v", name, err)
+		}
+	}
+}
+
+// TestReceiveShipment_Validation tests the checks made before a shipment
+// reaches the database.
+func TestReceiveShipment_Validation(t *testing.T) {
+	svc := &AcquisitionService{}
+	ctx := asRole(RoleLibrarian)
+	if _, err := svc.ReceiveShipment(asRole(RoleMember), 1, 0, []ReceiptItem{{LineID: 1, Quantity: 1}}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden for a member, got %v", err)
+	}
+	for name, items := range map[string][]ReceiptItem{
+		"no items":      nil,
+		"zero quantity": {{LineID: 1}},
+		"repeated line": {{LineID: 1, Quantity: 1}, {LineID: 1, Quantity: 2}},
+	} {
+		if _, err := svc.ReceiveShipment(ctx, 1, 0, items); !errors.Is(err, ErrInvalidAcquisition) {
+			t.Errorf("%s: expected ErrInvalidAcquisition, got %v", name, err)
+		}
+	}
+}
+
+// TestWriteAcquisitionError tests the mapping of acquisition errors to
+// HTTP statuses.
+func TestWriteAcquisitionError(t *testing.T) {
+	tests := map[error]int{
+		fmt.Errorf("%w: purchase order 7", ErrAcquisitionNotFound): http.StatusNotFound,
+		fmt.Errorf("%w: order 7 is draft", ErrOrderState):          http.StatusConflict,
+		fmt.Errorf("%w: fund BOOKS has 0 left", ErrOverBudget):     http.StatusConflict,
+		fmt.Errorf("%w: no lines", ErrInvalidAcquisition):          http.StatusUnprocessableEntity,
+		fmt.Errorf("%w: role member", ErrForbidden):                http.StatusForbidden,
+		errors.New("connection refused"):                           http.StatusInternalServerError,
+	}
+	for err, want := range tests {
+		rec := httptest.NewRecorder()
+		writeAcquisitionError(rec, err)
+		if rec.Code != want {
+			t.Errorf("writeAcquisitionError(%v) = %d, want %d", err, rec.Code, want)
+		}
+	}
+}
+
+// TestAcquisitions tests an order from drafting to full receipt: the fund
+// budget check, partial shipments adding copies to the catalogue and a
+// branch, new books created under the vendor's publisher, fund totals and
+// book costs.
+func TestAcquisitions(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenant := mustCreateTenant(t, &TenantService{db: db}, "acquisitions")
+	ctx := withTenant(asRole(RoleLibrarian), tenant.ID)
+	tdb := db.WithContext(ctx)
+	svc := &AcquisitionService{db: db}
+
+	publisher := mustCreatePublisher(t, tdb, &Publisher{})
+	vendor := &Vendor{Name: "Acme Books", PublisherID: &publisher.ID}
+	if err := svc.CreateVendor(ctx, vendor); err != nil {
+		t.Fatalf("CreateVendor: %v", err)
+	}
+	unknown := uint(999999)
+	if err := svc.CreateVendor(ctx, &Vendor{Name: "Nobody", PublisherID: &unknown}); !errors.Is(err, ErrInvalidAcquisition) {
+		t.Errorf("expected ErrInvalidAcquisition for an unknown publisher, got %v", err)
+	}
+	fund := &Fund{Code: "ADULT", Name: "Adult fiction", BudgetCents: 10000}
+	if err := svc.CreateFund(ctx, fund); err != nil {
+		t.Fatalf("CreateFund: %v", err)
+	}
+	if err := svc.CreateFund(ctx, &Fund{Code: "ADULT", Name: "Again"}); !errors.Is(err, ErrInvalidAcquisition) {
+		t.Errorf("expected ErrInvalidAcquisition for a duplicate fund code, got %v", err)
+	}
+
+	stocked := &Book{Copies: 1}
+	mustCreateBook(t, tdb, stocked)
+	newISBN := testISBN()
+	order := &PurchaseOrder{VendorID: vendor.ID, FundID: fund.ID, Lines: []PurchaseOrderLine{
+		{ISBN: stocked.ISBN, Quantity: 3, UnitCostCents: 1500},
+		{ISBN: newISBN, Title: "A New Arrival", Quantity: 2, UnitCostCents: 2000},
+	}}
+	if err := svc.CreateOrder(ctx, order); err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if _, err := svc.ReceiveShipment(ctx, order.ID, 0, []ReceiptItem{{LineID: order.Lines[0].ID, Quantity: 1}}); !errors.Is(err, ErrOrderState) {
+		t.Errorf("expected ErrOrderState when receiving a draft, got %v", err)
+	}
+
+	// A second order that does not fit in what is left of the budget.
+	greedy := &PurchaseOrder{VendorID: vendor.ID, FundID: fund.ID, Lines: []PurchaseOrderLine{{ISBN: testISBN(), Title: "Too Much", Quantity: 1, UnitCostCents: 2000}}}
+	if err := svc.CreateOrder(ctx, greedy); err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if _, err := svc.PlaceOrder(ctx, order.ID); err != nil {
+		t.Fatalf("PlaceOrder: %v", err)
+	}
+	if _, err := svc.PlaceOrder(ctx, greedy.ID); !errors.Is(err, ErrOverBudget) {
+		t.Errorf("expected ErrOverBudget, got %v", err)
+	}
+
+	branch := mustCreateBranch(t, tdb, "receiving")
+	placed, err := svc.ReceiveShipment(ctx, order.ID, branch.ID, []ReceiptItem{
+		{LineID: order.Lines[0].ID, Quantity: 2},
+		{LineID: order.Lines[1].ID, Quantity: 2},
+	})
+	if err != nil {
+		t.Fatalf("ReceiveShipment: %v", err)
+	}
+	if placed.Status != OrderPartiallyReceived {
+		t.Errorf("expected a partially received order, got %s", placed.Status)
+	}
+	var book Book
+	tdb.First(&book, stocked.ID)
+	if book.Copies != 3 || book.Available != 3 {
+		t.Errorf("expected 3 copies available after the shipment, got %d of %d", book.Available, book.Copies)
+	}
+	if copies, available := branchAvailable(t, tdb, branch.ID, stocked.ID); copies != 2 || available != 2 {
+		t.Errorf("expected 2 copies at the branch, got %d copies, %d available", copies, available)
+	}
+	var added Book
+	if err := tdb.Where("isbn = ?", newISBN).First(&added).Error; err != nil || added.Copies != 2 || added.Available != 2 || added.PublisherID != publisher.ID {
+		t.Errorf("expected the new book added with 2 copies, got %+v, %v", added, err)
+	}
+	var events int64
+	tdb.Model(&OutboxEvent{}).Where("type = ? AND aggregate_id = ?", EventBookAdded, added.ID).Count(&events)
+	if events != 1 {
+		t.Errorf("expected a %s event, got %d", EventBookAdded, events)
+	}
+
+	if _, err := svc.ReceiveShipment(ctx, order.ID, 0, []ReceiptItem{{LineID: order.Lines[0].ID, Quantity: 2}}); !errors.Is(err, ErrInvalidAcquisition) {
+		t.Errorf("expected ErrInvalidAcquisition when receiving more than outstanding, got %v", err)
+	}
+	funds, err := svc.FundSummaries(ctx)
+	if err != nil || len(funds) != 1 {
+		t.Fatalf("FundSummaries = %+v, %v", funds, err)
+	}
+	if f := funds[0]; f.CommittedCents != 1500 || f.SpentCents != 7000 || f.RemainingCents != 1500 {
+		t.Errorf("unexpected fund summary %+v", f)
+	}
+
+	received, err := svc.ReceiveShipment(ctx, order.ID, 0, []ReceiptItem{{LineID: order.Lines[0].ID, Quantity: 1}})
+	if err != nil || received.Status != OrderReceived {
+		t.Fatalf("expected the order received, got %+v, %v", received, err)
+	}
+	if _, err := svc.CancelOrder(ctx, order.ID); !errors.Is(err, ErrOrderState) {
+		t.Errorf("expected ErrOrderState when cancelling a received order, got %v", err)
+	}
+
+	cost, err := svc.BookCosts(ctx, stocked.ID)
+	if err != nil {
+		t.Fatalf("BookCosts: %v", err)
+	}
+	if cost.Acquired != 3 || cost.TotalCents != 4500 || cost.AverageUnitCents != 1500 || len(cost.Receipts) != 2 {
+		t.Errorf("unexpected book cost %+v", cost)
+	}
+	if _, err := svc.BookCosts(ctx, 999999); !errors.Is(err, ErrAcquisitionNotFound) {
+		t.Errorf("expected ErrAcquisitionNotFound for an unknown book, got %v", err)
+	}
+
+	if _, err := svc.ListVendors(context.Background()); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expected ErrUnauthenticated without a principal, got %v", err)
+	}
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // register decoders for thumbnails
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Attachment limits. The upload limit can be raised with ATTACHMENT_MAX_BYTES.
+const (
+	defaultAttachmentMaxBytes = 10 << 20
+	thumbnailSize             = 256
+	maxImagePixels            = 40_000_000
+)
+
+// ErrAttachmentNotFound is returned for unknown attachments and missing thumbnails.
+var ErrAttachmentNotFound = errors.New("attachment not found")
+
+// ErrInvalidAttachment is returned for uploads that are too large or of a
+// content type not allowed for their kind.
+var ErrInvalidAttachment = errors.New("invalid attachment")
+
+// AttachmentKind says what an attachment is for.
+type AttachmentKind string
+
+// Attachment kinds. A book has at most one cover.
+const (
+	AttachmentCover AttachmentKind = "cover"
+	AttachmentFile  AttachmentKind = "file"
+)
+
+// allowedContentTypes lists the sniffed content types accepted per kind.
+var allowedContentTypes = map[AttachmentKind][]string{
+	AttachmentCover: {"image/jpeg", "image/png", "image/gif"},
+	AttachmentFile:  {"application/pdf", "image/jpeg", "image/png", "image/gif", "text/plain; charset=utf-8"},
+}
+
+// Attachment is a file stored in the BlobStore and linked to a book.
+type Attachment struct {
+	ID           uint           `gorm:"primaryKey"`
+	TenantID     uint           `gorm:"index;not null;default:0"`
+	BookID       uint           `gorm:"index;not null"`
+	Kind         AttachmentKind `gorm:"type:varchar(20);not null"`
+	Filename     string         `gorm:"size:255;not null"`
+	ContentType  string         `gorm:"size:100;not null"`
+	Size         int64          `gorm:"not null"`
+	SHA256       string         `gorm:"size:64;not null"`
+	StorageKey   string         `gorm:"uniqueIndex;size:255;not null"`
+	ThumbnailKey string         `gorm:"size:255"`
+	UploadedBy   uint
+	CreatedAt    time.Time
+}
+
+// AttachmentService stores and serves book covers and attached files.
+type AttachmentService struct {
+	db           *gorm.DB
+	queryTimeout time.Duration
+	store        BlobStore
+	maxBytes     int64
+}
+
+// attachmentMaxBytesFromEnv reads the upload size limit from ATTACHMENT_MAX_BYTES.
+func attachmentMaxBytesFromEnv() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("ATTACHMENT_MAX_BYTES"), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return defaultAttachmentMaxBytes
+}
+
+// validateContentType sniffs data and checks it is allowed for kind.
+func validateContentType(kind AttachmentKind, data []byte) (string, error) {
+	allowed, ok := allowedContentTypes[kind]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown kind %q", ErrInvalidAttachment, kind)
+	}
+	ct := http.DetectContentType(data)
+	for _, a := range allowed {
+		if ct == a {
+			return ct, nil
+		}
+	}
+	return "", fmt.Errorf("%w: content type %s is not allowed for %s", ErrInvalidAttachment, ct, kind)
+}
+
+// makeThumbnail scales an image down to fit in size x size pixels,
+// averaging the source pixels each thumbnail pixel covers, and encodes it
+// as JPEG.
+func makeThumbnail(data []byte, size int) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	if cfg.Width*cfg.Height > maxImagePixels {
+		return nil, fmt.Errorf("%w: image of %dx%d pixels is too large", ErrInvalidAttachment, cfg.Width, cfg.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, max(1, h*size/b.Dx())
+		} else {
+			w, h = max(1, w*size/b.Dy()), size
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/h, b.Min.Y+max((y+1)*b.Dy()/h, y*b.Dy()/h+1)
+		for x := 0; x < w; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/w, b.Min.X+max((x+1)*b.Dx()/w, x*b.Dx()/w+1)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, dst, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	return out.Bytes(), nil
+}
+
+// newStorageKey returns a fresh blob key for a file of the tenant's book.
+func newStorageKey(tenantID, bookID uint, filename string) string {
+	var b [12]byte
+	_, _ = rand.Read(b[:])
+	return fmt.Sprintf("tenants/%d/books/%d/%s%s", tenantID, bookID, hex.EncodeToString(b[:]), strings.ToLower(path.Ext(filename)))
+}
+
+// Upload stores a file for a book. Images get a thumbnail. Uploading a
+// cover replaces the previous cover and points Book.CoverURL at it.
+// Requires PermManageCatalog.
+func (s *AttachmentService) Upload(ctx context.Context, bookID uint, kind AttachmentKind, filename string, r io.Reader) (_ *Attachment, err error) {
+	defer observeOperation("upload_attachment", time.Now(), &err)
+	user, err := authorize(ctx, PermManageCatalog)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+	db := s.db.WithContext(ctx)
+
+	var book Book
+	if err := db.First(&book, bookID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("book not found")
+		}
+		return nil, fmt.Errorf("error finding book: %w", err)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, s.maxBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload: %w", err)
+	}
+	if int64(len(data)) > s.maxBytes {
+		return nil, fmt.Errorf("%w: larger than %d bytes", ErrInvalidAttachment, s.maxBytes)
+	}
+	ct, err := validateContentType(kind, data)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	att := &Attachment{
+		BookID:      book.ID,
+		Kind:        kind,
+		Filename:    path.Base(filename),
+		ContentType: ct,
+		Size:        int64(len(data)),
+		SHA256:      hex.EncodeToString(sum[:]),
+		StorageKey:  newStorageKey(book.TenantID, book.ID, filename),
+		UploadedBy:  user.ID,
+	}
+
+	var thumb []byte
+	if strings.HasPrefix(ct, "image/") {
+		if thumb, err = makeThumbnail(data, thumbnailSize); err != nil {
+			return nil, err
+		}
+		att.ThumbnailKey = att.StorageKey + ".thumb.jpg"
+	}
+
+	if err := s.store.Put(ctx, att.StorageKey, bytes.NewReader(data), att.Size, ct); err != nil {
+		return nil, err
+	}
+	if thumb != nil {
+		if err := s.store.Put(ctx, att.ThumbnailKey, bytes.NewReader(thumb), int64(len(thumb)), "image/jpeg"); err != nil {
+			deleteBlobs(ctx, s.store, *att)
+			return nil, err
+		}
+	}
+
+	var replaced []Attachment
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if kind == AttachmentCover {
+			if err := tx.Where("book_id = ? AND kind = ?", book.ID, AttachmentCover).Find(&replaced).Error; err != nil {
+				return err
+			}
+			if len(replaced) > 0 {
+				if err := tx.Delete(&replaced).Error; err != nil {
+					return err
+				}
+			}
+		}
+		if err := tx.Create(att).Error; err != nil {
+			return err
+		}
+		if kind == AttachmentCover {
+			return tx.Model(&book).UpdateColumn("cover_url", fmt.Sprintf("/attachments/%d", att.ID)).Error
+		}
+		return nil
+	})
+	if err != nil {
+		deleteBlobs(ctx, s.store, *att)
+		return nil, fmt.Errorf("failed to save attachment: %w", err)
+	}
+	for _, old := range replaced {
+		deleteBlobs(ctx, s.store, old)
+	}
+	return att, nil
+}
+
+// deleteBlobs removes the blobs of attachments from store, logging
+// failures; a leftover blob is harmless once no row references it.
+func deleteBlobs(ctx context.Context, store BlobStore, atts ...Attachment) {
+	ctx = context.WithoutCancel(ctx)
+	for _, att := range atts {
+		for _, key := range []string{att.StorageKey, att.ThumbnailKey} {
+			if key == "" {
+				continue
+			}
+			if err := store.Delete(ctx, key); err != nil {
+				slog.WarnContext(ctx, "failed to delete blob", "key", key, "error", err)
+			}
+		}
+	}
+}
+
+// find loads an attachment of the context tenant.
+func (s *AttachmentService) find(ctx context.Context, id uint) (*Attachment, error) {
+	var att Attachment
+	if err := s.db.WithContext(ctx).First(&att, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, fmt.Errorf("error finding attachment: %w", err)
+	}
+	return &att, nil
+}
+
+// Open returns an attachment and a reader for its content, or for its
+// thumbnail when thumbnail is true. The caller must close the reader.
+// Requires PermViewCatalog.
+func (s *AttachmentService) Open(ctx context.Context, id uint, thumbnail bool) (_ *Attachment, _ io.ReadCloser, err error) {
+	defer observeOperation("open_attachment", time.Now(), &err)
+	if _, err := authorize(ctx, PermViewCatalog); err != nil {
+		return nil, nil, err
+	}
+	att, err := s.find(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := att.StorageKey
+	if thumbnail {
+		if att.ThumbnailKey == "" {
+			return nil, nil, fmt.Errorf("%w: no thumbnail", ErrAttachmentNotFound)
+		}
+		key = att.ThumbnailKey
+	}
+	rc, err := s.store.Get(ctx, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return att, rc, nil
+}
+
+// List returns the attachments of a book. Requires PermViewCatalog.
+func (s *AttachmentService) List(ctx context.Context, bookID uint) (_ []Attachment, err error) {
+	defer observeOperation("list_attachments", time.Now(), &err)
+	if _, err := authorize(ctx, PermViewCatalog); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var atts []Attachment
+	if err := s.db.WithContext(ctx).Where("book_id = ?", bookID).Order("id").Find(&atts).Error; err != nil {
+		return nil, fmt.Errorf("failed to list attachments: %w", err)
+	}
+	return atts, nil
+}
+
+// Delete removes an attachment and its blobs. Deleting a cover clears
+// Book.CoverURL. Requires PermManageCatalog.
+func (s *AttachmentService) Delete(ctx context.Context, id uint) (err error) {
+	defer observeOperation("delete_attachment", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageCatalog); err != nil {
+		return err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	att, err := s.find(ctx, id)
+	if err != nil {
+		return err
+	}
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(att).Error; err != nil {
+			return err
+		}
+		if att.Kind == AttachmentCover {
+			return tx.Model(&Book{}).Where("id = ?", att.BookID).UpdateColumn("cover_url", "").Error
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete attachment: %w", err)
+	}
+	deleteBlobs(ctx, s.store, *att)
+	return nil
+}
+
+// handleUploadAttachment serves POST /books/{id}/attachments?kind=cover|file
+// with the file in the "file" field of a multipart form.
+func (s *apiServer) handleUploadAttachment(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid book id"})
+		return
+	}
+	kind := AttachmentKind(r.URL.Query().Get("kind"))
+	if kind == "" {
+		kind = AttachmentFile
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, s.attachments.maxBytes+1<<20)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "missing file: " + err.Error()})
+		return
+	}
+	defer file.Close()
+
+	att, err := s.attachments.Upload(r.Context(), uint(bookID), kind, header.Filename, file)
+	switch {
+	case errors.Is(err, ErrInvalidAttachment):
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	case err != nil:
+		writeError(w, err)
+	default:
+		writeJSON(w, http.StatusCreated, att)
+	}
+}
+
+// handleListAttachments serves GET /books/{id}/attachments.
+func (s *apiServer) handleListAttachments(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid book id"})
+		return
+	}
+	atts, err := s.attachments.List(r.Context(), uint(bookID))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, atts)
+}
+
+// handleDownloadAttachment serves GET /attachments/{id}, or the thumbnail
+// with ?thumbnail=1.
+func (s *apiServer) handleDownloadAttachment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid attachment id"})
+		return
+	}
+	thumbnail := r.URL.Query().Get("thumbnail") != ""
+	att, rc, err := s.attachments.Open(r.Context(), uint(id), thumbnail)
+	if err != nil {
+		if errors.Is(err, ErrAttachmentNotFound) || errors.Is(err, ErrBlobNotFound) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		writeError(w, err)
+		return
+	}
+	defer rc.Close()
+
+	ct, disposition := att.ContentType, "attachment"
+	if thumbnail {
+		ct = "image/jpeg"
+	}
+	if strings.HasPrefix(ct, "image/") {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": att.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if !thumbnail {
+		w.Header().Set("Content-Length", strconv.FormatInt(att.Size, 10))
+	}
+	_, _ = io.Copy(w, rc)
+}
+
+// handleDeleteAttachment serves DELETE /attachments/{id}.
+func (s *apiServer) handleDeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid attachment id"})
+		return
+	}
+	if err := s.attachments.Delete(r.Context(), uint(id)); err != nil {
+		if errors.Is(err, ErrAttachmentNotFound) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
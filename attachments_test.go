@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+)
+
+// testPNG encodes a solid w x h PNG image.
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{200, 30, 30, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("png.Encode: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestLocalBlobStore tests storing, reading and deleting local blobs.
+func TestLocalBlobStore(t *testing.T) {
+	s := &LocalBlobStore{Dir: t.TempDir()}
+	ctx := context.Background()
+
+	if err := s.Put(ctx, "a/b/c.txt", strings.NewReader("hello"), 5, "text/plain"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	rc, err := s.Get(ctx, "a/b/c.txt")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	b, _ := io.ReadAll(rc)
+	rc.Close()
+	if string(b) != "hello" {
+		t.Errorf("got %q", b)
+	}
+	if err := s.Delete(ctx, "a/b/c.txt"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := s.Get(ctx, "a/b/c.txt"); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("expected ErrBlobNotFound, got %v", err)
+	}
+	if err := s.Put(ctx, "../escape", strings.NewReader("x"), 1, ""); err == nil {
+		t.Errorf("expected keys outside the store to be rejected")
+	}
+}
+
+// TestValidateContentType tests content sniffing per attachment kind.
+func TestValidateContentType(t *testing.T) {
+	pdf := []byte("%PDF-1.7\n...")
+	if ct, err := validateContentType(AttachmentFile, pdf); err != nil || ct != "application/pdf" {
+		t.Errorf("PDF file: got %q, %v", ct, err)
+	}
+	if _, err := validateContentType(AttachmentCover, pdf); !errors.Is(err, ErrInvalidAttachment) {
+		t.Errorf("PDF cover should be rejected, got %v", err)
+	}
+	if _, err := validateContentType(AttachmentFile, []byte{0x7f, 'E', 'L', 'F', 2, 1, 1, 0}); !errors.Is(err, ErrInvalidAttachment) {
+		t.Errorf("executable should be rejected, got %v", err)
+	}
+}
+
+// TestMakeThumbnail tests that thumbnails keep the aspect ratio.
+func TestMakeThumbnail(t *testing.T) {
+	thumb, err := makeThumbnail(testPNG(t, 800, 400), thumbnailSize)
+	if err != nil {
+		t.Fatalf("makeThumbnail: %v", err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(thumb))
+	if err != nil {
+		t.Fatalf("thumbnail is not a JPEG: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 256 || b.Dy() != 128 {
+		t.Errorf("expected 256x128, got %dx%d", b.Dx(), b.Dy())
+	}
+	r, _, _, _ := img.At(10, 10).RGBA()
+	if r>>8 < 150 {
+		t.Errorf("thumbnail lost the image colour, red=%d", r>>8)
+	}
+}
+
+// TestAttachmentService_Cover tests uploading, replacing and deleting a cover.
+func TestAttachmentService_Cover(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenant := mustCreateTenant(t, &TenantService{db: db}, "attach")
+	ctx := withTenant(asRole(RoleLibrarian), tenant.ID)
+	store := &LocalBlobStore{Dir: t.TempDir()}
+	svc := &AttachmentService{db: db, store: store, maxBytes: 1 << 20}
+
+	book := &Book{ISBN: "9782424242424", Title: "Covered", Copies: 1}
+	mustCreateBook(t, db.WithContext(ctx), book)
+
+	first, err := svc.Upload(ctx, book.ID, AttachmentCover, "front.png", bytes.NewReader(testPNG(t, 300, 450)))
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if first.ContentType != "image/png" || first.ThumbnailKey == "" {
+		t.Errorf("unexpected attachment: %+v", first)
+	}
+	second, err := svc.Upload(ctx, book.ID, AttachmentCover, "front2.png", bytes.NewReader(testPNG(t, 60, 90)))
+	if err != nil {
+		t.Fatalf("second Upload: %v", err)
+	}
+	if _, err := store.Get(ctx, first.StorageKey); !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("replaced cover blob should be deleted, got %v", err)
+	}
+
+	var got Book
+	db.WithContext(ctx).Preload("Attachments").First(&got, book.ID)
+	if got.CoverURL != fmt.Sprintf("/attachments/%d", second.ID) || len(got.Attachments) != 1 {
+		t.Errorf("book should link to the new cover only: %q, %d attachments", got.CoverURL, len(got.Attachments))
+	}
+
+	if _, err := svc.Upload(ctx, book.ID, AttachmentCover, "notes.txt", strings.NewReader("not an image")); !errors.Is(err, ErrInvalidAttachment) {
+		t.Errorf("expected ErrInvalidAttachment for a text cover, got %v", err)
+	}
+	if _, err := svc.Upload(asRole(RoleMember), book.ID, AttachmentFile, "x.pdf", strings.NewReader("%PDF-1.7")); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected members to be forbidden, got %v", err)
+	}
+
+	_, rc, err := svc.Open(ctx, second.ID, true)
+	if err != nil {
+		t.Fatalf("Open thumbnail: %v", err)
+	}
+	if _, err := jpeg.Decode(rc); err != nil {
+		t.Errorf("thumbnail is not a JPEG: %v", err)
+	}
+	rc.Close()
+
+	if err := svc.Delete(ctx, second.ID); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	db.WithContext(ctx).First(&got, book.ID)
+	if got.CoverURL != "" {
+		t.Errorf("deleting the cover should clear CoverURL, got %q", got.CoverURL)
+	}
+	if _, _, err := svc.Open(ctx, second.ID, false); !errors.Is(err, ErrAttachmentNotFound) {
+		t.Errorf("expected ErrAttachmentNotFound, got %v", err)
+	}
+}
+
+// TestBookService_RemoveBookDeletesBlobs tests that removing a book deletes
+// the blobs of its attachments along with their rows.
+func TestBookService_RemoveBookDeletesBlobs(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenant := mustCreateTenant(t, &TenantService{db: db}, "attach-remove")
+	ctx := withTenant(asRole(RoleLibrarian), tenant.ID)
+	store := &LocalBlobStore{Dir: t.TempDir()}
+	attachments := &AttachmentService{db: db, store: store, maxBytes: 1 << 20}
+	books := &BookService{db: db, blobs: store}
+
+	book := &Book{Copies: 1}
+	mustCreateBook(t, db.WithContext(ctx), book)
+	cover, err := attachments.Upload(ctx, book.ID, AttachmentCover, "front.png", bytes.NewReader(testPNG(t, 300, 450)))
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	file, err := attachments.Upload(ctx, book.ID, AttachmentFile, "notes.pdf", strings.NewReader("%PDF-1.7"))
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+
+	if err := books.RemoveBook(ctx, book.ISBN); err != nil {
+		t.Fatalf("RemoveBook: %v", err)
+	}
+	for _, key := range []string{cover.StorageKey, cover.ThumbnailKey, file.StorageKey} {
+		if _, err := store.Get(ctx, key); !errors.Is(err, ErrBlobNotFound) {
+			t.Errorf("blob %s should be deleted, got %v", key, err)
+		}
+	}
+	var rows int64
+	db.WithContext(ctx).Model(&Attachment{}).Where("book_id = ?", book.ID).Count(&rows)
+	if rows != 0 {
+		t.Errorf("expected the attachment rows to be deleted, got %d", rows)
+	}
+	if err := books.RemoveBook(ctx, book.ISBN); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("expected ErrBookNotFound for a removed book, got %v", err)
+	}
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// minPasswordLength is the shortest password accepted by CreateUser.
+const minPasswordLength = 8
+
+// tokenPrefix marks API tokens so they are easy to recognise in configs.
+const tokenPrefix = "lib_"
+
+var (
+	// ErrUnauthenticated is returned when a call carries no valid identity.
+	ErrUnauthenticated = errors.New("authentication required")
+	// ErrForbidden is returned when the caller lacks the required permission.
+	ErrForbidden = errors.New("permission denied")
+	// ErrInvalidUser is returned for accounts that cannot be created as given.
+	ErrInvalidUser = errors.New("invalid user")
+	// ErrUserNotFound is returned for user IDs outside the current tenant.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrTokenNotFound is returned for unknown API token IDs.
+	ErrTokenNotFound = errors.New("token not found")
+)
+
+// Role names a set of permissions granted to a user.
+type Role string
+
+// Roles known to the system, from most to least privileged.
+const (
+	RoleAdmin     Role = "admin"
+	RoleLibrarian Role = "librarian"
+	RoleMember    Role = "member"
+	RoleGuest     Role = "guest"
+)
+
+// Permission names an action guarded by the service layer.
+type Permission string
+
+// Permissions checked by the services.
+const (
+	PermViewCatalog        Permission = "catalog:view"
+	PermManageCatalog      Permission = "catalog:manage"
+	PermBorrow             Permission = "loans:borrow"
+	PermManageLoans        Permission = "loans:manage"
+	PermManageUsers        Permission = "users:manage"
+	PermViewReports        Permission = "reports:view"
+	PermManageWebhooks     Permission = "webhooks:manage"
+	PermManageAcquisitions Permission = "acquisitions:manage"
+)
+
+// rolePermissions maps each role to the permissions it grants.
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin:     {PermViewCatalog, PermManageCatalog, PermBorrow, PermManageLoans, PermManageUsers, PermViewReports, PermManageWebhooks, PermManageAcquisitions},
+	RoleLibrarian: {PermViewCatalog, PermManageCatalog, PermBorrow, PermManageLoans, PermViewReports, PermManageAcquisitions},
+	RoleMember:    {PermViewCatalog, PermBorrow},
+	RoleGuest:     {PermViewCatalog},
+}
+
+// systemPrincipal acts on behalf of the application itself, e.g. for
+// startup tasks and background jobs.
+var systemPrincipal = &User{Username: "system", Role: RoleAdmin}
+
+// guestPrincipal is attached to unauthenticated HTTP requests.
+var guestPrincipal = &User{Username: "guest", Role: RoleGuest}
+
+// User represents an account that can sign in with a password or API token.
+type User struct {
+	ID           uint   `gorm:"primaryKey"`
+	TenantID     uint   `gorm:"uniqueIndex:idx_users_tenant_username;not null;default:0"`
+	Username     string `gorm:"uniqueIndex:idx_users_tenant_username;not null;size:100"`
+	Email        string `gorm:"size:255"`
+	PasswordHash string `gorm:"not null" json:"-"`
+	Role         Role   `gorm:"type:varchar(20);not null;default:guest"`
+	CreatedAt    time.Time
+}
+
+// APIToken is a bearer credential belonging to a user. Only the SHA-256
+// hash of the token is stored.
+type APIToken struct {
+	ID         uint   `gorm:"primaryKey"`
+	TenantID   uint   `gorm:"index;not null;default:0"`
+	UserID     uint   `gorm:"index;not null"`
+	User       User   `json:"-"`
+	Name       string `gorm:"size:100"`
+	TokenHash  string `gorm:"uniqueIndex;not null;size:64" json:"-"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// Can reports whether the user's role grants perm.
+func (u *User) Can(perm Permission) bool {
+	for _, p := range rolePermissions[u.Role] {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+// withPrincipal returns a copy of ctx carrying the acting user.
+func withPrincipal(ctx context.Context, u *User) context.Context {
+	return context.WithValue(ctx, principalKey, u)
+}
+
+// principalFromContext returns the acting user stored in ctx, or nil.
+func principalFromContext(ctx context.Context) *User {
+	u, _ := ctx.Value(principalKey).(*User)
+	return u
+}
+
+// authorize returns the acting user if it holds perm. Stored users may
+// only act within their own tenant.
+func authorize(ctx context.Context, perm Permission) (*User, error) {
+	u := principalFromContext(ctx)
+	if u == nil {
+		return nil, ErrUnauthenticated
+	}
+	if u.ID != 0 && u.TenantID != tenantFromContext(ctx) {
+		return nil, fmt.Errorf("%w: user belongs to another tenant", ErrForbidden)
+	}
+	if !u.Can(perm) {
+		return nil, fmt.Errorf("%w: role %s lacks %s", ErrForbidden, u.Role, perm)
+	}
+	return u, nil
+}
+
+// authorizeMember allows staff to act on any member's loans and members
+// to act only on their own.
+func authorizeMember(ctx context.Context, memberID uint) error {
+	u, err := authorize(ctx, PermBorrow)
+	if err != nil {
+		return err
+	}
+	if u.Can(PermManageLoans) || u.ID == memberID {
+		return nil
+	}
+	return fmt.Errorf("%w: loans of another member", ErrForbidden)
+}
+
+// authorizeSelf allows users to manage their own credentials and callers
+// with PermManageUsers those of anyone. Guests and the system principal
+// have ID 0 and never match a stored user.
+func authorizeSelf(ctx context.Context, userID uint) error {
+	u, err := authorize(ctx, PermViewCatalog)
+	if err != nil {
+		return err
+	}
+	if u.ID != 0 && u.ID == userID {
+		return nil
+	}
+	_, err = authorize(ctx, PermManageUsers)
+	return err
+}
+
+// hashToken returns the hex SHA-256 digest under which a token is stored.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// AuthService manages user accounts and credentials.
+type AuthService struct {
+	db           *gorm.DB
+	queryTimeout time.Duration
+}
+
+// CreateUser creates an account with a bcrypt-hashed password.
+// Only callers with PermManageUsers may create users.
+func (s *AuthService) CreateUser(ctx context.Context, username, email, password string, role Role) (*User, error) {
+	if _, err := authorize(ctx, PermManageUsers); err != nil {
+		return nil, err
+	}
+	if username == "" {
+		return nil, fmt.Errorf("%w: username is required", ErrInvalidUser)
+	}
+	if _, ok := rolePermissions[role]; !ok {
+		return nil, fmt.Errorf("%w: unknown role %q", ErrInvalidUser, role)
+	}
+	if len(password) < minPasswordLength {
+		return nil, fmt.Errorf("%w: password must be at least %d characters", ErrInvalidUser, minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+	user := &User{Username: username, Email: email, PasswordHash: string(hash), Role: role}
+	if err := s.db.WithContext(ctx).Create(user).Error; err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	return user, nil
+}
+
+// Authenticate verifies a username and password and returns the user.
+func (s *AuthService) Authenticate(ctx context.Context, username, password string) (*User, error) {
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var user User
+	if err := s.db.WithContext(ctx).Where("username = ?", username).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: invalid credentials", ErrUnauthenticated)
+		}
+		return nil, fmt.Errorf("error finding user: %w", err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, fmt.Errorf("%w: invalid credentials", ErrUnauthenticated)
+	}
+	return &user, nil
+}
+
+// IssueToken creates an API token for the user and returns its plaintext,
+// which is not stored and cannot be recovered. A zero ttl never expires.
+// Users may issue tokens for themselves; PermManageUsers is needed otherwise.
+func (s *AuthService) IssueToken(ctx context.Context, userID uint, name string, ttl time.Duration) (string, error) {
+	if err := authorizeSelf(ctx, userID); err != nil {
+		return "", err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+	if err := s.db.WithContext(ctx).Select("id").First(&User{}, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", fmt.Errorf("%w: %d", ErrUserNotFound, userID)
+		}
+		return "", fmt.Errorf("error finding user: %w", err)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := tokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+
+	rec := &APIToken{UserID: userID, Name: name, TokenHash: hashToken(token)}
+	if ttl > 0 {
+		exp := time.Now().Add(ttl)
+		rec.ExpiresAt = &exp
+	}
+	if err := s.db.WithContext(ctx).Create(rec).Error; err != nil {
+		return "", fmt.Errorf("failed to store token: %w", err)
+	}
+	return token, nil
+}
+
+// ListTokens returns the API tokens of a user, without their hashes.
+// Users may list their own tokens; PermManageUsers is needed otherwise.
+func (s *AuthService) ListTokens(ctx context.Context, userID uint) ([]APIToken, error) {
+	if err := authorizeSelf(ctx, userID); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+	var tokens []APIToken
+	if err := s.db.WithContext(ctx).Where("user_id = ?", userID).Order("id").Find(&tokens).Error; err != nil {
+		return nil, fmt.Errorf("failed to list tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+// RevokeToken deletes an API token, which stops authenticating at once.
+// Users may revoke their own tokens; PermManageUsers is needed otherwise.
+func (s *AuthService) RevokeToken(ctx context.Context, tokenID uint) error {
+	if _, err := authorize(ctx, PermViewCatalog); err != nil {
+		return err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+	db := s.db.WithContext(ctx)
+	var rec APIToken
+	if err := db.First(&rec, tokenID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: %d", ErrTokenNotFound, tokenID)
+		}
+		return fmt.Errorf("error finding token: %w", err)
+	}
+	if err := authorizeSelf(ctx, rec.UserID); err != nil {
+		return err
+	}
+	if err := db.Delete(&rec).Error; err != nil {
+		return fmt.Errorf("failed to revoke token: %w", err)
+	}
+	return nil
+}
+
+// AuthenticateToken resolves an API token to its user.
+func (s *AuthService) AuthenticateToken(ctx context.Context, token string) (*User, error) {
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var rec APIToken
+	err := s.db.WithContext(ctx).Preload("User").Where("token_hash = ?", hashToken(token)).First(&rec).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: invalid token", ErrUnauthenticated)
+		}
+		return nil, fmt.Errorf("error finding token: %w", err)
+	}
+	now := time.Now()
+	if rec.ExpiresAt != nil && now.After(*rec.ExpiresAt) {
+		return nil, fmt.Errorf("%w: token expired", ErrUnauthenticated)
+	}
+	if err := s.db.WithContext(ctx).Model(&rec).Update("last_used_at", now).Error; err != nil {
+		return nil, fmt.Errorf("failed to record token use: %w", err)
+	}
+	return &rec.User, nil
+}
+
+// middleware resolves the request's credentials into a principal. Requests
+// without an Authorization header proceed as guests; invalid credentials
+// are rejected with 401.
+func (s *AuthService) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		user := guestPrincipal
+		var err error
+		if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			user, err = s.AuthenticateToken(ctx, strings.TrimPrefix(header, "Bearer "))
+		} else if username, password, ok := r.BasicAuth(); ok {
+			user, err = s.Authenticate(ctx, username, password)
+		} else if header != "" {
+			err = fmt.Errorf("%w: unsupported authorization scheme", ErrUnauthenticated)
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="library"`)
+			writeError(w, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withPrincipal(ctx, user)))
+	})
+}
+
+// bootstrapAdmin creates the initial administrator account if no admin
+// exists yet and both username and password are set.
+func (s *AuthService) bootstrapAdmin(ctx context.Context, username, password string) error {
+	if username == "" || password == "" {
+		return nil
+	}
+	var count int64
+	if err := s.db.WithContext(ctx).Model(&User{}).Where("role = ?", RoleAdmin).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to count admins: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+	_, err := s.CreateUser(withPrincipal(ctx, systemPrincipal), username, "", password, RoleAdmin)
+	return err
+}
+
+// writeAuthError writes err with the status matching the account errors,
+// falling back to writeError.
+func writeAuthError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrUserNotFound), errors.Is(err, ErrTokenNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrInvalidUser):
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	default:
+		writeError(w, err)
+	}
+}
+
+// handleCreateUser serves POST /users.
+func (s *apiServer) handleCreateUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		Role     Role   `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid user"})
+		return
+	}
+	user, err := s.auth.CreateUser(r.Context(), req.Username, req.Email, req.Password, req.Role)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, user)
+}
+
+// handleIssueToken serves POST /users/{id}/tokens with an optional ttl in
+// Go duration syntax ("720h"). The token is only returned here.
+func (s *apiServer) handleIssueToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := pathUint(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Name string `json:"name"`
+		TTL  string `json:"ttl"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid token request"})
+		return
+	}
+	var ttl time.Duration
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid ttl"})
+			return
+		}
+		ttl = d
+	}
+	token, err := s.auth.IssueToken(r.Context(), userID, req.Name, ttl)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, map[string]string{"token": token})
+}
+
+// handleListTokens serves GET /users/{id}/tokens.
+func (s *apiServer) handleListTokens(w http.ResponseWriter, r *http.Request) {
+	userID, ok := pathUint(w, r, "id")
+	if !ok {
+		return
+	}
+	tokens, err := s.auth.ListTokens(r.Context(), userID)
+	if err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, tokens)
+}
+
+// handleRevokeToken serves DELETE /tokens/{id}.
+func (s *apiServer) handleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathUint(w, r, "id")
+	if !ok {
+		return
+	}
+	if err := s.auth.RevokeToken(r.Context(), id); err != nil {
+		writeAuthError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TestRolePermissions tests the permission matrix of the built-in roles.
+func TestRolePermissions(t *testing.T) {
+	cases := []struct {
+		role Role
+		perm Permission
+		want bool
+	}{
+		{RoleAdmin, PermManageUsers, true},
+		{RoleLibrarian, PermManageCatalog, true},
+		{RoleLibrarian, PermManageUsers, false},
+		{RoleMember, PermBorrow, true},
+		{RoleMember, PermManageCatalog, false},
+		{RoleGuest, PermViewCatalog, true},
+		{RoleGuest, PermBorrow, false},
+		{RoleLibrarian, PermViewReports, true},
+		{RoleMember, PermViewReports, false},
+		{RoleAdmin, PermManageWebhooks, true},
+		{RoleLibrarian, PermManageWebhooks, false},
+		{RoleLibrarian, PermManageAcquisitions, true},
+		{RoleMember, PermManageAcquisitions, false},
+	}
+	for _, c := range cases {
+		if got := (&User{Role: c.role}).Can(c.perm); got != c.want {
+			t.Errorf("%s can %s: got %v want %v", c.role, c.perm, got, c.want)
+		}
+	}
+}
+
+// TestBookService_Authorization tests that catalog changes are restricted to staff.
+func TestBookService_Authorization(t *testing.T) {
+	svc := &BookService{} // rejected calls must not reach the database
+
+	if err := svc.RemoveBook(asRole(RoleMember), "9780000000001"); !errors.Is(err, ErrForbidden) {
+		t.Errorf("member RemoveBook: expected ErrForbidden, got %v", err)
+	}
+	if err := svc.UpdateBookCopies(asRole(RoleGuest), "9780000000001", 3); !errors.Is(err, ErrForbidden) {
+		t.Errorf("guest UpdateBookCopies: expected ErrForbidden, got %v", err)
+	}
+	if err := svc.AddBook(context.Background(), &Book{}); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("anonymous AddBook: expected ErrUnauthenticated, got %v", err)
+	}
+}
+
+// TestAuthService_PasswordAndToken tests password sign-in and API token resolution.
+func TestAuthService_PasswordAndToken(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	svc := &AuthService{db: db}
+	admin := withPrincipal(context.Background(), systemPrincipal)
+
+	username := fmt.Sprintf("auth-%d", time.Now().UnixNano())
+	user, err := svc.CreateUser(admin, username, "reader@example.com", "correct horse", RoleMember)
+	if err != nil {
+		t.Fatalf("CreateUser returned error: %v", err)
+	}
+	if user.PasswordHash == "correct horse" {
+		t.Fatalf("password stored in plaintext")
+	}
+	if _, err := svc.CreateUser(asRole(RoleLibrarian), username+"-x", "", "correct horse", RoleMember); !errors.Is(err, ErrForbidden) {
+		t.Errorf("librarian CreateUser: expected ErrForbidden, got %v", err)
+	}
+
+	if _, err := svc.Authenticate(context.Background(), username, "wrong password"); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expected ErrUnauthenticated for wrong password, got %v", err)
+	}
+	got, err := svc.Authenticate(context.Background(), username, "correct horse")
+	if err != nil || got.ID != user.ID {
+		t.Fatalf("Authenticate: got %+v, %v", got, err)
+	}
+
+	token, err := svc.IssueToken(withPrincipal(context.Background(), user), user.ID, "cli", time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken returned error: %v", err)
+	}
+	got, err = svc.AuthenticateToken(context.Background(), token)
+	if err != nil || got.ID != user.ID || got.Role != RoleMember {
+		t.Fatalf("AuthenticateToken: got %+v, %v", got, err)
+	}
+	if _, err := svc.AuthenticateToken(context.Background(), token+"x"); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expected ErrUnauthenticated for unknown token, got %v", err)
+	}
+
+	expired, err := svc.IssueToken(admin, user.ID, "expired", time.Nanosecond)
+	if err != nil {
+		t.Fatalf("IssueToken returned error: %v", err)
+	}
+	time.Sleep(time.Millisecond)
+	if _, err := svc.AuthenticateToken(context.Background(), expired); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expected ErrUnauthenticated for expired token, got %v", err)
+	}
+}
+
+// TestAuthMiddleware tests credential resolution on HTTP requests.
+func TestAuthMiddleware(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	auth := &AuthService{db: db}
+
+	var seen *User
+	handler := auth.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = principalFromContext(r.Context())
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK || seen == nil || seen.Role != RoleGuest {
+		t.Errorf("anonymous request: code=%d principal=%+v", rec.Code, seen)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer lib_invalid")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("invalid token: expected 401, got %d", rec.Code)
+	}
+
+	srv := &apiServer{db: db, auth: auth, registry: prometheus.NewRegistry()}
+	rec = httptest.NewRecorder()
+	srv.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("healthz should stay public, got %d", rec.Code)
+	}
+}
+
+// TestAuthorizeSelf tests that only stored users match themselves, so the
+// ID-0 guest cannot issue tokens for user 0.
+func TestAuthorizeSelf(t *testing.T) {
+	member := &User{ID: 5, Role: RoleMember}
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		userID uint
+		want   error
+	}{
+		{"own account", withPrincipal(context.Background(), member), 5, nil},
+		{"other account", withPrincipal(context.Background(), member), 6, ErrForbidden},
+		{"guest as user 0", withPrincipal(context.Background(), guestPrincipal), 0, ErrForbidden},
+		{"no principal", context.Background(), 5, ErrUnauthenticated},
+		{"user manager", asRole(RoleAdmin), 6, nil},
+	}
+	for _, tt := range tests {
+		if err := authorizeSelf(tt.ctx, tt.userID); !errors.Is(err, tt.want) {
+			t.Errorf("%s: authorizeSelf = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	svc := &AuthService{}
+	if _, err := svc.IssueToken(withPrincipal(context.Background(), guestPrincipal), 0, "guess", 0); !errors.Is(err, ErrForbidden) {
+		t.Errorf("guest IssueToken: expected ErrForbidden, got %v", err)
+	}
+}
+
+// TestUserEndpoints tests creating an account and issuing, listing and
+// revoking its tokens over HTTP.
+func TestUserEndpoints(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	auth := &AuthService{db: db}
+	if err := auth.bootstrapAdmin(context.Background(), "users-admin", "admin password"); err != nil {
+		t.Fatalf("bootstrapAdmin: %v", err)
+	}
+	handler := (&apiServer{db: db, auth: auth, registry: prometheus.NewRegistry()}).routes()
+	do := func(method, path, body string, authorize func(*http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if authorize != nil {
+			authorize(req)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	basic := func(username, password string) func(*http.Request) {
+		return func(r *http.Request) { r.SetBasicAuth(username, password) }
+	}
+
+	body := `{"username":"users-member","password":"member password","role":"member"}`
+	if rec := do(http.MethodPost, "/users", body, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("guest POST /users: expected 403, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/users", `{"username":"x","password":"short","role":"member"}`, basic("users-admin", "admin password")); rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("short password: expected 422, got %d", rec.Code)
+	}
+	rec := do(http.MethodPost, "/users", body, basic("users-admin", "admin password"))
+	var member User
+	if rec.Code != http.StatusCreated || json.NewDecoder(rec.Body).Decode(&member) != nil || member.ID == 0 {
+		t.Fatalf("POST /users: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := do(http.MethodPost, "/users/0/tokens", `{"name":"guess"}`, nil); rec.Code != http.StatusForbidden {
+		t.Errorf("guest token for user 0: expected 403, got %d", rec.Code)
+	}
+	var admin User
+	db.Where("username = ?", "users-admin").First(&admin)
+	if rec := do(http.MethodPost, fmt.Sprintf("/users/%d/tokens", admin.ID), `{}`, basic("users-member", "member password")); rec.Code != http.StatusForbidden {
+		t.Errorf("member token for the admin: expected 403, got %d", rec.Code)
+	}
+	rec = do(http.MethodPost, fmt.Sprintf("/users/%d/tokens", member.ID), `{"name":"cli","ttl":"24h"}`, basic("users-member", "member password"))
+	var issued struct{ Token string }
+	if rec.Code != http.StatusCreated || json.NewDecoder(rec.Body).Decode(&issued) != nil || issued.Token == "" {
+		t.Fatalf("POST /users/{id}/tokens: %d %s", rec.Code, rec.Body)
+	}
+	bearer := func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+issued.Token) }
+
+	rec = do(http.MethodGet, fmt.Sprintf("/users/%d/tokens", member.ID), "", bearer)
+	var tokens []APIToken
+	if rec.Code != http.StatusOK || json.NewDecoder(rec.Body).Decode(&tokens) != nil || len(tokens) != 1 || tokens[0].ExpiresAt == nil {
+		t.Fatalf("GET /users/{id}/tokens: %d %+v", rec.Code, tokens)
+	}
+	if rec := do(http.MethodDelete, fmt.Sprintf("/tokens/%d", tokens[0].ID), "", bearer); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE /tokens/{id}: expected 204, got %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/books", "", bearer); rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked token: expected 401, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// ErrBlobNotFound is returned when a blob does not exist.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// defaultBlobDir is where LocalBlobStore keeps blobs when BLOB_DIR is unset.
+const defaultBlobDir = "data/blobs"
+
+// BlobStore stores opaque binary objects under slash-separated keys.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// LocalBlobStore keeps blobs as files below Dir.
+type LocalBlobStore struct {
+	Dir string
+}
+
+// path maps key to a file below Dir, rejecting keys that would escape it.
+func (s *LocalBlobStore) path(key string) (string, error) {
+	p := filepath.FromSlash(key)
+	if !filepath.IsLocal(p) {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.Dir, p), nil
+}
+
+// Put implements BlobStore. The blob is written to a temporary file and
+// renamed into place so readers never see partial content.
+func (s *LocalBlobStore) Put(_ context.Context, key string, r io.Reader, _ int64, _ string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return fmt.Errorf("failed to store blob: %w", err)
+	}
+	return nil
+}
+
+// Get implements BlobStore.
+func (s *LocalBlobStore) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob: %w", err)
+	}
+	return f, nil
+}
+
+// Delete implements BlobStore. Deleting a missing blob is not an error.
+func (s *LocalBlobStore) Delete(_ context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+	return nil
+}
+
+// S3BlobStore keeps blobs in a bucket of an S3-compatible object store.
+type S3BlobStore struct {
+	Client *minio.Client
+	Bucket string
+}
+
+// newS3BlobStore connects to an S3-compatible endpoint such as
+// "s3.amazonaws.com" or "localhost:9000".
+func newS3BlobStore(endpoint, region, bucket, accessKey, secretKey string, useSSL bool) (*S3BlobStore, error) {
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(accessKey, secretKey, ""),
+		Secure: useSSL,
+		Region: region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+	return &S3BlobStore{Client: client, Bucket: bucket}, nil
+}
+
+// Put implements BlobStore.
+func (s *S3BlobStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	_, err := s.Client.PutObject(ctx, s.Bucket, key, r, size, minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return fmt.Errorf("failed to upload blob: %w", err)
+	}
+	return nil
+}
+
+// Get implements BlobStore.
+func (s *S3BlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	obj, err := s.Client.GetObject(ctx, s.Bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob: %w", err)
+	}
+	// GetObject is lazy; Stat surfaces a missing key before streaming.
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return nil, ErrBlobNotFound
+		}
+		return nil, fmt.Errorf("failed to open blob: %w", err)
+	}
+	return obj, nil
+}
+
+// Delete implements BlobStore.
+func (s *S3BlobStore) Delete(ctx context.Context, key string) error {
+	if err := s.Client.RemoveObject(ctx, s.Bucket, key, minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+	return nil
+}
+
+// blobStoreFromEnv returns the store selected by BLOB_STORE: "s3" uses the
+// S3_* variables, anything else stores files below BLOB_DIR.
+func blobStoreFromEnv() (BlobStore, error) {
+	if os.Getenv("BLOB_STORE") == "s3" {
+		return newS3BlobStore(
+			os.Getenv("S3_ENDPOINT"),
+			os.Getenv("S3_REGION"),
+			os.Getenv("S3_BUCKET"),
+			os.Getenv("S3_ACCESS_KEY"),
+			os.Getenv("S3_SECRET_KEY"),
+			os.Getenv("S3_USE_SSL") != "false",
+		)
+	}
+	dir := os.Getenv("BLOB_DIR")
+	if dir == "" {
+		dir = defaultBlobDir
+	}
+	return &LocalBlobStore{Dir: dir}, nil
+}
@@ -0,0 +1,508 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Errors returned by BranchService.
+var (
+	ErrBranchNotFound   = errors.New("branch not found")
+	ErrTransferNotFound = errors.New("transfer not found")
+	ErrInvalidInventory = errors.New("invalid branch inventory")
+	ErrInvalidTransfer  = errors.New("invalid transfer")
+	ErrTransferState    = errors.New("transfer cannot move to that status")
+)
+
+// inTransitReturnsSQL sums the copies of the book in the enclosing query
+// that were returned at another branch and are still travelling back to
+// their lending branch. Those copies are neither on loan nor available.
+const inTransitReturnsSQL = `(SELECT COALESCE(SUM(transfer_requests.quantity), 0) FROM transfer_requests
+	WHERE transfer_requests.book_id = books.id AND transfer_requests.tenant_id = books.tenant_id
+	AND transfer_requests.status = 'in_transit' AND transfer_requests.reason = 'return')`
+
+// TransferStatus is a stage in the lifecycle of a TransferRequest.
+type TransferStatus string
+
+// Transfer statuses. Rebalancing transfers move requested → approved →
+// in_transit → received; returns made at another branch start in_transit.
+const (
+	TransferRequested TransferStatus = "requested"
+	TransferApproved  TransferStatus = "approved"
+	TransferInTransit TransferStatus = "in_transit"
+	TransferReceived  TransferStatus = "received"
+	TransferRejected  TransferStatus = "rejected"
+	TransferCancelled TransferStatus = "cancelled"
+)
+
+// TransferReason tells why copies are moving between branches.
+type TransferReason string
+
+// Transfer reasons.
+const (
+	TransferRebalance TransferReason = "rebalance"
+	TransferReturn    TransferReason = "return"
+)
+
+// transferTransitions lists the statuses reachable from each status.
+var transferTransitions = map[TransferStatus][]TransferStatus{
+	TransferRequested: {TransferApproved, TransferRejected, TransferCancelled},
+	TransferApproved:  {TransferInTransit, TransferCancelled},
+	TransferInTransit: {TransferReceived},
+}
+
+// Branch represents a physical library location.
+type Branch struct {
+	ID       uint   `gorm:"primaryKey"`
+	TenantID uint   `gorm:"uniqueIndex:idx_branches_tenant_code;not null;default:0"`
+	Code     string `gorm:"uniqueIndex:idx_branches_tenant_code;not null;size:20"`
+	Name     string `gorm:"not null"`
+	Address  string `gorm:"type:text"`
+}
+
+// BranchInventory holds the copies of a book assigned to a branch.
+// Available counts copies on the branch's shelves.
+type BranchInventory struct {
+	ID        uint `gorm:"primaryKey"`
+	TenantID  uint `gorm:"index;not null;default:0"`
+	BranchID  uint `gorm:"uniqueIndex:idx_branch_book;not null"`
+	Branch    Branch
+	BookID    uint `gorm:"uniqueIndex:idx_branch_book;not null"`
+	Book      Book
+	Copies    int `gorm:"default:0"`
+	Available int `gorm:"default:0"`
+}
+
+// TransferRequest moves copies of a book from one branch to another.
+type TransferRequest struct {
+	ID           uint           `gorm:"primaryKey"`
+	TenantID     uint           `gorm:"index;not null;default:0"`
+	BookID       uint           `gorm:"index;not null"`
+	FromBranchID uint           `gorm:"index;not null"`
+	ToBranchID   uint           `gorm:"index;not null"`
+	Quantity     int            `gorm:"not null;check:quantity > 0"`
+	Reason       TransferReason `gorm:"type:varchar(20);not null"`
+	Status       TransferStatus `gorm:"type:varchar(20);not null;index"`
+	LoanID       *uint
+	RequestedBy  uint
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	ShippedAt    *time.Time
+	ReceivedAt   *time.Time
+}
+
+// canTransition reports whether a transfer may move from one status to another.
+func canTransition(from, to TransferStatus) bool {
+	for _, s := range transferTransitions[from] {
+		if s == to {
+			return true
+		}
+	}
+	return false
+}
+
+// BranchService manages branches, their inventory and transfers between them.
+type BranchService struct {
+	db           *gorm.DB
+	queryTimeout time.Duration
+	cache        *bookCache
+}
+
+// findBranches checks that every branch ID belongs to a branch of the
+// current tenant.
+func findBranches(db *gorm.DB, ids ...uint) error {
+	for _, id := range ids {
+		if err := db.Select("id").First(&Branch{}, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("%w: %d", ErrBranchNotFound, id)
+			}
+			return fmt.Errorf("error finding branch: %w", err)
+		}
+	}
+	return nil
+}
+
+// CreateBranch adds a new branch. Requires PermManageCatalog.
+func (s *BranchService) CreateBranch(ctx context.Context, branch *Branch) error {
+	if _, err := authorize(ctx, PermManageCatalog); err != nil {
+		return err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+	if err := s.db.WithContext(ctx).Create(branch).Error; err != nil {
+		return fmt.Errorf("failed to create branch: %w", err)
+	}
+	return nil
+}
+
+// ListBranches returns all branches by code. Requires PermViewCatalog.
+func (s *BranchService) ListBranches(ctx context.Context) ([]Branch, error) {
+	if _, err := authorize(ctx, PermViewCatalog); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+	var branches []Branch
+	if err := s.db.WithContext(ctx).Order("code").Find(&branches).Error; err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+	return branches, nil
+}
+
+// SetInventory assigns copies of a book to a branch. The copies assigned
+// across all branches may not exceed the book's Copies, and copies that
+// are currently on loan cannot be withdrawn. Requires PermManageCatalog.
+func (s *BranchService) SetInventory(ctx context.Context, branchID, bookID uint, copies int) error {
+	if _, err := authorize(ctx, PermManageCatalog); err != nil {
+		return err
+	}
+	if copies < 0 {
+		return fmt.Errorf("%w: copies cannot be negative", ErrInvalidInventory)
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := findBranches(tx, branchID); err != nil {
+			return err
+		}
+		var book Book
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&book, bookID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("%w: %d", ErrBookNotFound, bookID)
+			}
+			return fmt.Errorf("error finding book: %w", err)
+		}
+		var elsewhere int64
+		if err := tx.Model(&BranchInventory{}).
+			Where("book_id = ? AND branch_id <> ?", bookID, branchID).
+			Select("COALESCE(SUM(copies), 0)").Scan(&elsewhere).Error; err != nil {
+			return fmt.Errorf("failed to sum branch copies: %w", err)
+		}
+		if int(elsewhere)+copies > book.Copies {
+			return fmt.Errorf("%w: branch copies would exceed the book's %d copies", ErrInvalidInventory, book.Copies)
+		}
+
+		inv := BranchInventory{BranchID: branchID, BookID: bookID}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where(BranchInventory{BranchID: branchID, BookID: bookID}).
+			FirstOrCreate(&inv).Error; err != nil {
+			return fmt.Errorf("failed to load branch inventory: %w", err)
+		}
+		available := inv.Available + copies - inv.Copies
+		if available < 0 {
+			return fmt.Errorf("%w: cannot withdraw copies that are on loan", ErrInvalidInventory)
+		}
+		return tx.Model(&inv).Updates(map[string]interface{}{"copies": copies, "available": available}).Error
+	})
+}
+
+// RequestTransfer asks to move quantity copies of a book between branches.
+// Requires PermManageCatalog.
+func (s *BranchService) RequestTransfer(ctx context.Context, bookID, fromBranchID, toBranchID uint, quantity int) (*TransferRequest, error) {
+	u, err := authorize(ctx, PermManageCatalog)
+	if err != nil {
+		return nil, err
+	}
+	if fromBranchID == toBranchID {
+		return nil, fmt.Errorf("%w: source and destination branch must differ", ErrInvalidTransfer)
+	}
+	if quantity <= 0 {
+		return nil, fmt.Errorf("%w: quantity must be positive", ErrInvalidTransfer)
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	db := s.db.WithContext(ctx)
+	if err := findBranches(db, fromBranchID, toBranchID); err != nil {
+		return nil, err
+	}
+	if err := db.Select("id").First(&Book{}, bookID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %d", ErrBookNotFound, bookID)
+		}
+		return nil, fmt.Errorf("error finding book: %w", err)
+	}
+
+	tr := &TransferRequest{
+		BookID:       bookID,
+		FromBranchID: fromBranchID,
+		ToBranchID:   toBranchID,
+		Quantity:     quantity,
+		Reason:       TransferRebalance,
+		Status:       TransferRequested,
+		RequestedBy:  u.ID,
+	}
+	if err := db.Create(tr).Error; err != nil {
+		return nil, fmt.Errorf("failed to request transfer: %w", err)
+	}
+	return tr, nil
+}
+
+// ApproveTransfer approves a requested transfer.
+func (s *BranchService) ApproveTransfer(ctx context.Context, id uint) error {
+	return s.advanceTransfer(ctx, id, TransferApproved, nil)
+}
+
+// RejectTransfer rejects a requested transfer.
+func (s *BranchService) RejectTransfer(ctx context.Context, id uint) error {
+	return s.advanceTransfer(ctx, id, TransferRejected, nil)
+}
+
+// CancelTransfer cancels a transfer that has not been shipped yet.
+func (s *BranchService) CancelTransfer(ctx context.Context, id uint) error {
+	return s.advanceTransfer(ctx, id, TransferCancelled, nil)
+}
+
+// ShipTransfer sends the copies of an approved transfer, taking them off
+// the source branch's shelves.
+func (s *BranchService) ShipTransfer(ctx context.Context, id uint) error {
+	return s.advanceTransfer(ctx, id, TransferInTransit, func(tx *gorm.DB, tr *TransferRequest) error {
+		res := tx.Model(&BranchInventory{}).
+			Where("branch_id = ? AND book_id = ? AND available >= ?", tr.FromBranchID, tr.BookID, tr.Quantity).
+			Updates(map[string]interface{}{
+				"copies":    gorm.Expr("copies - ?", tr.Quantity),
+				"available": gorm.Expr("available - ?", tr.Quantity),
+			})
+		if res.Error != nil {
+			return fmt.Errorf("failed to take copies off the shelf: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return fmt.Errorf("%w: not enough available copies at the source branch", ErrInvalidTransfer)
+		}
+		now := time.Now()
+		tr.ShippedAt = &now
+		return nil
+	})
+}
+
+// ReceiveTransfer shelves the copies of an in-transit transfer at the
+// destination branch. Copies returned from a loan at another branch only
+// become available again, at the branch and in the book's Available count;
+// rebalanced copies also join the branch's stock.
+func (s *BranchService) ReceiveTransfer(ctx context.Context, id uint) error {
+	var bookID uint
+	err := s.advanceTransfer(ctx, id, TransferReceived, func(tx *gorm.DB, tr *TransferRequest) error {
+		bookID = tr.BookID
+		inv := BranchInventory{BranchID: tr.ToBranchID, BookID: tr.BookID}
+		if err := tx.Where(BranchInventory{BranchID: tr.ToBranchID, BookID: tr.BookID}).FirstOrCreate(&inv).Error; err != nil {
+			return fmt.Errorf("failed to load branch inventory: %w", err)
+		}
+		updates := map[string]interface{}{"available": gorm.Expr("available + ?", tr.Quantity)}
+		if tr.Reason == TransferRebalance {
+			updates["copies"] = gorm.Expr("copies + ?", tr.Quantity)
+		}
+		if err := tx.Model(&inv).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to shelve copies: %w", err)
+		}
+		if tr.Reason == TransferReturn {
+			if err := tx.Model(&Book{}).Where("id = ?", tr.BookID).
+				UpdateColumn("available", gorm.Expr("available + ?", tr.Quantity)).Error; err != nil {
+				return fmt.Errorf("failed to make returned copies available: %w", err)
+			}
+		}
+		now := time.Now()
+		tr.ReceivedAt = &now
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.cache.invalidateBookIDs(ctx, s.db, bookID)
+	return nil
+}
+
+// ListTransfers returns the transfers touching a branch, optionally
+// filtered by status, most recent first.
+func (s *BranchService) ListTransfers(ctx context.Context, branchID uint, status TransferStatus) ([]TransferRequest, error) {
+	if _, err := authorize(ctx, PermManageCatalog); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	q := s.db.WithContext(ctx).Where("from_branch_id = ? OR to_branch_id = ?", branchID, branchID)
+	if status != "" {
+		q = q.Where("status = ?", status)
+	}
+	var transfers []TransferRequest
+	if err := q.Order("created_at DESC").Find(&transfers).Error; err != nil {
+		return nil, fmt.Errorf("failed to list transfers: %w", err)
+	}
+	return transfers, nil
+}
+
+// advanceTransfer moves a transfer to status `to` in a transaction, running
+// apply (if any) to perform the inventory side effects of the transition.
+func (s *BranchService) advanceTransfer(ctx context.Context, id uint, to TransferStatus, apply func(tx *gorm.DB, tr *TransferRequest) error) error {
+	if _, err := authorize(ctx, PermManageCatalog); err != nil {
+		return err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var tr TransferRequest
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&tr, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return fmt.Errorf("%w: %d", ErrTransferNotFound, id)
+			}
+			return fmt.Errorf("error finding transfer: %w", err)
+		}
+		if !canTransition(tr.Status, to) {
+			return fmt.Errorf("%w: cannot move transfer from %s to %s", ErrTransferState, tr.Status, to)
+		}
+		if apply != nil {
+			if err := apply(tx, &tr); err != nil {
+				return err
+			}
+		}
+		tr.Status = to
+		return tx.Save(&tr).Error
+	})
+}
+
+// writeBranchError maps branch and transfer errors to HTTP statuses.
+func writeBranchError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrBranchNotFound), errors.Is(err, ErrTransferNotFound), errors.Is(err, ErrBookNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrTransferState):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrInvalidInventory), errors.Is(err, ErrInvalidTransfer):
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	default:
+		writeError(w, err)
+	}
+}
+
+// pathUint parses the named path value, writing 400 when it is invalid.
+func pathUint(w http.ResponseWriter, r *http.Request, name string) (uint, bool) {
+	id, err := strconv.ParseUint(r.PathValue(name), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid " + name})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// handleCreateBranch serves POST /branches.
+func (s *apiServer) handleCreateBranch(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code    string `json:"code"`
+		Name    string `json:"name"`
+		Address string `json:"address"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Code == "" || req.Name == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "branch code and name are required"})
+		return
+	}
+	branch := &Branch{Code: req.Code, Name: req.Name, Address: req.Address}
+	if err := s.branches.CreateBranch(r.Context(), branch); err != nil {
+		writeBranchError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, branch)
+}
+
+// handleListBranches serves GET /branches.
+func (s *apiServer) handleListBranches(w http.ResponseWriter, r *http.Request) {
+	branches, err := s.branches.ListBranches(r.Context())
+	if err != nil {
+		writeBranchError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, branches)
+}
+
+// handleSetBranchInventory serves PUT /branches/{id}/inventory/{book_id}.
+func (s *apiServer) handleSetBranchInventory(w http.ResponseWriter, r *http.Request) {
+	branchID, ok := pathUint(w, r, "id")
+	if !ok {
+		return
+	}
+	bookID, ok := pathUint(w, r, "book_id")
+	if !ok {
+		return
+	}
+	var req struct {
+		Copies int `json:"copies"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid inventory"})
+		return
+	}
+	if err := s.branches.SetInventory(r.Context(), branchID, bookID, req.Copies); err != nil {
+		writeBranchError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListBranchTransfers serves GET /branches/{id}/transfers?status=.
+func (s *apiServer) handleListBranchTransfers(w http.ResponseWriter, r *http.Request) {
+	branchID, ok := pathUint(w, r, "id")
+	if !ok {
+		return
+	}
+	transfers, err := s.branches.ListTransfers(r.Context(), branchID, TransferStatus(r.URL.Query().Get("status")))
+	if err != nil {
+		writeBranchError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, transfers)
+}
+
+// handleRequestTransfer serves POST /transfers.
+func (s *apiServer) handleRequestTransfer(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		BookID       uint `json:"book_id"`
+		FromBranchID uint `json:"from_branch_id"`
+		ToBranchID   uint `json:"to_branch_id"`
+		Quantity     int  `json:"quantity"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid transfer"})
+		return
+	}
+	tr, err := s.branches.RequestTransfer(r.Context(), req.BookID, req.FromBranchID, req.ToBranchID, req.Quantity)
+	if err != nil {
+		writeBranchError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, tr)
+}
+
+// handleAdvanceTransfer serves POST /transfers/{id}/{action}, where action
+// is approve, reject, cancel, ship or receive.
+func (s *apiServer) handleAdvanceTransfer(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathUint(w, r, "id")
+	if !ok {
+		return
+	}
+	actions := map[string]func(context.Context, uint) error{
+		"approve": s.branches.ApproveTransfer,
+		"reject":  s.branches.RejectTransfer,
+		"cancel":  s.branches.CancelTransfer,
+		"ship":    s.branches.ShipTransfer,
+		"receive": s.branches.ReceiveTransfer,
+	}
+	action, ok := actions[r.PathValue("action")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "unknown transfer action"})
+		return
+	}
+	if err := action(r.Context(), id); err != nil {
+		writeBranchError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
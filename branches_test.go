@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// mustCreateBranch creates a branch with a unique code derived from prefix.
+func mustCreateBranch(t *testing.T, db *gorm.DB, prefix string) *Branch {
+	t.Helper()
+	b := &Branch{Code: fmt.Sprintf("%s-%d", prefix, time.Now().UnixNano()%1e9), Name: prefix + " Branch"}
+	if err := db.Create(b).Error; err != nil {
+		t.Fatalf("failed to create branch: %v", err)
+	}
+	return b
+}
+
+// branchAvailable returns the shelf-available copies of a book at a branch.
+func branchAvailable(t *testing.T, db *gorm.DB, branchID, bookID uint) (copies, available int) {
+	t.Helper()
+	var inv BranchInventory
+	if err := db.Where("branch_id = ? AND book_id = ?", branchID, bookID).First(&inv).Error; err != nil {
+		t.Fatalf("failed to fetch branch inventory: %v", err)
+	}
+	return inv.Copies, inv.Available
+}
+
+// TestCanTransition tests the transfer lifecycle state machine.
+func TestCanTransition(t *testing.T) {
+	cases := []struct {
+		from, to TransferStatus
+		want     bool
+	}{
+		{TransferRequested, TransferApproved, true},
+		{TransferRequested, TransferInTransit, false},
+		{TransferApproved, TransferInTransit, true},
+		{TransferInTransit, TransferReceived, true},
+		{TransferInTransit, TransferCancelled, false},
+		{TransferReceived, TransferRequested, false},
+	}
+	for _, c := range cases {
+		if got := canTransition(c.from, c.to); got != c.want {
+			t.Errorf("%s -> %s: got %v want %v", c.from, c.to, got, c.want)
+		}
+	}
+}
+
+// TestBranch_SetInventoryLimitedByCopies tests that branches cannot hold more copies than the book has.
+func TestBranch_SetInventoryLimitedByCopies(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	svc := &BranchService{db: db}
+	ctx := asRole(RoleLibrarian)
+
+	book := &Book{ISBN: "9781616161616", Title: "Split Stock", Copies: 3}
+	mustCreateBook(t, db, book)
+	north := mustCreateBranch(t, db, "north")
+	south := mustCreateBranch(t, db, "south")
+
+	if err := svc.SetInventory(ctx, north.ID, book.ID, 2); err != nil {
+		t.Fatalf("SetInventory north: %v", err)
+	}
+	if err := svc.SetInventory(ctx, south.ID, book.ID, 2); err == nil {
+		t.Fatalf("expected error assigning more copies than the book has")
+	}
+	if err := svc.SetInventory(ctx, south.ID, book.ID, 1); err != nil {
+		t.Fatalf("SetInventory south: %v", err)
+	}
+}
+
+// TestBranch_ReturnElsewhereGoesInTransit tests checkout at one branch and return at another.
+func TestBranch_ReturnElsewhereGoesInTransit(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	branches := &BranchService{db: db}
+	loans := &LoanService{db: db}
+	ctx := asRole(RoleLibrarian)
+
+	book := &Book{ISBN: "9781717171717", Title: "Wanderer", Copies: 1}
+	mustCreateBook(t, db, book)
+	home := mustCreateBranch(t, db, "home")
+	away := mustCreateBranch(t, db, "away")
+	if err := branches.SetInventory(ctx, home.ID, book.ID, 1); err != nil {
+		t.Fatalf("SetInventory: %v", err)
+	}
+
+	member := mustCreateMember(t, db)
+	if _, err := loans.CheckoutBookAt(ctx, away.ID, member.ID, book.ID, time.Now().Add(24*time.Hour)); err == nil {
+		t.Fatalf("expected checkout to fail at a branch without stock")
+	}
+	loan, err := loans.CheckoutBookAt(ctx, home.ID, member.ID, book.ID, time.Now().Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("CheckoutBookAt: %v", err)
+	}
+	if _, avail := branchAvailable(t, db, home.ID, book.ID); avail != 0 {
+		t.Errorf("home branch should have 0 available after checkout, got %d", avail)
+	}
+
+	if err := loans.ReturnBookAt(ctx, loan.ID, away.ID); err != nil {
+		t.Fatalf("ReturnBookAt: %v", err)
+	}
+	if _, avail := branchAvailable(t, db, home.ID, book.ID); avail != 0 {
+		t.Errorf("copy should be in transit, home available got %d", avail)
+	}
+	var got Book
+	db.First(&got, book.ID)
+	if got.Available != 0 {
+		t.Errorf("a copy in transit should not be available to lend, got %d", got.Available)
+	}
+	if report, err := (&Reconciler{db: db}).ReconcileTenant(context.Background(), false); err != nil || len(report.Discrepancies) != 0 {
+		t.Errorf("a copy in transit should not be reported as drift, got %+v, %v", report, err)
+	}
+
+	var tr TransferRequest
+	if err := db.Where("loan_id = ?", loan.ID).First(&tr).Error; err != nil {
+		t.Fatalf("expected in-transit transfer for the return: %v", err)
+	}
+	if tr.Status != TransferInTransit || tr.FromBranchID != away.ID || tr.ToBranchID != home.ID {
+		t.Errorf("unexpected transfer: %+v", tr)
+	}
+
+	if err := branches.ReceiveTransfer(ctx, tr.ID); err != nil {
+		t.Fatalf("ReceiveTransfer: %v", err)
+	}
+	if copies, avail := branchAvailable(t, db, home.ID, book.ID); copies != 1 || avail != 1 {
+		t.Errorf("home branch should have 1/1 after receiving, got %d/%d", copies, avail)
+	}
+	db.First(&got, book.ID)
+	if got.Available != 1 {
+		t.Errorf("the received copy should be available again, got %d", got.Available)
+	}
+}
+
+// TestBranch_UnknownIDs tests that inventory, transfers and returns refuse
+// branches and books that do not exist in the tenant.
+func TestBranch_UnknownIDs(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenants := &TenantService{db: db}
+	other := withTenant(asRole(RoleLibrarian), mustCreateTenant(t, tenants, "other").ID)
+	ctx := withTenant(asRole(RoleLibrarian), mustCreateTenant(t, tenants, "branches").ID)
+	tdb := db.WithContext(ctx)
+	branches := &BranchService{db: db}
+
+	book := &Book{Copies: 2}
+	mustCreateBook(t, tdb, book)
+	here := mustCreateBranch(t, tdb, "here")
+	foreign := mustCreateBranch(t, db.WithContext(other), "foreign")
+
+	if err := branches.SetInventory(ctx, foreign.ID, book.ID, 1); !errors.Is(err, ErrBranchNotFound) {
+		t.Errorf("expected ErrBranchNotFound for another tenant's branch, got %v", err)
+	}
+	if err := branches.SetInventory(ctx, here.ID, 999999, 1); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("expected ErrBookNotFound, got %v", err)
+	}
+	if _, err := branches.RequestTransfer(ctx, book.ID, here.ID, foreign.ID, 1); !errors.Is(err, ErrBranchNotFound) {
+		t.Errorf("expected ErrBranchNotFound for a transfer to another tenant, got %v", err)
+	}
+	if _, err := branches.RequestTransfer(ctx, 999999, here.ID, mustCreateBranch(t, tdb, "there").ID, 1); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("expected ErrBookNotFound, got %v", err)
+	}
+	loan := mustCreateLoan(t, tdb, &BookLoan{BookID: book.ID})
+	if err := (&LoanService{db: db}).ReturnBookAt(ctx, loan.ID, foreign.ID); !errors.Is(err, ErrBranchNotFound) {
+		t.Errorf("expected ErrBranchNotFound when returning at another tenant's branch, got %v", err)
+	}
+}
+
+// TestHandleAdvanceTransfer_BadRequests tests the requests rejected before
+// reaching the service.
+func TestHandleAdvanceTransfer_BadRequests(t *testing.T) {
+	s := &apiServer{branches: &BranchService{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /transfers/{id}/{action}", s.handleAdvanceTransfer)
+	for path, want := range map[string]int{
+		"/transfers/abc/ship": http.StatusBadRequest,
+		"/transfers/1/lose":   http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		if rec.Code != want {
+			t.Errorf("POST %s = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+// TestWriteBranchError tests the mapping of branch errors to HTTP statuses.
+func TestWriteBranchError(t *testing.T) {
+	tests := map[error]int{
+		fmt.Errorf("%w: 7", ErrBranchNotFound):                           http.StatusNotFound,
+		fmt.Errorf("%w: 7", ErrTransferNotFound):                         http.StatusNotFound,
+		fmt.Errorf("%w: from received", ErrTransferState):                http.StatusConflict,
+		fmt.Errorf("%w: copies cannot be negative", ErrInvalidInventory): http.StatusUnprocessableEntity,
+		fmt.Errorf("%w: quantity must be positive", ErrInvalidTransfer):  http.StatusUnprocessableEntity,
+		errors.New("connection refused"):                                 http.StatusInternalServerError,
+	}
+	for err, want := range tests {
+		rec := httptest.NewRecorder()
+		writeBranchError(rec, err)
+		if rec.Code != want {
+			t.Errorf("writeBranchError(%v) = %d, want %d", err, rec.Code, want)
+		}
+	}
+}
+
+// TestBranch_TransferLifecycle tests a rebalancing transfer from request to receipt.
+func TestBranch_TransferLifecycle(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	svc := &BranchService{db: db}
+	ctx := asRole(RoleLibrarian)
+
+	book := &Book{ISBN: "9781818181818", Title: "Mover", Copies: 4}
+	mustCreateBook(t, db, book)
+	from := mustCreateBranch(t, db, "from")
+	to := mustCreateBranch(t, db, "to")
+	if err := svc.SetInventory(ctx, from.ID, book.ID, 4); err != nil {
+		t.Fatalf("SetInventory: %v", err)
+	}
+
+	tr, err := svc.RequestTransfer(ctx, book.ID, from.ID, to.ID, 3)
+	if err != nil {
+		t.Fatalf("RequestTransfer: %v", err)
+	}
+	if err := svc.ShipTransfer(ctx, tr.ID); err == nil {
+		t.Fatalf("expected shipping an unapproved transfer to fail")
+	}
+	if err := svc.ApproveTransfer(ctx, tr.ID); err != nil {
+		t.Fatalf("ApproveTransfer: %v", err)
+	}
+	if err := svc.ShipTransfer(ctx, tr.ID); err != nil {
+		t.Fatalf("ShipTransfer: %v", err)
+	}
+	if copies, avail := branchAvailable(t, db, from.ID, book.ID); copies != 1 || avail != 1 {
+		t.Errorf("source should have 1/1 after shipping, got %d/%d", copies, avail)
+	}
+	if err := svc.ReceiveTransfer(ctx, tr.ID); err != nil {
+		t.Fatalf("ReceiveTransfer: %v", err)
+	}
+	if copies, avail := branchAvailable(t, db, to.ID, book.ID); copies != 3 || avail != 3 {
+		t.Errorf("destination should have 3/3 after receiving, got %d/%d", copies, avail)
+	}
+
+	transfers, err := svc.ListTransfers(ctx, to.ID, TransferReceived)
+	if err != nil || len(transfers) != 1 {
+		t.Errorf("ListTransfers: got %d transfers, err %v", len(transfers), err)
+	}
+	if _, err := svc.ListTransfers(context.Background(), to.ID, ""); err == nil {
+		t.Errorf("expected anonymous ListTransfers to fail")
+	}
+}
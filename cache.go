@@ -0,0 +1,282 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// Cache defaults used when the corresponding CACHE_* variable is unset.
+const (
+	defaultCacheSize = 10000
+	defaultCacheTTL  = 5 * time.Minute
+)
+
+// Cache is a key/value store with per-entry expiry. Get reports a missing
+// or expired key as a miss, not as an error.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, keys ...string) error
+}
+
+// LRUCache is an in-process Cache holding at most capacity entries; the
+// least recently used entry is evicted first.
+type LRUCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // front is most recently used
+	items    map[string]*list.Element
+}
+
+// lruEntry is an entry of an LRUCache.
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// newLRUCache returns an empty LRUCache with the given capacity.
+func newLRUCache(capacity int) *LRUCache {
+	return &LRUCache{capacity: capacity, order: list.New(), items: map[string]*list.Element{}}
+}
+
+// Get implements Cache.
+func (c *LRUCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	e := el.Value.(*lruEntry)
+	if time.Now().After(e.expires) {
+		c.order.Remove(el)
+		delete(c.items, key)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(el)
+	return e.value, true, nil
+}
+
+// Set implements Cache.
+func (c *LRUCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().Add(ttl)
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*lruEntry)
+		e.value, e.expires = value, expires
+		c.order.MoveToFront(el)
+		return nil
+	}
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+	return nil
+}
+
+// Delete implements Cache.
+func (c *LRUCache) Delete(_ context.Context, keys ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range keys {
+		if el, ok := c.items[key]; ok {
+			c.order.Remove(el)
+			delete(c.items, key)
+		}
+	}
+	return nil
+}
+
+// RedisCache is a Cache backed by Redis or a Redis-compatible server.
+// Prefix is prepended to every key so that several applications can share
+// one server.
+type RedisCache struct {
+	Client *redis.Client
+	Prefix string
+}
+
+// Get implements Cache.
+func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	b, err := c.Client.Get(ctx, c.Prefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read from cache: %w", err)
+	}
+	return b, true, nil
+}
+
+// Set implements Cache.
+func (c *RedisCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := c.Client.Set(ctx, c.Prefix+key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to write to cache: %w", err)
+	}
+	return nil
+}
+
+// Delete implements Cache.
+func (c *RedisCache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	prefixed := make([]string, len(keys))
+	for i, k := range keys {
+		prefixed[i] = c.Prefix + k
+	}
+	if err := c.Client.Del(ctx, prefixed...).Err(); err != nil {
+		return fmt.Errorf("failed to delete from cache: %w", err)
+	}
+	return nil
+}
+
+// cacheRequests counts book cache lookups by result ("hit" or "miss").
+var cacheRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "cache_requests_total",
+	Help:      "Number of book cache lookups by result.",
+}, []string{"result"})
+
+// bookCache caches FindBook and ListBooks results per tenant. A nil
+// *bookCache caches nothing. Cache failures are logged and treated as
+// misses so that lookups fall back to the database.
+//
+// Each book is cached under its ISBN. Book lists are cached under a
+// per-tenant generation; invalidating any book starts a new generation,
+// which orphans every cached list of the tenant until it expires.
+type bookCache struct {
+	cache Cache
+	ttl   time.Duration
+}
+
+// bookKey is the cache key of the book with the given ISBN.
+func bookKey(ctx context.Context, isbn string) string {
+	return fmt.Sprintf("tenants/%d/books/isbn/%s", tenantFromContext(ctx), isbn)
+}
+
+// listGenerationKey is the cache key of the tenant's book list generation.
+func listGenerationKey(ctx context.Context) string {
+	return fmt.Sprintf("tenants/%d/books/generation", tenantFromContext(ctx))
+}
+
+// get decodes the cached value of key into v and reports whether it was found.
+func (c *bookCache) get(ctx context.Context, key string, v any) bool {
+	if c == nil {
+		return false
+	}
+	b, ok, err := c.cache.Get(ctx, key)
+	if err == nil && ok {
+		err = json.Unmarshal(b, v)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "book cache read failed", "key", key, "error", err)
+		ok = false
+	}
+	if ok {
+		cacheRequests.WithLabelValues("hit").Inc()
+	} else {
+		cacheRequests.WithLabelValues("miss").Inc()
+	}
+	return ok
+}
+
+// set caches v under key.
+func (c *bookCache) set(ctx context.Context, key string, v any) {
+	if c == nil {
+		return
+	}
+	b, err := json.Marshal(v)
+	if err == nil {
+		err = c.cache.Set(ctx, key, b, c.ttl)
+	}
+	if err != nil {
+		slog.WarnContext(ctx, "book cache write failed", "key", key, "error", err)
+	}
+}
+
+// listKey returns the cache key of a page of the tenant's book list.
+func (c *bookCache) listKey(ctx context.Context, offset, limit int) string {
+	if c == nil {
+		return ""
+	}
+	key := listGenerationKey(ctx)
+	gen, ok, err := c.cache.Get(ctx, key)
+	if err != nil || !ok {
+		gen = []byte(strconv.FormatInt(time.Now().UnixNano(), 36))
+		if err := c.cache.Set(ctx, key, gen, c.ttl); err != nil {
+			slog.WarnContext(ctx, "book cache write failed", "key", key, "error", err)
+		}
+	}
+	return fmt.Sprintf("tenants/%d/books/list/%s/%d/%d", tenantFromContext(ctx), gen, offset, limit)
+}
+
+// invalidate drops the cached books with the given ISBNs and every cached
+// book list of the tenant of ctx.
+func (c *bookCache) invalidate(ctx context.Context, isbns ...string) {
+	if c == nil {
+		return
+	}
+	keys := []string{listGenerationKey(ctx)}
+	for _, isbn := range isbns {
+		keys = append(keys, bookKey(ctx, isbn))
+	}
+	if err := c.cache.Delete(ctx, keys...); err != nil {
+		slog.WarnContext(ctx, "book cache invalidation failed", "error", err)
+	}
+}
+
+// invalidateBookIDs is like invalidate for books given by ID, as known to
+// loans.
+func (c *bookCache) invalidateBookIDs(ctx context.Context, db *gorm.DB, ids ...uint) {
+	if c == nil {
+		return
+	}
+	var isbns []string
+	if err := db.WithContext(ctx).Model(&Book{}).Where("id IN ?", ids).Pluck("isbn", &isbns).Error; err != nil {
+		slog.WarnContext(ctx, "book cache invalidation failed", "error", err)
+	}
+	c.invalidate(ctx, isbns...)
+}
+
+// bookCacheFromEnv returns the book cache selected by CACHE_BACKEND
+// ("memory" or "redis"), or nil when caching is disabled.
+func bookCacheFromEnv() (*bookCache, error) {
+	ttl := defaultCacheTTL
+	if d, err := time.ParseDuration(os.Getenv("CACHE_TTL")); err == nil && d > 0 {
+		ttl = d
+	}
+	switch backend := os.Getenv("CACHE_BACKEND"); backend {
+	case "":
+		return nil, nil
+	case "memory":
+		size := defaultCacheSize
+		if n, err := strconv.Atoi(os.Getenv("CACHE_SIZE")); err == nil && n > 0 {
+			size = n
+		}
+		return &bookCache{cache: newLRUCache(size), ttl: ttl}, nil
+	case "redis":
+		opts, err := redis.ParseURL(os.Getenv("REDIS_URL"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid REDIS_URL: %w", err)
+		}
+		return &bookCache{cache: &RedisCache{Client: redis.NewClient(opts), Prefix: "library:"}, ttl: ttl}, nil
+	default:
+		return nil, fmt.Errorf("unknown CACHE_BACKEND %q", backend)
+	}
+}
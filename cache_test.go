@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// testCache runs the behaviour every Cache must share against c.
+func testCache(t *testing.T, c Cache) {
+	t.Helper()
+	ctx := context.Background()
+	if _, ok, err := c.Get(ctx, "missing"); ok || err != nil {
+		t.Errorf("Get(missing) = %v, %v", ok, err)
+	}
+	if err := c.Set(ctx, "a", []byte("1"), time.Minute); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if b, ok, err := c.Get(ctx, "a"); !ok || err != nil || string(b) != "1" {
+		t.Errorf("Get(a) = %q, %v, %v", b, ok, err)
+	}
+	if err := c.Delete(ctx, "a", "missing"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok, _ := c.Get(ctx, "a"); ok {
+		t.Errorf("deleted key still cached")
+	}
+}
+
+// TestLRUCache tests eviction of the least recently used entry and expiry.
+func TestLRUCache(t *testing.T) {
+	testCache(t, newLRUCache(10))
+
+	ctx := context.Background()
+	c := newLRUCache(2)
+	c.Set(ctx, "a", []byte("a"), time.Minute)
+	c.Set(ctx, "b", []byte("b"), time.Minute)
+	c.Get(ctx, "a")
+	c.Set(ctx, "c", []byte("c"), time.Minute)
+	if _, ok, _ := c.Get(ctx, "b"); ok {
+		t.Errorf("least recently used entry should be evicted")
+	}
+	if _, ok, _ := c.Get(ctx, "a"); !ok {
+		t.Errorf("recently used entry should be kept")
+	}
+
+	c.Set(ctx, "short", []byte("x"), -time.Second)
+	if _, ok, _ := c.Get(ctx, "short"); ok {
+		t.Errorf("expired entry should be a miss")
+	}
+}
+
+// TestRedisCache tests the Redis cache against an in-memory Redis server.
+func TestRedisCache(t *testing.T) {
+	srv := miniredis.RunT(t)
+	c := &RedisCache{Client: redis.NewClient(&redis.Options{Addr: srv.Addr()}), Prefix: "test:"}
+	testCache(t, c)
+
+	c.Set(context.Background(), "ttl", []byte("x"), time.Minute)
+	if !srv.Exists("test:ttl") || srv.TTL("test:ttl") != time.Minute {
+		t.Errorf("expected prefixed key with TTL, got ttl %v", srv.TTL("test:ttl"))
+	}
+}
+
+// TestBookCache_Invalidate tests that invalidation drops the book and the
+// tenant's lists, but not other tenants' lists.
+func TestBookCache_Invalidate(t *testing.T) {
+	c := &bookCache{cache: newLRUCache(100), ttl: time.Minute}
+	ctx := withTenant(context.Background(), 1)
+	other := withTenant(context.Background(), 2)
+
+	c.set(ctx, bookKey(ctx, "9780000000001"), Book{Title: "Cached"})
+	listKey, otherKey := c.listKey(ctx, 0, 50), c.listKey(other, 0, 50)
+	if listKey != c.listKey(ctx, 0, 50) {
+		t.Fatalf("list key should be stable until invalidated")
+	}
+	var b Book
+	if !c.get(ctx, bookKey(ctx, "9780000000001"), &b) || b.Title != "Cached" {
+		t.Fatalf("expected a cached book, got %+v", b)
+	}
+	if c.get(other, bookKey(other, "9780000000001"), &b) {
+		t.Errorf("books must not be shared between tenants")
+	}
+
+	c.invalidate(ctx, "9780000000001")
+	if c.get(ctx, bookKey(ctx, "9780000000001"), &b) {
+		t.Errorf("book should be invalidated")
+	}
+	if c.listKey(ctx, 0, 50) == listKey {
+		t.Errorf("invalidation should start a new list generation")
+	}
+	if c.listKey(other, 0, 50) != otherKey {
+		t.Errorf("other tenants' lists should be kept")
+	}
+
+	var nilCache *bookCache
+	nilCache.invalidate(ctx, "x")
+	if nilCache.get(ctx, "x", &b) {
+		t.Errorf("a nil cache caches nothing")
+	}
+}
+
+// TestBookService_Cache tests that lookups are served from the cache and
+// refreshed after catalogue and loan changes.
+func TestBookService_Cache(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenant := mustCreateTenant(t, &TenantService{db: db}, "cache")
+	ctx := withTenant(asRole(RoleLibrarian), tenant.ID)
+	cache := &bookCache{cache: newLRUCache(100), ttl: time.Minute}
+	books := &BookService{db: db, cache: cache}
+	loans := &LoanService{db: db, cache: cache}
+
+	book := &Book{ISBN: "9784545454545", Title: "Cached", Copies: 2}
+	if err := books.AddBook(ctx, book); err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	if list, err := books.ListBooks(ctx, 0, 0); err != nil || len(list) != 1 {
+		t.Fatalf("ListBooks = %d books, %v", len(list), err)
+	}
+	if _, err := books.FindBook(ctx, book.ISBN); err != nil {
+		t.Fatalf("FindBook: %v", err)
+	}
+
+	// A change behind the service's back is not seen until invalidation.
+	db.WithContext(ctx).Model(&Book{}).Where("id = ?", book.ID).UpdateColumn("title", "Renamed")
+	if got, _ := books.FindBook(ctx, book.ISBN); got.Title != "Cached" {
+		t.Errorf("expected the cached title, got %q", got.Title)
+	}
+
+	member := mustCreateMember(t, db.WithContext(ctx))
+	if _, err := loans.CheckoutBook(ctx, member.ID, book.ID, time.Now().Add(24*time.Hour)); err != nil {
+		t.Fatalf("CheckoutBook: %v", err)
+	}
+	got, err := books.FindBook(ctx, book.ISBN)
+	if err != nil || got.Available != 1 || got.Title != "Renamed" {
+		t.Errorf("checkout should invalidate the book: %+v, %v", got, err)
+	}
+
+	if err := books.UpdateBookCopies(ctx, book.ISBN, 5); err != nil {
+		t.Fatalf("UpdateBookCopies: %v", err)
+	}
+	if list, _ := books.ListBooks(ctx, 0, 0); len(list) != 1 || list[0].Copies != 5 {
+		t.Errorf("list should reflect new copies: %+v", list)
+	}
+
+	unloved := &Book{ISBN: "9784646464646", Title: "Unloved", Copies: 1}
+	if err := books.AddBook(ctx, unloved); err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	if _, err := books.FindBook(ctx, unloved.ISBN); err != nil {
+		t.Fatalf("FindBook: %v", err)
+	}
+	if err := books.RemoveBook(ctx, unloved.ISBN); err != nil {
+		t.Fatalf("RemoveBook: %v", err)
+	}
+	if _, err := books.FindBook(ctx, unloved.ISBN); !errors.Is(err, ErrBookNotFound) {
+		t.Errorf("expected ErrBookNotFound after removal, got %v", err)
+	}
+}
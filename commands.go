@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// commandUsage lists the subcommands accepted on the command line.
+const commandUsage = `usage:
+  library                      run the server
+  library job list             list maintenance jobs
+  library job run <name>       run a job now
+  library job history <name>   show recent runs of a job
+  library seed [flags]         generate a sample dataset (seed -h for flags)
+  library reconcile [-fix]     check available copies against open loans
+  library tenant create [-admin <username>] <slug> <name>
+                               register a library (admin password from TENANT_ADMIN_PASSWORD)
+  library tenant list          list registered libraries`
+
+// runCommand executes the command-line subcommand in args and writes its
+// output to out.
+func runCommand(ctx context.Context, db *gorm.DB, scheduler *Scheduler, args []string, out io.Writer) error {
+	switch args[0] {
+	case "job":
+		return runJobCommand(ctx, scheduler, args[1:], out)
+	case "seed":
+		return runSeedCommand(ctx, db, args[1:], out)
+	case "reconcile":
+		return runReconcileCommand(ctx, db, args[1:], out)
+	case "tenant":
+		return runTenantCommand(ctx, db, args[1:], out)
+	case "help", "-h", "--help":
+		fmt.Fprintln(out, commandUsage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], commandUsage)
+	}
+}
+
+// runJobCommand implements "job list", "job run" and "job history".
+func runJobCommand(ctx context.Context, scheduler *Scheduler, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing job subcommand\n%s", commandUsage)
+	}
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	defer tw.Flush()
+
+	switch args[0] {
+	case "list":
+		fmt.Fprintln(tw, "JOB\tSCHEDULE\tNEXT RUN")
+		for _, job := range scheduler.Jobs() {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", job.Name, job.Schedule, job.schedule.Next(time.Now()).Format(time.RFC3339))
+		}
+		return nil
+	case "run":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: job run <name>")
+		}
+		run, err := scheduler.RunJob(ctx, args[1])
+		if run != nil {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", run.Job, run.Status, run.FinishedAt.Sub(run.StartedAt))
+		}
+		return err
+	case "history":
+		if len(args) < 2 || len(args) > 3 {
+			return fmt.Errorf("usage: job history <name> [limit]")
+		}
+		limit := 20
+		if len(args) == 3 {
+			n, err := strconv.Atoi(args[2])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid limit %q", args[2])
+			}
+			limit = n
+		}
+		runs, err := scheduler.History(ctx, args[1], limit)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(tw, "STARTED\tTRIGGER\tSTATUS\tDURATION\tERROR")
+		for _, r := range runs {
+			duration := "-"
+			if r.FinishedAt != nil {
+				duration = r.FinishedAt.Sub(r.StartedAt).String()
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.StartedAt.Format(time.RFC3339), r.Trigger, r.Status, duration, r.Error)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown job subcommand %q\n%s", args[0], commandUsage)
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BookFormat is the medium a book is lent in.
+type BookFormat string
+
+// Book formats. Print books are lent from Copies/Available; digital formats
+// are lent from license pools.
+const (
+	FormatPrint     BookFormat = "print"
+	FormatEbook     BookFormat = "ebook"
+	FormatAudiobook BookFormat = "audiobook"
+)
+
+// valid reports whether f is a known format. The empty format stands for
+// FormatPrint, the column default.
+func (f BookFormat) valid() bool {
+	switch f {
+	case "", FormatPrint, FormatEbook, FormatAudiobook:
+		return true
+	}
+	return false
+}
+
+// digital reports whether books of format f are lent from license pools.
+func (f BookFormat) digital() bool {
+	return f == FormatEbook || f == FormatAudiobook
+}
+
+// ErrNoLicenseAvailable is returned when every license of a digital book is
+// in use or expired.
+var ErrNoLicenseAvailable = errors.New("no license available")
+
+// LicensePool is a batch of licenses for a digital book bought from a
+// provider. Concurrent is the number of members who may borrow the book at
+// the same time and Available the number of licenses not currently lent.
+// A pool with ExpiresAt set cannot be lent from after that time.
+type LicensePool struct {
+	ID         uint       `gorm:"primaryKey"`
+	TenantID   uint       `gorm:"index;not null;default:0"`
+	BookID     uint       `gorm:"index;not null"`
+	Provider   string     `gorm:"size:100"`
+	Concurrent int        `gorm:"not null;check:concurrent > 0"`
+	Available  int        `gorm:"not null;default:0"`
+	ExpiresAt  *time.Time `gorm:"index"`
+	CreatedAt  time.Time
+}
+
+func (p *LicensePool) BeforeCreate(tx *gorm.DB) error {
+	p.Available = p.Concurrent
+	return nil
+}
+
+// LicenseService manages the license pools of digital books.
+type LicenseService struct {
+	db           *gorm.DB
+	queryTimeout time.Duration
+}
+
+// AddLicensePool adds a pool of licenses to a digital book.
+// Requires PermManageCatalog.
+func (s *LicenseService) AddLicensePool(ctx context.Context, pool *LicensePool) (err error) {
+	defer observeOperation("add_license_pool", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageCatalog); err != nil {
+		return err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	if pool.Concurrent <= 0 {
+		return fmt.Errorf("a license pool needs at least one concurrent license")
+	}
+	if pool.ExpiresAt != nil && !pool.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("license pool has already expired")
+	}
+	db := s.db.WithContext(ctx)
+	var book Book
+	if err := db.First(&book, pool.BookID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("book not found")
+		}
+		return fmt.Errorf("error finding book: %w", err)
+	}
+	if !book.Format.digital() {
+		return fmt.Errorf("licenses can only be added to digital books")
+	}
+	if err := db.Create(pool).Error; err != nil {
+		return fmt.Errorf("failed to add license pool: %w", err)
+	}
+	return nil
+}
+
+// ListLicensePools returns the license pools of a book, soonest to expire first.
+func (s *LicenseService) ListLicensePools(ctx context.Context, bookID uint) (_ []LicensePool, err error) {
+	defer observeOperation("list_license_pools", time.Now(), &err)
+	if _, err := authorize(ctx, PermViewCatalog); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var pools []LicensePool
+	if err := s.db.WithContext(ctx).Where("book_id = ?", bookID).Order("expires_at IS NULL, expires_at, id").Find(&pools).Error; err != nil {
+		return nil, fmt.Errorf("failed to list license pools: %w", err)
+	}
+	return pools, nil
+}
+
+// assignLicense picks the license pool a loan of a digital book is lent
+// from, preferring the pool that expires first, and shortens the loan so
+// that it ends when the license does. Loans of print books are left as is.
+// The license itself is taken by the BookLoan BeforeCreate hook.
+func assignLicense(tx *gorm.DB, loan *BookLoan) error {
+	var book Book
+	if err := tx.Select("id", "format").Limit(1).Find(&book, loan.BookID).Error; err != nil {
+		return fmt.Errorf("error finding book: %w", err)
+	}
+	if !book.Format.digital() {
+		return nil
+	}
+	if loan.BranchID != 0 {
+		return fmt.Errorf("digital books are not lent from a branch")
+	}
+	var pool LicensePool
+	err := tx.Where("book_id = ? AND available > 0 AND (expires_at IS NULL OR expires_at > ?)", loan.BookID, loan.LoanDate).
+		Order("expires_at IS NULL, expires_at, id").
+		Limit(1).
+		Find(&pool).Error
+	if err != nil {
+		return fmt.Errorf("failed to find license pool: %w", err)
+	}
+	if pool.ID == 0 {
+		return ErrNoLicenseAvailable
+	}
+	loan.LicensePoolID = &pool.ID
+	if pool.ExpiresAt != nil && loan.DueDate.After(*pool.ExpiresAt) {
+		loan.DueDate = *pool.ExpiresAt
+	}
+	return nil
+}
+
+// ReturnDigitalLoans returns every digital loan whose due date is not after
+// now, releasing its license. Access ends at the due date, which is
+// recorded as the return time. It returns the number of loans returned.
+// Requires PermManageLoans.
+func (s *LoanService) ReturnDigitalLoans(ctx context.Context, now time.Time) (_ int, err error) {
+	defer observeOperation("return_digital_loans", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageLoans); err != nil {
+		return 0, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	db := s.db.WithContext(ctx)
+	var loans []BookLoan
+	if err := db.Where("returned = ? AND license_pool_id IS NOT NULL AND due_date <= ?", false, now).Find(&loans).Error; err != nil {
+		return 0, fmt.Errorf("failed to find ended digital loans: %w", err)
+	}
+	returned := 0
+	for _, loan := range loans {
+		changed := false
+		err := db.Transaction(func(tx *gorm.DB) error {
+			// The loan may have been returned by its member since it was
+			// listed; re-reading it under a row lock keeps the license from
+			// being released twice.
+			res := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("returned = ?", false).Limit(1).Find(&loan, loan.ID)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return nil
+			}
+			if err := tx.Model(&loan).Updates(map[string]interface{}{"returned": true, "returned_at": loan.DueDate}).Error; err != nil {
+				return err
+			}
+			event := newLoanEvent(&loan)
+			event.ReturnedAt = &loan.DueDate
+			changed = true
+			return emitEvent(tx, EventLoanReturned, loan.ID, event)
+		})
+		if err != nil {
+			return returned, fmt.Errorf("failed to return loan %d: %w", loan.ID, err)
+		}
+		if changed {
+			returned++
+		}
+	}
+	return returned, nil
+}
+
+// LicenseUsage is the use made of one license pool within a report range.
+// Utilization is the share of the pool's license time that was lent out
+// while the pool was active, from 0 to 1.
+type LicenseUsage struct {
+	PoolID      uint       `json:"pool_id"`
+	BookID      uint       `json:"book_id"`
+	ISBN        string     `json:"isbn"`
+	Title       string     `json:"title"`
+	Format      BookFormat `json:"format"`
+	Provider    string     `json:"provider"`
+	Concurrent  int        `json:"concurrent"`
+	InUse       int        `json:"in_use"`
+	Loans       int64      `json:"loans"`
+	Utilization float64    `json:"utilization"`
+	Expires     string     `json:"expires"`
+}
+
+// licensePoolRow is a license pool with the book it belongs to.
+type licensePoolRow struct {
+	LicensePool
+	ISBN   string
+	Title  string
+	Format BookFormat
+}
+
+// licenseUsage computes the usage of pools within r from the digital loans
+// overlapping r. Open loans count as lent until now.
+func licenseUsage(pools []licensePoolRow, loans []BookLoan, r ReportRange, now time.Time) []LicenseUsage {
+	byPool := map[uint][]BookLoan{}
+	for _, l := range loans {
+		if l.LicensePoolID != nil {
+			byPool[*l.LicensePoolID] = append(byPool[*l.LicensePoolID], l)
+		}
+	}
+
+	rows := make([]LicenseUsage, 0, len(pools))
+	for _, p := range pools {
+		u := LicenseUsage{
+			PoolID:     p.ID,
+			BookID:     p.BookID,
+			ISBN:       p.ISBN,
+			Title:      p.Title,
+			Format:     p.Format,
+			Provider:   p.Provider,
+			Concurrent: p.Concurrent,
+			InUse:      p.Concurrent - p.Available,
+		}
+		start, end := r.From, r.To
+		if p.CreatedAt.After(start) {
+			start = p.CreatedAt
+		}
+		if now.Before(end) {
+			end = now
+		}
+		if p.ExpiresAt != nil {
+			u.Expires = p.ExpiresAt.Format(time.DateOnly)
+			if p.ExpiresAt.Before(end) {
+				end = *p.ExpiresAt
+			}
+		}
+
+		var busy time.Duration
+		for _, l := range byPool[p.ID] {
+			if !l.LoanDate.Before(r.From) && l.LoanDate.Before(r.To) {
+				u.Loans++
+			}
+			from, until := l.LoanDate, now
+			if l.ReturnedAt != nil {
+				until = *l.ReturnedAt
+			}
+			if from.Before(start) {
+				from = start
+			}
+			if until.After(end) {
+				until = end
+			}
+			if until.After(from) {
+				busy += until.Sub(from)
+			}
+		}
+		if end.After(start) {
+			u.Utilization = busy.Seconds() / (end.Sub(start).Seconds() * float64(p.Concurrent))
+		}
+		rows = append(rows, u)
+	}
+	return rows
+}
+
+// LicenseUtilization reports how much of every license pool was used in r,
+// most used first.
+func (s *ReportService) LicenseUtilization(ctx context.Context, r ReportRange) (_ []LicenseUsage, err error) {
+	defer observeOperation("report_license_utilization", time.Now(), &err)
+	db, cancel, err := s.begin(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	var pools []licensePoolRow
+	err = db.Model(&LicensePool{}).
+		Select("license_pools.*, books.isbn, books.title, books.format").
+		Joins("JOIN books ON books.id = license_pools.book_id").
+		Where("license_pools.created_at < ?", r.To).
+		Order("books.title, license_pools.id").
+		Scan(&pools).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load license pools: %w", err)
+	}
+	var loans []BookLoan
+	err = db.Where("license_pool_id IS NOT NULL AND loan_date < ? AND (returned_at IS NULL OR returned_at > ?)", r.To, r.From).
+		Find(&loans).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load digital loans: %w", err)
+	}
+
+	rows := licenseUsage(pools, loans, r, time.Now())
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].Utilization > rows[j].Utilization })
+	return rows, nil
+}
+
+// registerDigitalLendingJobs registers the job that ends digital loans on
+// their due date.
+func registerDigitalLendingJobs(s *Scheduler, loans *LoanService) error {
+	return s.Register("return-digital-loans", "*/5 * * * *", func(ctx context.Context) error {
+		return forEachTenant(ctx, s.db, func(ctx context.Context) error {
+			n, err := loans.ReturnDigitalLoans(ctx, time.Now())
+			if n > 0 {
+				slog.InfoContext(ctx, "digital loans returned", "count", n)
+			}
+			return err
+		})
+	})
+}
+
+// handleAddLicensePool serves POST /books/{id}/licenses.
+func (s *apiServer) handleAddLicensePool(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid book id"})
+		return
+	}
+	var pool LicensePool
+	if err := json.NewDecoder(r.Body).Decode(&pool); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid license pool"})
+		return
+	}
+	pool.ID, pool.TenantID, pool.BookID = 0, 0, uint(bookID)
+	if err := s.licenses.AddLicensePool(r.Context(), &pool); err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, pool)
+}
+
+// handleListLicensePools serves GET /books/{id}/licenses.
+func (s *apiServer) handleListLicensePools(w http.ResponseWriter, r *http.Request) {
+	bookID, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid book id"})
+		return
+	}
+	pools, err := s.licenses.ListLicensePools(r.Context(), uint(bookID))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, pools)
+}
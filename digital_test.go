@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// TestLicenseUsage tests utilization over the active part of a pool.
+func TestLicenseUsage(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.UTC) }
+	r := ReportRange{From: day(1), To: day(11)}
+	expires := day(9)
+	poolID := uint(1)
+	pools := []licensePoolRow{{
+		LicensePool: LicensePool{ID: poolID, BookID: 7, Concurrent: 2, Available: 1, CreatedAt: day(3), ExpiresAt: &expires},
+		Title:       "Digital",
+		Format:      FormatEbook,
+	}}
+	returned := day(5)
+	loans := []BookLoan{
+		// Lent before the range starts; counts from the pool's creation on.
+		{LicensePoolID: &poolID, LoanDate: day(1), ReturnedAt: &returned},
+		// Still open; counts until the pool expires.
+		{LicensePoolID: &poolID, LoanDate: day(6)},
+	}
+
+	rows := licenseUsage(pools, loans, r, day(20))
+	if len(rows) != 1 {
+		t.Fatalf("expected one row, got %d", len(rows))
+	}
+	u := rows[0]
+	// Active from day 3 to day 9 with 2 licenses: 12 license-days, 2+3 lent.
+	if want := 5.0 / 12.0; math.Abs(u.Utilization-want) > 1e-9 {
+		t.Errorf("utilization = %f, want %f", u.Utilization, want)
+	}
+	if u.Loans != 2 || u.InUse != 1 || u.Expires != "2024-01-09" {
+		t.Errorf("unexpected usage: %+v", u)
+	}
+}
+
+// TestLoanService_DigitalLending tests lending from a license pool and the
+// automatic return of digital loans.
+func TestLoanService_DigitalLending(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenant := mustCreateTenant(t, &TenantService{db: db}, "digital")
+	ctx := withTenant(asRole(RoleLibrarian), tenant.ID)
+	loans := &LoanService{db: db}
+	licenses := &LicenseService{db: db}
+
+	paper := &Book{ISBN: "9783939393939", Title: "Paper", Copies: 1}
+	mustCreateBook(t, db.WithContext(ctx), paper)
+	ebook := &Book{ISBN: "9783838383838", Title: "Pixels", Format: FormatEbook}
+	mustCreateBook(t, db.WithContext(ctx), ebook)
+
+	if err := licenses.AddLicensePool(ctx, &LicensePool{BookID: paper.ID, Concurrent: 1}); err == nil {
+		t.Errorf("expected licenses for a print book to be rejected")
+	}
+	expires := time.Now().Add(10 * 24 * time.Hour).Truncate(time.Microsecond)
+	pool := &LicensePool{BookID: ebook.ID, Provider: "OverDrive", Concurrent: 1, ExpiresAt: &expires}
+	if err := licenses.AddLicensePool(ctx, pool); err != nil {
+		t.Fatalf("AddLicensePool: %v", err)
+	}
+
+	first, second := mustCreateMember(t, db.WithContext(ctx)), mustCreateMember(t, db.WithContext(ctx))
+	loan, err := loans.CheckoutBook(ctx, first.ID, ebook.ID, time.Now().Add(20*24*time.Hour))
+	if err != nil {
+		t.Fatalf("CheckoutBook: %v", err)
+	}
+	if loan.LicensePoolID == nil || *loan.LicensePoolID != pool.ID || !loan.DueDate.Equal(expires) {
+		t.Errorf("loan should use the pool and end when it expires: %+v", loan)
+	}
+	if _, err := loans.CheckoutBook(ctx, second.ID, ebook.ID, time.Now().Add(24*time.Hour)); !errors.Is(err, ErrNoLicenseAvailable) {
+		t.Errorf("expected ErrNoLicenseAvailable, got %v", err)
+	}
+	if _, err := loans.CheckoutBook(ctx, second.ID, paper.ID, time.Now().Add(24*time.Hour)); err != nil {
+		t.Errorf("print checkout: %v", err)
+	}
+
+	if n, err := loans.MarkOverdue(ctx, expires.Add(time.Hour)); err != nil || n != 1 {
+		t.Errorf("only the print loan should become overdue, got %d, %v", n, err)
+	}
+	n, err := loans.ReturnDigitalLoans(ctx, expires.Add(time.Hour))
+	if err != nil || n != 1 {
+		t.Fatalf("ReturnDigitalLoans = %d, %v", n, err)
+	}
+	var got BookLoan
+	db.WithContext(ctx).First(&got, loan.ID)
+	if !got.Returned || got.ReturnedAt == nil || !got.ReturnedAt.Equal(expires) {
+		t.Errorf("loan should be returned at its due date: %+v", got)
+	}
+	var p LicensePool
+	db.WithContext(ctx).First(&p, pool.ID)
+	if p.Available != 1 {
+		t.Errorf("license should be released, available = %d", p.Available)
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// testSeq numbers the records made by the factories below, so that their
+// names, ISBNs and usernames do not collide within a test.
+var testSeq atomic.Int64
+
+// testISBN returns a unique ISBN-13 with a valid check digit.
+func testISBN() string {
+	digits := fmt.Sprintf("979%09d", testSeq.Add(1))
+	return digits + string(isbn13CheckDigit(digits))
+}
+
+// mustCreatePublisher creates a publisher, named if p has no name.
+func mustCreatePublisher(t *testing.T, db *gorm.DB, p *Publisher) *Publisher {
+	t.Helper()
+	if p.Name == "" {
+		p.Name = fmt.Sprintf("Publisher %d", testSeq.Add(1))
+	}
+	if err := db.Create(p).Error; err != nil {
+		t.Fatalf("failed to create publisher: %v", err)
+	}
+	return p
+}
+
+// mustCreateAuthor creates an author, named if a has no name.
+func mustCreateAuthor(t *testing.T, db *gorm.DB, a *Author) *Author {
+	t.Helper()
+	if a.Name == "" {
+		a.Name = fmt.Sprintf("Author %d", testSeq.Add(1))
+	}
+	if err := db.Create(a).Error; err != nil {
+		t.Fatalf("failed to create author: %v", err)
+	}
+	return a
+}
+
+// mustCreateCategory creates a category with a unique name unless c has
+// one.
+func mustCreateCategory(t *testing.T, db *gorm.DB, c *Category) *Category {
+	t.Helper()
+	if c.Name == "" {
+		c.Name = fmt.Sprintf("Category %d", testSeq.Add(1))
+	}
+	if err := db.Create(c).Error; err != nil {
+		t.Fatalf("failed to create category: %v", err)
+	}
+	return c
+}
+
+// mustCreateMember creates a member with a unique username.
+func mustCreateMember(t *testing.T, db *gorm.DB) *User {
+	t.Helper()
+	return mustCreateUser(t, db, fmt.Sprintf("member-%d", testSeq.Add(1)), RoleMember)
+}
+
+// mustCreateLoan checks out a book through the loan hooks. A loan without
+// a book or member gets new ones, and the loan runs for two weeks from
+// now unless its dates are set.
+func mustCreateLoan(t *testing.T, db *gorm.DB, l *BookLoan) *BookLoan {
+	t.Helper()
+	if l.BookID == 0 {
+		b := &Book{Copies: 1}
+		mustCreateBook(t, db, b)
+		l.BookID = b.ID
+	}
+	if l.MemberID == 0 {
+		l.MemberID = mustCreateMember(t, db).ID
+	}
+	if l.LoanDate.IsZero() {
+		l.LoanDate = time.Now()
+	}
+	if l.DueDate.IsZero() {
+		l.DueDate = l.LoanDate.Add(14 * 24 * time.Hour)
+	}
+	if err := db.Create(l).Error; err != nil {
+		t.Fatalf("failed to create loan: %v", err)
+	}
+	return l
+}
+
+// mustCreateReview creates a five-star review of a new book by a new
+// member, unless r says otherwise.
+func mustCreateReview(t *testing.T, db *gorm.DB, r *Review) *Review {
+	t.Helper()
+	if r.Rating == 0 {
+		r.Rating = 5
+	}
+	if r.ProductID == 0 {
+		b := &Book{}
+		mustCreateBook(t, db, b)
+		r.ProductID = b.ID
+	}
+	if r.CustomerID == 0 {
+		r.CustomerID = mustCreateMember(t, db).ID
+	}
+	if err := db.Create(r).Error; err != nil {
+		t.Fatalf("failed to create review: %v", err)
+	}
+	return r
+}
+
+// mustCreateLicensePool creates a pool of one concurrent license for a new
+// e-book, unless p says otherwise.
+func mustCreateLicensePool(t *testing.T, db *gorm.DB, p *LicensePool) *LicensePool {
+	t.Helper()
+	if p.BookID == 0 {
+		b := &Book{Format: FormatEbook}
+		mustCreateBook(t, db, b)
+		p.BookID = b.ID
+	}
+	if p.Concurrent == 0 {
+		p.Concurrent = 1
+	}
+	if err := db.Create(p).Error; err != nil {
+		t.Fatalf("failed to create license pool: %v", err)
+	}
+	return p
+}
+
+// mustCreateWebhookSubscription subscribes url to eventTypes with a fixed
+// secret.
+func mustCreateWebhookSubscription(t *testing.T, db *gorm.DB, url string, eventTypes ...string) *WebhookSubscription {
+	t.Helper()
+	s := &WebhookSubscription{URL: url, EventTypes: eventTypes, Secret: "test-secret"}
+	if err := db.Create(s).Error; err != nil {
+		t.Fatalf("failed to create webhook subscription: %v", err)
+	}
+	return s
+}
+
+// TestTestSchemaDSN tests that the search path is added to both forms of
+// connection string.
+func TestTestSchemaDSN(t *testing.T) {
+	tests := map[string]string{
+		"host=localhost dbname=lib":              "host=localhost dbname=lib search_path=test_x",
+		"postgres://u:p@localhost/lib":           "postgres://u:p@localhost/lib?search_path=test_x",
+		"postgres://localhost/lib?sslmode=allow": "postgres://localhost/lib?search_path=test_x&sslmode=allow",
+	}
+	for dsn, want := range tests {
+		if got := testSchemaDSN(dsn, "test_x"); got != want {
+			t.Errorf("testSchemaDSN(%q) = %q, want %q", dsn, got, want)
+		}
+	}
+	if name := testSchemaName("TestFoo/Sub-Case"); len(name) > 63 || name[:22] != "test_testfoo_sub_case_" {
+		t.Errorf("unexpected schema name %q", name)
+	}
+}
+
+// TestNewTestDB_Isolated tests that each test database starts empty and
+// sees nothing of another, and that the factories fill in what tests
+// leave out.
+func TestNewTestDB_Isolated(t *testing.T) {
+	db1, cleanup1 := newTestDB(t)
+	defer cleanup1()
+	db2, cleanup2 := newTestDB(t)
+	defer cleanup2()
+
+	mustCreateBook(t, db1, &Book{ISBN: "9780000000017", Title: "Isolated"})
+	mustCreateBook(t, db2, &Book{ISBN: "9780000000017", Title: "Isolated"})
+	var n int64
+	db2.Model(&Book{}).Count(&n)
+	if n != 1 {
+		t.Errorf("expected one book in the second database, got %d", n)
+	}
+
+	loan := mustCreateLoan(t, db1, &BookLoan{})
+	var book Book
+	db1.First(&book, loan.BookID)
+	if book.Available != 0 || isbn13CheckDigit(book.ISBN) != book.ISBN[12] {
+		t.Errorf("expected the loan to take the only copy of a valid book, got %+v", book)
+	}
+	review := mustCreateReview(t, db1, &Review{Rating: 3})
+	pool := mustCreateLicensePool(t, db1, &LicensePool{Concurrent: 2})
+	author := mustCreateAuthor(t, db1, &Author{})
+	category := mustCreateCategory(t, db1, &Category{})
+	if review.ID == 0 || pool.Available != 2 || author.ID == 0 || category.ID == 0 {
+		t.Errorf("factories did not create records: %+v %+v %+v %+v", review, pool, author, category)
+	}
+	mustCreatePublisher(t, db1, &Publisher{})
+	mustCreateWebhookSubscription(t, db1, "https://example.com/hook", EventLoanCreated)
+
+	cleanup2()
+	var schemas int64
+	db1.WithContext(context.Background()).Raw("SELECT count(*) FROM information_schema.schemata WHERE schema_name LIKE ?", "test_testnewtestdb_isolated_%").Scan(&schemas)
+	if schemas != 1 {
+		t.Errorf("expected only the first test schema to remain, found %d", schemas)
+	}
+}
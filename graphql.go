@@ -0,0 +1,504 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+	"gorm.io/gorm"
+)
+
+// graphqlSchema is the SDL served at POST /graphql.
+//
+//go:embed schema.graphql
+var graphqlSchema string
+
+// batchLoader loads values by key in batches to avoid one query per parent
+// object. Keys are primed as parents are resolved; the first load then
+// fetches every primed key not loaded yet with a single call to fetch.
+// Keys for which fetch returns no value load as the zero value.
+type batchLoader[K comparable, V any] struct {
+	fetch func(ctx context.Context, keys []K) (map[K]V, error)
+
+	mu      sync.Mutex
+	pending map[K]struct{}
+	loaded  map[K]V
+}
+
+// newBatchLoader returns a batchLoader using fetch.
+func newBatchLoader[K comparable, V any](fetch func(context.Context, []K) (map[K]V, error)) *batchLoader[K, V] {
+	return &batchLoader[K, V]{fetch: fetch, pending: map[K]struct{}{}, loaded: map[K]V{}}
+}
+
+// prime schedules keys to be fetched with the next batch.
+func (l *batchLoader[K, V]) prime(keys ...K) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, k := range keys {
+		if _, ok := l.loaded[k]; !ok {
+			l.pending[k] = struct{}{}
+		}
+	}
+}
+
+// load returns the value of key, fetching it together with all primed keys.
+func (l *batchLoader[K, V]) load(ctx context.Context, key K) (V, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if v, ok := l.loaded[key]; ok {
+		return v, nil
+	}
+	l.pending[key] = struct{}{}
+	keys := make([]K, 0, len(l.pending))
+	for k := range l.pending {
+		keys = append(keys, k)
+	}
+	values, err := l.fetch(ctx, keys)
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	for _, k := range keys {
+		l.loaded[k] = values[k]
+		delete(l.pending, k)
+	}
+	return l.loaded[key], nil
+}
+
+// catalogLoaders batch the association lookups of one GraphQL request.
+// Associations are keyed by book ID, publishers by publisher ID.
+type catalogLoaders struct {
+	authors    *batchLoader[uint, []Author]
+	categories *batchLoader[uint, []Category]
+	reviews    *batchLoader[uint, []Review]
+	loans      *batchLoader[uint, []BookLoan]
+	publishers *batchLoader[uint, *Publisher]
+}
+
+// loadersKey is the context key of a request's catalogLoaders.
+type loadersKey struct{}
+
+// withCatalogLoaders returns ctx with fresh catalogLoaders reading from db.
+func withCatalogLoaders(ctx context.Context, db *gorm.DB) context.Context {
+	return context.WithValue(ctx, loadersKey{}, newCatalogLoaders(db))
+}
+
+// loadersFromContext returns the catalogLoaders of ctx.
+func loadersFromContext(ctx context.Context) *catalogLoaders {
+	l, _ := ctx.Value(loadersKey{}).(*catalogLoaders)
+	return l
+}
+
+// newCatalogLoaders returns loaders reading from db. Each fetch runs in the
+// context of the load that triggered it, so it is tenant-scoped.
+func newCatalogLoaders(db *gorm.DB) *catalogLoaders {
+	return &catalogLoaders{
+		authors: newBatchLoader(func(ctx context.Context, bookIDs []uint) (map[uint][]Author, error) {
+			links, authorIDs, err := bookLinks(db.WithContext(ctx), "book_authors", "author_id", bookIDs)
+			if err != nil {
+				return nil, err
+			}
+			var authors []Author
+			if err := db.WithContext(ctx).Where("id IN ?", authorIDs).Order("name").Find(&authors).Error; err != nil {
+				return nil, fmt.Errorf("failed to load authors: %w", err)
+			}
+			out := map[uint][]Author{}
+			for _, a := range authors {
+				for _, bookID := range links[a.ID] {
+					out[bookID] = append(out[bookID], a)
+				}
+			}
+			return out, nil
+		}),
+		categories: newBatchLoader(func(ctx context.Context, bookIDs []uint) (map[uint][]Category, error) {
+			links, categoryIDs, err := bookLinks(db.WithContext(ctx), "book_categories", "category_id", bookIDs)
+			if err != nil {
+				return nil, err
+			}
+			var categories []Category
+			if err := db.WithContext(ctx).Where("id IN ?", categoryIDs).Order("name").Find(&categories).Error; err != nil {
+				return nil, fmt.Errorf("failed to load categories: %w", err)
+			}
+			out := map[uint][]Category{}
+			for _, c := range categories {
+				for _, bookID := range links[c.ID] {
+					out[bookID] = append(out[bookID], c)
+				}
+			}
+			return out, nil
+		}),
+		reviews: newBatchLoader(func(ctx context.Context, bookIDs []uint) (map[uint][]Review, error) {
+			var reviews []Review
+			if err := db.WithContext(ctx).Where("product_id IN ?", bookIDs).Order("id").Find(&reviews).Error; err != nil {
+				return nil, fmt.Errorf("failed to load reviews: %w", err)
+			}
+			out := map[uint][]Review{}
+			for _, r := range reviews {
+				out[r.ProductID] = append(out[r.ProductID], r)
+			}
+			return out, nil
+		}),
+		loans: newBatchLoader(func(ctx context.Context, bookIDs []uint) (map[uint][]BookLoan, error) {
+			var loans []BookLoan
+			if err := db.WithContext(ctx).Where("book_id IN ? AND returned = ?", bookIDs, false).Order("due_date").Find(&loans).Error; err != nil {
+				return nil, fmt.Errorf("failed to load loans: %w", err)
+			}
+			out := map[uint][]BookLoan{}
+			for _, l := range loans {
+				out[l.BookID] = append(out[l.BookID], l)
+			}
+			return out, nil
+		}),
+		publishers: newBatchLoader(func(ctx context.Context, ids []uint) (map[uint]*Publisher, error) {
+			var pubs []Publisher
+			if err := db.WithContext(ctx).Where("id IN ?", ids).Find(&pubs).Error; err != nil {
+				return nil, fmt.Errorf("failed to load publishers: %w", err)
+			}
+			out := map[uint]*Publisher{}
+			for i := range pubs {
+				out[pubs[i].ID] = &pubs[i]
+			}
+			return out, nil
+		}),
+	}
+}
+
+// bookLinks reads the rows of a many2many join table for bookIDs. It
+// returns the books linked to each ID found in column, and those IDs.
+func bookLinks(db *gorm.DB, table, column string, bookIDs []uint) (map[uint][]uint, []uint, error) {
+	var rows []struct{ BookID, OtherID uint }
+	err := db.Table(table).
+		Select("book_id, "+column+" AS other_id").
+		Where("book_id IN ?", bookIDs).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read %s: %w", table, err)
+	}
+	links := map[uint][]uint{}
+	var ids []uint
+	for _, r := range rows {
+		if _, ok := links[r.OtherID]; !ok {
+			ids = append(ids, r.OtherID)
+		}
+		links[r.OtherID] = append(links[r.OtherID], r.BookID)
+	}
+	return links, ids, nil
+}
+
+// graphqlID formats a database ID as a GraphQL ID.
+func graphqlID(id uint) graphql.ID {
+	return graphql.ID(strconv.FormatUint(uint64(id), 10))
+}
+
+// parseGraphqlID parses a GraphQL ID into a database ID.
+func parseGraphqlID(id graphql.ID) (uint, error) {
+	n, err := strconv.ParseUint(string(id), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid id %q", id)
+	}
+	return uint(n), nil
+}
+
+// parseOptionalGraphqlID parses an optional GraphQL ID, returning zero
+// when it is absent.
+func parseOptionalGraphqlID(id *graphql.ID) (uint, error) {
+	if id == nil {
+		return 0, nil
+	}
+	return parseGraphqlID(*id)
+}
+
+// graphqlResolver resolves the Query and Mutation types through the services.
+type graphqlResolver struct {
+	books *BookService
+	loans *LoanService
+}
+
+// newBookResolvers wraps books and primes the request's loaders with them,
+// so that their associations are fetched together.
+func newBookResolvers(ctx context.Context, books []Book) []*bookResolver {
+	l := loadersFromContext(ctx)
+	out := make([]*bookResolver, len(books))
+	ids := make([]uint, len(books))
+	var publisherIDs []uint
+	for i := range books {
+		out[i] = &bookResolver{book: books[i]}
+		ids[i] = books[i].ID
+		if books[i].PublisherID != 0 {
+			publisherIDs = append(publisherIDs, books[i].PublisherID)
+		}
+	}
+	l.authors.prime(ids...)
+	l.categories.prime(ids...)
+	l.reviews.prime(ids...)
+	l.loans.prime(ids...)
+	l.publishers.prime(publisherIDs...)
+	return out
+}
+
+func (r *graphqlResolver) Book(ctx context.Context, args struct{ ISBN string }) (*bookResolver, error) {
+	book, err := r.books.FindBook(ctx, args.ISBN)
+	if errors.Is(err, ErrBookNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newBookResolvers(ctx, []Book{*book})[0], nil
+}
+
+func (r *graphqlResolver) Books(ctx context.Context, args struct{ Offset, Limit int32 }) ([]*bookResolver, error) {
+	books, err := r.books.ListBooks(ctx, int(args.Offset), int(args.Limit))
+	if err != nil {
+		return nil, err
+	}
+	return newBookResolvers(ctx, books), nil
+}
+
+// bookInput is the BookInput GraphQL input type.
+type bookInput struct {
+	ISBN            string
+	Title           *string
+	Format          *string
+	PublicationYear *int32
+	Copies          int32
+	PublisherID     *graphql.ID
+}
+
+func (r *graphqlResolver) AddBook(ctx context.Context, args struct{ Input bookInput }) (*bookResolver, error) {
+	in := args.Input
+	book := &Book{ISBN: in.ISBN, Copies: int(in.Copies)}
+	if in.Title != nil {
+		book.Title = *in.Title
+	}
+	if in.Format != nil {
+		book.Format = BookFormat(*in.Format)
+	}
+	if in.PublicationYear != nil {
+		book.PublicationYear = int(*in.PublicationYear)
+	}
+	if in.PublisherID != nil {
+		id, err := parseGraphqlID(*in.PublisherID)
+		if err != nil {
+			return nil, err
+		}
+		book.PublisherID = id
+	}
+	if err := r.books.AddBook(ctx, book); err != nil {
+		return nil, err
+	}
+	return newBookResolvers(ctx, []Book{*book})[0], nil
+}
+
+func (r *graphqlResolver) UpdateBookCopies(ctx context.Context, args struct {
+	ISBN   string
+	Copies int32
+}) (*bookResolver, error) {
+	if err := r.books.UpdateBookCopies(ctx, args.ISBN, int(args.Copies)); err != nil {
+		return nil, err
+	}
+	book, err := r.books.FindBook(ctx, args.ISBN)
+	if err != nil {
+		return nil, err
+	}
+	return newBookResolvers(ctx, []Book{*book})[0], nil
+}
+
+func (r *graphqlResolver) RemoveBook(ctx context.Context, args struct{ ISBN string }) (bool, error) {
+	if err := r.books.RemoveBook(ctx, args.ISBN); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *graphqlResolver) CheckoutBook(ctx context.Context, args struct {
+	MemberID graphql.ID
+	BookID   graphql.ID
+	DueDate  graphql.Time
+	BranchID *graphql.ID
+}) (*loanResolver, error) {
+	memberID, err := parseGraphqlID(args.MemberID)
+	if err != nil {
+		return nil, err
+	}
+	bookID, err := parseGraphqlID(args.BookID)
+	if err != nil {
+		return nil, err
+	}
+	branchID, err := parseOptionalGraphqlID(args.BranchID)
+	if err != nil {
+		return nil, err
+	}
+	loan, err := r.loans.CheckoutBookAt(ctx, branchID, memberID, bookID, args.DueDate.Time)
+	if err != nil {
+		return nil, err
+	}
+	return &loanResolver{loan: *loan}, nil
+}
+
+func (r *graphqlResolver) ReturnBook(ctx context.Context, args struct {
+	LoanID   graphql.ID
+	BranchID *graphql.ID
+}) (bool, error) {
+	loanID, err := parseGraphqlID(args.LoanID)
+	if err != nil {
+		return false, err
+	}
+	branchID, err := parseOptionalGraphqlID(args.BranchID)
+	if err != nil {
+		return false, err
+	}
+	if err := r.loans.ReturnBookAt(ctx, loanID, branchID); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// bookResolver resolves the Book type.
+type bookResolver struct{ book Book }
+
+func (r *bookResolver) ID() graphql.ID         { return graphqlID(r.book.ID) }
+func (r *bookResolver) ISBN() string           { return r.book.ISBN }
+func (r *bookResolver) Title() string          { return r.book.Title }
+func (r *bookResolver) PublicationYear() int32 { return int32(r.book.PublicationYear) }
+func (r *bookResolver) Copies() int32          { return int32(r.book.Copies) }
+func (r *bookResolver) Available() int32       { return int32(r.book.Available) }
+
+func (r *bookResolver) Format() string {
+	if r.book.Format == "" {
+		return string(FormatPrint)
+	}
+	return string(r.book.Format)
+}
+
+func (r *bookResolver) CoverURL() *string {
+	if r.book.CoverURL == "" {
+		return nil
+	}
+	return &r.book.CoverURL
+}
+
+func (r *bookResolver) Publisher(ctx context.Context) (*publisherResolver, error) {
+	if r.book.PublisherID == 0 {
+		return nil, nil
+	}
+	pub, err := loadersFromContext(ctx).publishers.load(ctx, r.book.PublisherID)
+	if err != nil || pub == nil {
+		return nil, err
+	}
+	return &publisherResolver{*pub}, nil
+}
+
+func (r *bookResolver) Authors(ctx context.Context) ([]*authorResolver, error) {
+	authors, err := loadersFromContext(ctx).authors.load(ctx, r.book.ID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*authorResolver, len(authors))
+	for i, a := range authors {
+		out[i] = &authorResolver{a}
+	}
+	return out, nil
+}
+
+func (r *bookResolver) Categories(ctx context.Context) ([]*categoryResolver, error) {
+	categories, err := loadersFromContext(ctx).categories.load(ctx, r.book.ID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*categoryResolver, len(categories))
+	for i, c := range categories {
+		out[i] = &categoryResolver{c}
+	}
+	return out, nil
+}
+
+func (r *bookResolver) Reviews(ctx context.Context) ([]*reviewResolver, error) {
+	reviews, err := loadersFromContext(ctx).reviews.load(ctx, r.book.ID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*reviewResolver, len(reviews))
+	for i, rv := range reviews {
+		out[i] = &reviewResolver{rv}
+	}
+	return out, nil
+}
+
+func (r *bookResolver) Loans(ctx context.Context) ([]*loanResolver, error) {
+	if _, err := authorize(ctx, PermManageLoans); err != nil {
+		return nil, err
+	}
+	loans, err := loadersFromContext(ctx).loans.load(ctx, r.book.ID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]*loanResolver, len(loans))
+	for i, l := range loans {
+		out[i] = &loanResolver{l}
+	}
+	return out, nil
+}
+
+// authorResolver resolves the Author type.
+type authorResolver struct{ author Author }
+
+func (r *authorResolver) ID() graphql.ID    { return graphqlID(r.author.ID) }
+func (r *authorResolver) Name() string      { return r.author.Name }
+func (r *authorResolver) Biography() string { return r.author.Biography }
+func (r *authorResolver) BirthYear() int32  { return int32(r.author.BirthYear) }
+
+// publisherResolver resolves the Publisher type.
+type publisherResolver struct{ publisher Publisher }
+
+func (r *publisherResolver) ID() graphql.ID  { return graphqlID(r.publisher.ID) }
+func (r *publisherResolver) Name() string    { return r.publisher.Name }
+func (r *publisherResolver) Address() string { return r.publisher.Address }
+
+// categoryResolver resolves the Category type.
+type categoryResolver struct{ category Category }
+
+func (r *categoryResolver) ID() graphql.ID { return graphqlID(r.category.ID) }
+func (r *categoryResolver) Name() string   { return r.category.Name }
+
+// reviewResolver resolves the Review type.
+type reviewResolver struct{ review Review }
+
+func (r *reviewResolver) ID() graphql.ID         { return graphqlID(uint(r.review.ID)) }
+func (r *reviewResolver) Rating() int32          { return int32(r.review.Rating) }
+func (r *reviewResolver) Comment() string        { return r.review.Comment }
+func (r *reviewResolver) CustomerID() graphql.ID { return graphqlID(r.review.CustomerID) }
+
+// loanResolver resolves the BookLoan type.
+type loanResolver struct{ loan BookLoan }
+
+func (r *loanResolver) ID() graphql.ID         { return graphqlID(r.loan.ID) }
+func (r *loanResolver) BookID() graphql.ID     { return graphqlID(r.loan.BookID) }
+func (r *loanResolver) MemberID() graphql.ID   { return graphqlID(r.loan.MemberID) }
+func (r *loanResolver) LoanDate() graphql.Time { return graphql.Time{Time: r.loan.LoanDate} }
+func (r *loanResolver) DueDate() graphql.Time  { return graphql.Time{Time: r.loan.DueDate} }
+func (r *loanResolver) Returned() bool         { return r.loan.Returned }
+func (r *loanResolver) Overdue() bool          { return r.loan.Overdue }
+
+// newGraphqlSchema parses the schema and binds it to the services.
+func newGraphqlSchema(books *BookService, loans *LoanService) (*graphql.Schema, error) {
+	schema, err := graphql.ParseSchema(graphqlSchema, &graphqlResolver{books: books, loans: loans})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse GraphQL schema: %w", err)
+	}
+	return schema, nil
+}
+
+// graphqlHandler serves GraphQL requests with per-request loaders.
+func graphqlHandler(db *gorm.DB, schema *graphql.Schema) http.Handler {
+	h := &relay.Handler{Schema: schema}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.ServeHTTP(w, r.WithContext(withCatalogLoaders(r.Context(), db)))
+	})
+}
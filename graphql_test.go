@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TestBatchLoader tests that primed keys are fetched in one batch.
+func TestBatchLoader(t *testing.T) {
+	var batches [][]int
+	l := newBatchLoader(func(_ context.Context, keys []int) (map[int]string, error) {
+		slices.Sort(keys)
+		batches = append(batches, keys)
+		out := map[int]string{}
+		for _, k := range keys {
+			if k != 3 {
+				out[k] = fmt.Sprint("v", k)
+			}
+		}
+		return out, nil
+	})
+	ctx := context.Background()
+
+	l.prime(1, 2, 3)
+	for _, k := range []int{2, 1, 3, 2} {
+		v, err := l.load(ctx, k)
+		if err != nil {
+			t.Fatalf("load(%d): %v", k, err)
+		}
+		if want := map[int]string{1: "v1", 2: "v2"}[k]; v != want {
+			t.Errorf("load(%d) = %q, want %q", k, v, want)
+		}
+	}
+	if _, err := l.load(ctx, 4); err != nil {
+		t.Fatalf("load(4): %v", err)
+	}
+	if len(batches) != 2 || !slices.Equal(batches[0], []int{1, 2, 3}) || !slices.Equal(batches[1], []int{4}) {
+		t.Errorf("unexpected batches: %v", batches)
+	}
+}
+
+// TestGraphqlSchema tests that every schema field has a resolver.
+func TestGraphqlSchema(t *testing.T) {
+	if _, err := newGraphqlSchema(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// graphqlRequest posts query to the GraphQL endpoint as the caller of ctx.
+func graphqlRequest(t *testing.T, h http.Handler, ctx context.Context, query string, out any) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"query": query})
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body))).WithContext(ctx)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Data   json.RawMessage
+		Errors []struct{ Message string }
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid response %s: %v", rec.Body, err)
+	}
+	if len(resp.Errors) > 0 {
+		t.Fatalf("GraphQL errors: %+v", resp.Errors)
+	}
+	if err := json.Unmarshal(resp.Data, out); err != nil {
+		t.Fatalf("invalid data %s: %v", resp.Data, err)
+	}
+}
+
+// TestGraphQL_BooksWithAssociations tests that a list of books with all
+// associations is resolved with a fixed number of queries.
+func TestGraphQL_BooksWithAssociations(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenant := mustCreateTenant(t, &TenantService{db: db}, "graphql")
+	ctx := withTenant(asRole(RoleLibrarian), tenant.ID)
+	tdb := db.WithContext(ctx)
+
+	pub := Publisher{Name: "Batch Press"}
+	tdb.Create(&pub)
+	author := Author{Name: "Ada Loader"}
+	tdb.Create(&author)
+	category := Category{Name: "Batching"}
+	tdb.Create(&category)
+	for i := 0; i < 3; i++ {
+		book := &Book{
+			ISBN: fmt.Sprintf("978575757575%d", i), Title: fmt.Sprintf("Volume %d", i), Copies: 2,
+			PublisherID: pub.ID, Authors: []Author{author}, Categories: []Category{category},
+		}
+		mustCreateBook(t, tdb, book)
+		tdb.Create(&Review{Rating: 4, Comment: "Solid", CustomerID: 1, ProductID: book.ID})
+	}
+	loans := &LoanService{db: db}
+	books := &BookService{db: db}
+	var first Book
+	tdb.Where("isbn = ?", "9785757575750").First(&first)
+	member := mustCreateMember(t, tdb)
+	if _, err := loans.CheckoutBook(ctx, member.ID, first.ID, time.Now().Add(24*time.Hour)); err != nil {
+		t.Fatalf("CheckoutBook: %v", err)
+	}
+
+	schema, err := newGraphqlSchema(books, loans)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := graphqlHandler(db, schema)
+
+	var queries atomic.Int32
+	count := func(*gorm.DB) { queries.Add(1) }
+	db.Callback().Query().After("gorm:query").Register("test:count_query", count)
+	db.Callback().Row().After("gorm:row").Register("test:count_row", count)
+
+	var data struct {
+		Books []struct {
+			Title      string
+			Available  int
+			Publisher  struct{ Name string }
+			Authors    []struct{ Name string }
+			Categories []struct{ Name string }
+			Reviews    []struct{ Rating int }
+			Loans      []struct{ MemberID string }
+		}
+	}
+	graphqlRequest(t, h, ctx, `{ books { title available publisher { name } authors { name } categories { name } reviews { rating } loans { memberId } } }`, &data)
+
+	if len(data.Books) != 3 {
+		t.Fatalf("expected 3 books, got %d", len(data.Books))
+	}
+	for _, b := range data.Books {
+		if b.Publisher.Name != "Batch Press" || len(b.Authors) != 1 || len(b.Categories) != 1 || len(b.Reviews) != 1 {
+			t.Errorf("incomplete book: %+v", b)
+		}
+	}
+	if len(data.Books[0].Loans) != 1 || data.Books[0].Loans[0].MemberID != "7" || data.Books[0].Available != 1 {
+		t.Errorf("expected one current loan of the first book: %+v", data.Books[0])
+	}
+	// One query for the list, two per many2many association and one for
+	// each other association, independent of the number of books.
+	if n := queries.Load(); n > 8 {
+		t.Errorf("expected at most 8 queries, got %d", n)
+	}
+
+	var added struct{ AddBook struct{ ID, Title string } }
+	graphqlRequest(t, h, ctx, `mutation { addBook(input: {isbn: "9785858585858", title: "Mutated", copies: 1}) { id title } }`, &added)
+	if added.AddBook.Title != "Mutated" || added.AddBook.ID == "" {
+		t.Errorf("unexpected addBook result: %+v", added)
+	}
+
+	body, _ := json.Marshal(map[string]string{"query": `{ book(isbn: "9785757575750") { loans { id } } }`})
+	req := httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(string(body))).
+		WithContext(withTenant(asRole(RoleMember), tenant.ID))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if !strings.Contains(rec.Body.String(), "permission denied") {
+		t.Errorf("members should not see other members' loans: %s", rec.Body)
+	}
+}
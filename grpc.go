@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// defaultGRPCAddr is the listen address used when GRPC_ADDR is unset.
+const defaultGRPCAddr = ":9090"
+
+// grpcAddrFromEnv returns the gRPC listen address from GRPC_ADDR. The gRPC
+// server is disabled when GRPC_ADDR is "off".
+func grpcAddrFromEnv() string {
+	if v := os.Getenv("GRPC_ADDR"); v != "" {
+		return v
+	}
+	return defaultGRPCAddr
+}
+
+// catalogServer implements CatalogService on top of BookService.
+type catalogServer struct {
+	UnimplementedCatalogServiceServer
+	books *BookService
+}
+
+// circulationServer implements CirculationService on top of LoanService.
+type circulationServer struct {
+	UnimplementedCirculationServiceServer
+	loans *LoanService
+}
+
+// newGRPCServer returns a gRPC server exposing the catalogue and
+// circulation services. Calls are authenticated and scoped to a tenant from
+// their metadata like HTTP requests are from their headers.
+func newGRPCServer(tenants *TenantService, auth *AuthService, books *BookService, loans *LoanService) *grpc.Server {
+	g := &grpcGateway{tenants: tenants, auth: auth}
+	srv := grpc.NewServer(
+		grpc.UnaryInterceptor(g.unaryInterceptor),
+		grpc.StreamInterceptor(g.streamInterceptor),
+	)
+	RegisterCatalogServiceServer(srv, &catalogServer{books: books})
+	RegisterCirculationServiceServer(srv, &circulationServer{loans: loans})
+	return srv
+}
+
+// runGRPCServer serves srv on addr until ctx is cancelled and then stops
+// it gracefully, letting in-flight calls complete.
+func runGRPCServer(ctx context.Context, addr string, srv *grpc.Server) error {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for gRPC: %w", err)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		slog.InfoContext(ctx, "grpc server listening", "addr", addr)
+		errc <- srv.Serve(lis)
+	}()
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("grpc server failed: %w", err)
+	case <-ctx.Done():
+	}
+	srv.GracefulStop()
+	if err := <-errc; err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		return fmt.Errorf("grpc server failed: %w", err)
+	}
+	return nil
+}
+
+// grpcGateway resolves the request ID, tenant and principal of each call
+// and translates the errors of the domain services into gRPC statuses.
+type grpcGateway struct {
+	tenants *TenantService
+	auth    *AuthService
+}
+
+// callContext returns ctx carrying the request ID, tenant and principal
+// named by the call's metadata: the x-request-id, x-tenant and
+// authorization keys mirror the HTTP headers of the same names. A
+// request ID is generated when none is given.
+func (g *grpcGateway) callContext(ctx context.Context) (context.Context, string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	first := func(key string) string {
+		if v := md.Get(key); len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	id := first("x-request-id")
+	if id == "" {
+		id = newRequestID()
+	}
+	ctx = withRequestID(ctx, id)
+
+	tenantID := defaultTenantID
+	if slug := first(strings.ToLower(tenantHeader)); slug != "" && g.tenants != nil {
+		var err error
+		if tenantID, err = g.tenants.ResolveSlug(ctx, slug); err != nil {
+			return nil, id, status.Error(codes.NotFound, err.Error())
+		}
+	}
+	ctx = withTenant(ctx, tenantID)
+
+	if g.auth == nil {
+		return ctx, id, nil
+	}
+	user := guestPrincipal
+	var err error
+	header := first("authorization")
+	if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+		user, err = g.auth.AuthenticateToken(ctx, token)
+	} else if username, password, ok := (&http.Request{Header: http.Header{"Authorization": {header}}}).BasicAuth(); ok {
+		user, err = g.auth.Authenticate(ctx, username, password)
+	} else if header != "" {
+		err = fmt.Errorf("%w: unsupported authorization scheme", ErrUnauthenticated)
+	}
+	if err != nil {
+		return nil, id, err
+	}
+	return withPrincipal(ctx, user), id, nil
+}
+
+// unaryInterceptor prepares the context of unary calls.
+func (g *grpcGateway) unaryInterceptor(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	callCtx, id, err := g.callContext(ctx)
+	_ = grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	resp, err := handler(callCtx, req)
+	return resp, grpcError(err)
+}
+
+// streamInterceptor prepares the context of streaming calls.
+func (g *grpcGateway) streamInterceptor(srv any, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, id, err := g.callContext(ss.Context())
+	_ = ss.SetHeader(metadata.Pairs("x-request-id", id))
+	if err != nil {
+		return grpcError(err)
+	}
+	return grpcError(handler(srv, &contextStream{ServerStream: ss, ctx: ctx}))
+}
+
+// contextStream is a grpc.ServerStream with a replaced context.
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the replaced context.
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// grpcError converts an error of the domain services into a gRPC status
+// error. Errors that already carry a status are returned unchanged and
+// unrecognised errors map to codes.Unknown.
+func grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	code := codes.Unknown
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		code = codes.Unauthenticated
+	case errors.Is(err, ErrForbidden):
+		code = codes.PermissionDenied
+	case errors.Is(err, ErrBookNotFound), errors.Is(err, ErrLoanNotFound):
+		code = codes.NotFound
+	case errors.Is(err, ErrInvalidISBN), errors.Is(err, ErrUnknownReference):
+		code = codes.InvalidArgument
+	case errors.Is(err, ErrBookUnavailable), errors.Is(err, ErrNoLicenseAvailable), errors.Is(err, ErrLoanReturned):
+		code = codes.FailedPrecondition
+	case errors.Is(err, context.DeadlineExceeded):
+		code = codes.DeadlineExceeded
+	case errors.Is(err, context.Canceled):
+		code = codes.Canceled
+	}
+	return status.Error(code, err.Error())
+}
+
+// bookRecord converts a book to its protobuf representation.
+func bookRecord(b *Book) *BookRecord {
+	return &BookRecord{
+		Id:              uint64(b.ID),
+		Isbn:            b.ISBN,
+		Title:           b.Title,
+		Format:          string(b.Format),
+		PublicationYear: int32(b.PublicationYear),
+		Copies:          int32(b.Copies),
+		Available:       int32(b.Available),
+		CoverUrl:        b.CoverURL,
+		PublisherId:     uint64(b.PublisherID),
+	}
+}
+
+// loanRecord converts a loan to its protobuf representation.
+func loanRecord(l *BookLoan) *LoanRecord {
+	return &LoanRecord{
+		Id:       uint64(l.ID),
+		BookId:   uint64(l.BookID),
+		MemberId: uint64(l.MemberID),
+		BranchId: uint64(l.BranchID),
+		LoanDate: timestamppb.New(l.LoanDate),
+		DueDate:  timestamppb.New(l.DueDate),
+		Returned: l.Returned,
+		Overdue:  l.Overdue,
+	}
+}
+
+// GetBook implements CatalogServiceServer.
+func (s *catalogServer) GetBook(ctx context.Context, req *GetBookRequest) (*BookRecord, error) {
+	book, err := s.books.FindBook(ctx, req.GetIsbn())
+	if err != nil {
+		return nil, err
+	}
+	return bookRecord(book), nil
+}
+
+// ListBooks implements CatalogServiceServer. It pages through the
+// catalogue so that large catalogues are never loaded at once.
+func (s *catalogServer) ListBooks(req *ListBooksRequest, stream CatalogService_ListBooksServer) error {
+	pageSize := int(req.GetPageSize())
+	if pageSize <= 0 {
+		pageSize = defaultBookPageSize
+	}
+	pageSize = min(pageSize, maxBookPageSize)
+	for offset := 0; ; offset += pageSize {
+		books, err := s.books.ListBooks(stream.Context(), offset, pageSize)
+		if err != nil {
+			return err
+		}
+		for i := range books {
+			if err := stream.Send(bookRecord(&books[i])); err != nil {
+				return err
+			}
+		}
+		if len(books) < pageSize {
+			return nil
+		}
+	}
+}
+
+// AddBook implements CatalogServiceServer.
+func (s *catalogServer) AddBook(ctx context.Context, req *AddBookRequest) (*BookRecord, error) {
+	book := &Book{
+		ISBN:            req.GetIsbn(),
+		Title:           req.GetTitle(),
+		Format:          BookFormat(req.GetFormat()),
+		PublicationYear: int(req.GetPublicationYear()),
+		Copies:          int(req.GetCopies()),
+		PublisherID:     uint(req.GetPublisherId()),
+	}
+	if err := s.books.AddBook(ctx, book); err != nil {
+		return nil, err
+	}
+	return bookRecord(book), nil
+}
+
+// UpdateBookCopies implements CatalogServiceServer.
+func (s *catalogServer) UpdateBookCopies(ctx context.Context, req *UpdateBookCopiesRequest) (*BookRecord, error) {
+	if err := s.books.UpdateBookCopies(ctx, req.GetIsbn(), int(req.GetCopies())); err != nil {
+		return nil, err
+	}
+	return s.GetBook(ctx, &GetBookRequest{Isbn: req.GetIsbn()})
+}
+
+// RemoveBook implements CatalogServiceServer.
+func (s *catalogServer) RemoveBook(ctx context.Context, req *RemoveBookRequest) (*RemoveBookResponse, error) {
+	if err := s.books.RemoveBook(ctx, req.GetIsbn()); err != nil {
+		return nil, err
+	}
+	return &RemoveBookResponse{}, nil
+}
+
+// CheckoutBook implements CirculationServiceServer.
+func (s *circulationServer) CheckoutBook(ctx context.Context, req *CheckoutBookRequest) (*LoanRecord, error) {
+	if req.GetDueDate() == nil {
+		return nil, status.Error(codes.InvalidArgument, "due_date is required")
+	}
+	loan, err := s.loans.CheckoutBookAt(ctx, uint(req.GetBranchId()), uint(req.GetMemberId()), uint(req.GetBookId()), req.GetDueDate().AsTime())
+	if err != nil {
+		return nil, err
+	}
+	return loanRecord(loan), nil
+}
+
+// ReturnBook implements CirculationServiceServer.
+func (s *circulationServer) ReturnBook(ctx context.Context, req *ReturnBookRequest) (*ReturnBookResponse, error) {
+	if err := s.loans.ReturnBookAt(ctx, uint(req.GetLoanId()), uint(req.GetBranchId())); err != nil {
+		return nil, err
+	}
+	return &ReturnBookResponse{}, nil
+}
+
+// ListLoans implements CirculationServiceServer.
+func (s *circulationServer) ListLoans(ctx context.Context, req *ListLoansRequest) (*ListLoansResponse, error) {
+	loans, err := s.loans.ListLoans(ctx, uint(req.GetMemberId()))
+	if err != nil {
+		return nil, err
+	}
+	resp := &ListLoansResponse{Loans: make([]*LoanRecord, len(loans))}
+	for i := range loans {
+		resp.Loans[i] = loanRecord(&loans[i])
+	}
+	return resp, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"gorm.io/gorm"
+)
+
+// TestGRPCError tests the mapping of domain errors to status codes.
+func TestGRPCError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want codes.Code
+	}{
+		{fmt.Errorf("%w: token expired", ErrUnauthenticated), codes.Unauthenticated},
+		{ErrForbidden, codes.PermissionDenied},
+		{fmt.Errorf("failed to find book: %w", ErrBookNotFound), codes.NotFound},
+		{ErrLoanNotFound, codes.NotFound},
+		{fmt.Errorf("failed to check out book: %w: %w", ErrBookUnavailable, gorm.ErrRecordNotFound), codes.FailedPrecondition},
+		{ErrNoLicenseAvailable, codes.FailedPrecondition},
+		{ErrLoanReturned, codes.FailedPrecondition},
+		{fmt.Errorf("failed to list books: %w", context.DeadlineExceeded), codes.DeadlineExceeded},
+		{context.Canceled, codes.Canceled},
+		{errors.New("boom"), codes.Unknown},
+		{status.Error(codes.InvalidArgument, "bad"), codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		if got := status.Code(grpcError(tt.err)); got != tt.want {
+			t.Errorf("grpcError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+	if grpcError(nil) != nil {
+		t.Errorf("grpcError(nil) should be nil")
+	}
+}
+
+// TestGRPC_CatalogAndCirculation tests the gRPC services over an in-memory
+// connection, authenticated with a token and scoped by tenant metadata.
+func TestGRPC_CatalogAndCirculation(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenants := &TenantService{db: db}
+	tenant := mustCreateTenant(t, tenants, "grpc")
+	auth := &AuthService{db: db}
+	ctx := withTenant(asRole(RoleAdmin), tenant.ID)
+	librarian := mustCreateUser(t, db.WithContext(ctx), "grpc-librarian", RoleLibrarian)
+	token, err := auth.IssueToken(ctx, librarian.ID, "grpc", time.Hour)
+	if err != nil {
+		t.Fatalf("IssueToken: %v", err)
+	}
+
+	lis := bufconn.Listen(1 << 20)
+	srv := newGRPCServer(tenants, auth, &BookService{db: db}, &LoanService{db: db})
+	go srv.Serve(lis)
+	defer srv.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	defer conn.Close()
+	catalog, circulation := NewCatalogServiceClient(conn), NewCirculationServiceClient(conn)
+
+	call := metadata.AppendToOutgoingContext(context.Background(), "x-tenant", tenant.Slug, "authorization", "Bearer "+token)
+	for i := 0; i < 5; i++ {
+		if _, err := catalog.AddBook(call, &AddBookRequest{Isbn: fmt.Sprintf("978636363636%d", i), Title: fmt.Sprintf("Stream %d", i), Copies: 1}); err != nil {
+			t.Fatalf("AddBook: %v", err)
+		}
+	}
+
+	stream, err := catalog.ListBooks(call, &ListBooksRequest{PageSize: 2})
+	if err != nil {
+		t.Fatalf("ListBooks: %v", err)
+	}
+	var titles []string
+	for {
+		b, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv: %v", err)
+		}
+		titles = append(titles, b.GetTitle())
+	}
+	if len(titles) != 5 || titles[0] != "Stream 0" || titles[4] != "Stream 4" {
+		t.Errorf("expected all 5 books in title order across pages, got %v", titles)
+	}
+
+	book, err := catalog.GetBook(call, &GetBookRequest{Isbn: "9786363636360"})
+	if err != nil {
+		t.Fatalf("GetBook: %v", err)
+	}
+	first, second := mustCreateMember(t, db.WithContext(ctx)), mustCreateMember(t, db.WithContext(ctx))
+	loan, err := circulation.CheckoutBook(call, &CheckoutBookRequest{MemberId: uint64(first.ID), BookId: book.GetId(), DueDate: timestamppb.New(time.Now().Add(24 * time.Hour))})
+	if err != nil {
+		t.Fatalf("CheckoutBook: %v", err)
+	}
+	_, err = circulation.CheckoutBook(call, &CheckoutBookRequest{MemberId: uint64(second.ID), BookId: book.GetId(), DueDate: timestamppb.New(time.Now().Add(24 * time.Hour))})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition for an unavailable book, got %v", err)
+	}
+	if _, err := circulation.ReturnBook(call, &ReturnBookRequest{LoanId: loan.GetId()}); err != nil {
+		t.Fatalf("ReturnBook: %v", err)
+	}
+	if _, err := circulation.ReturnBook(call, &ReturnBookRequest{LoanId: loan.GetId()}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected FailedPrecondition for a returned loan, got %v", err)
+	}
+
+	if _, err := catalog.GetBook(call, &GetBookRequest{Isbn: "9780000000000"}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected NotFound, got %v", err)
+	}
+	guest := metadata.AppendToOutgoingContext(context.Background(), "x-tenant", tenant.Slug)
+	if _, err := catalog.RemoveBook(guest, &RemoveBookRequest{Isbn: "9786363636361"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected PermissionDenied for a guest, got %v", err)
+	}
+	bad := metadata.AppendToOutgoingContext(context.Background(), "x-tenant", tenant.Slug, "authorization", "Bearer nope")
+	if _, err := catalog.GetBook(bad, &GetBookRequest{Isbn: "9786363636360"}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("expected Unauthenticated for an invalid token, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// readinessTimeout bounds the checks performed by /readyz.
+const readinessTimeout = 2 * time.Second
+
+// startedAt is the process start time reported by /healthz.
+var startedAt = time.Now()
+
+// checkResult is the JSON detail of a single readiness check.
+type checkResult struct {
+	Status   string `json:"status"`
+	Latency  string `json:"latency,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Current  uint   `json:"current_version,omitempty"`
+	Expected uint   `json:"expected_version,omitempty"`
+}
+
+// healthReport is the JSON body returned by /healthz and /readyz.
+type healthReport struct {
+	Status string                 `json:"status"`
+	Uptime string                 `json:"uptime,omitempty"`
+	Checks map[string]checkResult `json:"checks,omitempty"`
+}
+
+// handleHealthz reports that the process is alive. It never touches the database.
+func (s *apiServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, healthReport{
+		Status: "ok",
+		Uptime: time.Since(startedAt).Round(time.Second).String(),
+	})
+}
+
+// handleReadyz reports whether the service can take traffic: the database
+// must answer a ping and the schema must be at schemaVersion.
+func (s *apiServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	report, ready := s.readiness(r.Context())
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+// readiness runs the readiness checks and reports whether all of them passed.
+func (s *apiServer) readiness(ctx context.Context) (healthReport, bool) {
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+
+	report := healthReport{Status: "ok", Checks: map[string]checkResult{}}
+	fail := func(name string, res checkResult) {
+		res.Status = "fail"
+		report.Checks[name] = res
+		report.Status = "fail"
+	}
+
+	start := time.Now()
+	sqlDB, err := s.db.DB()
+	if err == nil {
+		err = sqlDB.PingContext(ctx)
+	}
+	latency := time.Since(start).String()
+	if err != nil {
+		fail("database", checkResult{Latency: latency, Error: err.Error()})
+		return report, false
+	}
+	report.Checks["database"] = checkResult{Status: "ok", Latency: latency}
+
+	version, err := currentSchemaVersion(ctx, s.db)
+	res := checkResult{Current: version, Expected: schemaVersion}
+	switch {
+	case err != nil:
+		res.Error = err.Error()
+		fail("migrations", res)
+	case version != schemaVersion:
+		res.Error = "schema version mismatch"
+		fail("migrations", res)
+	default:
+		res.Status = "ok"
+		report.Checks["migrations"] = res
+	}
+	return report, report.Status == "ok"
+}
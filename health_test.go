@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+// getHealthReport performs GET path against srv and decodes the JSON report.
+func getHealthReport(t *testing.T, srv *apiServer, path string) (int, healthReport) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	srv.routes().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	var report healthReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("invalid JSON from %s: %v", path, err)
+	}
+	return rec.Code, report
+}
+
+// TestHealthz tests that liveness is reported without a database.
+func TestHealthz(t *testing.T) {
+	code, report := getHealthReport(t, &apiServer{registry: prometheus.NewRegistry()}, "/healthz")
+	if code != http.StatusOK || report.Status != "ok" {
+		t.Errorf("unexpected liveness response: %d %+v", code, report)
+	}
+}
+
+// TestReadyz_Ready tests that a migrated, reachable database is reported as ready.
+func TestReadyz_Ready(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+
+	code, report := getHealthReport(t, &apiServer{db: db, registry: prometheus.NewRegistry()}, "/readyz")
+	if code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %+v", code, report)
+	}
+	if got := report.Checks["migrations"]; got.Current != schemaVersion || got.Status != "ok" {
+		t.Errorf("unexpected migrations check: %+v", got)
+	}
+	if report.Checks["database"].Status != "ok" {
+		t.Errorf("unexpected database check: %+v", report.Checks["database"])
+	}
+}
+
+// TestReadyz_DatabaseDown tests that an unreachable database makes the service unready.
+func TestReadyz_DatabaseDown(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	cleanup() // close the pool so the ping fails
+
+	code, report := getHealthReport(t, &apiServer{db: db, registry: prometheus.NewRegistry()}, "/readyz")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d: %+v", code, report)
+	}
+	if report.Checks["database"].Status != "fail" || report.Checks["database"].Error == "" {
+		t.Errorf("expected failed database check with error, got %+v", report.Checks["database"])
+	}
+}
+
+// TestConnectWithRetry tests that connection attempts are retried until one succeeds.
+func TestConnectWithRetry(t *testing.T) {
+	calls := 0
+	connect := func() (*gorm.DB, error) {
+		calls++
+		if calls < 3 {
+			return nil, errors.New("connection refused")
+		}
+		return &gorm.DB{}, nil
+	}
+
+	db, err := connectWithRetry(context.Background(), connect, 5, time.Millisecond)
+	if err != nil || db == nil {
+		t.Fatalf("expected success on third attempt, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 attempts, got %d", calls)
+	}
+
+	calls = 0
+	failing := func() (*gorm.DB, error) { calls++; return nil, errors.New("connection refused") }
+	if _, err := connectWithRetry(context.Background(), failing, 2, time.Millisecond); err == nil {
+		t.Fatalf("expected error after exhausting attempts")
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 attempts, got %d", calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := connectWithRetry(ctx, failing, 5, time.Hour); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
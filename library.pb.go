@@ -0,0 +1,943 @@
+// gRPC API of the library for other services. The generated Go code lives
+// in library.pb.go and library_grpc.pb.go; regenerate it after editing
+// this file with:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	  --go-grpc_out=. --go-grpc_opt=paths=source_relative library.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v5.29.3
+// source: library.proto
+
+package main
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type BookRecord struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	Isbn  string                 `protobuf:"bytes,2,opt,name=isbn,proto3" json:"isbn,omitempty"`
+	Title string                 `protobuf:"bytes,3,opt,name=title,proto3" json:"title,omitempty"`
+	// One of "print", "ebook" or "audiobook".
+	Format          string `protobuf:"bytes,4,opt,name=format,proto3" json:"format,omitempty"`
+	PublicationYear int32  `protobuf:"varint,5,opt,name=publication_year,json=publicationYear,proto3" json:"publication_year,omitempty"`
+	Copies          int32  `protobuf:"varint,6,opt,name=copies,proto3" json:"copies,omitempty"`
+	Available       int32  `protobuf:"varint,7,opt,name=available,proto3" json:"available,omitempty"`
+	CoverUrl        string `protobuf:"bytes,8,opt,name=cover_url,json=coverUrl,proto3" json:"cover_url,omitempty"`
+	PublisherId     uint64 `protobuf:"varint,9,opt,name=publisher_id,json=publisherId,proto3" json:"publisher_id,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *BookRecord) Reset() {
+	*x = BookRecord{}
+	mi := &file_library_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BookRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BookRecord) ProtoMessage() {}
+
+func (x *BookRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_library_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BookRecord.ProtoReflect.Descriptor instead.
+func (*BookRecord) Descriptor() ([]byte, []int) {
+	return file_library_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *BookRecord) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *BookRecord) GetIsbn() string {
+	if x != nil {
+		return x.Isbn
+	}
+	return ""
+}
+
+func (x *BookRecord) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *BookRecord) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *BookRecord) GetPublicationYear() int32 {
+	if x != nil {
+		return x.PublicationYear
+	}
+	return 0
+}
+
+func (x *BookRecord) GetCopies() int32 {
+	if x != nil {
+		return x.Copies
+	}
+	return 0
+}
+
+func (x *BookRecord) GetAvailable() int32 {
+	if x != nil {
+		return x.Available
+	}
+	return 0
+}
+
+func (x *BookRecord) GetCoverUrl() string {
+	if x != nil {
+		return x.CoverUrl
+	}
+	return ""
+}
+
+func (x *BookRecord) GetPublisherId() uint64 {
+	if x != nil {
+		return x.PublisherId
+	}
+	return 0
+}
+
+type LoanRecord struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	BookId        uint64                 `protobuf:"varint,2,opt,name=book_id,json=bookId,proto3" json:"book_id,omitempty"`
+	MemberId      uint64                 `protobuf:"varint,3,opt,name=member_id,json=memberId,proto3" json:"member_id,omitempty"`
+	BranchId      uint64                 `protobuf:"varint,4,opt,name=branch_id,json=branchId,proto3" json:"branch_id,omitempty"`
+	LoanDate      *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=loan_date,json=loanDate,proto3" json:"loan_date,omitempty"`
+	DueDate       *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=due_date,json=dueDate,proto3" json:"due_date,omitempty"`
+	Returned      bool                   `protobuf:"varint,7,opt,name=returned,proto3" json:"returned,omitempty"`
+	Overdue       bool                   `protobuf:"varint,8,opt,name=overdue,proto3" json:"overdue,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LoanRecord) Reset() {
+	*x = LoanRecord{}
+	mi := &file_library_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LoanRecord) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LoanRecord) ProtoMessage() {}
+
+func (x *LoanRecord) ProtoReflect() protoreflect.Message {
+	mi := &file_library_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LoanRecord.ProtoReflect.Descriptor instead.
+func (*LoanRecord) Descriptor() ([]byte, []int) {
+	return file_library_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *LoanRecord) GetId() uint64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *LoanRecord) GetBookId() uint64 {
+	if x != nil {
+		return x.BookId
+	}
+	return 0
+}
+
+func (x *LoanRecord) GetMemberId() uint64 {
+	if x != nil {
+		return x.MemberId
+	}
+	return 0
+}
+
+func (x *LoanRecord) GetBranchId() uint64 {
+	if x != nil {
+		return x.BranchId
+	}
+	return 0
+}
+
+func (x *LoanRecord) GetLoanDate() *timestamppb.Timestamp {
+	if x != nil {
+		return x.LoanDate
+	}
+	return nil
+}
+
+func (x *LoanRecord) GetDueDate() *timestamppb.Timestamp {
+	if x != nil {
+		return x.DueDate
+	}
+	return nil
+}
+
+func (x *LoanRecord) GetReturned() bool {
+	if x != nil {
+		return x.Returned
+	}
+	return false
+}
+
+func (x *LoanRecord) GetOverdue() bool {
+	if x != nil {
+		return x.Overdue
+	}
+	return false
+}
+
+type GetBookRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Isbn          string                 `protobuf:"bytes,1,opt,name=isbn,proto3" json:"isbn,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetBookRequest) Reset() {
+	*x = GetBookRequest{}
+	mi := &file_library_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetBookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetBookRequest) ProtoMessage() {}
+
+func (x *GetBookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_library_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetBookRequest.ProtoReflect.Descriptor instead.
+func (*GetBookRequest) Descriptor() ([]byte, []int) {
+	return file_library_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GetBookRequest) GetIsbn() string {
+	if x != nil {
+		return x.Isbn
+	}
+	return ""
+}
+
+type ListBooksRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Number of books fetched from the database per round trip; the server
+	// default is used when zero.
+	PageSize      int32 `protobuf:"varint,1,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListBooksRequest) Reset() {
+	*x = ListBooksRequest{}
+	mi := &file_library_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListBooksRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListBooksRequest) ProtoMessage() {}
+
+func (x *ListBooksRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_library_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListBooksRequest.ProtoReflect.Descriptor instead.
+func (*ListBooksRequest) Descriptor() ([]byte, []int) {
+	return file_library_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ListBooksRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+type AddBookRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Isbn  string                 `protobuf:"bytes,1,opt,name=isbn,proto3" json:"isbn,omitempty"`
+	// Filled in from the ISBN's metadata when empty, if lookup is configured.
+	Title           string `protobuf:"bytes,2,opt,name=title,proto3" json:"title,omitempty"`
+	Format          string `protobuf:"bytes,3,opt,name=format,proto3" json:"format,omitempty"`
+	PublicationYear int32  `protobuf:"varint,4,opt,name=publication_year,json=publicationYear,proto3" json:"publication_year,omitempty"`
+	Copies          int32  `protobuf:"varint,5,opt,name=copies,proto3" json:"copies,omitempty"`
+	PublisherId     uint64 `protobuf:"varint,6,opt,name=publisher_id,json=publisherId,proto3" json:"publisher_id,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *AddBookRequest) Reset() {
+	*x = AddBookRequest{}
+	mi := &file_library_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AddBookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AddBookRequest) ProtoMessage() {}
+
+func (x *AddBookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_library_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AddBookRequest.ProtoReflect.Descriptor instead.
+func (*AddBookRequest) Descriptor() ([]byte, []int) {
+	return file_library_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *AddBookRequest) GetIsbn() string {
+	if x != nil {
+		return x.Isbn
+	}
+	return ""
+}
+
+func (x *AddBookRequest) GetTitle() string {
+	if x != nil {
+		return x.Title
+	}
+	return ""
+}
+
+func (x *AddBookRequest) GetFormat() string {
+	if x != nil {
+		return x.Format
+	}
+	return ""
+}
+
+func (x *AddBookRequest) GetPublicationYear() int32 {
+	if x != nil {
+		return x.PublicationYear
+	}
+	return 0
+}
+
+func (x *AddBookRequest) GetCopies() int32 {
+	if x != nil {
+		return x.Copies
+	}
+	return 0
+}
+
+func (x *AddBookRequest) GetPublisherId() uint64 {
+	if x != nil {
+		return x.PublisherId
+	}
+	return 0
+}
+
+type UpdateBookCopiesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Isbn          string                 `protobuf:"bytes,1,opt,name=isbn,proto3" json:"isbn,omitempty"`
+	Copies        int32                  `protobuf:"varint,2,opt,name=copies,proto3" json:"copies,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateBookCopiesRequest) Reset() {
+	*x = UpdateBookCopiesRequest{}
+	mi := &file_library_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateBookCopiesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateBookCopiesRequest) ProtoMessage() {}
+
+func (x *UpdateBookCopiesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_library_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateBookCopiesRequest.ProtoReflect.Descriptor instead.
+func (*UpdateBookCopiesRequest) Descriptor() ([]byte, []int) {
+	return file_library_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *UpdateBookCopiesRequest) GetIsbn() string {
+	if x != nil {
+		return x.Isbn
+	}
+	return ""
+}
+
+func (x *UpdateBookCopiesRequest) GetCopies() int32 {
+	if x != nil {
+		return x.Copies
+	}
+	return 0
+}
+
+type RemoveBookRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Isbn          string                 `protobuf:"bytes,1,opt,name=isbn,proto3" json:"isbn,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RemoveBookRequest) Reset() {
+	*x = RemoveBookRequest{}
+	mi := &file_library_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RemoveBookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemoveBookRequest) ProtoMessage() {}
+
+func (x *RemoveBookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_library_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemoveBookRequest.ProtoReflect.Descriptor instead.
+func (*RemoveBookRequest) Descriptor() ([]byte, []int) {
+	return file_library_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *RemoveBookRequest) GetIsbn() string {
+	if x != nil {
+		return x.Isbn
+	}
+	return ""
+}
+
+type RemoveBookResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RemoveBookResponse) Reset() {
+	*x = RemoveBookResponse{}
+	mi := &file_library_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RemoveBookResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RemoveBookResponse) ProtoMessage() {}
+
+func (x *RemoveBookResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_library_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RemoveBookResponse.ProtoReflect.Descriptor instead.
+func (*RemoveBookResponse) Descriptor() ([]byte, []int) {
+	return file_library_proto_rawDescGZIP(), []int{7}
+}
+
+type CheckoutBookRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	MemberId uint64                 `protobuf:"varint,1,opt,name=member_id,json=memberId,proto3" json:"member_id,omitempty"`
+	BookId   uint64                 `protobuf:"varint,2,opt,name=book_id,json=bookId,proto3" json:"book_id,omitempty"`
+	DueDate  *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=due_date,json=dueDate,proto3" json:"due_date,omitempty"`
+	// Lending branch; zero lends from the global inventory.
+	BranchId      uint64 `protobuf:"varint,4,opt,name=branch_id,json=branchId,proto3" json:"branch_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CheckoutBookRequest) Reset() {
+	*x = CheckoutBookRequest{}
+	mi := &file_library_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CheckoutBookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CheckoutBookRequest) ProtoMessage() {}
+
+func (x *CheckoutBookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_library_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CheckoutBookRequest.ProtoReflect.Descriptor instead.
+func (*CheckoutBookRequest) Descriptor() ([]byte, []int) {
+	return file_library_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *CheckoutBookRequest) GetMemberId() uint64 {
+	if x != nil {
+		return x.MemberId
+	}
+	return 0
+}
+
+func (x *CheckoutBookRequest) GetBookId() uint64 {
+	if x != nil {
+		return x.BookId
+	}
+	return 0
+}
+
+func (x *CheckoutBookRequest) GetDueDate() *timestamppb.Timestamp {
+	if x != nil {
+		return x.DueDate
+	}
+	return nil
+}
+
+func (x *CheckoutBookRequest) GetBranchId() uint64 {
+	if x != nil {
+		return x.BranchId
+	}
+	return 0
+}
+
+type ReturnBookRequest struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	LoanId uint64                 `protobuf:"varint,1,opt,name=loan_id,json=loanId,proto3" json:"loan_id,omitempty"`
+	// Branch the copy is handed back at; zero means its lending branch.
+	BranchId      uint64 `protobuf:"varint,2,opt,name=branch_id,json=branchId,proto3" json:"branch_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReturnBookRequest) Reset() {
+	*x = ReturnBookRequest{}
+	mi := &file_library_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReturnBookRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReturnBookRequest) ProtoMessage() {}
+
+func (x *ReturnBookRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_library_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReturnBookRequest.ProtoReflect.Descriptor instead.
+func (*ReturnBookRequest) Descriptor() ([]byte, []int) {
+	return file_library_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *ReturnBookRequest) GetLoanId() uint64 {
+	if x != nil {
+		return x.LoanId
+	}
+	return 0
+}
+
+func (x *ReturnBookRequest) GetBranchId() uint64 {
+	if x != nil {
+		return x.BranchId
+	}
+	return 0
+}
+
+type ReturnBookResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ReturnBookResponse) Reset() {
+	*x = ReturnBookResponse{}
+	mi := &file_library_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ReturnBookResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ReturnBookResponse) ProtoMessage() {}
+
+func (x *ReturnBookResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_library_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ReturnBookResponse.ProtoReflect.Descriptor instead.
+func (*ReturnBookResponse) Descriptor() ([]byte, []int) {
+	return file_library_proto_rawDescGZIP(), []int{10}
+}
+
+type ListLoansRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	MemberId      uint64                 `protobuf:"varint,1,opt,name=member_id,json=memberId,proto3" json:"member_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListLoansRequest) Reset() {
+	*x = ListLoansRequest{}
+	mi := &file_library_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListLoansRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListLoansRequest) ProtoMessage() {}
+
+func (x *ListLoansRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_library_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListLoansRequest.ProtoReflect.Descriptor instead.
+func (*ListLoansRequest) Descriptor() ([]byte, []int) {
+	return file_library_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *ListLoansRequest) GetMemberId() uint64 {
+	if x != nil {
+		return x.MemberId
+	}
+	return 0
+}
+
+type ListLoansResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Loans         []*LoanRecord          `protobuf:"bytes,1,rep,name=loans,proto3" json:"loans,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListLoansResponse) Reset() {
+	*x = ListLoansResponse{}
+	mi := &file_library_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListLoansResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListLoansResponse) ProtoMessage() {}
+
+func (x *ListLoansResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_library_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListLoansResponse.ProtoReflect.Descriptor instead.
+func (*ListLoansResponse) Descriptor() ([]byte, []int) {
+	return file_library_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *ListLoansResponse) GetLoans() []*LoanRecord {
+	if x != nil {
+		return x.Loans
+	}
+	return nil
+}
+
+var File_library_proto protoreflect.FileDescriptor
+
+const file_library_proto_rawDesc = "" +
+	"\n" +
+	"\rlibrary.proto\x12\n" +
+	"library.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xff\x01\n" +
+	"\n" +
+	"BookRecord\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12\x12\n" +
+	"\x04isbn\x18\x02 \x01(\tR\x04isbn\x12\x14\n" +
+	"\x05title\x18\x03 \x01(\tR\x05title\x12\x16\n" +
+	"\x06format\x18\x04 \x01(\tR\x06format\x12)\n" +
+	"\x10publication_year\x18\x05 \x01(\x05R\x0fpublicationYear\x12\x16\n" +
+	"\x06copies\x18\x06 \x01(\x05R\x06copies\x12\x1c\n" +
+	"\tavailable\x18\a \x01(\x05R\tavailable\x12\x1b\n" +
+	"\tcover_url\x18\b \x01(\tR\bcoverUrl\x12!\n" +
+	"\fpublisher_id\x18\t \x01(\x04R\vpublisherId\"\x95\x02\n" +
+	"\n" +
+	"LoanRecord\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12\x17\n" +
+	"\abook_id\x18\x02 \x01(\x04R\x06bookId\x12\x1b\n" +
+	"\tmember_id\x18\x03 \x01(\x04R\bmemberId\x12\x1b\n" +
+	"\tbranch_id\x18\x04 \x01(\x04R\bbranchId\x127\n" +
+	"\tloan_date\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\bloanDate\x125\n" +
+	"\bdue_date\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\adueDate\x12\x1a\n" +
+	"\breturned\x18\a \x01(\bR\breturned\x12\x18\n" +
+	"\aoverdue\x18\b \x01(\bR\aoverdue\"$\n" +
+	"\x0eGetBookRequest\x12\x12\n" +
+	"\x04isbn\x18\x01 \x01(\tR\x04isbn\"/\n" +
+	"\x10ListBooksRequest\x12\x1b\n" +
+	"\tpage_size\x18\x01 \x01(\x05R\bpageSize\"\xb8\x01\n" +
+	"\x0eAddBookRequest\x12\x12\n" +
+	"\x04isbn\x18\x01 \x01(\tR\x04isbn\x12\x14\n" +
+	"\x05title\x18\x02 \x01(\tR\x05title\x12\x16\n" +
+	"\x06format\x18\x03 \x01(\tR\x06format\x12)\n" +
+	"\x10publication_year\x18\x04 \x01(\x05R\x0fpublicationYear\x12\x16\n" +
+	"\x06copies\x18\x05 \x01(\x05R\x06copies\x12!\n" +
+	"\fpublisher_id\x18\x06 \x01(\x04R\vpublisherId\"E\n" +
+	"\x17UpdateBookCopiesRequest\x12\x12\n" +
+	"\x04isbn\x18\x01 \x01(\tR\x04isbn\x12\x16\n" +
+	"\x06copies\x18\x02 \x01(\x05R\x06copies\"'\n" +
+	"\x11RemoveBookRequest\x12\x12\n" +
+	"\x04isbn\x18\x01 \x01(\tR\x04isbn\"\x14\n" +
+	"\x12RemoveBookResponse\"\x9f\x01\n" +
+	"\x13CheckoutBookRequest\x12\x1b\n" +
+	"\tmember_id\x18\x01 \x01(\x04R\bmemberId\x12\x17\n" +
+	"\abook_id\x18\x02 \x01(\x04R\x06bookId\x125\n" +
+	"\bdue_date\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\adueDate\x12\x1b\n" +
+	"\tbranch_id\x18\x04 \x01(\x04R\bbranchId\"I\n" +
+	"\x11ReturnBookRequest\x12\x17\n" +
+	"\aloan_id\x18\x01 \x01(\x04R\x06loanId\x12\x1b\n" +
+	"\tbranch_id\x18\x02 \x01(\x04R\bbranchId\"\x14\n" +
+	"\x12ReturnBookResponse\"/\n" +
+	"\x10ListLoansRequest\x12\x1b\n" +
+	"\tmember_id\x18\x01 \x01(\x04R\bmemberId\"A\n" +
+	"\x11ListLoansResponse\x12,\n" +
+	"\x05loans\x18\x01 \x03(\v2\x16.library.v1.LoanRecordR\x05loans2\xf1\x02\n" +
+	"\x0eCatalogService\x12=\n" +
+	"\aGetBook\x12\x1a.library.v1.GetBookRequest\x1a\x16.library.v1.BookRecord\x12C\n" +
+	"\tListBooks\x12\x1c.library.v1.ListBooksRequest\x1a\x16.library.v1.BookRecord0\x01\x12=\n" +
+	"\aAddBook\x12\x1a.library.v1.AddBookRequest\x1a\x16.library.v1.BookRecord\x12O\n" +
+	"\x10UpdateBookCopies\x12#.library.v1.UpdateBookCopiesRequest\x1a\x16.library.v1.BookRecord\x12K\n" +
+	"\n" +
+	"RemoveBook\x12\x1d.library.v1.RemoveBookRequest\x1a\x1e.library.v1.RemoveBookResponse2\xf4\x01\n" +
+	"\x12CirculationService\x12G\n" +
+	"\fCheckoutBook\x12\x1f.library.v1.CheckoutBookRequest\x1a\x16.library.v1.LoanRecord\x12K\n" +
+	"\n" +
+	"ReturnBook\x12\x1d.library.v1.ReturnBookRequest\x1a\x1e.library.v1.ReturnBookResponse\x12H\n" +
+	"\tListLoans\x12\x1c.library.v1.ListLoansRequest\x1a\x1d.library.v1.ListLoansResponseB9Z7github.com/gary-vladimir/GORM_Library_Backend_Demo;mainb\x06proto3"
+
+var (
+	file_library_proto_rawDescOnce sync.Once
+	file_library_proto_rawDescData []byte
+)
+
+func file_library_proto_rawDescGZIP() []byte {
+	file_library_proto_rawDescOnce.Do(func() {
+		file_library_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_library_proto_rawDesc), len(file_library_proto_rawDesc)))
+	})
+	return file_library_proto_rawDescData
+}
+
+var file_library_proto_msgTypes = make([]protoimpl.MessageInfo, 13)
+var file_library_proto_goTypes = []any{
+	(*BookRecord)(nil),              // 0: library.v1.BookRecord
+	(*LoanRecord)(nil),              // 1: library.v1.LoanRecord
+	(*GetBookRequest)(nil),          // 2: library.v1.GetBookRequest
+	(*ListBooksRequest)(nil),        // 3: library.v1.ListBooksRequest
+	(*AddBookRequest)(nil),          // 4: library.v1.AddBookRequest
+	(*UpdateBookCopiesRequest)(nil), // 5: library.v1.UpdateBookCopiesRequest
+	(*RemoveBookRequest)(nil),       // 6: library.v1.RemoveBookRequest
+	(*RemoveBookResponse)(nil),      // 7: library.v1.RemoveBookResponse
+	(*CheckoutBookRequest)(nil),     // 8: library.v1.CheckoutBookRequest
+	(*ReturnBookRequest)(nil),       // 9: library.v1.ReturnBookRequest
+	(*ReturnBookResponse)(nil),      // 10: library.v1.ReturnBookResponse
+	(*ListLoansRequest)(nil),        // 11: library.v1.ListLoansRequest
+	(*ListLoansResponse)(nil),       // 12: library.v1.ListLoansResponse
+	(*timestamppb.Timestamp)(nil),   // 13: google.protobuf.Timestamp
+}
+var file_library_proto_depIdxs = []int32{
+	13, // 0: library.v1.LoanRecord.loan_date:type_name -> google.protobuf.Timestamp
+	13, // 1: library.v1.LoanRecord.due_date:type_name -> google.protobuf.Timestamp
+	13, // 2: library.v1.CheckoutBookRequest.due_date:type_name -> google.protobuf.Timestamp
+	1,  // 3: library.v1.ListLoansResponse.loans:type_name -> library.v1.LoanRecord
+	2,  // 4: library.v1.CatalogService.GetBook:input_type -> library.v1.GetBookRequest
+	3,  // 5: library.v1.CatalogService.ListBooks:input_type -> library.v1.ListBooksRequest
+	4,  // 6: library.v1.CatalogService.AddBook:input_type -> library.v1.AddBookRequest
+	5,  // 7: library.v1.CatalogService.UpdateBookCopies:input_type -> library.v1.UpdateBookCopiesRequest
+	6,  // 8: library.v1.CatalogService.RemoveBook:input_type -> library.v1.RemoveBookRequest
+	8,  // 9: library.v1.CirculationService.CheckoutBook:input_type -> library.v1.CheckoutBookRequest
+	9,  // 10: library.v1.CirculationService.ReturnBook:input_type -> library.v1.ReturnBookRequest
+	11, // 11: library.v1.CirculationService.ListLoans:input_type -> library.v1.ListLoansRequest
+	0,  // 12: library.v1.CatalogService.GetBook:output_type -> library.v1.BookRecord
+	0,  // 13: library.v1.CatalogService.ListBooks:output_type -> library.v1.BookRecord
+	0,  // 14: library.v1.CatalogService.AddBook:output_type -> library.v1.BookRecord
+	0,  // 15: library.v1.CatalogService.UpdateBookCopies:output_type -> library.v1.BookRecord
+	7,  // 16: library.v1.CatalogService.RemoveBook:output_type -> library.v1.RemoveBookResponse
+	1,  // 17: library.v1.CirculationService.CheckoutBook:output_type -> library.v1.LoanRecord
+	10, // 18: library.v1.CirculationService.ReturnBook:output_type -> library.v1.ReturnBookResponse
+	12, // 19: library.v1.CirculationService.ListLoans:output_type -> library.v1.ListLoansResponse
+	12, // [12:20] is the sub-list for method output_type
+	4,  // [4:12] is the sub-list for method input_type
+	4,  // [4:4] is the sub-list for extension type_name
+	4,  // [4:4] is the sub-list for extension extendee
+	0,  // [0:4] is the sub-list for field type_name
+}
+
+func init() { file_library_proto_init() }
+func file_library_proto_init() {
+	if File_library_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_library_proto_rawDesc), len(file_library_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   13,
+			NumExtensions: 0,
+			NumServices:   2,
+		},
+		GoTypes:           file_library_proto_goTypes,
+		DependencyIndexes: file_library_proto_depIdxs,
+		MessageInfos:      file_library_proto_msgTypes,
+	}.Build()
+	File_library_proto = out.File
+	file_library_proto_goTypes = nil
+	file_library_proto_depIdxs = nil
+}
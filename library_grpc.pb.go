@@ -0,0 +1,476 @@
+// gRPC API of the library for other services. The generated Go code lives
+// in library.pb.go and library_grpc.pb.go; regenerate it after editing
+// this file with:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	  --go-grpc_out=. --go-grpc_opt=paths=source_relative library.proto
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.6.2
+// - protoc             v5.29.3
+// source: library.proto
+
+package main
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	CatalogService_GetBook_FullMethodName          = "/library.v1.CatalogService/GetBook"
+	CatalogService_ListBooks_FullMethodName        = "/library.v1.CatalogService/ListBooks"
+	CatalogService_AddBook_FullMethodName          = "/library.v1.CatalogService/AddBook"
+	CatalogService_UpdateBookCopies_FullMethodName = "/library.v1.CatalogService/UpdateBookCopies"
+	CatalogService_RemoveBook_FullMethodName       = "/library.v1.CatalogService/RemoveBook"
+)
+
+// CatalogServiceClient is the client API for CatalogService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// CatalogService manages the books of the caller's tenant.
+type CatalogServiceClient interface {
+	// GetBook returns the book with the given ISBN.
+	GetBook(ctx context.Context, in *GetBookRequest, opts ...grpc.CallOption) (*BookRecord, error)
+	// ListBooks streams the catalogue ordered by title, one book per message.
+	ListBooks(ctx context.Context, in *ListBooksRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[BookRecord], error)
+	AddBook(ctx context.Context, in *AddBookRequest, opts ...grpc.CallOption) (*BookRecord, error)
+	UpdateBookCopies(ctx context.Context, in *UpdateBookCopiesRequest, opts ...grpc.CallOption) (*BookRecord, error)
+	RemoveBook(ctx context.Context, in *RemoveBookRequest, opts ...grpc.CallOption) (*RemoveBookResponse, error)
+}
+
+type catalogServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCatalogServiceClient(cc grpc.ClientConnInterface) CatalogServiceClient {
+	return &catalogServiceClient{cc}
+}
+
+func (c *catalogServiceClient) GetBook(ctx context.Context, in *GetBookRequest, opts ...grpc.CallOption) (*BookRecord, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BookRecord)
+	err := c.cc.Invoke(ctx, CatalogService_GetBook_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *catalogServiceClient) ListBooks(ctx context.Context, in *ListBooksRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[BookRecord], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &CatalogService_ServiceDesc.Streams[0], CatalogService_ListBooks_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ListBooksRequest, BookRecord]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CatalogService_ListBooksClient = grpc.ServerStreamingClient[BookRecord]
+
+func (c *catalogServiceClient) AddBook(ctx context.Context, in *AddBookRequest, opts ...grpc.CallOption) (*BookRecord, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BookRecord)
+	err := c.cc.Invoke(ctx, CatalogService_AddBook_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *catalogServiceClient) UpdateBookCopies(ctx context.Context, in *UpdateBookCopiesRequest, opts ...grpc.CallOption) (*BookRecord, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BookRecord)
+	err := c.cc.Invoke(ctx, CatalogService_UpdateBookCopies_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *catalogServiceClient) RemoveBook(ctx context.Context, in *RemoveBookRequest, opts ...grpc.CallOption) (*RemoveBookResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RemoveBookResponse)
+	err := c.cc.Invoke(ctx, CatalogService_RemoveBook_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CatalogServiceServer is the server API for CatalogService service.
+// All implementations must embed UnimplementedCatalogServiceServer
+// for forward compatibility.
+//
+// CatalogService manages the books of the caller's tenant.
+type CatalogServiceServer interface {
+	// GetBook returns the book with the given ISBN.
+	GetBook(context.Context, *GetBookRequest) (*BookRecord, error)
+	// ListBooks streams the catalogue ordered by title, one book per message.
+	ListBooks(*ListBooksRequest, grpc.ServerStreamingServer[BookRecord]) error
+	AddBook(context.Context, *AddBookRequest) (*BookRecord, error)
+	UpdateBookCopies(context.Context, *UpdateBookCopiesRequest) (*BookRecord, error)
+	RemoveBook(context.Context, *RemoveBookRequest) (*RemoveBookResponse, error)
+	mustEmbedUnimplementedCatalogServiceServer()
+}
+
+// UnimplementedCatalogServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedCatalogServiceServer struct{}
+
+func (UnimplementedCatalogServiceServer) GetBook(context.Context, *GetBookRequest) (*BookRecord, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetBook not implemented")
+}
+func (UnimplementedCatalogServiceServer) ListBooks(*ListBooksRequest, grpc.ServerStreamingServer[BookRecord]) error {
+	return status.Error(codes.Unimplemented, "method ListBooks not implemented")
+}
+func (UnimplementedCatalogServiceServer) AddBook(context.Context, *AddBookRequest) (*BookRecord, error) {
+	return nil, status.Error(codes.Unimplemented, "method AddBook not implemented")
+}
+func (UnimplementedCatalogServiceServer) UpdateBookCopies(context.Context, *UpdateBookCopiesRequest) (*BookRecord, error) {
+	return nil, status.Error(codes.Unimplemented, "method UpdateBookCopies not implemented")
+}
+func (UnimplementedCatalogServiceServer) RemoveBook(context.Context, *RemoveBookRequest) (*RemoveBookResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method RemoveBook not implemented")
+}
+func (UnimplementedCatalogServiceServer) mustEmbedUnimplementedCatalogServiceServer() {}
+func (UnimplementedCatalogServiceServer) testEmbeddedByValue()                        {}
+
+// UnsafeCatalogServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CatalogServiceServer will
+// result in compilation errors.
+type UnsafeCatalogServiceServer interface {
+	mustEmbedUnimplementedCatalogServiceServer()
+}
+
+func RegisterCatalogServiceServer(s grpc.ServiceRegistrar, srv CatalogServiceServer) {
+	// If the following call panics, it indicates UnimplementedCatalogServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&CatalogService_ServiceDesc, srv)
+}
+
+func _CatalogService_GetBook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CatalogServiceServer).GetBook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CatalogService_GetBook_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CatalogServiceServer).GetBook(ctx, req.(*GetBookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CatalogService_ListBooks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(ListBooksRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(CatalogServiceServer).ListBooks(m, &grpc.GenericServerStream[ListBooksRequest, BookRecord]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type CatalogService_ListBooksServer = grpc.ServerStreamingServer[BookRecord]
+
+func _CatalogService_AddBook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddBookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CatalogServiceServer).AddBook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CatalogService_AddBook_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CatalogServiceServer).AddBook(ctx, req.(*AddBookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CatalogService_UpdateBookCopies_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateBookCopiesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CatalogServiceServer).UpdateBookCopies(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CatalogService_UpdateBookCopies_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CatalogServiceServer).UpdateBookCopies(ctx, req.(*UpdateBookCopiesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CatalogService_RemoveBook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemoveBookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CatalogServiceServer).RemoveBook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CatalogService_RemoveBook_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CatalogServiceServer).RemoveBook(ctx, req.(*RemoveBookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// CatalogService_ServiceDesc is the grpc.ServiceDesc for CatalogService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var CatalogService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "library.v1.CatalogService",
+	HandlerType: (*CatalogServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetBook",
+			Handler:    _CatalogService_GetBook_Handler,
+		},
+		{
+			MethodName: "AddBook",
+			Handler:    _CatalogService_AddBook_Handler,
+		},
+		{
+			MethodName: "UpdateBookCopies",
+			Handler:    _CatalogService_UpdateBookCopies_Handler,
+		},
+		{
+			MethodName: "RemoveBook",
+			Handler:    _CatalogService_RemoveBook_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "ListBooks",
+			Handler:       _CatalogService_ListBooks_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "library.proto",
+}
+
+const (
+	CirculationService_CheckoutBook_FullMethodName = "/library.v1.CirculationService/CheckoutBook"
+	CirculationService_ReturnBook_FullMethodName   = "/library.v1.CirculationService/ReturnBook"
+	CirculationService_ListLoans_FullMethodName    = "/library.v1.CirculationService/ListLoans"
+)
+
+// CirculationServiceClient is the client API for CirculationService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// CirculationService checks books out to members and back in.
+type CirculationServiceClient interface {
+	CheckoutBook(ctx context.Context, in *CheckoutBookRequest, opts ...grpc.CallOption) (*LoanRecord, error)
+	ReturnBook(ctx context.Context, in *ReturnBookRequest, opts ...grpc.CallOption) (*ReturnBookResponse, error)
+	// ListLoans returns the loans of a member, most recent first.
+	ListLoans(ctx context.Context, in *ListLoansRequest, opts ...grpc.CallOption) (*ListLoansResponse, error)
+}
+
+type circulationServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewCirculationServiceClient(cc grpc.ClientConnInterface) CirculationServiceClient {
+	return &circulationServiceClient{cc}
+}
+
+func (c *circulationServiceClient) CheckoutBook(ctx context.Context, in *CheckoutBookRequest, opts ...grpc.CallOption) (*LoanRecord, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(LoanRecord)
+	err := c.cc.Invoke(ctx, CirculationService_CheckoutBook_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *circulationServiceClient) ReturnBook(ctx context.Context, in *ReturnBookRequest, opts ...grpc.CallOption) (*ReturnBookResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ReturnBookResponse)
+	err := c.cc.Invoke(ctx, CirculationService_ReturnBook_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *circulationServiceClient) ListLoans(ctx context.Context, in *ListLoansRequest, opts ...grpc.CallOption) (*ListLoansResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListLoansResponse)
+	err := c.cc.Invoke(ctx, CirculationService_ListLoans_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CirculationServiceServer is the server API for CirculationService service.
+// All implementations must embed UnimplementedCirculationServiceServer
+// for forward compatibility.
+//
+// CirculationService checks books out to members and back in.
+type CirculationServiceServer interface {
+	CheckoutBook(context.Context, *CheckoutBookRequest) (*LoanRecord, error)
+	ReturnBook(context.Context, *ReturnBookRequest) (*ReturnBookResponse, error)
+	// ListLoans returns the loans of a member, most recent first.
+	ListLoans(context.Context, *ListLoansRequest) (*ListLoansResponse, error)
+	mustEmbedUnimplementedCirculationServiceServer()
+}
+
+// UnimplementedCirculationServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedCirculationServiceServer struct{}
+
+func (UnimplementedCirculationServiceServer) CheckoutBook(context.Context, *CheckoutBookRequest) (*LoanRecord, error) {
+	return nil, status.Error(codes.Unimplemented, "method CheckoutBook not implemented")
+}
+func (UnimplementedCirculationServiceServer) ReturnBook(context.Context, *ReturnBookRequest) (*ReturnBookResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ReturnBook not implemented")
+}
+func (UnimplementedCirculationServiceServer) ListLoans(context.Context, *ListLoansRequest) (*ListLoansResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListLoans not implemented")
+}
+func (UnimplementedCirculationServiceServer) mustEmbedUnimplementedCirculationServiceServer() {}
+func (UnimplementedCirculationServiceServer) testEmbeddedByValue()                            {}
+
+// UnsafeCirculationServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to CirculationServiceServer will
+// result in compilation errors.
+type UnsafeCirculationServiceServer interface {
+	mustEmbedUnimplementedCirculationServiceServer()
+}
+
+func RegisterCirculationServiceServer(s grpc.ServiceRegistrar, srv CirculationServiceServer) {
+	// If the following call panics, it indicates UnimplementedCirculationServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&CirculationService_ServiceDesc, srv)
+}
+
+func _CirculationService_CheckoutBook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CheckoutBookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CirculationServiceServer).CheckoutBook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CirculationService_CheckoutBook_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CirculationServiceServer).CheckoutBook(ctx, req.(*CheckoutBookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CirculationService_ReturnBook_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReturnBookRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CirculationServiceServer).ReturnBook(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CirculationService_ReturnBook_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CirculationServiceServer).ReturnBook(ctx, req.(*ReturnBookRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _CirculationService_ListLoans_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListLoansRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(CirculationServiceServer).ListLoans(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: CirculationService_ListLoans_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(CirculationServiceServer).ListLoans(ctx, req.(*ListLoansRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// CirculationService_ServiceDesc is the grpc.ServiceDesc for CirculationService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var CirculationService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "library.v1.CirculationService",
+	HandlerType: (*CirculationServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CheckoutBook",
+			Handler:    _CirculationService_CheckoutBook_Handler,
+		},
+		{
+			MethodName: "ReturnBook",
+			Handler:    _CirculationService_ReturnBook_Handler,
+		},
+		{
+			MethodName: "ListLoans",
+			Handler:    _CirculationService_ListLoans_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "library.proto",
+}
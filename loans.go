@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LoanService handles checkout and return of books. Loans change a book's
+// Available count, so cached books are invalidated after each change.
+type LoanService struct {
+	db           *gorm.DB
+	queryTimeout time.Duration
+	cache        *bookCache
+	fines        FinePolicy
+}
+
+// FinePolicy is the fine charged on print loans kept past their due date:
+// PerDayCents for each full day late, capped at MaxCents unless it is
+// zero. A zero PerDayCents charges no fines.
+type FinePolicy struct {
+	PerDayCents int64
+	MaxCents    int64
+}
+
+// finePolicyFromEnv reads the fine policy from FINE_PER_DAY_CENTS and
+// FINE_MAX_CENTS. Fines are off unless FINE_PER_DAY_CENTS is set.
+func finePolicyFromEnv() FinePolicy {
+	var p FinePolicy
+	if n, err := strconv.ParseInt(os.Getenv("FINE_PER_DAY_CENTS"), 10, 64); err == nil && n > 0 {
+		p.PerDayCents = n
+	}
+	if n, err := strconv.ParseInt(os.Getenv("FINE_MAX_CENTS"), 10, 64); err == nil && n > 0 {
+		p.MaxCents = n
+	}
+	return p
+}
+
+// fine returns the fine owed at at for a loan due at due.
+func (p FinePolicy) fine(due, at time.Time) int64 {
+	days := int64(at.Sub(due) / (24 * time.Hour))
+	if days <= 0 || p.PerDayCents <= 0 {
+		return 0
+	}
+	fine := days * p.PerDayCents
+	if p.MaxCents > 0 && fine > p.MaxCents {
+		fine = p.MaxCents
+	}
+	return fine
+}
+
+// Errors returned by ReturnBook.
+var (
+	ErrLoanNotFound = errors.New("loan not found")
+	ErrLoanReturned = errors.New("loan already returned")
+)
+
+// CheckoutBook lends the book with the given ID to a member until dueDate.
+// Members may only borrow for themselves; staff may check out for anyone.
+// Digital books are lent from a license pool, and the loan ends no later
+// than the license does.
+// The availability check and decrement run in the BookLoan hooks inside
+// a single transaction bound to ctx, so cancelling ctx aborts the checkout.
+func (s *LoanService) CheckoutBook(ctx context.Context, memberID, bookID uint, dueDate time.Time) (*BookLoan, error) {
+	return s.CheckoutBookAt(ctx, 0, memberID, bookID, dueDate)
+}
+
+// CheckoutBookAt is like CheckoutBook but lends a copy from the shelves of
+// the given branch. A zero branchID lends from the global inventory only.
+func (s *LoanService) CheckoutBookAt(ctx context.Context, branchID, memberID, bookID uint, dueDate time.Time) (_ *BookLoan, err error) {
+	defer observeOperation("checkout_book", time.Now(), &err)
+	if err := authorizeMember(ctx, memberID); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	loan := &BookLoan{BookID: bookID, MemberID: memberID, BranchID: branchID, LoanDate: time.Now(), DueDate: dueDate}
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := checkTenantRefs(tx, &User{}, "member", memberID); err != nil {
+			return err
+		}
+		if branchID != 0 {
+			if err := findBranches(tx, branchID); err != nil {
+				return err
+			}
+		}
+		if err := assignLicense(tx, loan); err != nil {
+			return err
+		}
+		if err := tx.Create(loan).Error; err != nil {
+			return err
+		}
+		return emitEvent(tx, EventLoanCreated, loan.ID, newLoanEvent(loan))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check out book: %w", err)
+	}
+	s.cache.invalidateBookIDs(ctx, s.db, loan.BookID)
+	return loan, nil
+}
+
+// ReturnBook marks the loan with the given ID as returned at its lending branch.
+// Returns an error if the loan is not found, belongs to another member
+// (for non-staff callers) or was already returned.
+func (s *LoanService) ReturnBook(ctx context.Context, loanID uint) error {
+	return s.ReturnBookAt(ctx, loanID, 0)
+}
+
+// ReturnBookAt marks the loan as returned at the given branch. A copy
+// handed back at its lending branch (or with a zero branchID) goes straight
+// back on the shelf; one returned elsewhere is recorded as an in-transit
+// transfer back to the lending branch and stays unavailable until the
+// transfer is received.
+func (s *LoanService) ReturnBookAt(ctx context.Context, loanID, branchID uint) (err error) {
+	defer observeOperation("return_book", time.Now(), &err)
+	if _, err := authorize(ctx, PermBorrow); err != nil {
+		return err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var bookID uint
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// The row lock makes concurrent returns of the same loan wait, so
+		// only the first sees it open and puts the copy back.
+		var loan BookLoan
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&loan, loanID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrLoanNotFound
+			}
+			return fmt.Errorf("error finding loan: %w", err)
+		}
+		if err := authorizeMember(ctx, loan.MemberID); err != nil {
+			return err
+		}
+		if loan.Returned {
+			return ErrLoanReturned
+		}
+		bookID = loan.BookID
+		if branchID == 0 {
+			branchID = loan.BranchID
+		} else if err := findBranches(tx, branchID); err != nil {
+			return err
+		}
+		now := time.Now()
+		updates := map[string]interface{}{"returned": true, "returned_at": now, "return_branch_id": branchID}
+		if s.fines.PerDayCents > 0 && loan.LicensePoolID == nil {
+			updates["fine_cents"] = s.fines.fine(loan.DueDate, now)
+		}
+		if err := tx.Model(&loan).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to return book: %w", err)
+		}
+		event := newLoanEvent(&loan)
+		event.ReturnedAt = &now
+		if err := emitEvent(tx, EventLoanReturned, loan.ID, event); err != nil {
+			return err
+		}
+		if loan.BranchID == 0 {
+			return nil
+		}
+		if branchID == loan.BranchID {
+			return tx.Model(&BranchInventory{}).
+				Where("branch_id = ? AND book_id = ?", loan.BranchID, loan.BookID).
+				Update("available", gorm.Expr("available + 1")).Error
+		}
+		return tx.Create(&TransferRequest{
+			BookID:       loan.BookID,
+			FromBranchID: branchID,
+			ToBranchID:   loan.BranchID,
+			Quantity:     1,
+			Reason:       TransferReturn,
+			Status:       TransferInTransit,
+			LoanID:       &loan.ID,
+			RequestedBy:  loan.MemberID,
+			ShippedAt:    &now,
+		}).Error
+	})
+	if err != nil {
+		return err
+	}
+	s.cache.invalidateBookIDs(ctx, s.db, bookID)
+	return nil
+}
+
+// ListLoans returns the loans of a member, most recent first.
+// Members may only list their own loans.
+func (s *LoanService) ListLoans(ctx context.Context, memberID uint) (_ []BookLoan, err error) {
+	defer observeOperation("list_loans", time.Now(), &err)
+	if err := authorizeMember(ctx, memberID); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var loans []BookLoan
+	if err := s.db.WithContext(ctx).Where("member_id = ?", memberID).Order("loan_date DESC").Find(&loans).Error; err != nil {
+		return nil, fmt.Errorf("failed to list loans: %w", err)
+	}
+	return loans, nil
+}
+
+// AccrueFines brings the fines of unreturned print loans past their due
+// date up to date as of now and returns how many fines changed. Fines are
+// settled when a loan is returned, so returned loans are left alone.
+// Requires PermManageLoans.
+func (s *LoanService) AccrueFines(ctx context.Context, now time.Time) (_ int, err error) {
+	defer observeOperation("accrue_fines", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageLoans); err != nil {
+		return 0, err
+	}
+	if s.fines.PerDayCents == 0 {
+		return 0, nil
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	db := s.db.WithContext(ctx)
+	var loans []BookLoan
+	if err := db.Select("id", "due_date", "fine_cents").
+		Where("returned = ? AND due_date < ? AND license_pool_id IS NULL", false, now).
+		Find(&loans).Error; err != nil {
+		return 0, fmt.Errorf("failed to find late loans: %w", err)
+	}
+	changed := 0
+	for _, loan := range loans {
+		fine := s.fines.fine(loan.DueDate, now)
+		if fine == loan.FineCents {
+			continue
+		}
+		if err := db.Model(&BookLoan{}).Where("id = ? AND returned = ?", loan.ID, false).
+			UpdateColumn("fine_cents", fine).Error; err != nil {
+			return changed, fmt.Errorf("failed to accrue fine of loan %d: %w", loan.ID, err)
+		}
+		changed++
+	}
+	return changed, nil
+}
+
+// MarkOverdue flags unreturned loans whose due date is before now as
+// overdue and returns how many loans were flagged. Digital loans are
+// returned automatically instead and never become overdue.
+func (s *LoanService) MarkOverdue(ctx context.Context, now time.Time) (_ int64, err error) {
+	defer observeOperation("mark_overdue", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageLoans); err != nil {
+		return 0, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var loans []BookLoan
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&loans).Clauses(clause.Returning{}).
+			Where("returned = ? AND overdue = ? AND due_date < ? AND license_pool_id IS NULL", false, false, now).
+			UpdateColumn("overdue", true).Error
+		if err != nil {
+			return err
+		}
+		for i := range loans {
+			if err := emitEvent(tx, EventLoanOverdue, loans[i].ID, newLoanEvent(&loans[i])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to mark overdue loans: %w", err)
+	}
+	return int64(len(loans)), nil
+}
+
+// writeLoanError maps loan errors to HTTP statuses.
+func writeLoanError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrLoanNotFound), errors.Is(err, ErrBookNotFound), errors.Is(err, ErrBranchNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrLoanReturned), errors.Is(err, ErrBookUnavailable), errors.Is(err, ErrNoLicenseAvailable):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	default:
+		writeError(w, err)
+	}
+}
+
+// handleCheckoutBook serves POST /loans. A branch_id lends a copy from
+// that branch's shelves.
+func (s *apiServer) handleCheckoutBook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		MemberID uint      `json:"member_id"`
+		BookID   uint      `json:"book_id"`
+		BranchID uint      `json:"branch_id"`
+		DueDate  time.Time `json:"due_date"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.BookID == 0 || req.DueDate.IsZero() {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "book_id and due_date are required"})
+		return
+	}
+	loan, err := s.loans.CheckoutBookAt(r.Context(), req.BranchID, req.MemberID, req.BookID, req.DueDate)
+	if err != nil {
+		writeLoanError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, loan)
+}
+
+// handleReturnBook serves POST /loans/{id}/return. A branch_id records the
+// branch the copy was handed back at.
+func (s *apiServer) handleReturnBook(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathUint(w, r, "id")
+	if !ok {
+		return
+	}
+	var req struct {
+		BranchID uint `json:"branch_id"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+			return
+		}
+	}
+	if err := s.loans.ReturnBookAt(r.Context(), id, req.BranchID); err != nil {
+		writeLoanError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
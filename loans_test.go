@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLoanService_CheckoutAndReturn tests a full checkout/return cycle through the service.
+func TestLoanService_CheckoutAndReturn(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	svc := &LoanService{db: db}
+	member := mustCreateUser(t, db, "loan-member", RoleMember)
+	ctx := withPrincipal(context.Background(), member)
+
+	book := &Book{ISBN: "9781212121212", Title: "Loanable", Copies: 1}
+	mustCreateBook(t, db, book)
+
+	loan, err := svc.CheckoutBook(ctx, member.ID, book.ID, time.Now().Add(7*24*time.Hour))
+	if err != nil {
+		t.Fatalf("CheckoutBook returned error: %v", err)
+	}
+
+	var got Book
+	if err := db.First(&got, book.ID).Error; err != nil {
+		t.Fatalf("failed to fetch book: %v", err)
+	}
+	if got.Available != 0 {
+		t.Errorf("Available should be 0 after checkout, got %d", got.Available)
+	}
+
+	if err := svc.ReturnBook(ctx, loan.ID); err != nil {
+		t.Fatalf("ReturnBook returned error: %v", err)
+	}
+	if err := db.First(&got, book.ID).Error; err != nil {
+		t.Fatalf("failed to fetch book: %v", err)
+	}
+	if got.Available != 1 {
+		t.Errorf("Available should be 1 after return, got %d", got.Available)
+	}
+
+	if err := svc.ReturnBook(ctx, loan.ID); err == nil || !strings.Contains(err.Error(), "already returned") {
+		t.Errorf("expected already returned error, got %v", err)
+	}
+}
+
+// TestLoanService_ConcurrentReturns tests that returning the same loan
+// twice at once puts the copy back only once.
+func TestLoanService_ConcurrentReturns(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	svc := &LoanService{db: db}
+	loan := mustCreateLoan(t, db, &BookLoan{})
+
+	ctx := asRole(RoleLibrarian)
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- svc.ReturnBook(ctx, loan.ID) }()
+	}
+	var returned int
+	for i := 0; i < 2; i++ {
+		switch err := <-errs; {
+		case err == nil:
+			returned++
+		case !errors.Is(err, ErrLoanReturned):
+			t.Errorf("unexpected error: %v", err)
+		}
+	}
+	if returned != 1 {
+		t.Errorf("expected exactly one return to succeed, got %d", returned)
+	}
+	var got Book
+	if err := db.First(&got, loan.BookID).Error; err != nil {
+		t.Fatalf("failed to fetch book: %v", err)
+	}
+	if got.Available != got.Copies {
+		t.Errorf("expected %d available after the return, got %d", got.Copies, got.Available)
+	}
+}
+
+// TestFinePolicy tests fines per full day late and the cap.
+func TestFinePolicy(t *testing.T) {
+	due := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	tests := []struct {
+		policy FinePolicy
+		at     time.Time
+		want   int64
+	}{
+		{FinePolicy{PerDayCents: 25}, due.Add(-day), 0},
+		{FinePolicy{PerDayCents: 25}, due.Add(23 * time.Hour), 0},
+		{FinePolicy{PerDayCents: 25}, due.Add(3*day + time.Hour), 75},
+		{FinePolicy{PerDayCents: 25, MaxCents: 100}, due.Add(30 * day), 100},
+		{FinePolicy{}, due.Add(30 * day), 0},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.fine(due, tt.at); got != tt.want {
+			t.Errorf("%+v.fine(%s late) = %d, want %d", tt.policy, tt.at.Sub(due), got, tt.want)
+		}
+	}
+}
+
+// TestLoanService_Fines tests that fines accrue on late print loans and
+// are settled when the loan is returned.
+func TestLoanService_Fines(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	svc := &LoanService{db: db, fines: FinePolicy{PerDayCents: 20, MaxCents: 200}}
+	ctx := asRole(RoleLibrarian)
+
+	now := time.Now()
+	late := mustCreateLoan(t, db, &BookLoan{LoanDate: now.Add(-20 * 24 * time.Hour), DueDate: now.Add(-(5*24 + 1) * time.Hour)})
+	veryLate := mustCreateLoan(t, db, &BookLoan{LoanDate: now.Add(-60 * 24 * time.Hour), DueDate: now.Add(-40 * 24 * time.Hour)})
+	mustCreateLoan(t, db, &BookLoan{})
+
+	if n, err := svc.AccrueFines(ctx, now); err != nil || n != 2 {
+		t.Fatalf("AccrueFines = %d, %v", n, err)
+	}
+	if n, err := svc.AccrueFines(ctx, now); err != nil || n != 0 {
+		t.Errorf("a second run the same day should change nothing, got %d, %v", n, err)
+	}
+	var got BookLoan
+	db.First(&got, late.ID)
+	if got.FineCents != 100 {
+		t.Errorf("expected a fine of 100 for 5 days late, got %d", got.FineCents)
+	}
+	db.First(&got, veryLate.ID)
+	if got.FineCents != 200 {
+		t.Errorf("expected the fine capped at 200, got %d", got.FineCents)
+	}
+
+	if err := svc.ReturnBook(ctx, late.ID); err != nil {
+		t.Fatalf("ReturnBook: %v", err)
+	}
+	db.First(&got, late.ID)
+	if got.FineCents != 100 {
+		t.Errorf("expected the fine settled at 100 on return, got %d", got.FineCents)
+	}
+	if _, err := svc.AccrueFines(asRole(RoleMember), now); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden for a member, got %v", err)
+	}
+}
+
+// TestLoanService_CheckoutCancelled tests that a cancelled context aborts the checkout without side effects.
+func TestLoanService_CheckoutCancelled(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	svc := &LoanService{db: db}
+
+	book := &Book{ISBN: "9781313131313", Title: "Never Lent", Copies: 1}
+	mustCreateBook(t, db, book)
+
+	ctx, cancel := context.WithCancel(asRole(RoleLibrarian))
+	cancel()
+
+	if _, err := svc.CheckoutBook(ctx, 1, book.ID, time.Now().Add(24*time.Hour)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+
+	var got Book
+	if err := db.First(&got, book.ID).Error; err != nil {
+		t.Fatalf("failed to fetch book: %v", err)
+	}
+	if got.Available != 1 {
+		t.Errorf("Available should be untouched after cancelled checkout, got %d", got.Available)
+	}
+}
+
+// TestLoanService_MemberIsolation tests that members can only see and return their own loans.
+func TestLoanService_MemberIsolation(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	svc := &LoanService{db: db}
+	alice := mustCreateUser(t, db, "isolation-alice", RoleMember)
+	bob := mustCreateUser(t, db, "isolation-bob", RoleMember)
+	aliceCtx := withPrincipal(context.Background(), alice)
+	bobCtx := withPrincipal(context.Background(), bob)
+
+	book := &Book{ISBN: "9781515151515", Title: "Shared Shelf", Copies: 2}
+	mustCreateBook(t, db, book)
+
+	loan, err := svc.CheckoutBook(aliceCtx, alice.ID, book.ID, time.Now().Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("CheckoutBook returned error: %v", err)
+	}
+	if _, err := svc.CheckoutBook(bobCtx, alice.ID, book.ID, time.Now().Add(24*time.Hour)); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden borrowing for another member, got %v", err)
+	}
+	if _, err := svc.ListLoans(bobCtx, alice.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden listing another member's loans, got %v", err)
+	}
+	if err := svc.ReturnBook(bobCtx, loan.ID); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden returning another member's loan, got %v", err)
+	}
+
+	loans, err := svc.ListLoans(asRole(RoleLibrarian), alice.ID)
+	if err != nil {
+		t.Fatalf("librarian ListLoans returned error: %v", err)
+	}
+	found := false
+	for _, l := range loans {
+		found = found || l.ID == loan.ID
+	}
+	if !found {
+		t.Errorf("expected loan %d in alice's loans, got %+v", loan.ID, loans)
+	}
+}
+
+// TestFindBook_DeadlineExceeded tests that an expired deadline reaches the database call.
+func TestFindBook_DeadlineExceeded(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	svc := &BookService{db: db, queryTimeout: time.Second}
+
+	ctx, cancel := context.WithDeadline(asRole(RoleGuest), time.Now().Add(-time.Second))
+	defer cancel()
+
+	if _, err := svc.FindBook(ctx, "9788888888888"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+// TestWithQueryTimeout tests that the default timeout applies only when it is tighter than the caller's deadline.
+func TestWithQueryTimeout(t *testing.T) {
+	ctx, cancel := withQueryTimeout(context.Background(), 0)
+	deadline, ok := ctx.Deadline()
+	cancel()
+	if !ok || time.Until(deadline) > defaultQueryTimeout {
+		t.Errorf("expected default timeout deadline, got %v (ok=%v)", deadline, ok)
+	}
+
+	parent, parentCancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer parentCancel()
+	ctx, cancel = withQueryTimeout(parent, time.Minute)
+	defer cancel()
+	got, _ := ctx.Deadline()
+	want, _ := parent.Deadline()
+	if !got.Equal(want) {
+		t.Errorf("expected caller deadline %v to be kept, got %v", want, got)
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils"
+)
+
+// defaultSlowQueryThreshold is used when DB_SLOW_QUERY_THRESHOLD is unset.
+const defaultSlowQueryThreshold = 200 * time.Millisecond
+
+// ctxKey is the type for values this package stores in a context.Context.
+type ctxKey int
+
+const (
+	requestIDKey ctxKey = iota
+	principalKey
+	tenantKey
+	allTenantsKey
+)
+
+// redactedKeys lists log attribute keys that may carry personal data.
+var redactedKeys = map[string]bool{
+	"address":   true,
+	"biography": true,
+	"comment":   true,
+	"dsn":       true,
+	"email":     true,
+	"name":      true,
+	"password":  true,
+	"token":     true,
+}
+
+// withRequestID returns a copy of ctx carrying the given correlation ID.
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// requestIDFromContext returns the correlation ID stored in ctx, if any.
+func requestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// newRequestID generates a random 128-bit correlation ID.
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// contextHandler decorates records with the correlation ID found in the
+// context passed to the *Context logging methods.
+type contextHandler struct {
+	slog.Handler
+}
+
+// Handle adds the request_id attribute before delegating to the wrapped handler.
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := requestIDFromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs keeps the wrapper when attributes are added.
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup keeps the wrapper when a group is opened.
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// redactAttr replaces the value of personal-data attributes with a placeholder.
+func redactAttr(_ []string, a slog.Attr) slog.Attr {
+	if redactedKeys[strings.ToLower(a.Key)] {
+		return slog.String(a.Key, "[REDACTED]")
+	}
+	return a
+}
+
+// newLogger builds a structured logger writing to w in the given format
+// ("json" or "text") with personal data redacted and request IDs attached.
+func newLogger(w io.Writer, level slog.Level, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr}
+	var h slog.Handler
+	if format == "text" {
+		h = slog.NewTextHandler(w, opts)
+	} else {
+		h = slog.NewJSONHandler(w, opts)
+	}
+	return slog.New(contextHandler{h})
+}
+
+// loggerFromEnv configures the application logger from LOG_LEVEL and LOG_FORMAT.
+func loggerFromEnv() *slog.Logger {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+	return newLogger(os.Stdout, level, os.Getenv("LOG_FORMAT"))
+}
+
+// gormLogger adapts a slog.Logger to GORM's logger.Interface.
+// Queries slower than slowThreshold are logged as warnings, and bound
+// parameters are left out of the logged SQL unless logParams is set.
+type gormLogger struct {
+	log           *slog.Logger
+	level         logger.LogLevel
+	slowThreshold time.Duration
+	logParams     bool
+}
+
+// newGormLogger returns a GORM logger backed by log.
+func newGormLogger(log *slog.Logger, slowThreshold time.Duration, logParams bool) *gormLogger {
+	return &gormLogger{
+		log:           log,
+		level:         logger.Info,
+		slowThreshold: slowThreshold,
+		logParams:     logParams,
+	}
+}
+
+// gormLoggerFromEnv builds a GORM logger using DB_SLOW_QUERY_THRESHOLD and
+// LOG_SQL_PARAMS for its configuration.
+func gormLoggerFromEnv(log *slog.Logger) *gormLogger {
+	threshold := defaultSlowQueryThreshold
+	if v := os.Getenv("DB_SLOW_QUERY_THRESHOLD"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			threshold = d
+		}
+	}
+	return newGormLogger(log, threshold, os.Getenv("LOG_SQL_PARAMS") == "true")
+}
+
+// LogMode returns a copy of the logger with the given level.
+func (l *gormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	cp := *l
+	cp.level = level
+	return &cp
+}
+
+// Info logs an informational message.
+func (l *gormLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Info {
+		l.log.InfoContext(ctx, fmt.Sprintf(msg, data...))
+	}
+}
+
+// Warn logs a warning message.
+func (l *gormLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Warn {
+		l.log.WarnContext(ctx, fmt.Sprintf(msg, data...))
+	}
+}
+
+// Error logs an error message.
+func (l *gormLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Error {
+		l.log.ErrorContext(ctx, fmt.Sprintf(msg, data...))
+	}
+}
+
+// Trace logs an executed statement with its duration and affected rows.
+func (l *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	sql, rows := fc()
+	attrs := []any{
+		slog.String("sql", sql),
+		slog.Int64("rows", rows),
+		slog.Duration("elapsed", elapsed),
+		slog.String("caller", utils.FileWithLineNum()),
+	}
+	switch {
+	case err != nil && l.level >= logger.Error && !errors.Is(err, gorm.ErrRecordNotFound):
+		l.log.ErrorContext(ctx, "query failed", append(attrs, slog.Any("error", err))...)
+	case l.slowThreshold > 0 && elapsed > l.slowThreshold && l.level >= logger.Warn:
+		l.log.WarnContext(ctx, "slow query", append(attrs, slog.Duration("threshold", l.slowThreshold))...)
+	case l.level >= logger.Info:
+		l.log.DebugContext(ctx, "query", attrs...)
+	}
+}
+
+// ParamsFilter drops bound parameters from logged SQL so that values such as
+// names or addresses never reach the log output.
+func (l *gormLogger) ParamsFilter(_ context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	if l.logParams {
+		return sql, params
+	}
+	return sql, nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm/logger"
+)
+
+// decodeLogLines parses JSON log output into one map per line.
+func decodeLogLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	t.Helper()
+	var out []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		m := map[string]any{}
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// TestLogger_RequestIDFromContext tests that the correlation ID in ctx is attached to records.
+func TestLogger_RequestIDFromContext(t *testing.T) {
+	var buf bytes.Buffer
+	log := newLogger(&buf, slog.LevelDebug, "json")
+
+	ctx := withRequestID(context.Background(), "req-123")
+	log.With("component", "test").InfoContext(ctx, "hello")
+	log.Info("no context")
+
+	lines := decodeLogLines(t, &buf)
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 log lines, got %d", len(lines))
+	}
+	if lines[0]["request_id"] != "req-123" {
+		t.Errorf("expected request_id req-123, got %v", lines[0]["request_id"])
+	}
+	if _, ok := lines[1]["request_id"]; ok {
+		t.Errorf("unexpected request_id without context: %v", lines[1])
+	}
+}
+
+// TestLogger_RedactsPersonalData tests that personal-data attributes never reach the output.
+func TestLogger_RedactsPersonalData(t *testing.T) {
+	var buf bytes.Buffer
+	log := newLogger(&buf, slog.LevelInfo, "json")
+
+	log.Info("member created", "email", "jane@example.com", "Name", "Jane Doe", "isbn", "9780000000001")
+
+	out := buf.String()
+	if strings.Contains(out, "jane@example.com") || strings.Contains(out, "Jane Doe") {
+		t.Fatalf("personal data leaked into log: %s", out)
+	}
+	if !strings.Contains(out, "9780000000001") {
+		t.Errorf("non-personal attribute should be kept: %s", out)
+	}
+}
+
+// TestGormLogger_SlowQueryWarning tests that statements over the threshold are logged as warnings.
+func TestGormLogger_SlowQueryWarning(t *testing.T) {
+	var buf bytes.Buffer
+	gl := newGormLogger(newLogger(&buf, slog.LevelDebug, "json"), 50*time.Millisecond, false)
+
+	ctx := withRequestID(context.Background(), "req-slow")
+	gl.Trace(ctx, time.Now().Add(-time.Second), func() (string, int64) { return "SELECT 1", 1 }, nil)
+	gl.Trace(ctx, time.Now(), func() (string, int64) { return "SELECT 2", 1 }, nil)
+	gl.Trace(ctx, time.Now(), func() (string, int64) { return "SELECT 3", 0 }, errors.New("boom"))
+
+	lines := decodeLogLines(t, &buf)
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 log lines, got %d", len(lines))
+	}
+	want := []struct{ level, msg string }{{"WARN", "slow query"}, {"DEBUG", "query"}, {"ERROR", "query failed"}}
+	for i, w := range want {
+		if lines[i]["level"] != w.level || lines[i]["msg"] != w.msg {
+			t.Errorf("line %d: got level=%v msg=%v, want %s %q", i, lines[i]["level"], lines[i]["msg"], w.level, w.msg)
+		}
+		if lines[i]["request_id"] != "req-slow" {
+			t.Errorf("line %d: missing request_id: %v", i, lines[i])
+		}
+	}
+
+	buf.Reset()
+	gl.LogMode(logger.Silent).Trace(ctx, time.Now().Add(-time.Second), func() (string, int64) { return "SELECT 1", 1 }, nil)
+	if buf.Len() != 0 {
+		t.Errorf("silent logger should not write, got %s", buf.String())
+	}
+}
+
+// TestGormLogger_ParamsFilter tests that bound parameters are dropped unless explicitly enabled.
+func TestGormLogger_ParamsFilter(t *testing.T) {
+	gl := newGormLogger(slog.Default(), 0, false)
+	if _, params := gl.ParamsFilter(context.Background(), "SELECT $1", "secret"); params != nil {
+		t.Errorf("expected params to be redacted, got %v", params)
+	}
+
+	gl = newGormLogger(slog.Default(), 0, true)
+	if _, params := gl.ParamsFilter(context.Background(), "SELECT $1", "value"); len(params) != 1 {
+		t.Errorf("expected params to be kept, got %v", params)
+	}
+}
@@ -4,29 +4,47 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
+	"gorm.io/gorm/clause"
 )
 
+// defaultQueryTimeout bounds service calls whose context carries no deadline.
+const defaultQueryTimeout = 5 * time.Second
+
+// Startup retry settings used while waiting for the database to come up.
+const (
+	defaultConnectAttempts = 10
+	initialConnectBackoff  = 500 * time.Millisecond
+	maxConnectBackoff      = 30 * time.Second
+)
+
+// AuditLog records a change made outside the regular services, such as a
+// reconciliation fix, with the model and record it applies to.
 type AuditLog struct {
-    ID        uint
-    Action    string
-    ModelType string
-    ModelID   uint
-    Details   string
-    CreatedAt time.Time
+	ID        uint
+	TenantID  uint `gorm:"index;not null;default:0"`
+	Action    string
+	ModelType string
+	ModelID   uint
+	Details   string
+	CreatedAt time.Time
 }
 
 // Author represents a book author with biographical information.
 type Author struct {
 	ID        uint   `gorm:"primaryKey"`
+	TenantID  uint   `gorm:"index;not null;default:0"`
 	Name      string `gorm:"not null"`
 	Biography string `gorm:"type:text"`
 	BirthYear int    `gorm:"type:smallint"`
@@ -35,52 +53,90 @@ type Author struct {
 
 // Book represents a book entity with metadata and relationships.
 type Book struct {
-	ID              uint      `gorm:"primaryKey"`
-	ISBN            string    `gorm:"uniqueIndex;not null;size:13"`
-	Title           string    `gorm:"size:200;not null"`
-	PublicationYear int       `gorm:"type:smallint"`
-	Copies          int       `gorm:"default:0"`
-	Available       int       `gorm:"default:0"`
-	CreatedAt       time.Time `gorm:"autoCreateTime"`
-	LastModified    time.Time `gorm:"autoUpdateTime"`
+	ID              uint       `gorm:"primaryKey"`
+	TenantID        uint       `gorm:"uniqueIndex:idx_books_tenant_isbn;not null;default:0"`
+	ISBN            string     `gorm:"uniqueIndex:idx_books_tenant_isbn;not null;size:13"`
+	Title           string     `gorm:"size:200;not null"`
+	Format          BookFormat `gorm:"type:varchar(20);not null;default:'print'"`
+	PublicationYear int        `gorm:"type:smallint"`
+	Copies          int        `gorm:"default:0"`
+	Available       int        `gorm:"default:0"`
+	CoverURL        string     `gorm:"size:500"`
+	CreatedAt       time.Time  `gorm:"autoCreateTime"`
+	LastModified    time.Time  `gorm:"autoUpdateTime"`
 	PublisherID     uint
 	Publisher       Publisher
-	Authors         []Author    `gorm:"many2many:book_authors;"`
-	Categories      []Category  `gorm:"many2many:book_categories;"`
+	Authors         []Author     `gorm:"many2many:book_authors;"`
+	Categories      []Category   `gorm:"many2many:book_categories;"`
+	Attachments     []Attachment `gorm:"constraint:OnDelete:CASCADE"`
 }
 
-// BookLoan represents a book checkout record
+// BookLoan represents a book checkout record. BranchID is the lending
+// branch (zero for loans not tied to a branch) and ReturnBranchID the
+// branch the copy was handed back at. Loans of digital books are lent from
+// the license pool LicensePoolID instead of the book's copies. FineCents is
+// the late fine owed under the LoanService's FinePolicy.
 type BookLoan struct {
-    ID       uint      `gorm:"primaryKey"`
-    BookID   uint
-    Book     Book
-    LoanDate time.Time
-    DueDate  time.Time
-    Returned bool
+	ID             uint `gorm:"primaryKey"`
+	TenantID       uint `gorm:"index;not null;default:0"`
+	BookID         uint
+	Book           Book
+	MemberID       uint `gorm:"index"`
+	BranchID       uint `gorm:"index"`
+	ReturnBranchID uint
+	LoanDate       time.Time
+	DueDate        time.Time
+	Returned       bool
+	ReturnedAt     *time.Time
+	Overdue        bool  `gorm:"not null;default:false"`
+	LicensePoolID  *uint `gorm:"index"`
+	FineCents      int64 `gorm:"not null;default:0"`
 }
 
 // BookService handles business logic for book-related operations.
+// When metadata is set, books added without a title are completed from
+// their ISBN. When cache is set, FindBook and ListBooks read through it.
 type BookService struct {
-	db *gorm.DB
+	db           *gorm.DB
+	queryTimeout time.Duration
+	metadata     MetadataProvider
+	cache        *bookCache
+	blobs        BlobStore
 }
 
+// ErrBookNotFound is returned when no book has the requested ISBN.
+var ErrBookNotFound = errors.New("book not found")
+
+// ErrBookUnavailable is returned when checking out a book that does not
+// exist or has no copy left.
+var ErrBookUnavailable = errors.New("book not found or not available")
+
+// Page size bounds for ListBooks.
+const (
+	defaultBookPageSize = 50
+	maxBookPageSize     = 200
+)
+
 // Category represents a book category for classification.
 type Category struct {
-	ID    uint   `gorm:"primaryKey"`
-	Name  string `gorm:"not null;unique"`
-	Books []Book `gorm:"many2many:book_categories;"`
+	ID       uint   `gorm:"primaryKey"`
+	TenantID uint   `gorm:"uniqueIndex:idx_categories_tenant_name;not null;default:0"`
+	Name     string `gorm:"uniqueIndex:idx_categories_tenant_name;not null"`
+	Books    []Book `gorm:"many2many:book_categories;"`
 }
 
 // Publisher represents a book publisher with contact information.
 type Publisher struct {
-	ID      uint   `gorm:"primaryKey"`
-	Name    string `gorm:"not null"`
-	Address string `gorm:"type:text"`
+	ID       uint   `gorm:"primaryKey"`
+	TenantID uint   `gorm:"index;not null;default:0"`
+	Name     string `gorm:"not null"`
+	Address  string `gorm:"type:text"`
 }
 
 // Review represents a customer review for a product.
 type Review struct {
 	ID         int  `gorm:"primaryKey"`
+	TenantID   uint `gorm:"index;not null;default:0"`
 	Rating     int  `gorm:"check:rating >= 1 AND rating <= 5"`
 	Comment    string
 	CustomerID uint
@@ -91,6 +147,9 @@ func (b *Book) BeforeCreate(tx *gorm.DB) error {
 	if len(b.ISBN) != 13 {
 		return fmt.Errorf("ISBN must be exactly 13 characters")
 	}
+	if !b.Format.valid() {
+		return fmt.Errorf("unknown book format %q", b.Format)
+	}
 	b.Available = b.Copies
     return nil
 }
@@ -101,17 +160,54 @@ func (b *Book) BeforeSave(tx *gorm.DB) error {
 }
 
 func (b *BookLoan) BeforeCreate(tx *gorm.DB) error {
+	if err := tx.Statement.Context.Err(); err != nil {
+		return fmt.Errorf("loan cancelled: %w", err)
+	}
 	if b.DueDate.Sub(b.LoanDate) > 30*24*time.Hour {
 		return errors.New("loan duration cannot exceed 30 days")
 	}
+	if b.LicensePoolID != nil {
+		res := tx.Model(&LicensePool{}).
+			Where("id = ? AND available > 0 AND (expires_at IS NULL OR expires_at > ?)", *b.LicensePoolID, b.LoanDate).
+			Update("available", gorm.Expr("available - 1"))
+		if res.Error != nil {
+			return fmt.Errorf("failed to update license pool: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrNoLicenseAvailable
+		}
+		return nil
+	}
 	book := Book{}
 	if err := tx.Model(&Book{}).Where("id = ? AND available > 0", b.BookID).First(&book).Error; err != nil {
-		return fmt.Errorf("book not found or not available: %w", err)
+		return fmt.Errorf("%w: %w", ErrBookUnavailable, err)
+	}
+	if b.BranchID != 0 {
+		res := tx.Model(&BranchInventory{}).
+			Where("branch_id = ? AND book_id = ? AND available > 0", b.BranchID, b.BookID).
+			Update("available", gorm.Expr("available - 1"))
+		if res.Error != nil {
+			return fmt.Errorf("failed to update branch inventory: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return errors.New("book not available at branch")
+		}
 	}
 	return tx.Model(&book).Update("available", gorm.Expr("available - 1")).Error
 }
 
 func (b *BookLoan) AfterUpdate(tx *gorm.DB) error {
+	if err := tx.Statement.Context.Err(); err != nil {
+		return fmt.Errorf("return cancelled: %w", err)
+	}
+	if b.Returned && b.LicensePoolID != nil {
+		return tx.Model(&LicensePool{}).Where("id = ?", *b.LicensePoolID).Update("available", gorm.Expr("available + 1")).Error
+	}
+	if b.Returned && b.BranchID != 0 && b.ReturnBranchID != 0 && b.ReturnBranchID != b.BranchID {
+		// Returned at another branch: the copy only becomes available
+		// when its transfer back to the lending branch is received.
+		return nil
+	}
 	if b.Returned {
 		book := Book{}
 		if err := tx.Model(&Book{}).Where("id = ?", b.BookID).First(&book).Error; err != nil {
@@ -123,39 +219,145 @@ func (b *BookLoan) AfterUpdate(tx *gorm.DB) error {
 }
 
 // AddBook creates a new book record in the database.
+// Requires PermManageCatalog. A book without a title is first filled in
+// from the metadata provider, if one is configured.
 // Returns an error if the operation fails.
-func (s *BookService) AddBook(book *Book) error {
-	result := s.db.Create(book)
-	if result.Error != nil {
-		return fmt.Errorf("failed to add book: %w", result.Error)
+func (s *BookService) AddBook(ctx context.Context, book *Book) (err error) {
+	defer observeOperation("add_book", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageCatalog); err != nil {
+		return err
 	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+	if book.Title == "" && s.metadata != nil {
+		if err := s.enrich(ctx, book); err != nil {
+			return err
+		}
+	}
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := checkBookRefs(tx, book); err != nil {
+			return err
+		}
+		if err := tx.Create(book).Error; err != nil {
+			return err
+		}
+		return emitEvent(tx, EventBookAdded, book.ID, newBookEvent(book))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add book: %w", err)
+	}
+	s.cache.invalidate(ctx, book.ISBN)
 	return nil
 }
 
+// checkBookRefs checks that the publisher, authors and categories book
+// refers to by ID belong to the current tenant. Associations without an
+// ID are created along with the book.
+func checkBookRefs(tx *gorm.DB, book *Book) error {
+	if err := checkTenantRefs(tx, &Publisher{}, "publisher", book.PublisherID, book.Publisher.ID); err != nil {
+		return err
+	}
+	authorIDs := make([]uint, len(book.Authors))
+	for i, a := range book.Authors {
+		authorIDs[i] = a.ID
+	}
+	if err := checkTenantRefs(tx, &Author{}, "author", authorIDs...); err != nil {
+		return err
+	}
+	categoryIDs := make([]uint, len(book.Categories))
+	for i, c := range book.Categories {
+		categoryIDs[i] = c.ID
+	}
+	return checkTenantRefs(tx, &Category{}, "category", categoryIDs...)
+}
+
 // FindBook retrieves a book by its ISBN from the database.
 // Returns the book if found, or an error if not found or on database error.
-func (s *BookService) FindBook(isbn string) (*Book, error) {
+func (s *BookService) FindBook(ctx context.Context, isbn string) (_ *Book, err error) {
+	defer observeOperation("find_book", time.Now(), &err)
+	if _, err := authorize(ctx, PermViewCatalog); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
 	var book Book
-	result := s.db.Where("isbn = ?", isbn).First(&book)
+	if s.cache.get(ctx, bookKey(ctx, isbn), &book) {
+		return &book, nil
+	}
+	result := s.db.WithContext(ctx).Where("isbn = ?", isbn).First(&book)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, fmt.Errorf("book not found")
+			return nil, ErrBookNotFound
 		}
 		return nil, fmt.Errorf("error finding book: %w", result.Error)
 	}
+	s.cache.set(ctx, bookKey(ctx, isbn), &book)
 	return &book, nil
 }
 
-// RemoveBook deletes a book from the database by ISBN.
-// Returns an error if the book is not found or on database error.
-func (s *BookService) RemoveBook(isbn string) error {
-	result := s.db.Where("isbn = ?", isbn).Delete(&Book{})
-	if result.Error != nil {
-		return fmt.Errorf("failed to remove book: %w", result.Error)
+// ListBooks returns up to limit books ordered by title, skipping the first
+// offset. limit defaults to 50 and is capped at 200.
+func (s *BookService) ListBooks(ctx context.Context, offset, limit int) (_ []Book, err error) {
+	defer observeOperation("list_books", time.Now(), &err)
+	if _, err := authorize(ctx, PermViewCatalog); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+	if limit <= 0 {
+		limit = defaultBookPageSize
+	}
+	limit = min(limit, maxBookPageSize)
+	offset = max(offset, 0)
+
+	key := s.cache.listKey(ctx, offset, limit)
+	var books []Book
+	if s.cache.get(ctx, key, &books) {
+		return books, nil
+	}
+	if err := s.db.WithContext(ctx).Order("title, id").Offset(offset).Limit(limit).Find(&books).Error; err != nil {
+		return nil, fmt.Errorf("failed to list books: %w", err)
+	}
+	s.cache.set(ctx, key, books)
+	return books, nil
+}
+
+// RemoveBook deletes a book from the database by ISBN, along with its
+// attachments and their blobs.
+// Requires PermManageCatalog. Returns an error if the book is not found or on database error.
+func (s *BookService) RemoveBook(ctx context.Context, isbn string) (err error) {
+	defer observeOperation("remove_book", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageCatalog); err != nil {
+		return err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+	// The database cascades the book's attachment rows; their blobs are
+	// collected first and deleted once the book is gone.
+	var attachments []Attachment
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var book Book
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("isbn = ?", isbn).First(&book).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrBookNotFound
+			}
+			return err
+		}
+		if err := tx.Where("book_id = ?", book.ID).Find(&attachments).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&book).Error
+	})
+	if errors.Is(err, ErrBookNotFound) {
+		return err
 	}
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("book not found")
+	if err != nil {
+		return fmt.Errorf("failed to remove book: %w", err)
+	}
+	if s.blobs != nil {
+		deleteBlobs(ctx, s.blobs, attachments...)
 	}
+	s.cache.invalidate(ctx, isbn)
 	return nil
 }
 
@@ -163,13 +365,15 @@ func (s *BookService) RemoveBook(isbn string) error {
 // Sets up connection pooling and returns a configured GORM database instance.
 func setupDB() (*gorm.DB, error) {
 	dsn := os.Getenv("GO_DATABASE_URL")
-	fmt.Println(dsn, "<< dsn")
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+		Logger: gormLoggerFromEnv(slog.Default()),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
+	if err := registerTenantScope(db); err != nil {
+		return nil, fmt.Errorf("failed to register tenant scope: %w", err)
+	}
 	sqlDB, err := db.DB()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get database instance: %w", err)
@@ -180,83 +384,241 @@ func setupDB() (*gorm.DB, error) {
 	return db, nil
 }
 
-// UpdateBookCopies updates the number of copies for a book by ISBN.
-// Returns an error if the book is not found or on database error.
-func (s *BookService) UpdateBookCopies(isbn string, copies int) error {
-	result := s.db.Model(&Book{}).
-		Where("isbn = ?", isbn).
-		Update("copies", copies)
+// connectWithRetry calls connect until it succeeds, ctx is cancelled or
+// attempts are exhausted, doubling the wait between attempts up to
+// maxConnectBackoff.
+func connectWithRetry(ctx context.Context, connect func() (*gorm.DB, error), attempts int, backoff time.Duration) (*gorm.DB, error) {
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		db, err := connect()
+		if err == nil {
+			return db, nil
+		}
+		lastErr = err
+		if attempt == attempts {
+			break
+		}
+		slog.WarnContext(ctx, "database not reachable, retrying", "attempt", attempt, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("gave up connecting to database: %w", ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxConnectBackoff)
+	}
+	return nil, fmt.Errorf("gave up connecting to database after %d attempts: %w", attempts, lastErr)
+}
 
-	if result.Error != nil {
-		return fmt.Errorf("failed to update copies: %w", result.Error)
+// connectAttemptsFromEnv returns the number of startup connection attempts
+// from DB_CONNECT_ATTEMPTS, falling back to defaultConnectAttempts.
+func connectAttemptsFromEnv() int {
+	if n, err := strconv.Atoi(os.Getenv("DB_CONNECT_ATTEMPTS")); err == nil && n > 0 {
+		return n
+	}
+	return defaultConnectAttempts
+}
+
+// queryTimeoutFromEnv returns the default per-call query timeout, read from
+// DB_QUERY_TIMEOUT (e.g. "3s") and falling back to defaultQueryTimeout.
+func queryTimeoutFromEnv() time.Duration {
+	if v := os.Getenv("DB_QUERY_TIMEOUT"); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultQueryTimeout
+}
+
+// withQueryTimeout bounds ctx by the given timeout (defaultQueryTimeout when
+// zero). An earlier deadline already set by the caller is kept as is.
+func withQueryTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = defaultQueryTimeout
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= timeout {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// UpdateBookCopies updates the number of copies for a book by ISBN.
+// Requires PermManageCatalog. Returns an error if the book is not found or on database error.
+func (s *BookService) UpdateBookCopies(ctx context.Context, isbn string, copies int) (err error) {
+	defer observeOperation("update_book_copies", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageCatalog); err != nil {
+		return err
 	}
-	if result.RowsAffected == 0 {
-		return fmt.Errorf("book not found")
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Book{}).
+			Where("isbn = ?", isbn).
+			Update("copies", copies)
+
+		if result.Error != nil {
+			return fmt.Errorf("failed to update copies: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrBookNotFound
+		}
+		var book Book
+		if err := tx.Where("isbn = ?", isbn).First(&book).Error; err != nil {
+			return fmt.Errorf("failed to reload book: %w", err)
+		}
+		return emitEvent(tx, EventBookCopiesChanged, book.ID, newBookEvent(&book))
+	})
+	if err != nil {
+		return err
 	}
+	s.cache.invalidate(ctx, isbn)
 	return nil
 }
 
 // main is the entry point of the application.
-// It sets up the database, migrates schemas, and demonstrates
-// the book service functionality with sample data.
+// It sets up the database, migrates schemas and then serves HTTP
+// endpoints, or runs the subcommand given on the command line.
 func main() {
-	db, err := setupDB()
+	slog.SetDefault(loggerFromEnv())
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx = withRequestID(ctx, newRequestID())
+
+	db, err := connectWithRetry(ctx, setupDB, connectAttemptsFromEnv(), initialConnectBackoff)
 	if err != nil {
-		log.Fatal(err)
+		slog.ErrorContext(ctx, "database setup failed", "error", err)
+		os.Exit(1)
 	}
-	log.Println("Connected to database")
+	slog.InfoContext(ctx, "connected to database")
 
 	// Get the underlying sql.DB and defer its close here
 	sqlDB, err := db.DB()
 	if err != nil {
-		log.Fatal(err)
+		slog.ErrorContext(ctx, "failed to get database instance", "error", err)
+		os.Exit(1)
 	}
 	defer sqlDB.Close()
 
-	if err := db.AutoMigrate(&Review{}, &Book{}, &Author{}, &Publisher{}, &Category{}); err != nil {
-		log.Fatal("Error migrating database: ", err)
+	if err := migrateDB(ctx, db); err != nil {
+		slog.ErrorContext(ctx, "error migrating database", "error", err)
+		os.Exit(1)
 	}
-	log.Println("Database migrated")
-
-	// Create a book service instance
-	bookService := &BookService{db: db}
+	slog.InfoContext(ctx, "database migrated")
 
-	// Test the book service
-	book := &Book{
-		ISBN:            "978-0-123456-47-2",
-		Title:           "The Go Programming Language",
-		PublicationYear: 2015,
-		Copies:          10,
-		PublisherID:     1,
+	tenantService := &TenantService{db: db, queryTimeout: queryTimeoutFromEnv()}
+	authService := &AuthService{db: db, queryTimeout: queryTimeoutFromEnv()}
+	if err := authService.bootstrapAdmin(ctx, os.Getenv("ADMIN_USERNAME"), os.Getenv("ADMIN_PASSWORD")); err != nil {
+		slog.ErrorContext(ctx, "failed to create admin account", "error", err)
+		os.Exit(1)
 	}
 
-	if err := bookService.AddBook(book); err != nil {
-		log.Printf("Failed to add book: %v", err)
-	} else {
-		fmt.Println("Book added successfully!")
+	cache, err := bookCacheFromEnv()
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to configure book cache", "error", err)
+		os.Exit(1)
 	}
 
-	// Test finding the book
-	foundBook, err := bookService.FindBook("978-0-123456-47-2")
+	// Register maintenance jobs; "job" subcommands run them and exit
+	loanService := &LoanService{db: db, queryTimeout: queryTimeoutFromEnv(), cache: cache, fines: finePolicyFromEnv()}
+	notifications, err := newNotificationService(db)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to configure notifications", "error", err)
+		os.Exit(1)
+	}
+	recommendations := &RecommendationService{db: db, queryTimeout: queryTimeoutFromEnv()}
+	blobs, err := blobStoreFromEnv()
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to configure blob store", "error", err)
+		os.Exit(1)
+	}
+	scheduler := newScheduler(db)
+	if err := registerMaintenanceJobs(scheduler, loanService, notifications, recommendations); err != nil {
+		slog.ErrorContext(ctx, "failed to register jobs", "error", err)
+		os.Exit(1)
+	}
+	if err := registerDigitalLendingJobs(scheduler, loanService); err != nil {
+		slog.ErrorContext(ctx, "failed to register jobs", "error", err)
+		os.Exit(1)
+	}
+	webhooks := newWebhookService(db, queryTimeoutFromEnv())
+	if err := registerWebhookJobs(scheduler, webhooks); err != nil {
+		slog.ErrorContext(ctx, "failed to register jobs", "error", err)
+		os.Exit(1)
+	}
+	limits, err := rateLimitsFromEnv(db)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to configure rate limits", "error", err)
+		os.Exit(1)
+	}
+	if err := registerRateLimitJobs(scheduler, limits); err != nil {
+		slog.ErrorContext(ctx, "failed to register jobs", "error", err)
+		os.Exit(1)
+	}
+	sinks, err := eventSinksFromEnv()
 	if err != nil {
-		log.Printf("Failed to find book: %v", err)
-	} else {
-		fmt.Printf("Found book: %s by ISBN %s\n", foundBook.Title, foundBook.ISBN)
+		slog.ErrorContext(ctx, "failed to configure event sinks", "error", err)
+		os.Exit(1)
+	}
+	relay := newOutboxRelay(db, append(sinks, &webhookFanout{db: db}))
+	if err := registerOutboxJobs(scheduler, relay); err != nil {
+		slog.ErrorContext(ctx, "failed to register jobs", "error", err)
+		os.Exit(1)
 	}
+	if len(os.Args) > 1 {
+		if err := runCommand(ctx, db, scheduler, os.Args[1:], os.Stdout); err != nil {
+			slog.ErrorContext(ctx, "command failed", "error", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	bookService := &BookService{db: db, queryTimeout: queryTimeoutFromEnv(), metadata: metadataProviderFromEnv(), cache: cache, blobs: blobs}
 
-	// Test updating copies
-	if err := bookService.UpdateBookCopies("978-0-123456-47-2", 15); err != nil {
-		log.Printf("Failed to update copies: %v", err)
-	} else {
-		fmt.Println("Book copies updated successfully!")
+	// Run maintenance jobs and deliver domain events in the background
+	go scheduler.Start(ctx)
+	go relay.Run(ctx, outboxPollIntervalFromEnv())
+
+	schema, err := newGraphqlSchema(bookService, loanService)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to set up GraphQL", "error", err)
+		os.Exit(1)
 	}
 
-	review := Review{
-		Rating:     5,
-		Comment:    "Great product!",
-		CustomerID: 1,
-		ProductID:  1,
+	// Serve the API, health and metrics endpoints until interrupted
+	server := &apiServer{
+		db:       db,
+		tenants:  tenantService,
+		auth:     authService,
+		books:    bookService,
+		loans:    loanService,
+		reports:  &ReportService{db: db, queryTimeout: queryTimeoutFromEnv()},
+		registry: newMetricsRegistry(db, sqlDB),
+
+		recommendations: recommendations,
+		attachments:     &AttachmentService{db: db, queryTimeout: queryTimeoutFromEnv(), store: blobs, maxBytes: attachmentMaxBytesFromEnv()},
+		licenses:        &LicenseService{db: db, queryTimeout: queryTimeoutFromEnv()},
+		schema:          schema,
+		webhooks:        webhooks,
+		limits:          limits,
+		stocktakes:      &StocktakeService{db: db, queryTimeout: queryTimeoutFromEnv(), cache: cache},
+		acquisitions:    &AcquisitionService{db: db, queryTimeout: queryTimeoutFromEnv(), cache: cache},
+		branches:        &BranchService{db: db, queryTimeout: queryTimeoutFromEnv(), cache: cache},
+	}
+	if addr := grpcAddrFromEnv(); addr != "off" {
+		go func() {
+			srv := newGRPCServer(tenantService, authService, bookService, loanService)
+			if err := runGRPCServer(ctx, addr, srv); err != nil {
+				slog.ErrorContext(ctx, "grpc server stopped", "error", err)
+			}
+		}()
+	}
+	if addr := metricsAddrFromEnv(); addr != "off" {
+		go func() {
+			if err := runHTTPServer(ctx, addr, server.metricsRoutes()); err != nil {
+				slog.ErrorContext(ctx, "metrics server stopped", "error", err)
+			}
+		}()
+	}
+	if err := runHTTPServer(ctx, httpAddrFromEnv(), server.routes()); err != nil {
+		slog.ErrorContext(ctx, "server stopped", "error", err)
 	}
-	result := db.Create(&review)
-	fmt.Printf("Review created? %v\n", result.Error == nil)
 }
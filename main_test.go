@@ -7,13 +7,18 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 // --- Test helpers ---
@@ -28,9 +33,16 @@ func ensurePublisher(t *testing.T, db *gorm.DB) uint {
 	return p.ID
 }
 
-// mustCreateBook seeds a book ensuring a valid PublisherID.
+// mustCreateBook seeds a book ensuring a valid PublisherID. A book without
+// an ISBN or title gets unique ones.
 func mustCreateBook(t *testing.T, db *gorm.DB, b *Book) {
 	t.Helper()
+	if b.ISBN == "" {
+		b.ISBN = testISBN()
+	}
+	if b.Title == "" {
+		b.Title = fmt.Sprintf("Test Book %d", testSeq.Add(1))
+	}
 	if b.PublisherID == 0 {
 		b.PublisherID = ensurePublisher(t, db)
 	}
@@ -39,40 +51,101 @@ func mustCreateBook(t *testing.T, db *gorm.DB, b *Book) {
 	}
 }
 
-// newTestDB connects to Postgres, migrates schemas, and returns a cleanup function.
+// asRole returns a context acting as an unsaved user with the given role.
+func asRole(role Role) context.Context {
+	return withPrincipal(context.Background(), &User{Username: string(role), Role: role})
+}
+
+// mustCreateUser creates or finds a user with the given username and role.
+func mustCreateUser(t *testing.T, db *gorm.DB, username string, role Role) *User {
+	t.Helper()
+	u := User{Username: username, PasswordHash: "-", Role: role}
+	if err := db.FirstOrCreate(&u, User{Username: username}).Error; err != nil {
+		t.Fatalf("failed to create/find user %s: %v", username, err)
+	}
+	return &u
+}
+
+// newTestDB connects to the Postgres server at TEST_PG_DSN and migrates a
+// schema of its own for the calling test, dropped again by the returned
+// cleanup function, so that tests never see each other's rows. Database
+// tests are skipped when TEST_PG_DSN is not set.
 func newTestDB(t *testing.T) (*gorm.DB, func()) {
 	t.Helper()
 
-	dsn := testPostgresDSN()
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	dsn := os.Getenv("TEST_PG_DSN")
+	if dsn == "" {
+		t.Skip("TEST_PG_DSN is not set")
+	}
+	admin, err := gorm.Open(postgres.Open(dsn), &gorm.Config{Logger: logger.Discard})
 	if err != nil {
 		t.Fatalf("failed to connect to postgres test db: %v", err)
 	}
-
-	if err := db.AutoMigrate(&Review{}, &Book{}, &Author{}, &Publisher{}, &Category{}, &BookLoan{}); err != nil {
-		t.Fatalf("failed to automigrate: %v", err)
+	adminDB, err := admin.DB()
+	if err != nil {
+		t.Fatalf("failed to get sql.DB: %v", err)
+	}
+	schema := testSchemaName(t.Name())
+	if err := admin.Exec("CREATE SCHEMA " + schema).Error; err != nil {
+		t.Fatalf("failed to create schema %s: %v", schema, err)
 	}
 
+	db, err := gorm.Open(postgres.Open(testSchemaDSN(dsn, schema)), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("failed to connect to postgres test db: %v", err)
+	}
 	sqlDB, err := db.DB()
 	if err != nil {
 		t.Fatalf("failed to get sql.DB: %v", err)
 	}
-
+	var once sync.Once
 	cleanup := func() {
-		_ = sqlDB.Close()
+		once.Do(func() {
+			_ = sqlDB.Close()
+			if err := admin.Exec("DROP SCHEMA " + schema + " CASCADE").Error; err != nil {
+				t.Errorf("failed to drop schema %s: %v", schema, err)
+			}
+			_ = adminDB.Close()
+		})
 	}
+	// Tests that stop with t.Fatalf before deferring cleanup still drop
+	// their schema.
+	t.Cleanup(cleanup)
 
+	if err := registerTenantScope(db); err != nil {
+		t.Fatalf("failed to register tenant scope: %v", err)
+	}
+	if err := migrateDB(context.Background(), db); err != nil {
+		t.Fatalf("failed to automigrate: %v", err)
+	}
 	return db, cleanup
 }
 
-// testPostgresDSN returns the test database connection string.
-// Uses TEST_PG_DSN environment variable if set, otherwise returns default.
-func testPostgresDSN() string {
-	if d := os.Getenv("TEST_PG_DSN"); d != "" {
-		return d
+// testSchemaName derives a schema name from a test name, made unique with
+// the current time.
+func testSchemaName(testName string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(testName))
+	if len(name) > 40 {
+		name = name[:40]
+	}
+	return fmt.Sprintf("test_%s_%x", name, time.Now().UnixNano())
+}
+
+// testSchemaDSN returns dsn with its search path set to schema, for both
+// URL and key=value connection strings.
+func testSchemaDSN(dsn, schema string) string {
+	if u, err := url.Parse(dsn); err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql") {
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		return u.String()
 	}
-	// Default provided by you:
-	return "host=localhost user=postgres password=genio123 dbname=gorm_db_test port=5432 sslmode=disable"
+	return dsn + " search_path=" + schema
 }
 
 // --- Tests for BookService.AddBook ---
@@ -82,16 +155,17 @@ func TestAddBook_DuplicateISBN(t *testing.T) {
 	db, cleanup := newTestDB(t)
 	defer cleanup()
 	svc := &BookService{db: db}
+	ctx := asRole(RoleLibrarian)
 
 	pubID := ensurePublisher(t, db)
 
 	b1 := &Book{ISBN: "9780000000001", Title: "First", PublisherID: pubID}
 	b2 := &Book{ISBN: "9780000000001", Title: "Second (dup)", PublisherID: pubID}
 
-	if err := svc.AddBook(b1); err != nil {
+	if err := svc.AddBook(ctx, b1); err != nil {
 		t.Fatalf("unexpected error adding first: %v", err)
 	}
-	if err := svc.AddBook(b2); err == nil {
+	if err := svc.AddBook(ctx, b2); err == nil {
 		t.Fatalf("expected duplicate ISBN error, got nil")
 	} else {
 		low := strings.ToLower(err.Error())
@@ -106,6 +180,7 @@ func TestAddBook_Success(t *testing.T) {
 	db, cleanup := newTestDB(t)
 	defer cleanup()
 	svc := &BookService{db: db}
+	ctx := asRole(RoleLibrarian)
 
 	pubID := ensurePublisher(t, db)
 
@@ -117,7 +192,7 @@ func TestAddBook_Success(t *testing.T) {
 		CreatedAt:       time.Now(),
 		PublisherID:     pubID,
 	}
-	if err := svc.AddBook(book); err != nil {
+	if err := svc.AddBook(ctx, book); err != nil {
 		t.Fatalf("AddBook returned error: %v", err)
 	}
 
@@ -138,11 +213,12 @@ func TestFindBook_Found(t *testing.T) {
 	db, cleanup := newTestDB(t)
 	defer cleanup()
 	svc := &BookService{db: db}
+	ctx := asRole(RoleLibrarian)
 
 	want := &Book{ISBN: "9788888888888", Title: "Found Me", Copies: 2}
 	mustCreateBook(t, db, want)
 
-	got, err := svc.FindBook("9788888888888")
+	got, err := svc.FindBook(ctx, "9788888888888")
 	if err != nil {
 		t.Fatalf("FindBook returned error: %v", err)
 	}
@@ -156,8 +232,9 @@ func TestFindBook_NotFound(t *testing.T) {
 	db, cleanup := newTestDB(t)
 	defer cleanup()
 	svc := &BookService{db: db}
+	ctx := asRole(RoleLibrarian)
 
-	_, err := svc.FindBook("nope")
+	_, err := svc.FindBook(ctx, "nope")
 	if err == nil {
 		t.Fatalf("expected error for missing book, got nil")
 	}
@@ -282,8 +359,9 @@ func TestRemoveBook_NotFound(t *testing.T) {
 	db, cleanup := newTestDB(t)
 	defer cleanup()
 	svc := &BookService{db: db}
+	ctx := asRole(RoleLibrarian)
 
-	if err := svc.RemoveBook("missing"); err == nil {
+	if err := svc.RemoveBook(ctx, "missing"); err == nil {
 		t.Fatalf("expected not found error, got nil")
 	} else if !strings.Contains(strings.ToLower(err.Error()), "book not found") {
 		t.Errorf("unexpected error: %v", err)
@@ -295,10 +373,11 @@ func TestRemoveBook_Success(t *testing.T) {
 	db, cleanup := newTestDB(t)
 	defer cleanup()
 	svc := &BookService{db: db}
+	ctx := asRole(RoleLibrarian)
 
 	mustCreateBook(t, db, &Book{ISBN: "9782222222222", Title: "To Be Removed", Copies: 1})
 
-	if err := svc.RemoveBook("9782222222222"); err != nil {
+	if err := svc.RemoveBook(ctx, "9782222222222"); err != nil {
 		t.Fatalf("RemoveBook returned error: %v", err)
 	}
 
@@ -370,8 +449,9 @@ func TestUpdateBookCopies_NotFound(t *testing.T) {
 	db, cleanup := newTestDB(t)
 	defer cleanup()
 	svc := &BookService{db: db}
+	ctx := asRole(RoleLibrarian)
 
-	if err := svc.UpdateBookCopies("missing", 10); err == nil {
+	if err := svc.UpdateBookCopies(ctx, "missing", 10); err == nil {
 		t.Fatalf("expected not found error, got nil")
 	} else if !strings.Contains(strings.ToLower(err.Error()), "book not found") {
 		t.Errorf("unexpected error: %v", err)
@@ -383,10 +463,11 @@ func TestUpdateBookCopies_Success(t *testing.T) {
 	db, cleanup := newTestDB(t)
 	defer cleanup()
 	svc := &BookService{db: db}
+	ctx := asRole(RoleLibrarian)
 
 	mustCreateBook(t, db, &Book{ISBN: "9789999999999", Title: "Inventory", Copies: 5})
 
-	if err := svc.UpdateBookCopies("9789999999999", 15); err != nil {
+	if err := svc.UpdateBookCopies(ctx, "9789999999999", 15); err != nil {
 		t.Fatalf("UpdateBookCopies returned error: %v", err)
 	}
 
@@ -399,10 +480,10 @@ func TestUpdateBookCopies_Success(t *testing.T) {
 	}
 
 	// Edge cases: zero and large value
-	if err := svc.UpdateBookCopies("9789999999999", 0); err != nil {
+	if err := svc.UpdateBookCopies(ctx, "9789999999999", 0); err != nil {
 		t.Fatalf("update to zero copies failed: %v", err)
 	}
-	if err := svc.UpdateBookCopies("9789999999999", 1000000); err != nil {
+	if err := svc.UpdateBookCopies(ctx, "9789999999999", 1000000); err != nil {
 		t.Fatalf("update to large copies failed: %v", err)
 	}
 }
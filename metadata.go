@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrMetadataNotFound is returned when a provider knows nothing about an ISBN.
+var ErrMetadataNotFound = errors.New("no metadata found for ISBN")
+
+// Metadata defaults used when the corresponding METADATA_* variable is unset.
+const (
+	defaultOpenLibraryURL   = "https://openlibrary.org"
+	defaultMetadataCacheTTL = 24 * time.Hour
+)
+
+// BookMetadata is descriptive data about an edition, found by its ISBN.
+type BookMetadata struct {
+	ISBN            string   `json:"isbn"`
+	Title           string   `json:"title"`
+	PublicationYear int      `json:"publication_year,omitempty"`
+	Authors         []string `json:"authors,omitempty"`
+	Publisher       string   `json:"publisher,omitempty"`
+	Categories      []string `json:"categories,omitempty"`
+	CoverURL        string   `json:"cover_url,omitempty"`
+}
+
+// MetadataProvider looks up book metadata by ISBN. Implementations return
+// ErrMetadataNotFound for unknown ISBNs.
+type MetadataProvider interface {
+	Lookup(ctx context.Context, isbn string) (*BookMetadata, error)
+}
+
+// ErrInvalidISBN is returned for ISBNs that are not 13 digits once
+// hyphens and spaces are removed.
+var ErrInvalidISBN = errors.New("invalid ISBN")
+
+// normalizeISBN strips hyphens and spaces from an ISBN.
+func normalizeISBN(isbn string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(isbn)
+}
+
+// parseISBN normalizes isbn and checks that it is 13 digits. ISBNs are
+// parsed before any lookup, since providers use them in URLs and paths.
+func parseISBN(isbn string) (string, error) {
+	n := normalizeISBN(isbn)
+	if len(n) != 13 || strings.Trim(n, "0123456789") != "" {
+		return "", fmt.Errorf("%w %q: expected 13 digits", ErrInvalidISBN, isbn)
+	}
+	return n, nil
+}
+
+// yearPattern finds a four-digit year in free-form publication dates such
+// as "October 26, 2015".
+var yearPattern = regexp.MustCompile(`\b(1[0-9]{3}|20[0-9]{2})\b`)
+
+// OpenLibraryProvider looks up metadata with the Open Library books API.
+type OpenLibraryProvider struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+// openLibraryBook is the part of an Open Library "jscmd=data" record we use.
+type openLibraryBook struct {
+	Title       string `json:"title"`
+	PublishDate string `json:"publish_date"`
+	Authors     []struct {
+		Name string `json:"name"`
+	} `json:"authors"`
+	Publishers []struct {
+		Name string `json:"name"`
+	} `json:"publishers"`
+	Subjects []struct {
+		Name string `json:"name"`
+	} `json:"subjects"`
+	Cover struct {
+		Medium string `json:"medium"`
+		Large  string `json:"large"`
+	} `json:"cover"`
+}
+
+// Lookup implements MetadataProvider.
+func (p *OpenLibraryProvider) Lookup(ctx context.Context, isbn string) (*BookMetadata, error) {
+	isbn, err := parseISBN(isbn)
+	if err != nil {
+		return nil, err
+	}
+	key := "ISBN:" + isbn
+	u := strings.TrimRight(p.BaseURL, "/") + "/api/books?" + url.Values{
+		"bibkeys": {key},
+		"format":  {"json"},
+		"jscmd":   {"data"},
+	}.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build metadata request: %w", err)
+	}
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch metadata: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("metadata provider returned status %d", resp.StatusCode)
+	}
+
+	var records map[string]openLibraryBook
+	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata: %w", err)
+	}
+	rec, ok := records[key]
+	if !ok {
+		return nil, ErrMetadataNotFound
+	}
+
+	md := &BookMetadata{ISBN: isbn, Title: rec.Title, CoverURL: rec.Cover.Large}
+	if md.CoverURL == "" {
+		md.CoverURL = rec.Cover.Medium
+	}
+	if m := yearPattern.FindString(rec.PublishDate); m != "" {
+		md.PublicationYear, _ = strconv.Atoi(m)
+	}
+	for _, a := range rec.Authors {
+		md.Authors = append(md.Authors, a.Name)
+	}
+	if len(rec.Publishers) > 0 {
+		md.Publisher = rec.Publishers[0].Name
+	}
+	for _, s := range rec.Subjects {
+		md.Categories = append(md.Categories, s.Name)
+	}
+	return md, nil
+}
+
+// FixtureProvider reads metadata from "<isbn>.json" files in Dir. It stands
+// in for a real provider in tests and offline development.
+type FixtureProvider struct {
+	Dir string
+}
+
+// Lookup implements MetadataProvider.
+func (p *FixtureProvider) Lookup(_ context.Context, isbn string) (*BookMetadata, error) {
+	isbn, err := parseISBN(isbn)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(filepath.Join(p.Dir, isbn+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrMetadataNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata fixture: %w", err)
+	}
+	var md BookMetadata
+	if err := json.Unmarshal(b, &md); err != nil {
+		return nil, fmt.Errorf("failed to decode metadata fixture %s: %w", isbn, err)
+	}
+	md.ISBN = isbn
+	return &md, nil
+}
+
+// CachedProvider remembers the answers of another provider, including
+// "not found", for TTL.
+type CachedProvider struct {
+	Provider MetadataProvider
+	TTL      time.Duration
+
+	mu      sync.Mutex
+	entries map[string]metadataCacheEntry
+}
+
+// metadataCacheEntry is a cached lookup result.
+type metadataCacheEntry struct {
+	md      *BookMetadata
+	err     error
+	expires time.Time
+}
+
+// Lookup implements MetadataProvider. Only successful lookups and
+// ErrMetadataNotFound are cached; transient errors are retried next time.
+func (p *CachedProvider) Lookup(ctx context.Context, isbn string) (*BookMetadata, error) {
+	isbn = normalizeISBN(isbn)
+	p.mu.Lock()
+	if e, ok := p.entries[isbn]; ok && time.Now().Before(e.expires) {
+		p.mu.Unlock()
+		return e.md, e.err
+	}
+	p.mu.Unlock()
+
+	md, err := p.Provider.Lookup(ctx, isbn)
+	if err != nil && !errors.Is(err, ErrMetadataNotFound) {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.entries == nil {
+		p.entries = map[string]metadataCacheEntry{}
+	}
+	p.entries[isbn] = metadataCacheEntry{md: md, err: err, expires: time.Now().Add(p.TTL)}
+	return md, err
+}
+
+// metadataProviderFromEnv returns the provider selected by
+// METADATA_PROVIDER ("openlibrary" or "fixtures"), wrapped in a cache, or
+// nil when metadata lookup is disabled.
+func metadataProviderFromEnv() MetadataProvider {
+	var p MetadataProvider
+	switch os.Getenv("METADATA_PROVIDER") {
+	case "openlibrary":
+		base := os.Getenv("METADATA_URL")
+		if base == "" {
+			base = defaultOpenLibraryURL
+		}
+		p = &OpenLibraryProvider{BaseURL: base, Client: &http.Client{Timeout: 10 * time.Second}}
+	case "fixtures":
+		p = &FixtureProvider{Dir: os.Getenv("METADATA_FIXTURES_DIR")}
+	default:
+		return nil
+	}
+	ttl := defaultMetadataCacheTTL
+	if d, err := time.ParseDuration(os.Getenv("METADATA_CACHE_TTL")); err == nil && d > 0 {
+		ttl = d
+	}
+	return &CachedProvider{Provider: p, TTL: ttl}
+}
+
+// PreviewBook looks up the metadata of isbn and returns the book AddBook
+// would save, without saving it. Authors, the publisher and categories
+// that already exist are reused; the others are returned unsaved.
+func (s *BookService) PreviewBook(ctx context.Context, isbn string) (_ *Book, err error) {
+	defer observeOperation("preview_book", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageCatalog); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	book := &Book{ISBN: isbn}
+	if err := s.enrich(ctx, book); err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+// enrich normalizes the ISBN of book and fills its empty fields from the
+// ISBN's metadata.
+func (s *BookService) enrich(ctx context.Context, book *Book) error {
+	isbn, err := parseISBN(book.ISBN)
+	if err != nil {
+		return err
+	}
+	book.ISBN = isbn
+	if s.metadata == nil {
+		return fmt.Errorf("metadata lookup is not configured")
+	}
+	md, err := s.metadata.Lookup(ctx, book.ISBN)
+	if err != nil {
+		return fmt.Errorf("failed to look up %s: %w", book.ISBN, err)
+	}
+	db := s.db.WithContext(ctx)
+
+	if book.Title == "" {
+		book.Title = md.Title
+	}
+	if book.PublicationYear == 0 {
+		book.PublicationYear = md.PublicationYear
+	}
+	if book.CoverURL == "" {
+		book.CoverURL = md.CoverURL
+	}
+	if book.PublisherID == 0 && md.Publisher != "" {
+		var pub Publisher
+		if err := db.Where("name = ?", md.Publisher).Limit(1).Find(&pub).Error; err != nil {
+			return fmt.Errorf("failed to find publisher: %w", err)
+		}
+		if pub.ID == 0 {
+			pub.Name = md.Publisher
+		}
+		book.PublisherID, book.Publisher = pub.ID, pub
+	}
+	if len(book.Authors) == 0 {
+		for _, name := range md.Authors {
+			var a Author
+			if err := db.Where("name = ?", name).Limit(1).Find(&a).Error; err != nil {
+				return fmt.Errorf("failed to find author: %w", err)
+			}
+			if a.ID == 0 {
+				a.Name = name
+			}
+			book.Authors = append(book.Authors, a)
+		}
+	}
+	if len(book.Categories) == 0 {
+		for _, name := range md.Categories {
+			var c Category
+			if err := db.Where("name = ?", name).Limit(1).Find(&c).Error; err != nil {
+				return fmt.Errorf("failed to find category: %w", err)
+			}
+			if c.ID == 0 {
+				c.Name = name
+			}
+			book.Categories = append(book.Categories, c)
+		}
+	}
+	return nil
+}
+
+// handlePreviewBook serves GET /books/{isbn}/preview.
+func (s *apiServer) handlePreviewBook(w http.ResponseWriter, r *http.Request) {
+	book, err := s.books.PreviewBook(r.Context(), r.PathValue("isbn"))
+	if errors.Is(err, ErrInvalidISBN) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ErrMetadataNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, book)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestOpenLibraryProvider tests decoding of a recorded Open Library response.
+func TestOpenLibraryProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		isbn := strings.TrimPrefix(r.URL.Query().Get("bibkeys"), "ISBN:")
+		b, err := os.ReadFile(filepath.Join("testdata", "openlibrary", isbn+".json"))
+		if err != nil {
+			w.Write([]byte("{}"))
+			return
+		}
+		w.Write(b)
+	}))
+	defer srv.Close()
+	p := &OpenLibraryProvider{BaseURL: srv.URL}
+
+	md, err := p.Lookup(context.Background(), "978-0-13-419044-0")
+	if err != nil {
+		t.Fatalf("Lookup: %v", err)
+	}
+	if md.Title != "The Go Programming Language" || md.PublicationYear != 2015 || md.Publisher != "Addison-Wesley" {
+		t.Errorf("unexpected metadata: %+v", md)
+	}
+	if len(md.Authors) != 2 || md.Authors[1] != "Brian W. Kernighan" || len(md.Categories) != 2 {
+		t.Errorf("unexpected authors or categories: %+v", md)
+	}
+	if !strings.HasSuffix(md.CoverURL, "-L.jpg") {
+		t.Errorf("expected the large cover, got %q", md.CoverURL)
+	}
+	if _, err := p.Lookup(context.Background(), "9780000000000"); !errors.Is(err, ErrMetadataNotFound) {
+		t.Errorf("expected ErrMetadataNotFound, got %v", err)
+	}
+}
+
+// countingProvider counts lookups made through it.
+type countingProvider struct {
+	MetadataProvider
+	calls int
+}
+
+func (p *countingProvider) Lookup(ctx context.Context, isbn string) (*BookMetadata, error) {
+	p.calls++
+	return p.MetadataProvider.Lookup(ctx, isbn)
+}
+
+// TestParseISBN tests ISBN normalization and the rejection of anything
+// that could escape a fixture directory or a lookup URL.
+func TestParseISBN(t *testing.T) {
+	if isbn, err := parseISBN(" 978-0-13-419044-0 "); err != nil || isbn != "9780134190440" {
+		t.Errorf("parseISBN = %q, %v; want 9780134190440", isbn, err)
+	}
+	p := &FixtureProvider{Dir: filepath.Join("testdata", "metadata")}
+	for _, isbn := range []string{"", "978013419044", "97801341904400", "978013419044X", "../../../../etc/passwd", "..%2f..%2f9780134190440", "9780134190440/../x"} {
+		if _, err := parseISBN(isbn); !errors.Is(err, ErrInvalidISBN) {
+			t.Errorf("parseISBN(%q): expected ErrInvalidISBN, got %v", isbn, err)
+		}
+		if _, err := p.Lookup(context.Background(), isbn); !errors.Is(err, ErrInvalidISBN) {
+			t.Errorf("FixtureProvider.Lookup(%q): expected ErrInvalidISBN, got %v", isbn, err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/books/x/preview", nil)
+	req.SetPathValue("isbn", "../secret")
+	s := &apiServer{books: &BookService{metadata: p}}
+	s.handlePreviewBook(rec, req.WithContext(asRole(RoleLibrarian)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a malformed ISBN, got %d", rec.Code)
+	}
+}
+
+// TestCachedProvider tests that hits and misses are cached.
+func TestCachedProvider(t *testing.T) {
+	inner := &countingProvider{MetadataProvider: &FixtureProvider{Dir: filepath.Join("testdata", "metadata")}}
+	p := &CachedProvider{Provider: inner, TTL: defaultMetadataCacheTTL}
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		if md, err := p.Lookup(ctx, "9780134190440"); err != nil || md.Title == "" {
+			t.Fatalf("Lookup: %+v, %v", md, err)
+		}
+		if _, err := p.Lookup(ctx, "9780000000000"); !errors.Is(err, ErrMetadataNotFound) {
+			t.Fatalf("expected ErrMetadataNotFound, got %v", err)
+		}
+	}
+	if inner.calls != 2 {
+		t.Errorf("expected 2 provider calls, got %d", inner.calls)
+	}
+}
+
+// TestBookService_PreviewAndAddFromISBN tests preview and enrichment of AddBook.
+func TestBookService_PreviewAndAddFromISBN(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenant := mustCreateTenant(t, &TenantService{db: db}, "metadata")
+	ctx := withTenant(asRole(RoleLibrarian), tenant.ID)
+	svc := &BookService{db: db, metadata: &FixtureProvider{Dir: filepath.Join("testdata", "metadata")}}
+
+	// An existing author is reused rather than duplicated.
+	existing := Author{Name: "Brian W. Kernighan"}
+	if err := db.WithContext(ctx).Create(&existing).Error; err != nil {
+		t.Fatalf("create author: %v", err)
+	}
+
+	preview, err := svc.PreviewBook(ctx, "978-0-13-419044-0")
+	if err != nil {
+		t.Fatalf("PreviewBook: %v", err)
+	}
+	if preview.ID != 0 || preview.Title != "The Go Programming Language" || len(preview.Authors) != 2 || preview.Authors[1].ID != existing.ID {
+		t.Errorf("unexpected preview: %+v", preview)
+	}
+	var count int64
+	db.WithContext(ctx).Model(&Book{}).Count(&count)
+	if count != 0 {
+		t.Errorf("preview must not save the book")
+	}
+
+	book := &Book{ISBN: "9780134190440", Copies: 2}
+	if err := svc.AddBook(ctx, book); err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	var saved Book
+	if err := db.WithContext(ctx).Preload("Authors").Preload("Categories").Preload("Publisher").First(&saved, book.ID).Error; err != nil {
+		t.Fatalf("failed to load book: %v", err)
+	}
+	if saved.Title != "The Go Programming Language" || saved.PublicationYear != 2015 || saved.CoverURL == "" {
+		t.Errorf("book not enriched: %+v", saved)
+	}
+	if len(saved.Authors) != 2 || len(saved.Categories) != 2 || saved.Publisher.Name != "Addison-Wesley" {
+		t.Errorf("associations not enriched: %+v", saved)
+	}
+	var authors int64
+	db.WithContext(ctx).Model(&Author{}).Where("name = ?", existing.Name).Count(&authors)
+	if authors != 1 {
+		t.Errorf("existing author duplicated, found %d", authors)
+	}
+
+	if err := svc.AddBook(ctx, &Book{ISBN: "9780000000000", Copies: 1}); !errors.Is(err, ErrMetadataNotFound) {
+		t.Errorf("expected ErrMetadataNotFound for an unknown untitled book, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"gorm.io/gorm"
+)
+
+// metricsNamespace prefixes every metric exported by the application.
+const metricsNamespace = "library"
+
+var (
+	// operationDuration tracks the latency of service operations.
+	operationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "operation_duration_seconds",
+		Help:      "Latency of book and loan service operations.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	// operationErrors counts service operations that returned an error.
+	operationErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "operation_errors_total",
+		Help:      "Number of book and loan service operations that failed.",
+	}, []string{"operation"})
+)
+
+// observeOperation records the latency of op and counts it as failed when
+// *errp is non-nil. It is meant to be deferred with a named error result.
+func observeOperation(op string, start time.Time, errp *error) {
+	operationDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if errp != nil && *errp != nil {
+		operationErrors.WithLabelValues(op).Inc()
+	}
+}
+
+// inventoryCollector exports catalogue-wide gauges computed at scrape time.
+type inventoryCollector struct {
+	db        *gorm.DB
+	copies    *prometheus.Desc
+	available *prometheus.Desc
+	overdue   *prometheus.Desc
+}
+
+// newInventoryCollector returns a collector reading inventory totals from db.
+func newInventoryCollector(db *gorm.DB) *inventoryCollector {
+	return &inventoryCollector{
+		db:        db,
+		copies:    prometheus.NewDesc(metricsNamespace+"_books_copies", "Total number of copies across all books.", nil, nil),
+		available: prometheus.NewDesc(metricsNamespace+"_books_available", "Number of copies currently available for loan.", nil, nil),
+		overdue:   prometheus.NewDesc(metricsNamespace+"_loans_overdue", "Number of unreturned loans past their due date.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *inventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.copies
+	ch <- c.available
+	ch <- c.overdue
+}
+
+// Collect implements prometheus.Collector.
+func (c *inventoryCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(withAllTenants(context.Background()), defaultQueryTimeout)
+	defer cancel()
+	db := c.db.WithContext(ctx)
+
+	var totals struct {
+		Copies    int64
+		Available int64
+	}
+	err := db.Model(&Book{}).
+		Select("COALESCE(SUM(copies), 0) AS copies, COALESCE(SUM(available), 0) AS available").
+		Scan(&totals).Error
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.copies, err)
+		ch <- prometheus.NewInvalidMetric(c.available, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.copies, prometheus.GaugeValue, float64(totals.Copies))
+		ch <- prometheus.MustNewConstMetric(c.available, prometheus.GaugeValue, float64(totals.Available))
+	}
+
+	var overdue int64
+	if err := db.Model(&BookLoan{}).Where("returned = ? AND due_date < ?", false, time.Now()).Count(&overdue).Error; err != nil {
+		ch <- prometheus.NewInvalidMetric(c.overdue, err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.overdue, prometheus.GaugeValue, float64(overdue))
+	}
+}
+
+// newMetricsRegistry builds the registry served on /metrics: Go runtime and
+// process metrics, connection pool statistics of sqlDB, service operation
+// metrics and inventory gauges.
+func newMetricsRegistry(db *gorm.DB, sqlDB *sql.DB) *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(sqlDB, "library"),
+		operationDuration,
+		operationErrors,
+		cacheRequests,
+		outboxDeliveries,
+		rateLimitedRequests,
+		newInventoryCollector(db),
+	)
+	return reg
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestObserveOperation_CountsErrors tests that only failed operations increment the error counter.
+func TestObserveOperation_CountsErrors(t *testing.T) {
+	const op = "test_observe_operation"
+	before := testutil.ToFloat64(operationErrors.WithLabelValues(op))
+
+	var ok error
+	observeOperation(op, time.Now(), &ok)
+	failed := errors.New("boom")
+	observeOperation(op, time.Now(), &failed)
+
+	if got := testutil.ToFloat64(operationErrors.WithLabelValues(op)) - before; got != 1 {
+		t.Errorf("expected 1 error to be counted, got %v", got)
+	}
+	if got := testutil.CollectAndCount(operationDuration, metricsNamespace+"_operation_duration_seconds"); got == 0 {
+		t.Errorf("expected latency histogram to be populated")
+	}
+}
+
+// TestMetricsEndpoint tests that /metrics exports pool stats and inventory
+// gauges on the metrics server and is not part of the API.
+func TestMetricsEndpoint(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+
+	mustCreateBook(t, db, &Book{ISBN: "9781414141414", Title: "Counted", Copies: 4})
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get sql.DB: %v", err)
+	}
+	srv := &apiServer{registry: newMetricsRegistry(db, sqlDB)}
+	api := httptest.NewServer(srv.routes())
+	defer api.Close()
+	if resp, err := http.Get(api.URL + "/metrics"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected /metrics to be absent from the API, got %v, %v", resp, err)
+	} else {
+		resp.Body.Close()
+	}
+
+	ts := httptest.NewServer(srv.metricsRoutes())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("GET /metrics failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status %d: %s", resp.StatusCode, body)
+	}
+	for _, name := range []string{
+		"go_sql_open_connections",
+		"library_books_copies",
+		"library_books_available",
+		"library_loans_overdue",
+	} {
+		if !strings.Contains(string(body), name) {
+			t.Errorf("expected metric %s in output", name)
+		}
+	}
+}
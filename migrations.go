@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// schemaVersion is the schema version this build expects. Bump it whenever
+// migrateDB starts migrating new models or columns.
+const schemaVersion = 18
+
+// SchemaMigration records each schema version applied to the database.
+type SchemaMigration struct {
+	Version   uint `gorm:"primaryKey;autoIncrement:false"`
+	AppliedAt time.Time
+}
+
+// migrateDB migrates all models and records schemaVersion as applied.
+func migrateDB(ctx context.Context, db *gorm.DB) error {
+	db = db.WithContext(ctx)
+	if err := db.AutoMigrate(
+		&SchemaMigration{},
+		&Review{},
+		&Book{},
+		&Author{},
+		&Publisher{},
+		&Category{},
+		&BookLoan{},
+		&User{},
+		&APIToken{},
+		&Branch{},
+		&BranchInventory{},
+		&TransferRequest{},
+		&Tenant{},
+		&NotificationRecord{},
+		&JobRun{},
+		&BookSimilarity{},
+		&MemberRecommendation{},
+		&Attachment{},
+		&LicensePool{},
+		&OutboxEvent{},
+		&WebhookSubscription{},
+		&WebhookDelivery{},
+		&RateLimitBucket{},
+		&AuditLog{},
+		&StocktakeSession{},
+		&StocktakeScan{},
+		&Vendor{},
+		&Fund{},
+		&PurchaseOrder{},
+		&PurchaseOrderLine{},
+		&PurchaseReceipt{},
+	); err != nil {
+		return fmt.Errorf("failed to migrate schema: %w", err)
+	}
+	if err := dropGlobalUniques(db); err != nil {
+		return err
+	}
+	m := SchemaMigration{Version: schemaVersion, AppliedAt: time.Now()}
+	if err := db.FirstOrCreate(&m, SchemaMigration{Version: schemaVersion}).Error; err != nil {
+		return fmt.Errorf("failed to record schema version: %w", err)
+	}
+	return nil
+}
+
+// globalUniques lists unique indexes and constraints that predate
+// multi-tenancy and are now replaced by per-tenant unique indexes.
+var globalUniques = []struct {
+	model interface{}
+	name  string
+}{
+	{&Book{}, "idx_books_isbn"},
+	{&Category{}, "uni_categories_name"},
+	{&Category{}, "categories_name_key"},
+	{&User{}, "idx_users_username"},
+	{&Branch{}, "idx_branches_code"},
+}
+
+// dropGlobalUniques removes the pre-tenancy unique indexes and constraints
+// so that the same ISBN, category, username or branch code can exist in
+// several tenants.
+func dropGlobalUniques(db *gorm.DB) error {
+	m := db.Migrator()
+	for _, u := range globalUniques {
+		if m.HasConstraint(u.model, u.name) {
+			if err := m.DropConstraint(u.model, u.name); err != nil {
+				return fmt.Errorf("failed to drop constraint %s: %w", u.name, err)
+			}
+		}
+		if m.HasIndex(u.model, u.name) {
+			if err := m.DropIndex(u.model, u.name); err != nil {
+				return fmt.Errorf("failed to drop index %s: %w", u.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// currentSchemaVersion returns the highest schema version applied to db.
+func currentSchemaVersion(ctx context.Context, db *gorm.DB) (uint, error) {
+	var version uint
+	err := db.WithContext(ctx).Model(&SchemaMigration{}).
+		Select("COALESCE(MAX(version), 0)").
+		Scan(&version).Error
+	if err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultNATSTimeout bounds a publish when the context has no deadline.
+const defaultNATSTimeout = 10 * time.Second
+
+// NATSPublisher is a MessagePublisher for a NATS server. It speaks the core
+// text protocol in verbose mode, so each message is acknowledged by the
+// server before Publish returns. Core NATS has no message keys; the key is
+// dropped, and the payload carries the tenant and aggregate anyway.
+type NATSPublisher struct {
+	Addr     string
+	User     string
+	Password string
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// newNATSPublisher returns a publisher for a nats://[user:password@]host:port URL.
+func newNATSPublisher(rawURL string) (*NATSPublisher, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "nats" || u.Host == "" {
+		return nil, fmt.Errorf("invalid NATS URL %q: want nats://host:port", rawURL)
+	}
+	p := &NATSPublisher{Addr: u.Host}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		p.Addr = net.JoinHostPort(u.Host, "4222")
+	}
+	if u.User != nil {
+		p.User = u.User.Username()
+		p.Password, _ = u.User.Password()
+	}
+	return p, nil
+}
+
+// Publish implements MessagePublisher.
+func (p *NATSPublisher) Publish(ctx context.Context, topic string, _, value []byte) error {
+	if topic == "" || strings.ContainsAny(topic, " \t\r\n") {
+		return fmt.Errorf("invalid NATS subject %q", topic)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultNATSTimeout)
+	}
+	if p.conn == nil {
+		if err := p.connect(ctx, deadline); err != nil {
+			return err
+		}
+	}
+	p.conn.SetDeadline(deadline)
+	msg := fmt.Sprintf("PUB %s %d\r\n%s\r\n", topic, len(value), value)
+	if _, err := p.conn.Write([]byte(msg)); err != nil {
+		p.close()
+		return fmt.Errorf("failed to publish to NATS: %w", err)
+	}
+	if err := p.awaitOK(); err != nil {
+		p.close()
+		return err
+	}
+	return nil
+}
+
+// connect dials the server, reads its INFO and sends CONNECT.
+func (p *NATSPublisher) connect(ctx context.Context, deadline time.Time) error {
+	dialer := net.Dialer{Deadline: deadline}
+	conn, err := dialer.DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	conn.SetDeadline(deadline)
+	p.conn, p.r = conn, bufio.NewReader(conn)
+	line, err := p.r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "INFO ") {
+		p.close()
+		return fmt.Errorf("unexpected NATS greeting %q: %v", strings.TrimSpace(line), err)
+	}
+	opts, _ := json.Marshal(map[string]interface{}{
+		"verbose": true, "pedantic": false, "name": "library", "lang": "go",
+		"user": p.User, "pass": p.Password,
+	})
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", opts); err != nil {
+		p.close()
+		return fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+	if err := p.awaitOK(); err != nil {
+		p.close()
+		return err
+	}
+	return nil
+}
+
+// awaitOK reads until the server acknowledges the last command, answering
+// its pings on the way.
+func (p *NATSPublisher) awaitOK() error {
+	for {
+		line, err := p.r.ReadString('\n')
+		if err != nil {
+			return fmt.Errorf("failed to read NATS reply: %w", err)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "+OK":
+			return nil
+		case line == "PING":
+			if _, err := p.conn.Write([]byte("PONG\r\n")); err != nil {
+				return fmt.Errorf("failed to answer NATS ping: %w", err)
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return fmt.Errorf("NATS refused the message: %s", strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// close drops the connection; the next Publish reconnects.
+func (p *NATSPublisher) close() {
+	if p.conn != nil {
+		p.conn.Close()
+	}
+	p.conn, p.r = nil, nil
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// defaultReminderDays is used when NOTIFY_REMINDER_DAYS is unset.
+const defaultReminderDays = 3
+
+// defaultOverdueLevels are the days past DueDate at which overdue notices
+// escalate: a first notice once overdue, then reminders after one and two weeks.
+var defaultOverdueLevels = []int{0, 7, 14}
+
+// NotificationKind identifies the template and purpose of a notification.
+type NotificationKind string
+
+// Notification kinds.
+const (
+	NotifyDueSoon NotificationKind = "due_soon"
+	NotifyOverdue NotificationKind = "overdue"
+)
+
+// defaultTemplates holds the built-in subject and body templates, keyed by
+// "<kind>.subject" and "<kind>.body".
+var defaultTemplates = map[string]string{
+	"due_soon.subject": `Reminder: "{{.Book.Title}}" is due {{.DueDate.Format "Jan 2"}}`,
+	"due_soon.body": `Hello {{.Member.Username}},
+
+"{{.Book.Title}}" (ISBN {{.Book.ISBN}}) is due on {{.DueDate.Format "Monday, Jan 2"}}.
+Please return or renew it before then.
+`,
+	"overdue.subject": `{{if ge .Level 3}}Final notice{{else if eq .Level 2}}Second notice{{else}}Overdue{{end}}: "{{.Book.Title}}"`,
+	"overdue.body": `Hello {{.Member.Username}},
+
+"{{.Book.Title}}" (ISBN {{.Book.ISBN}}) was due on {{.DueDate.Format "Monday, Jan 2"}} and is {{.DaysOverdue}} day(s) overdue.
+{{if ge .Level 3}}This is the final notice before the loan is escalated to library staff.
+{{end}}Please return it as soon as possible.
+`,
+}
+
+// Message is a rendered notification ready to be sent.
+type Message struct {
+	Kind    NotificationKind `json:"kind"`
+	Level   int              `json:"level"`
+	LoanID  uint             `json:"loan_id"`
+	To      string           `json:"to"`
+	Subject string           `json:"subject"`
+	Body    string           `json:"body"`
+}
+
+// Channel delivers messages to members.
+type Channel interface {
+	Name() string
+	Send(ctx context.Context, msg Message) error
+}
+
+// NotificationRecord remembers each notification sent so that it is never
+// sent twice over the same channel.
+type NotificationRecord struct {
+	ID       uint             `gorm:"primaryKey"`
+	TenantID uint             `gorm:"index;not null;default:0"`
+	LoanID   uint             `gorm:"uniqueIndex:idx_notification_once;not null"`
+	Kind     NotificationKind `gorm:"uniqueIndex:idx_notification_once;type:varchar(20);not null"`
+	Level    int              `gorm:"uniqueIndex:idx_notification_once;not null"`
+	Channel  string           `gorm:"uniqueIndex:idx_notification_once;size:50;not null"`
+	SentAt   time.Time
+}
+
+// SMTPChannel sends messages as plain-text email.
+type SMTPChannel struct {
+	Addr string
+	From string
+	Auth smtp.Auth
+}
+
+// Name implements Channel.
+func (c *SMTPChannel) Name() string { return "email" }
+
+// Send implements Channel.
+func (c *SMTPChannel) Send(_ context.Context, msg Message) error {
+	email, err := emailMessage(c.From, msg)
+	if err != nil {
+		return err
+	}
+	if err := smtp.SendMail(c.Addr, c.Auth, c.From, []string{msg.To}, email); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// emailMessage formats msg as a plain-text email. The subject, which
+// contains book titles, has line breaks removed and is Q-encoded, so it
+// cannot add headers; an address with a line break is refused.
+func emailMessage(from string, msg Message) ([]byte, error) {
+	if msg.To == "" {
+		return nil, fmt.Errorf("member has no email address")
+	}
+	if strings.ContainsAny(msg.To, "\r\n") || strings.ContainsAny(from, "\r\n") {
+		return nil, fmt.Errorf("invalid email address %q", msg.To)
+	}
+	subject := strings.Join(strings.FieldsFunc(msg.Subject, func(r rune) bool { return r == '\r' || r == '\n' }), " ")
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\nTo: %s\r\nSubject: %s\r\n", from, msg.To, mime.QEncoding.Encode("utf-8", subject))
+	b.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String()), nil
+}
+
+// WebhookChannel posts messages as JSON to a URL.
+type WebhookChannel struct {
+	URL    string
+	Client *http.Client
+}
+
+// Name implements Channel.
+func (c *WebhookChannel) Name() string { return "webhook" }
+
+// Send implements Channel.
+func (c *WebhookChannel) Send(ctx context.Context, msg Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// FileOutboxChannel appends messages as JSON lines to a local file. It is
+// meant for development and tests.
+type FileOutboxChannel struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// Name implements Channel.
+func (c *FileOutboxChannel) Name() string { return "outbox" }
+
+// Send implements Channel.
+func (c *FileOutboxChannel) Send(_ context.Context, msg Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f, err := os.OpenFile(c.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open outbox: %w", err)
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(msg); err != nil {
+		return fmt.Errorf("failed to write outbox: %w", err)
+	}
+	return nil
+}
+
+// channelsFromEnv builds the notification channels configured through
+// NOTIFY_SMTP_ADDR, NOTIFY_WEBHOOK_URL and NOTIFY_OUTBOX_FILE.
+func channelsFromEnv() []Channel {
+	var channels []Channel
+	if addr := os.Getenv("NOTIFY_SMTP_ADDR"); addr != "" {
+		c := &SMTPChannel{Addr: addr, From: os.Getenv("NOTIFY_SMTP_FROM")}
+		if user := os.Getenv("NOTIFY_SMTP_USER"); user != "" {
+			host, _, _ := strings.Cut(addr, ":")
+			c.Auth = smtp.PlainAuth("", user, os.Getenv("NOTIFY_SMTP_PASSWORD"), host)
+		}
+		channels = append(channels, c)
+	}
+	if url := os.Getenv("NOTIFY_WEBHOOK_URL"); url != "" {
+		channels = append(channels, &WebhookChannel{URL: url, Client: &http.Client{Timeout: 10 * time.Second}})
+	}
+	if path := os.Getenv("NOTIFY_OUTBOX_FILE"); path != "" {
+		channels = append(channels, &FileOutboxChannel{Path: path})
+	}
+	return channels
+}
+
+// parseTemplates parses the built-in templates, replacing any of them with
+// a "<name>.tmpl" file found in dir (when dir is not empty).
+func parseTemplates(dir string) (*template.Template, error) {
+	root := template.New("notifications")
+	for name, text := range defaultTemplates {
+		if dir != "" {
+			if b, err := os.ReadFile(filepath.Join(dir, name+".tmpl")); err == nil {
+				text = string(b)
+			}
+		}
+		if _, err := root.New(name).Parse(text); err != nil {
+			return nil, fmt.Errorf("failed to parse template %s: %w", name, err)
+		}
+	}
+	return root, nil
+}
+
+// notificationData is the data available to notification templates.
+type notificationData struct {
+	Member      User
+	Book        Book
+	DueDate     time.Time
+	DaysOverdue int
+	Level       int
+}
+
+// overdueLevel returns the escalation level (1-based) reached by a loan
+// that is daysOverdue days late, or 0 if no level is reached yet.
+func overdueLevel(daysOverdue int, levels []int) int {
+	level := 0
+	for i, d := range levels {
+		if daysOverdue >= d {
+			level = i + 1
+		}
+	}
+	return level
+}
+
+// NotificationService sends due-date reminders and overdue notices.
+type NotificationService struct {
+	db            *gorm.DB
+	channels      []Channel
+	templates     *template.Template
+	reminderDays  int
+	overdueLevels []int
+}
+
+// newNotificationService configures a NotificationService from the
+// NOTIFY_* environment variables.
+func newNotificationService(db *gorm.DB) (*NotificationService, error) {
+	tmpl, err := parseTemplates(os.Getenv("NOTIFY_TEMPLATE_DIR"))
+	if err != nil {
+		return nil, err
+	}
+	days := defaultReminderDays
+	if n, err := strconv.Atoi(os.Getenv("NOTIFY_REMINDER_DAYS")); err == nil && n >= 0 {
+		days = n
+	}
+	return &NotificationService{
+		db:            db,
+		channels:      channelsFromEnv(),
+		templates:     tmpl,
+		reminderDays:  days,
+		overdueLevels: defaultOverdueLevels,
+	}, nil
+}
+
+// render executes the subject and body templates of kind.
+func (s *NotificationService) render(kind NotificationKind, data notificationData) (subject, body string, err error) {
+	var b bytes.Buffer
+	if err := s.templates.ExecuteTemplate(&b, string(kind)+".subject", data); err != nil {
+		return "", "", fmt.Errorf("failed to render subject: %w", err)
+	}
+	subject = strings.TrimSpace(b.String())
+	b.Reset()
+	if err := s.templates.ExecuteTemplate(&b, string(kind)+".body", data); err != nil {
+		return "", "", fmt.Errorf("failed to render body: %w", err)
+	}
+	return subject, b.String(), nil
+}
+
+// SendReminders notifies members of open loans that are due within
+// reminderDays of now or overdue, in every tenant. Notifications already
+// recorded for a loan, kind, level and channel are skipped, so calling it
+// repeatedly is safe. It returns the number of notifications sent.
+func (s *NotificationService) SendReminders(ctx context.Context, now time.Time) (int, error) {
+	sent := 0
+	err := forEachTenant(ctx, s.db, func(ctx context.Context) error {
+		n, err := s.sendTenantReminders(ctx, now)
+		sent += n
+		return err
+	})
+	return sent, err
+}
+
+// sendTenantReminders runs SendReminders for the tenant of ctx.
+func (s *NotificationService) sendTenantReminders(ctx context.Context, now time.Time) (int, error) {
+	db := s.db.WithContext(ctx)
+	var loans []BookLoan
+	horizon := now.Add(time.Duration(s.reminderDays) * 24 * time.Hour)
+	if err := db.Preload("Book").
+		Where("returned = ? AND due_date <= ? AND member_id <> 0", false, horizon).
+		Find(&loans).Error; err != nil {
+		return 0, fmt.Errorf("failed to load open loans: %w", err)
+	}
+
+	sent := 0
+	for _, loan := range loans {
+		kind, level, daysOverdue := NotifyDueSoon, 0, 0
+		if !loan.DueDate.After(now) {
+			if loan.LicensePoolID != nil {
+				// Digital loans end at their due date; there is nothing to chase.
+				continue
+			}
+			daysOverdue = int(now.Sub(loan.DueDate) / (24 * time.Hour))
+			kind, level = NotifyOverdue, overdueLevel(daysOverdue, s.overdueLevels)
+		}
+
+		var member User
+		if err := db.First(&member, loan.MemberID).Error; err != nil {
+			slog.WarnContext(ctx, "skipping notification for unknown member", "loan_id", loan.ID, "error", err)
+			continue
+		}
+		subject, body, err := s.render(kind, notificationData{
+			Member: member, Book: loan.Book, DueDate: loan.DueDate, DaysOverdue: daysOverdue, Level: level,
+		})
+		if err != nil {
+			return sent, err
+		}
+		msg := Message{Kind: kind, Level: level, LoanID: loan.ID, To: member.Email, Subject: subject, Body: body}
+
+		for _, ch := range s.channels {
+			ok, err := s.deliver(ctx, ch, msg, now)
+			if err != nil {
+				slog.ErrorContext(ctx, "failed to send notification", "loan_id", loan.ID, "channel", ch.Name(), "error", err)
+				continue
+			}
+			if ok {
+				sent++
+			}
+		}
+	}
+	return sent, nil
+}
+
+// deliver sends msg over ch unless it was sent before, and records it.
+// It reports whether the message was sent.
+func (s *NotificationService) deliver(ctx context.Context, ch Channel, msg Message, now time.Time) (bool, error) {
+	db := s.db.WithContext(ctx)
+	var count int64
+	if err := db.Model(&NotificationRecord{}).
+		Where("loan_id = ? AND kind = ? AND level = ? AND channel = ?", msg.LoanID, msg.Kind, msg.Level, ch.Name()).
+		Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check sent notifications: %w", err)
+	}
+	if count > 0 {
+		return false, nil
+	}
+	if err := ch.Send(ctx, msg); err != nil {
+		return false, err
+	}
+	rec := NotificationRecord{LoanID: msg.LoanID, Kind: msg.Kind, Level: msg.Level, Channel: ch.Name(), SentAt: now}
+	if err := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&rec).Error; err != nil {
+		return true, fmt.Errorf("failed to record notification: %w", err)
+	}
+	return true, nil
+}
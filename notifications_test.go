@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readOutbox decodes every message written to a FileOutboxChannel.
+func readOutbox(t *testing.T, path string) []Message {
+	t.Helper()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("failed to open outbox: %v", err)
+	}
+	defer f.Close()
+	var msgs []Message
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var m Message
+		if err := json.Unmarshal(sc.Bytes(), &m); err != nil {
+			t.Fatalf("invalid outbox line %q: %v", sc.Text(), err)
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs
+}
+
+// TestOverdueLevel tests escalation of overdue notices.
+func TestOverdueLevel(t *testing.T) {
+	cases := map[int]int{0: 1, 6: 1, 7: 2, 13: 2, 14: 3, 40: 3}
+	for days, want := range cases {
+		if got := overdueLevel(days, defaultOverdueLevels); got != want {
+			t.Errorf("overdueLevel(%d) = %d, want %d", days, got, want)
+		}
+	}
+}
+
+// TestEmailMessage tests that a book title cannot inject email headers.
+func TestEmailMessage(t *testing.T) {
+	msg := Message{To: "reader@example.com", Subject: "Due soon: Evil\r\nBcc: victim@example.com", Body: "line one\nline two"}
+	email, err := emailMessage("library@example.com", msg)
+	if err != nil {
+		t.Fatalf("emailMessage: %v", err)
+	}
+	headers, body, _ := strings.Cut(string(email), "\r\n\r\n")
+	for _, line := range strings.Split(headers, "\r\n") {
+		if strings.HasPrefix(line, "Bcc:") {
+			t.Errorf("subject injected a header: %q", headers)
+		}
+	}
+	if !strings.Contains(headers, "Subject: Due soon: Evil Bcc: victim@example.com\r\n") {
+		t.Errorf("unexpected headers %q", headers)
+	}
+	if body != "line one\r\nline two" {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	msg.Subject = "Überfällig: Kafka"
+	email, _ = emailMessage("library@example.com", msg)
+	if !strings.Contains(string(email), "Subject: =?utf-8?q?") {
+		t.Errorf("expected a Q-encoded subject, got %q", email)
+	}
+	if _, err := emailMessage("library@example.com", Message{To: "a@example.com\r\nBcc: b@example.com"}); err == nil {
+		t.Errorf("expected an address with a line break to be refused")
+	}
+	if _, err := emailMessage("library@example.com", Message{}); err == nil {
+		t.Errorf("expected a missing address to be refused")
+	}
+}
+
+// TestNotification_RenderTemplates tests the built-in templates and overrides from a directory.
+func TestNotification_RenderTemplates(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "due_soon.subject.tmpl"), []byte("Due: {{.Book.Title}}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := parseTemplates(dir)
+	if err != nil {
+		t.Fatalf("parseTemplates: %v", err)
+	}
+	svc := &NotificationService{templates: tmpl}
+	data := notificationData{
+		Member:      User{Username: "ada"},
+		Book:        Book{Title: "Go", ISBN: "9780134190440"},
+		DueDate:     time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		DaysOverdue: 15,
+		Level:       3,
+	}
+
+	subject, _, err := svc.render(NotifyDueSoon, data)
+	if err != nil || subject != "Due: Go" {
+		t.Errorf("overridden subject: got %q, %v", subject, err)
+	}
+	subject, body, err := svc.render(NotifyOverdue, data)
+	if err != nil {
+		t.Fatalf("render overdue: %v", err)
+	}
+	if !strings.HasPrefix(subject, "Final notice") {
+		t.Errorf("level 3 subject should be a final notice, got %q", subject)
+	}
+	if !strings.Contains(body, "15 day(s) overdue") || !strings.Contains(body, "Hello ada") {
+		t.Errorf("unexpected body: %q", body)
+	}
+}
+
+// TestNotification_SendRemindersOnce tests that reminders and overdue notices are sent once per level.
+func TestNotification_SendRemindersOnce(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tmpl, err := parseTemplates("")
+	if err != nil {
+		t.Fatalf("parseTemplates: %v", err)
+	}
+	outbox := filepath.Join(t.TempDir(), "outbox.jsonl")
+	svc := &NotificationService{
+		db:            db,
+		channels:      []Channel{&FileOutboxChannel{Path: outbox}},
+		templates:     tmpl,
+		reminderDays:  3,
+		overdueLevels: defaultOverdueLevels,
+	}
+
+	member := mustCreateUser(t, db, "notify-member", RoleMember)
+	db.Model(member).Update("email", "notify@example.com")
+	book := &Book{ISBN: "9781919191919", Title: "Reminded", Copies: 3}
+	mustCreateBook(t, db, book)
+
+	now := time.Now()
+	dueSoon := BookLoan{BookID: book.ID, MemberID: member.ID, LoanDate: now, DueDate: now.Add(2 * 24 * time.Hour)}
+	overdue := BookLoan{BookID: book.ID, MemberID: member.ID, LoanDate: now.Add(-20 * 24 * time.Hour), DueDate: now.Add(-8 * 24 * time.Hour)}
+	later := BookLoan{BookID: book.ID, MemberID: member.ID, LoanDate: now, DueDate: now.Add(20 * 24 * time.Hour)}
+	for _, l := range []*BookLoan{&dueSoon, &overdue, &later} {
+		if err := db.Create(l).Error; err != nil {
+			t.Fatalf("failed to create loan: %v", err)
+		}
+	}
+
+	ctx := context.Background()
+	if _, err := svc.SendReminders(ctx, now); err != nil {
+		t.Fatalf("SendReminders: %v", err)
+	}
+	if _, err := svc.SendReminders(ctx, now); err != nil {
+		t.Fatalf("second SendReminders: %v", err)
+	}
+
+	got := map[uint][]Message{}
+	for _, m := range readOutbox(t, outbox) {
+		got[m.LoanID] = append(got[m.LoanID], m)
+	}
+	if msgs := got[dueSoon.ID]; len(msgs) != 1 || msgs[0].Kind != NotifyDueSoon || msgs[0].To != "notify@example.com" {
+		t.Errorf("expected one due-soon reminder, got %+v", msgs)
+	}
+	if msgs := got[overdue.ID]; len(msgs) != 1 || msgs[0].Kind != NotifyOverdue || msgs[0].Level != 2 {
+		t.Errorf("expected one level 2 overdue notice, got %+v", msgs)
+	}
+	if msgs := got[later.ID]; len(msgs) != 0 {
+		t.Errorf("loan due in 20 days should not be notified, got %+v", msgs)
+	}
+
+	// A week later the overdue loan escalates to the final notice.
+	if _, err := svc.SendReminders(ctx, now.Add(7*24*time.Hour)); err != nil {
+		t.Fatalf("SendReminders a week later: %v", err)
+	}
+	var levels []int
+	for _, m := range readOutbox(t, outbox) {
+		if m.LoanID == overdue.ID {
+			levels = append(levels, m.Level)
+		}
+	}
+	if len(levels) != 2 || levels[1] != 3 {
+		t.Errorf("expected escalation to level 3, got levels %v", levels)
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Outbox relay defaults.
+const (
+	defaultOutboxBatchSize    = 100
+	defaultOutboxPollInterval = 2 * time.Second
+	outboxMaxBackoff          = time.Hour
+	outboxRetention           = 7 * 24 * time.Hour
+	outboxLease               = 5 * time.Minute
+)
+
+// Domain event types.
+const (
+	EventBookAdded         = "book.added"
+	EventBookCopiesChanged = "book.copies_changed"
+	EventLoanCreated       = "loan.created"
+	EventLoanReturned      = "loan.returned"
+	EventLoanOverdue       = "loan.overdue"
+)
+
+// OutboxEvent is a domain event waiting in the outbox table to be
+// delivered. Events are written in the transaction of the change they
+// describe, so an event exists if and only if the change was committed.
+// PublishedAt is set once every sink has accepted the event; until then
+// the relay retries it at NextAttemptAt with exponential backoff.
+type OutboxEvent struct {
+	ID            uint            `gorm:"primaryKey" json:"id"`
+	TenantID      uint            `gorm:"index;not null;default:0" json:"tenant_id"`
+	Type          string          `gorm:"size:50;not null" json:"type"`
+	AggregateID   uint            `gorm:"not null" json:"aggregate_id"`
+	Payload       json.RawMessage `gorm:"type:jsonb;not null" json:"payload"`
+	CreatedAt     time.Time       `json:"created_at"`
+	Attempts      int             `gorm:"not null;default:0" json:"-"`
+	NextAttemptAt time.Time       `gorm:"index:idx_outbox_pending,where:published_at IS NULL" json:"-"`
+	PublishedAt   *time.Time      `gorm:"index" json:"-"`
+	LastError     string          `gorm:"size:500" json:"-"`
+}
+
+// BookEvent is the payload of book events.
+type BookEvent struct {
+	BookID    uint   `json:"book_id"`
+	ISBN      string `json:"isbn"`
+	Title     string `json:"title"`
+	Copies    int    `json:"copies"`
+	Available int    `json:"available"`
+}
+
+// LoanEvent is the payload of loan events.
+type LoanEvent struct {
+	LoanID     uint       `json:"loan_id"`
+	BookID     uint       `json:"book_id"`
+	MemberID   uint       `json:"member_id"`
+	BranchID   uint       `json:"branch_id"`
+	DueDate    time.Time  `json:"due_date"`
+	ReturnedAt *time.Time `json:"returned_at,omitempty"`
+}
+
+// newBookEvent returns the event payload describing b.
+func newBookEvent(b *Book) BookEvent {
+	return BookEvent{BookID: b.ID, ISBN: b.ISBN, Title: b.Title, Copies: b.Copies, Available: b.Available}
+}
+
+// newLoanEvent returns the event payload describing l.
+func newLoanEvent(l *BookLoan) LoanEvent {
+	return LoanEvent{LoanID: l.ID, BookID: l.BookID, MemberID: l.MemberID, BranchID: l.BranchID, DueDate: l.DueDate, ReturnedAt: l.ReturnedAt}
+}
+
+// emitEvent records an event in the outbox. tx must be the transaction of
+// the change the event describes.
+func emitEvent(tx *gorm.DB, eventType string, aggregateID uint, payload any) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s event: %w", eventType, err)
+	}
+	e := &OutboxEvent{Type: eventType, AggregateID: aggregateID, Payload: b, NextAttemptAt: time.Now()}
+	if err := tx.Create(e).Error; err != nil {
+		return fmt.Errorf("failed to record %s event: %w", eventType, err)
+	}
+	return nil
+}
+
+// EventSink delivers domain events to another system. Delivery is at least
+// once: an event is sent again to every sink when any sink fails, so
+// consumers should ignore event IDs they have already seen.
+type EventSink interface {
+	Name() string
+	Publish(ctx context.Context, e OutboxEvent) error
+}
+
+// WebhookEventSink posts events as JSON to a URL.
+type WebhookEventSink struct {
+	URL    string
+	Client *http.Client
+}
+
+// Name implements EventSink.
+func (s *WebhookEventSink) Name() string { return "webhook" }
+
+// Publish implements EventSink.
+func (s *WebhookEventSink) Publish(ctx context.Context, e OutboxEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Type", e.Type)
+	req.Header.Set("X-Event-ID", fmt.Sprint(e.ID))
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// MessagePublisher is the part of a message broker client, such as a NATS
+// connection or a Kafka producer, that BrokerEventSink needs.
+type MessagePublisher interface {
+	Publish(ctx context.Context, topic string, key, value []byte) error
+}
+
+// defaultBrokerTopicPrefix is the topic prefix of the broker sink unless
+// EVENTS_BROKER_TOPIC_PREFIX is set.
+const defaultBrokerTopicPrefix = "library."
+
+// BrokerEventSink publishes events to a message broker on the topic
+// TopicPrefix + event type. The relay hands over the events of one book
+// or loan in order, each only once the previous one was accepted, and
+// messages are keyed by tenant and aggregate for brokers that partition
+// by key.
+type BrokerEventSink struct {
+	Publisher   MessagePublisher
+	TopicPrefix string
+}
+
+// Name implements EventSink.
+func (s *BrokerEventSink) Name() string { return "broker" }
+
+// Publish implements EventSink.
+func (s *BrokerEventSink) Publish(ctx context.Context, e OutboxEvent) error {
+	value, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	key := fmt.Sprintf("%d:%d", e.TenantID, e.AggregateID)
+	if err := s.Publisher.Publish(ctx, s.TopicPrefix+e.Type, []byte(key), value); err != nil {
+		return fmt.Errorf("failed to publish event: %w", err)
+	}
+	return nil
+}
+
+// FileEventSink appends events as JSON lines to a local file. It is meant
+// for development and tests.
+type FileEventSink struct {
+	Path string
+	mu   sync.Mutex
+}
+
+// Name implements EventSink.
+func (s *FileEventSink) Name() string { return "file" }
+
+// Publish implements EventSink.
+func (s *FileEventSink) Publish(_ context.Context, e OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open event file: %w", err)
+	}
+	defer f.Close()
+	if err := json.NewEncoder(f).Encode(e); err != nil {
+		return fmt.Errorf("failed to write event file: %w", err)
+	}
+	return nil
+}
+
+// eventSinksFromEnv builds the event sinks configured through
+// EVENTS_WEBHOOK_URL, EVENTS_NATS_URL and EVENTS_FILE.
+func eventSinksFromEnv() ([]EventSink, error) {
+	var sinks []EventSink
+	if url := os.Getenv("EVENTS_WEBHOOK_URL"); url != "" {
+		sinks = append(sinks, &WebhookEventSink{URL: url, Client: &http.Client{Timeout: 10 * time.Second}})
+	}
+	if rawURL := os.Getenv("EVENTS_NATS_URL"); rawURL != "" {
+		publisher, err := newNATSPublisher(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		prefix, ok := os.LookupEnv("EVENTS_BROKER_TOPIC_PREFIX")
+		if !ok {
+			prefix = defaultBrokerTopicPrefix
+		}
+		sinks = append(sinks, &BrokerEventSink{Publisher: publisher, TopicPrefix: prefix})
+	}
+	if path := os.Getenv("EVENTS_FILE"); path != "" {
+		sinks = append(sinks, &FileEventSink{Path: path})
+	}
+	return sinks, nil
+}
+
+// outboxDeliveries counts event delivery attempts by result ("published"
+// or "failed").
+var outboxDeliveries = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "outbox_deliveries_total",
+	Help:      "Number of outbox event delivery attempts by result.",
+}, []string{"result"})
+
+// outboxBackoff returns the delay before the next delivery attempt of an
+// event that has failed attempts times: one second, doubling with each
+// failure up to outboxMaxBackoff.
+func outboxBackoff(attempts int) time.Duration {
+	if attempts > 12 {
+		return outboxMaxBackoff
+	}
+	return min(time.Second<<max(attempts-1, 0), outboxMaxBackoff)
+}
+
+// OutboxRelay delivers outbox events of every tenant to the sinks. Several
+// relays may run against the same database: each batch is claimed with
+// FOR UPDATE SKIP LOCKED and leased for outboxLease by moving its
+// next_attempt_at, so no event is handled by two relays at once and no
+// transaction stays open while sinks are called. Events of one book or
+// loan are delivered in ID order: an event is held back while an earlier
+// event of its aggregate is unpublished. Events of other aggregates are
+// not held up by a failing one.
+type OutboxRelay struct {
+	db        *gorm.DB
+	sinks     []EventSink
+	batchSize int
+}
+
+// newOutboxRelay returns a relay delivering to sinks. With no sinks,
+// events are marked published without being sent anywhere.
+func newOutboxRelay(db *gorm.DB, sinks []EventSink) *OutboxRelay {
+	return &OutboxRelay{db: db, sinks: sinks, batchSize: defaultOutboxBatchSize}
+}
+
+// outboxHeldBackSQL matches events with an earlier unpublished event of
+// the same aggregate. Book and loan IDs overlap, so the aggregate is told
+// apart by the prefix of the event type.
+const outboxHeldBackSQL = `EXISTS (SELECT 1 FROM outbox_events prior
+	WHERE prior.published_at IS NULL AND prior.id < outbox_events.id
+	  AND prior.tenant_id = outbox_events.tenant_id AND prior.aggregate_id = outbox_events.aggregate_id
+	  AND split_part(prior.type, '.', 1) = split_part(outbox_events.type, '.', 1))`
+
+// RelayBatch delivers up to one batch of events that are due at now and
+// returns the number of events handled, delivered or not.
+func (r *OutboxRelay) RelayBatch(ctx context.Context, now time.Time) (int, error) {
+	ctx = withAllTenants(ctx)
+	events, err := r.claim(ctx, now)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	// Deliveries must end before the lease does, or another relay could
+	// claim the same events.
+	publishCtx, cancel := context.WithTimeout(ctx, outboxLease)
+	defer cancel()
+	db := r.db.WithContext(ctx)
+	for _, e := range events {
+		updates := map[string]interface{}{"published_at": now, "last_error": ""}
+		if err := r.publish(publishCtx, e); err != nil {
+			slog.WarnContext(ctx, "event delivery failed", "event_id", e.ID, "type", e.Type, "attempts", e.Attempts+1, "error", err)
+			outboxDeliveries.WithLabelValues("failed").Inc()
+			msg := err.Error()
+			if len(msg) > 500 {
+				msg = msg[:500]
+			}
+			updates = map[string]interface{}{
+				"attempts":        e.Attempts + 1,
+				"next_attempt_at": now.Add(outboxBackoff(e.Attempts + 1)),
+				"last_error":      msg,
+			}
+		} else {
+			outboxDeliveries.WithLabelValues("published").Inc()
+		}
+		if err := db.Model(&OutboxEvent{}).Where("id = ?", e.ID).Updates(updates).Error; err != nil {
+			return len(events), fmt.Errorf("failed to update outbox event %d: %w", e.ID, err)
+		}
+	}
+	return len(events), nil
+}
+
+// claim selects up to one batch of due events that are not held back and
+// leases them by moving their next attempt past outboxLease. A relay that
+// stops before recording the results leaves them to be retried then.
+func (r *OutboxRelay) claim(ctx context.Context, now time.Time) ([]OutboxEvent, error) {
+	var events []OutboxEvent
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("published_at IS NULL AND next_attempt_at <= ?", now).
+			Where("NOT " + outboxHeldBackSQL).
+			Order("id").Limit(r.batchSize).Find(&events).Error; err != nil {
+			return fmt.Errorf("failed to claim outbox events: %w", err)
+		}
+		if len(events) == 0 {
+			return nil
+		}
+		ids := make([]uint, len(events))
+		for i, e := range events {
+			ids[i] = e.ID
+		}
+		if err := tx.Model(&OutboxEvent{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(outboxLease)).Error; err != nil {
+			return fmt.Errorf("failed to lease outbox events: %w", err)
+		}
+		return nil
+	})
+	return events, err
+}
+
+// publish sends e to every sink, stopping at the first failure.
+func (r *OutboxRelay) publish(ctx context.Context, e OutboxEvent) error {
+	for _, sink := range r.sinks {
+		if err := sink.Publish(ctx, e); err != nil {
+			return fmt.Errorf("%s: %w", sink.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Run relays events until ctx is cancelled, draining full batches
+// immediately and otherwise polling every interval.
+func (r *OutboxRelay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := r.RelayBatch(ctx, time.Now())
+		if err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "outbox relay failed", "error", err)
+		}
+		if err == nil && n == r.batchSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pruneOutbox deletes published events older than outboxRetention.
+func (r *OutboxRelay) pruneOutbox(ctx context.Context) error {
+	res := r.db.WithContext(withAllTenants(ctx)).
+		Where("published_at < ?", time.Now().Add(-outboxRetention)).
+		Delete(&OutboxEvent{})
+	if res.Error != nil {
+		return fmt.Errorf("failed to prune outbox: %w", res.Error)
+	}
+	slog.InfoContext(ctx, "pruned outbox", "count", res.RowsAffected)
+	return nil
+}
+
+// outboxPollIntervalFromEnv returns the relay polling interval from
+// EVENTS_POLL_INTERVAL.
+func outboxPollIntervalFromEnv() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("EVENTS_POLL_INTERVAL")); err == nil && d > 0 {
+		return d
+	}
+	return defaultOutboxPollInterval
+}
+
+// registerOutboxJobs registers the job pruning delivered events.
+func registerOutboxJobs(s *Scheduler, relay *OutboxRelay) error {
+	return s.Register("prune-outbox", "@daily", relay.pruneOutbox)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestOutboxBackoff tests that retry delays double up to the maximum.
+func TestOutboxBackoff(t *testing.T) {
+	tests := map[int]time.Duration{
+		0:   time.Second,
+		1:   time.Second,
+		2:   2 * time.Second,
+		5:   16 * time.Second,
+		13:  outboxMaxBackoff,
+		100: outboxMaxBackoff,
+	}
+	for attempts, want := range tests {
+		if got := outboxBackoff(attempts); got != want {
+			t.Errorf("outboxBackoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+// fakePublisher records messages published to a broker.
+type fakePublisher struct {
+	topics, keys []string
+}
+
+func (p *fakePublisher) Publish(_ context.Context, topic string, key, _ []byte) error {
+	p.topics = append(p.topics, topic)
+	p.keys = append(p.keys, string(key))
+	return nil
+}
+
+// TestBrokerEventSink tests topic and key selection.
+func TestBrokerEventSink(t *testing.T) {
+	p := &fakePublisher{}
+	sink := &BrokerEventSink{Publisher: p, TopicPrefix: "library."}
+	if err := sink.Publish(context.Background(), OutboxEvent{ID: 1, TenantID: 2, Type: EventLoanCreated, AggregateID: 3, Payload: json.RawMessage(`{}`)}); err != nil {
+		t.Fatal(err)
+	}
+	if p.topics[0] != "library.loan.created" || p.keys[0] != "2:3" {
+		t.Errorf("unexpected message: topic %q key %q", p.topics[0], p.keys[0])
+	}
+}
+
+// recordingSink records the events of one tenant and fails while failing
+// is set.
+type recordingSink struct {
+	mu       sync.Mutex
+	tenantID uint
+	failing  bool
+	events   []OutboxEvent
+}
+
+func (s *recordingSink) Name() string { return "recording" }
+
+func (s *recordingSink) Publish(_ context.Context, e OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failing {
+		return errors.New("sink unavailable")
+	}
+	if e.TenantID == s.tenantID {
+		s.events = append(s.events, e)
+	}
+	return nil
+}
+
+// TestOutboxRelay tests that service changes emit events in their
+// transaction and that the relay retries failed deliveries.
+func TestOutboxRelay(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenant := mustCreateTenant(t, &TenantService{db: db}, "outbox")
+	ctx := withTenant(asRole(RoleLibrarian), tenant.ID)
+	books := &BookService{db: db}
+	loans := &LoanService{db: db}
+
+	book := &Book{ISBN: "9787171717171", Title: "Evented", Copies: 1}
+	if err := books.AddBook(ctx, book); err != nil {
+		t.Fatalf("AddBook: %v", err)
+	}
+	first, second := mustCreateMember(t, db.WithContext(ctx)), mustCreateMember(t, db.WithContext(ctx))
+	loan, err := loans.CheckoutBook(ctx, first.ID, book.ID, time.Now().Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("CheckoutBook: %v", err)
+	}
+	if _, err := loans.CheckoutBook(ctx, second.ID, book.ID, time.Now().Add(24*time.Hour)); err == nil {
+		t.Fatalf("expected the second checkout to fail")
+	}
+	if err := loans.ReturnBook(ctx, loan.ID); err != nil {
+		t.Fatalf("ReturnBook: %v", err)
+	}
+	if err := books.UpdateBookCopies(ctx, book.ISBN, 3); err != nil {
+		t.Fatalf("UpdateBookCopies: %v", err)
+	}
+
+	var pending []OutboxEvent
+	db.WithContext(ctx).Order("id").Find(&pending)
+	want := []string{EventBookAdded, EventLoanCreated, EventLoanReturned, EventBookCopiesChanged}
+	if len(pending) != len(want) {
+		t.Fatalf("expected %d events (none for the failed checkout), got %d", len(want), len(pending))
+	}
+	for i, e := range pending {
+		if e.Type != want[i] || e.PublishedAt != nil {
+			t.Errorf("event %d = %s (published at %v), want unpublished %s", i, e.Type, e.PublishedAt, want[i])
+		}
+	}
+
+	sink := &recordingSink{tenantID: tenant.ID, failing: true}
+	relay := newOutboxRelay(db, []EventSink{sink})
+	relay.batchSize = 1000
+	now := time.Now()
+	if n, err := relay.RelayBatch(context.Background(), now); err != nil || n != 2 {
+		t.Fatalf("expected the first event of the book and of the loan only, got %d, %v", n, err)
+	}
+	var failed OutboxEvent
+	db.WithContext(ctx).First(&failed, pending[0].ID)
+	if failed.PublishedAt != nil || failed.Attempts != 1 || failed.LastError == "" || !failed.NextAttemptAt.After(now) {
+		t.Errorf("failed delivery should be scheduled for retry: %+v", failed)
+	}
+	if n, _ := relay.RelayBatch(context.Background(), now); n != 0 {
+		t.Errorf("events should not be retried before their backoff, got %d", n)
+	}
+
+	// A claimed batch is leased: another relay skips it until the lease
+	// runs out.
+	later := now.Add(time.Minute)
+	if claimed, err := relay.claim(withAllTenants(context.Background()), later); err != nil || len(claimed) != 2 {
+		t.Fatalf("claim = %d events, %v", len(claimed), err)
+	}
+	if n, _ := relay.RelayBatch(context.Background(), later); n != 0 {
+		t.Errorf("leased events should not be claimed again, got %d", n)
+	}
+
+	sink.failing = false
+	for {
+		n, err := relay.RelayBatch(context.Background(), later.Add(outboxLease))
+		if err != nil {
+			t.Fatalf("RelayBatch: %v", err)
+		}
+		if n == 0 {
+			break
+		}
+	}
+	if len(sink.events) != len(want) {
+		t.Fatalf("expected %d delivered events, got %d", len(want), len(sink.events))
+	}
+	for i, e := range sink.events {
+		if e.Type != want[i] {
+			t.Errorf("delivered event %d = %s, want %s", i, e.Type, want[i])
+		}
+	}
+	var payload LoanEvent
+	if err := json.Unmarshal(sink.events[2].Payload, &payload); err != nil || payload.LoanID != loan.ID || payload.ReturnedAt == nil {
+		t.Errorf("unexpected loan.returned payload %s: %v", sink.events[2].Payload, err)
+	}
+	var unpublished int64
+	db.WithContext(ctx).Model(&OutboxEvent{}).Where("published_at IS NULL").Count(&unpublished)
+	if unpublished != 0 {
+		t.Errorf("expected every event to be published, %d left", unpublished)
+	}
+}
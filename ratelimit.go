@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// rateLimitBucketRetention is how long an idle Postgres bucket is kept.
+// Buckets refill completely long before that, so dropping them changes
+// nothing.
+const rateLimitBucketRetention = 24 * time.Hour
+
+// RateLimit allows Burst requests at once, refilled at Rate requests per
+// second. A zero RateLimit allows everything.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+// unlimited reports whether l does not limit anything.
+func (l RateLimit) unlimited() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+// parseRateLimit parses "<requests>/<s|m|h>", e.g. "60/m", into a limit
+// of that many requests per period with an equal burst. "off" and "0"
+// disable the limit.
+func parseRateLimit(s string) (RateLimit, error) {
+	if s == "off" || s == "0" {
+		return RateLimit{}, nil
+	}
+	n, unit, ok := strings.Cut(s, "/")
+	count, err := strconv.Atoi(n)
+	if !ok || err != nil || count < 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q", s)
+	}
+	period := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}[unit]
+	if period == 0 {
+		return RateLimit{}, fmt.Errorf("invalid rate limit %q: unit must be s, m or h", s)
+	}
+	return RateLimit{Rate: float64(count) / period.Seconds(), Burst: count}, nil
+}
+
+// tokenBucket is the state of one rate-limited key.
+type tokenBucket struct {
+	Tokens  float64
+	Updated time.Time
+}
+
+// take refills b for the time elapsed since it was last updated and takes
+// a token if one is available. Otherwise it returns how long the caller
+// has to wait for the next token.
+func (b *tokenBucket) take(limit RateLimit, now time.Time) (bool, time.Duration) {
+	if elapsed := now.Sub(b.Updated).Seconds(); elapsed > 0 {
+		b.Tokens = math.Min(float64(limit.Burst), b.Tokens+elapsed*limit.Rate)
+		b.Updated = now
+	}
+	if b.Tokens >= 1 {
+		b.Tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.Tokens) / limit.Rate * float64(time.Second))
+}
+
+// peek reports what take would return, without changing the bucket.
+func (b tokenBucket) peek(limit RateLimit, now time.Time) (bool, time.Duration) {
+	return b.take(limit, now)
+}
+
+// RateLimiter decides whether a request for key is within limit. When it
+// is not, retryAfter tells when the next request will be allowed. Check
+// answers the same without taking a token.
+type RateLimiter interface {
+	Allow(ctx context.Context, key string, limit RateLimit, now time.Time) (allowed bool, retryAfter time.Duration, err error)
+	Check(ctx context.Context, key string, limit RateLimit, now time.Time) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// MemoryRateLimiter keeps token buckets in process. Each application
+// instance limits on its own.
+type MemoryRateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	swept   time.Time
+}
+
+// newMemoryRateLimiter returns an empty MemoryRateLimiter.
+func newMemoryRateLimiter() *MemoryRateLimiter {
+	return &MemoryRateLimiter{buckets: map[string]*tokenBucket{}}
+}
+
+// Allow implements RateLimiter.
+func (l *MemoryRateLimiter) Allow(_ context.Context, key string, limit RateLimit, now time.Time) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.swept) > time.Minute {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{Tokens: float64(limit.Burst), Updated: now}
+		l.buckets[key] = b
+	}
+	allowed, retryAfter := b.take(limit, now)
+	return allowed, retryAfter, nil
+}
+
+// Check implements RateLimiter.
+func (l *MemoryRateLimiter) Check(_ context.Context, key string, limit RateLimit, now time.Time) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[key]
+	if !ok {
+		return true, 0, nil
+	}
+	allowed, retryAfter := b.peek(limit, now)
+	return allowed, retryAfter, nil
+}
+
+// sweep forgets buckets idle for an hour, which have long refilled, so
+// that one-off clients do not accumulate.
+func (l *MemoryRateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.Updated) > time.Hour {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
+
+// RateLimitBucket is a token bucket stored in Postgres.
+type RateLimitBucket struct {
+	Key       string    `gorm:"primaryKey;size:200"`
+	Tokens    float64   `gorm:"type:double precision;not null"`
+	UpdatedAt time.Time `gorm:"index;autoUpdateTime:false"`
+}
+
+// PostgresRateLimiter keeps token buckets in the database, so that every
+// application instance shares the same limits. Each decision locks the
+// key's row for the duration of one short transaction.
+type PostgresRateLimiter struct {
+	db *gorm.DB
+}
+
+// Allow implements RateLimiter.
+func (l *PostgresRateLimiter) Allow(ctx context.Context, key string, limit RateLimit, now time.Time) (allowed bool, retryAfter time.Duration, err error) {
+	err = l.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		row := RateLimitBucket{Key: key, Tokens: float64(limit.Burst), UpdatedAt: now}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&row).Error; err != nil {
+			return err
+		}
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&row, "key = ?", key).Error; err != nil {
+			return err
+		}
+		b := tokenBucket{Tokens: row.Tokens, Updated: row.UpdatedAt}
+		allowed, retryAfter = b.take(limit, now)
+		return tx.Model(&row).Updates(map[string]interface{}{"tokens": b.Tokens, "updated_at": b.Updated}).Error
+	})
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to update rate limit: %w", err)
+	}
+	return allowed, retryAfter, nil
+}
+
+// Check implements RateLimiter.
+func (l *PostgresRateLimiter) Check(ctx context.Context, key string, limit RateLimit, now time.Time) (bool, time.Duration, error) {
+	var rows []RateLimitBucket
+	if err := l.db.WithContext(ctx).Where("key = ?", key).Limit(1).Find(&rows).Error; err != nil {
+		return false, 0, fmt.Errorf("failed to read rate limit: %w", err)
+	}
+	if len(rows) == 0 {
+		return true, 0, nil
+	}
+	allowed, retryAfter := tokenBucket{Tokens: rows[0].Tokens, Updated: rows[0].UpdatedAt}.peek(limit, now)
+	return allowed, retryAfter, nil
+}
+
+// pruneBuckets deletes buckets idle for longer than rateLimitBucketRetention.
+func (l *PostgresRateLimiter) pruneBuckets(ctx context.Context) error {
+	res := l.db.WithContext(ctx).Where("updated_at < ?", time.Now().Add(-rateLimitBucketRetention)).Delete(&RateLimitBucket{})
+	if res.Error != nil {
+		return fmt.Errorf("failed to prune rate limit buckets: %w", res.Error)
+	}
+	slog.InfoContext(ctx, "pruned rate limit buckets", "count", res.RowsAffected)
+	return nil
+}
+
+// RateLimitPolicy holds the limits of a group of routes. Anonymous callers
+// are limited per client IP address; authenticated callers per API token,
+// or per user when they log in with a password.
+type RateLimitPolicy struct {
+	Anonymous     RateLimit
+	Authenticated RateLimit
+}
+
+// defaultRateLimitPolicies are the built-in route groups. The catalogue is
+// the public, anonymously searchable part of the API.
+var defaultRateLimitPolicies = map[string]RateLimitPolicy{
+	"catalog": {Anonymous: RateLimit{Rate: 1, Burst: 60}, Authenticated: RateLimit{Rate: 10, Burst: 600}},
+	"default": {Anonymous: RateLimit{Rate: 0.5, Burst: 30}, Authenticated: RateLimit{Rate: 5, Burst: 300}},
+}
+
+// rateLimitedRequests counts requests rejected with 429 by route group.
+var rateLimitedRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metricsNamespace,
+	Name:      "rate_limited_requests_total",
+	Help:      "Number of HTTP requests rejected by rate limiting, by route group.",
+}, []string{"route"})
+
+// defaultAuthFailureLimit is how many failed sign-ins a client IP may make.
+var defaultAuthFailureLimit = RateLimit{Rate: 10.0 / 60, Burst: 10}
+
+// rateLimits applies RateLimitPolicies to HTTP routes, and authFailures
+// to failed sign-ins.
+type rateLimits struct {
+	limiter      RateLimiter
+	policies     map[string]RateLimitPolicy
+	authFailures RateLimit
+	trustProxy   bool
+}
+
+// clientKey identifies the caller of r: its API token, its user or, for
+// anonymous requests, its IP address. X-Forwarded-For is only honoured
+// behind a trusted proxy.
+func (l *rateLimits) clientKey(r *http.Request) (string, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return "token:" + hashToken(token)[:32], true
+	}
+	if u := principalFromContext(r.Context()); u != nil && u.ID != 0 {
+		return fmt.Sprintf("user:%d", u.ID), true
+	}
+	return "ip:" + l.clientIP(r), false
+}
+
+// clientIP returns the address r comes from. X-Forwarded-For is only
+// honoured behind a trusted proxy.
+func (l *rateLimits) clientIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if l.trustProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			ip = strings.TrimSpace(first)
+		}
+	}
+	return ip
+}
+
+// rateLimitRoutes assigns route patterns to the route groups of the rate
+// limit policies. Unlisted patterns belong to "default"; health checks are
+// never limited.
+var rateLimitRoutes = map[string]string{
+	"GET /books":                    "catalog",
+	"GET /books/{isbn}":             "catalog",
+	"GET /books/{isbn}/preview":     "catalog",
+	"GET /books/{id}/also-borrowed": "catalog",
+	"POST /graphql":                 "catalog",
+	"GET /healthz":                  "",
+	"GET /readyz":                   "",
+}
+
+// middleware limits requests to mux by the policy of the route group of
+// the pattern they match. Requests over the limit get 429 with
+// Retry-After; limiter failures let requests through. It must run after
+// authentication so that callers are told apart.
+func (l *rateLimits) middleware(mux *http.ServeMux) http.Handler {
+	if l == nil {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		route, ok := rateLimitRoutes[pattern]
+		if !ok {
+			route = "default"
+		}
+		key, authenticated := l.clientKey(r)
+		limit := l.policies[route].Anonymous
+		if authenticated {
+			limit = l.policies[route].Authenticated
+		}
+		if pattern == "" || limit.unlimited() {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		allowed, retryAfter, err := l.limiter.Allow(r.Context(), route+":"+key, limit, time.Now())
+		if err != nil {
+			slog.WarnContext(r.Context(), "rate limiter failed", "error", err)
+			allowed = true
+		}
+		if !allowed {
+			rateLimitedRequests.WithLabelValues(route).Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "rate limit exceeded"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// rateLimitsFromEnv configures rate limiting from RATE_LIMIT_BACKEND
+// ("memory", "postgres" or "off"), RATE_LIMIT_TRUST_PROXY,
+// RATE_LIMIT_AUTH_FAILURES and RATE_LIMIT_<ROUTE>_ANONYMOUS /
+// RATE_LIMIT_<ROUTE>_AUTHENTICATED overrides of the default policies. It
+// returns nil when rate limiting is off.
+func rateLimitsFromEnv(db *gorm.DB) (*rateLimits, error) {
+	l := &rateLimits{policies: map[string]RateLimitPolicy{}, authFailures: defaultAuthFailureLimit}
+	switch backend := os.Getenv("RATE_LIMIT_BACKEND"); backend {
+	case "", "memory":
+		l.limiter = newMemoryRateLimiter()
+	case "postgres":
+		l.limiter = &PostgresRateLimiter{db: db}
+	case "off":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_BACKEND %q", backend)
+	}
+	l.trustProxy, _ = strconv.ParseBool(os.Getenv("RATE_LIMIT_TRUST_PROXY"))
+
+	var errs []error
+	if v := os.Getenv("RATE_LIMIT_AUTH_FAILURES"); v != "" {
+		parsed, err := parseRateLimit(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("RATE_LIMIT_AUTH_FAILURES: %w", err))
+		}
+		l.authFailures = parsed
+	}
+	for route, policy := range defaultRateLimitPolicies {
+		prefix := "RATE_LIMIT_" + strings.ToUpper(route)
+		for suffix, limit := range map[string]*RateLimit{"_ANONYMOUS": &policy.Anonymous, "_AUTHENTICATED": &policy.Authenticated} {
+			if v := os.Getenv(prefix + suffix); v != "" {
+				parsed, err := parseRateLimit(v)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s%s: %w", prefix, suffix, err))
+				}
+				*limit = parsed
+			}
+		}
+		l.policies[route] = policy
+	}
+	return l, errors.Join(errs...)
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// authMiddleware limits failed sign-ins per client IP and must wrap the
+// authentication middleware. Each 401 is charged to the IP; once its
+// failures are used up, requests with credentials get 429 before their
+// credentials are checked, so guessing costs neither a bcrypt comparison
+// nor a token lookup.
+func (l *rateLimits) authMiddleware(next http.Handler) http.Handler {
+	if l == nil || l.authFailures.unlimited() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := "auth:ip:" + l.clientIP(r)
+		allowed, retryAfter, err := l.limiter.Check(r.Context(), key, l.authFailures, time.Now())
+		if err != nil {
+			slog.WarnContext(r.Context(), "rate limiter failed", "error", err)
+			allowed = true
+		}
+		if !allowed {
+			rateLimitedRequests.WithLabelValues("auth").Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			writeJSON(w, http.StatusTooManyRequests, map[string]string{"error": "too many failed sign-ins"})
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status == http.StatusUnauthorized {
+			if _, _, err := l.limiter.Allow(r.Context(), key, l.authFailures, time.Now()); err != nil {
+				slog.WarnContext(r.Context(), "rate limiter failed", "error", err)
+			}
+		}
+	})
+}
+
+// registerRateLimitJobs registers the job pruning idle Postgres buckets
+// when that backend is in use.
+func registerRateLimitJobs(s *Scheduler, limits *rateLimits) error {
+	if limits == nil {
+		return nil
+	}
+	pg, ok := limits.limiter.(*PostgresRateLimiter)
+	if !ok {
+		return nil
+	}
+	return s.Register("prune-rate-limits", "@hourly", pg.pruneBuckets)
+}
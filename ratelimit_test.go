@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestParseRateLimit tests parsing of rate limit settings.
+func TestParseRateLimit(t *testing.T) {
+	tests := map[string]RateLimit{
+		"60/m":  {Rate: 1, Burst: 60},
+		"10/s":  {Rate: 10, Burst: 10},
+		"360/h": {Rate: 0.1, Burst: 360},
+		"off":   {},
+		"0":     {},
+	}
+	for s, want := range tests {
+		got, err := parseRateLimit(s)
+		if err != nil || got != want {
+			t.Errorf("parseRateLimit(%q) = %+v, %v, want %+v", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "60", "x/m", "-1/m", "60/d"} {
+		if _, err := parseRateLimit(s); err == nil {
+			t.Errorf("parseRateLimit(%q) should fail", s)
+		}
+	}
+}
+
+// TestTokenBucket tests that a bucket drains, tells how long to wait and
+// refills up to its burst.
+func TestTokenBucket(t *testing.T) {
+	limit := RateLimit{Rate: 2, Burst: 3}
+	now := time.Now()
+	b := &tokenBucket{Tokens: 3, Updated: now}
+	for i := 0; i < 3; i++ {
+		if ok, _ := b.take(limit, now); !ok {
+			t.Fatalf("request %d within the burst was refused", i)
+		}
+	}
+	ok, retryAfter := b.take(limit, now)
+	if ok || retryAfter != 500*time.Millisecond {
+		t.Errorf("expected a refusal with a 500ms wait, got %v, %v", ok, retryAfter)
+	}
+	if ok, _ := b.take(limit, now.Add(500*time.Millisecond)); !ok {
+		t.Errorf("expected a token after waiting")
+	}
+	b.take(limit, now.Add(time.Hour))
+	if b.Tokens != 2 {
+		t.Errorf("expected the bucket to refill to its burst, %v tokens left", b.Tokens)
+	}
+}
+
+// TestMemoryRateLimiter tests that keys are limited separately.
+func TestMemoryRateLimiter(t *testing.T) {
+	l := newMemoryRateLimiter()
+	limit := RateLimit{Rate: 1, Burst: 1}
+	now := time.Now()
+	ctx := context.Background()
+	if ok, _, _ := l.Allow(ctx, "a", limit, now); !ok {
+		t.Fatalf("first request for a was refused")
+	}
+	if ok, _, _ := l.Allow(ctx, "a", limit, now); ok {
+		t.Errorf("second request for a should be refused")
+	}
+	if ok, _, _ := l.Allow(ctx, "b", limit, now); !ok {
+		t.Errorf("first request for b was refused")
+	}
+	if ok, retryAfter, _ := l.Check(ctx, "a", limit, now); ok || retryAfter != time.Second {
+		t.Errorf("Check of an empty bucket = %v, %v", ok, retryAfter)
+	}
+	if ok, _, _ := l.Check(ctx, "c", limit, now); !ok {
+		t.Errorf("Check of an unknown key should allow")
+	}
+	if ok, _, _ := l.Allow(ctx, "a", limit, now.Add(time.Second)); !ok {
+		t.Errorf("Check should not take a token")
+	}
+	l.sweep(now.Add(2 * time.Hour))
+	if len(l.buckets) != 0 {
+		t.Errorf("expected idle buckets to be swept, %d left", len(l.buckets))
+	}
+}
+
+// TestRateLimitMiddleware tests route groups, per-client buckets, exempt
+// routes and the 429 response.
+func TestRateLimitMiddleware(t *testing.T) {
+	mux := http.NewServeMux()
+	ok := func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }
+	mux.HandleFunc("GET /books", ok)
+	mux.HandleFunc("POST /loans", ok)
+	mux.HandleFunc("GET /healthz", ok)
+	limits := &rateLimits{
+		limiter: newMemoryRateLimiter(),
+		policies: map[string]RateLimitPolicy{
+			"catalog": {Anonymous: RateLimit{Rate: 1, Burst: 2}, Authenticated: RateLimit{Rate: 1, Burst: 5}},
+			"default": {Anonymous: RateLimit{Rate: 1, Burst: 1}},
+		},
+	}
+	handler := limits.middleware(mux)
+	do := func(method, path, ip, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := do(http.MethodGet, "/books", "10.0.0.1", ""); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: expected 200, got %d", i, rec.Code)
+		}
+	}
+	rec := do(http.MethodGet, "/books", "10.0.0.1", "")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("expected 429 with Retry-After 1, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := do(http.MethodGet, "/books", "10.0.0.2", ""); rec.Code != http.StatusOK {
+		t.Errorf("another client should have its own bucket, got %d", rec.Code)
+	}
+	if rec := do(http.MethodGet, "/books", "10.0.0.1", "secret"); rec.Code != http.StatusOK {
+		t.Errorf("a token should have its own bucket, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/loans", "10.0.0.1", ""); rec.Code != http.StatusOK {
+		t.Errorf("another route group should have its own bucket, got %d", rec.Code)
+	}
+	for i := 0; i < 3; i++ {
+		if rec := do(http.MethodPost, "/loans", "10.0.0.1", "secret"); rec.Code != http.StatusOK {
+			t.Errorf("an unlimited policy should not limit, got %d", rec.Code)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if rec := do(http.MethodGet, "/healthz", "10.0.0.1", ""); rec.Code != http.StatusOK {
+			t.Errorf("health checks should not be limited, got %d", rec.Code)
+		}
+	}
+}
+
+// TestRateLimitAuthFailures tests that failed sign-ins are charged to the
+// client IP and that an IP out of failures is refused before its
+// credentials are checked.
+func TestRateLimitAuthFailures(t *testing.T) {
+	var checked int
+	auth := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		checked++
+		if r.Header.Get("Authorization") == "Bearer bad" {
+			writeError(w, ErrUnauthenticated)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	limits := &rateLimits{limiter: newMemoryRateLimiter(), authFailures: RateLimit{Rate: 1.0 / 60, Burst: 2}}
+	handler := limits.authMiddleware(auth)
+	do := func(ip, token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/books", nil)
+		req.RemoteAddr = ip + ":1234"
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for i := 0; i < 3; i++ {
+		if code := do("10.0.0.1", "good"); code != http.StatusOK {
+			t.Fatalf("successful sign-in %d: got %d", i, code)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if code := do("10.0.0.1", "bad"); code != http.StatusUnauthorized {
+			t.Fatalf("failed sign-in %d: expected 401, got %d", i, code)
+		}
+	}
+	checked = 0
+	if code := do("10.0.0.1", "bad"); code != http.StatusTooManyRequests || checked != 0 {
+		t.Errorf("expected 429 without checking credentials, got %d after %d checks", code, checked)
+	}
+	if code := do("10.0.0.1", ""); code != http.StatusOK {
+		t.Errorf("anonymous requests are not sign-ins, got %d", code)
+	}
+	if code := do("10.0.0.2", "bad"); code != http.StatusUnauthorized {
+		t.Errorf("another IP should have its own failures, got %d", code)
+	}
+}
+
+// TestRateLimitClientKey tests that X-Forwarded-For is only trusted behind
+// a proxy.
+func TestRateLimitClientKey(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/books", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	if key, _ := (&rateLimits{}).clientKey(req); key != "ip:10.0.0.1" {
+		t.Errorf("untrusted proxy: got %q", key)
+	}
+	if key, _ := (&rateLimits{trustProxy: true}).clientKey(req); key != "ip:203.0.113.7" {
+		t.Errorf("trusted proxy: got %q", key)
+	}
+	req = req.WithContext(withPrincipal(req.Context(), &User{ID: 7}))
+	if key, authenticated := (&rateLimits{}).clientKey(req); key != "user:7" || !authenticated {
+		t.Errorf("password login: got %q, %v", key, authenticated)
+	}
+}
+
+// TestPostgresRateLimiter tests that buckets are shared through the
+// database and pruned when idle.
+func TestPostgresRateLimiter(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	a, b := &PostgresRateLimiter{db: db}, &PostgresRateLimiter{db: db}
+	ctx := context.Background()
+	limit := RateLimit{Rate: 1, Burst: 2}
+	now := time.Now()
+
+	for i, l := range []*PostgresRateLimiter{a, b} {
+		if ok, _, err := l.Allow(ctx, "test:shared", limit, now); err != nil || !ok {
+			t.Fatalf("request %d: expected to be allowed, got %v, %v", i, ok, err)
+		}
+	}
+	ok, retryAfter, err := a.Allow(ctx, "test:shared", limit, now)
+	if err != nil || ok || retryAfter != time.Second {
+		t.Errorf("expected the shared bucket to be empty, got %v, %v, %v", ok, retryAfter, err)
+	}
+	if ok, retryAfter, err := b.Check(ctx, "test:shared", limit, now); err != nil || ok || retryAfter != time.Second {
+		t.Errorf("Check should see the empty bucket, got %v, %v, %v", ok, retryAfter, err)
+	}
+	if ok, _, err := b.Check(ctx, "test:unknown", limit, now); err != nil || !ok {
+		t.Errorf("Check of an unknown key should allow, got %v, %v", ok, err)
+	}
+	if ok, _, _ := b.Allow(ctx, "test:shared", limit, now.Add(time.Second)); !ok {
+		t.Errorf("expected a token after waiting")
+	}
+
+	db.Model(&RateLimitBucket{}).Where("key = ?", "test:shared").Update("updated_at", now.Add(-2*rateLimitBucketRetention))
+	if err := a.pruneBuckets(ctx); err != nil {
+		t.Fatalf("pruneBuckets: %v", err)
+	}
+	var n int64
+	db.Model(&RateLimitBucket{}).Where("key = ?", "test:shared").Count(&n)
+	if n != 0 {
+		t.Errorf("expected the idle bucket to be pruned")
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Recommendation tuning. Similarity between two books adds the cosine
+// similarity of their borrowers to weighted counts of shared authors and
+// categories, and is then scaled by the reviews of the recommended book.
+const (
+	sharedAuthorWeight       = 0.5
+	sharedCategoryWeight     = 0.25
+	similarBooksPerBook      = 10
+	recommendationsPerMember = 20
+	defaultRecommendations   = 5
+)
+
+// BookSimilarity is a precomputed "members who borrowed this also
+// borrowed" entry: SimilarBookID is recommended to readers of BookID.
+type BookSimilarity struct {
+	ID            uint      `gorm:"primaryKey"`
+	TenantID      uint      `gorm:"index;not null;default:0"`
+	BookID        uint      `gorm:"uniqueIndex:idx_book_similarity;not null"`
+	SimilarBookID uint      `gorm:"uniqueIndex:idx_book_similarity;not null"`
+	Score         float64   `gorm:"not null"`
+	ComputedAt    time.Time `gorm:"index"`
+}
+
+// MemberRecommendation is a precomputed personalised suggestion.
+type MemberRecommendation struct {
+	ID         uint      `gorm:"primaryKey"`
+	TenantID   uint      `gorm:"index;not null;default:0"`
+	MemberID   uint      `gorm:"uniqueIndex:idx_member_recommendation;not null"`
+	BookID     uint      `gorm:"uniqueIndex:idx_member_recommendation;not null"`
+	Score      float64   `gorm:"not null"`
+	ComputedAt time.Time `gorm:"index"`
+}
+
+// Recommendation is a suggested book as returned to callers.
+type Recommendation struct {
+	BookID uint    `json:"book_id"`
+	ISBN   string  `json:"isbn"`
+	Title  string  `json:"title"`
+	Score  float64 `json:"score"`
+}
+
+// bookPair is an ordered pair of distinct books.
+type bookPair struct{ a, b uint }
+
+// memberBook is a book a member borrowed or reviewed.
+type memberBook struct {
+	MemberID uint
+	BookID   uint
+}
+
+// memberRating is a member's review of a book.
+type memberRating struct {
+	MemberID uint
+	BookID   uint
+	Rating   int
+}
+
+// recommenderInput holds the signals read from loans, the author and
+// category join tables and reviews of one tenant.
+type recommenderInput struct {
+	borrowers        map[uint]int
+	coBorrowers      map[bookPair]int
+	sharedAuthors    map[bookPair]int
+	sharedCategories map[bookPair]int
+	avgRating        map[uint]float64
+	borrowed         map[uint][]uint
+	ratings          []memberRating
+}
+
+// ratingFactor scales a score by the average review of a book, from 0.8
+// for one star to 1.2 for five; unreviewed books are left unchanged.
+func (in *recommenderInput) ratingFactor(bookID uint) float64 {
+	avg, ok := in.avgRating[bookID]
+	if !ok {
+		return 1
+	}
+	return 1 + (avg-3)/10
+}
+
+// similarities scores every pair of related books and keeps the topN
+// most similar books for each book.
+func (in *recommenderInput) similarities(topN int) map[uint][]scoredBook {
+	scores := map[bookPair]float64{}
+	for p, n := range in.coBorrowers {
+		scores[p] += float64(n) / math.Sqrt(float64(in.borrowers[p.a]*in.borrowers[p.b]))
+	}
+	for p, n := range in.sharedAuthors {
+		scores[p] += sharedAuthorWeight * float64(n)
+	}
+	for p, n := range in.sharedCategories {
+		scores[p] += sharedCategoryWeight * float64(n)
+	}
+
+	byBook := map[uint][]scoredBook{}
+	for p, s := range scores {
+		byBook[p.a] = append(byBook[p.a], scoredBook{p.b, s * in.ratingFactor(p.b)})
+	}
+	for id, list := range byBook {
+		byBook[id] = topScored(list, topN)
+	}
+	return byBook
+}
+
+// forMembers suggests up to topN books per member: books similar to the
+// ones they borrowed, and to the ones they rated, weighted by the rating.
+// Books the member already borrowed or reviewed are never suggested.
+func (in *recommenderInput) forMembers(similar map[uint][]scoredBook, topN int) map[uint][]scoredBook {
+	seeds := map[uint]map[uint]float64{}
+	addSeed := func(member, book uint, weight float64) {
+		if seeds[member] == nil {
+			seeds[member] = map[uint]float64{}
+		}
+		if weight > seeds[member][book] {
+			seeds[member][book] = weight
+		}
+	}
+	for member, books := range in.borrowed {
+		for _, b := range books {
+			addSeed(member, b, 1)
+		}
+	}
+	for _, r := range in.ratings {
+		addSeed(r.MemberID, r.BookID, float64(r.Rating)/5)
+	}
+
+	result := map[uint][]scoredBook{}
+	for member, seedBooks := range seeds {
+		candidates := map[uint]float64{}
+		for seed, weight := range seedBooks {
+			for _, s := range similar[seed] {
+				if _, known := seedBooks[s.bookID]; !known {
+					candidates[s.bookID] += weight * s.score
+				}
+			}
+		}
+		list := make([]scoredBook, 0, len(candidates))
+		for id, score := range candidates {
+			list = append(list, scoredBook{id, score})
+		}
+		if len(list) > 0 {
+			result[member] = topScored(list, topN)
+		}
+	}
+	return result
+}
+
+// scoredBook is a candidate book with its score.
+type scoredBook struct {
+	bookID uint
+	score  float64
+}
+
+// topScored sorts list by descending score (then book ID) and keeps n.
+func topScored(list []scoredBook, n int) []scoredBook {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].score != list[j].score {
+			return list[i].score > list[j].score
+		}
+		return list[i].bookID < list[j].bookID
+	})
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// RecommendationService serves book recommendations precomputed by Recompute.
+type RecommendationService struct {
+	db           *gorm.DB
+	queryTimeout time.Duration
+}
+
+// AlsoBorrowed returns up to limit books that readers of bookID also
+// borrowed or that share its authors and categories.
+func (s *RecommendationService) AlsoBorrowed(ctx context.Context, bookID uint, limit int) (_ []Recommendation, err error) {
+	defer observeOperation("also_borrowed", time.Now(), &err)
+	if _, err := authorize(ctx, PermViewCatalog); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+	if limit <= 0 {
+		limit = defaultRecommendations
+	}
+
+	var recs []Recommendation
+	err = s.db.WithContext(ctx).Model(&BookSimilarity{}).
+		Select("books.id AS book_id, books.isbn, books.title, book_similarities.score").
+		Joins("JOIN books ON books.id = book_similarities.similar_book_id").
+		Where("book_similarities.book_id = ?", bookID).
+		Order("book_similarities.score DESC").
+		Limit(limit).
+		Scan(&recs).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load similar books: %w", err)
+	}
+	return recs, nil
+}
+
+// ForMember returns up to limit personalised suggestions for a member.
+// Members may only see their own suggestions.
+func (s *RecommendationService) ForMember(ctx context.Context, memberID uint, limit int) (_ []Recommendation, err error) {
+	defer observeOperation("member_recommendations", time.Now(), &err)
+	if err := authorizeMember(ctx, memberID); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+	if limit <= 0 {
+		limit = defaultRecommendations
+	}
+
+	var recs []Recommendation
+	err = s.db.WithContext(ctx).Model(&MemberRecommendation{}).
+		Select("books.id AS book_id, books.isbn, books.title, member_recommendations.score").
+		Joins("JOIN books ON books.id = member_recommendations.book_id").
+		Where("member_recommendations.member_id = ?", memberID).
+		Order("member_recommendations.score DESC").
+		Limit(limit).
+		Scan(&recs).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to load recommendations: %w", err)
+	}
+	return recs, nil
+}
+
+// Recompute rebuilds the similar-book and member recommendation tables of
+// the tenant of ctx from its loans, authors, categories and reviews.
+// Reviews are read as ratings of book ProductID by member CustomerID.
+func (s *RecommendationService) Recompute(ctx context.Context) (err error) {
+	defer observeOperation("recompute_recommendations", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageCatalog); err != nil {
+		return err
+	}
+
+	in, err := s.loadSignals(ctx)
+	if err != nil {
+		return err
+	}
+	similar := in.similarities(similarBooksPerBook)
+	members := in.forMembers(similar, recommendationsPerMember)
+
+	// Postgres keeps microseconds; truncate so fresh rows compare equal to now.
+	now := time.Now().Truncate(time.Microsecond)
+	var sims []BookSimilarity
+	for book, list := range similar {
+		for _, sb := range list {
+			sims = append(sims, BookSimilarity{BookID: book, SimilarBookID: sb.bookID, Score: sb.score, ComputedAt: now})
+		}
+	}
+	var recs []MemberRecommendation
+	for member, list := range members {
+		for _, sb := range list {
+			recs = append(recs, MemberRecommendation{MemberID: member, BookID: sb.bookID, Score: sb.score, ComputedAt: now})
+		}
+	}
+
+	// Upsert the new results, then drop whatever this run did not produce.
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if len(sims) > 0 {
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "book_id"}, {Name: "similar_book_id"}},
+				DoUpdates: clause.AssignmentColumns([]string{"score", "computed_at"}),
+			}).CreateInBatches(sims, 500).Error; err != nil {
+				return fmt.Errorf("failed to store similar books: %w", err)
+			}
+		}
+		if len(recs) > 0 {
+			if err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "member_id"}, {Name: "book_id"}},
+				DoUpdates: clause.AssignmentColumns([]string{"score", "computed_at"}),
+			}).CreateInBatches(recs, 500).Error; err != nil {
+				return fmt.Errorf("failed to store recommendations: %w", err)
+			}
+		}
+		if err := tx.Where("computed_at < ?", now).Delete(&BookSimilarity{}).Error; err != nil {
+			return fmt.Errorf("failed to remove stale similar books: %w", err)
+		}
+		if err := tx.Where("computed_at < ?", now).Delete(&MemberRecommendation{}).Error; err != nil {
+			return fmt.Errorf("failed to remove stale recommendations: %w", err)
+		}
+		return nil
+	})
+}
+
+// loadSignals reads the recommendation signals of the tenant of ctx. The
+// join tables have no tenant column, so the queries filter on the tenant
+// of the books (or loans and reviews) explicitly.
+func (s *RecommendationService) loadSignals(ctx context.Context) (*recommenderInput, error) {
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+	db := s.db.WithContext(ctx)
+	tenant := tenantFromContext(ctx)
+
+	in := &recommenderInput{
+		borrowers:        map[uint]int{},
+		coBorrowers:      map[bookPair]int{},
+		sharedAuthors:    map[bookPair]int{},
+		sharedCategories: map[bookPair]int{},
+		avgRating:        map[uint]float64{},
+		borrowed:         map[uint][]uint{},
+	}
+
+	var borrowed []memberBook
+	if err := db.Raw(`SELECT DISTINCT member_id, book_id FROM book_loans
+		WHERE tenant_id = ? AND member_id <> 0`, tenant).Scan(&borrowed).Error; err != nil {
+		return nil, fmt.Errorf("failed to load loan history: %w", err)
+	}
+	for _, mb := range borrowed {
+		in.borrowed[mb.MemberID] = append(in.borrowed[mb.MemberID], mb.BookID)
+		in.borrowers[mb.BookID]++
+	}
+
+	pairQueries := []struct {
+		into  map[bookPair]int
+		query string
+	}{
+		{in.coBorrowers, `SELECT x.book_id AS a, y.book_id AS b, COUNT(DISTINCT x.member_id) AS n
+			FROM book_loans x JOIN book_loans y ON y.member_id = x.member_id AND y.book_id <> x.book_id AND y.tenant_id = x.tenant_id
+			WHERE x.tenant_id = ? AND x.member_id <> 0 GROUP BY x.book_id, y.book_id`},
+		{in.sharedAuthors, `SELECT x.book_id AS a, y.book_id AS b, COUNT(*) AS n
+			FROM book_authors x JOIN book_authors y ON y.author_id = x.author_id AND y.book_id <> x.book_id
+			JOIN books ON books.id = x.book_id
+			WHERE books.tenant_id = ? GROUP BY x.book_id, y.book_id`},
+		{in.sharedCategories, `SELECT x.book_id AS a, y.book_id AS b, COUNT(*) AS n
+			FROM book_categories x JOIN book_categories y ON y.category_id = x.category_id AND y.book_id <> x.book_id
+			JOIN books ON books.id = x.book_id
+			WHERE books.tenant_id = ? GROUP BY x.book_id, y.book_id`},
+	}
+	for _, pq := range pairQueries {
+		var rows []struct {
+			A, B uint
+			N    int
+		}
+		if err := db.Raw(pq.query, tenant).Scan(&rows).Error; err != nil {
+			return nil, fmt.Errorf("failed to load related books: %w", err)
+		}
+		for _, r := range rows {
+			pq.into[bookPair{r.A, r.B}] = r.N
+		}
+	}
+
+	if err := db.Raw(`SELECT customer_id AS member_id, product_id AS book_id, rating FROM reviews
+		WHERE tenant_id = ? AND customer_id <> 0`, tenant).Scan(&in.ratings).Error; err != nil {
+		return nil, fmt.Errorf("failed to load reviews: %w", err)
+	}
+	sums, counts := map[uint]int{}, map[uint]int{}
+	for _, r := range in.ratings {
+		sums[r.BookID] += r.Rating
+		counts[r.BookID]++
+	}
+	for id, n := range counts {
+		in.avgRating[id] = float64(sums[id]) / float64(n)
+	}
+	return in, nil
+}
+
+// handleAlsoBorrowed serves GET /books/{id}/also-borrowed?limit=.
+func (s *apiServer) handleAlsoBorrowed(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid book id"})
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	recs, err := s.recommendations.AlsoBorrowed(r.Context(), uint(id), limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, recs)
+}
+
+// handleMemberRecommendations serves GET /members/{id}/recommendations?limit=.
+func (s *apiServer) handleMemberRecommendations(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid member id"})
+		return
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	recs, err := s.recommendations.ForMember(r.Context(), uint(id), limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, recs)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+// TestRecommender_Scores tests similarity and member scoring on hand-built signals.
+func TestRecommender_Scores(t *testing.T) {
+	in := &recommenderInput{
+		borrowers: map[uint]int{1: 2, 2: 2, 3: 2},
+		coBorrowers: map[bookPair]int{
+			{1, 2}: 2, {2, 1}: 2,
+			{1, 3}: 1, {3, 1}: 1,
+			{2, 3}: 1, {3, 2}: 1,
+		},
+		sharedAuthors:    map[bookPair]int{{1, 3}: 1, {3, 1}: 1},
+		sharedCategories: map[bookPair]int{},
+		avgRating:        map[uint]float64{3: 5},
+		borrowed:         map[uint][]uint{1: {1, 2}, 2: {1, 2, 3}, 3: {3}},
+		ratings:          []memberRating{{MemberID: 2, BookID: 3, Rating: 5}},
+	}
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-9 }
+
+	similar := in.similarities(10)
+	if got := similar[1]; len(got) != 2 || got[0].bookID != 3 || !near(got[0].score, 1.2) || got[1].bookID != 2 || !near(got[1].score, 1) {
+		t.Errorf("similar to book 1: got %+v", got)
+	}
+
+	members := in.forMembers(similar, 10)
+	if got := members[1]; len(got) != 1 || got[0].bookID != 3 || !near(got[0].score, 1.8) {
+		t.Errorf("member 1: got %+v", got)
+	}
+	if got := members[3]; len(got) != 2 || got[0].bookID != 1 || got[1].bookID != 2 {
+		t.Errorf("member 3: got %+v", got)
+	}
+	if got := members[2]; len(got) != 0 {
+		t.Errorf("member 2 has read everything, got %+v", got)
+	}
+}
+
+// TestRecommendationService_Recompute tests precomputing and serving recommendations.
+func TestRecommendationService_Recompute(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenant := mustCreateTenant(t, &TenantService{db: db}, "recs")
+	ctx := withTenant(asRole(RoleLibrarian), tenant.ID)
+	tdb := db.WithContext(ctx)
+	svc := &RecommendationService{db: db}
+
+	dune := &Book{ISBN: "9782323232321", Title: "Dune", Copies: 5}
+	messiah := &Book{ISBN: "9782323232322", Title: "Dune Messiah", Copies: 5}
+	cooking := &Book{ISBN: "9782323232323", Title: "Cooking", Copies: 5}
+	for _, b := range []*Book{dune, messiah, cooking} {
+		mustCreateBook(t, tdb, b)
+	}
+	readers := []struct{ member, book uint }{{101, dune.ID}, {101, messiah.ID}, {102, dune.ID}, {102, messiah.ID}, {103, dune.ID}, {104, cooking.ID}}
+	for _, r := range readers {
+		loan := BookLoan{BookID: r.book, MemberID: r.member, Returned: true}
+		if err := tdb.Create(&loan).Error; err != nil {
+			t.Fatalf("failed to create loan: %v", err)
+		}
+	}
+
+	if err := svc.Recompute(ctx); err != nil {
+		t.Fatalf("Recompute: %v", err)
+	}
+	// A second run must replace, not duplicate, the results.
+	if err := svc.Recompute(ctx); err != nil {
+		t.Fatalf("second Recompute: %v", err)
+	}
+
+	also, err := svc.AlsoBorrowed(ctx, dune.ID, 5)
+	if err != nil || len(also) != 1 || also[0].BookID != messiah.ID {
+		t.Errorf("AlsoBorrowed: got %+v, %v", also, err)
+	}
+	recs, err := svc.ForMember(ctx, 103, 5)
+	if err != nil || len(recs) != 1 || recs[0].Title != "Dune Messiah" {
+		t.Errorf("ForMember: got %+v, %v", recs, err)
+	}
+
+	member := withTenant(withPrincipal(context.Background(), &User{ID: 104, TenantID: tenant.ID, Role: RoleMember}), tenant.ID)
+	if _, err := svc.ForMember(member, 103, 5); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected members to be limited to their own suggestions, got %v", err)
+	}
+	if err := svc.Recompute(member); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected members not to recompute, got %v", err)
+	}
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Kinds of discrepancy found by the inventory reconciliation.
+const (
+	IssueAvailableMismatch    = "available_mismatch"
+	IssueNegativeAvailable    = "negative_available"
+	IssueAvailableAboveCopies = "available_above_copies"
+	IssueOverLent             = "over_lent"
+	IssueLicensePoolMismatch  = "license_pool_mismatch"
+	IssueBranchMismatch       = "branch_available_mismatch"
+	IssueOrphanedLoan         = "orphaned_loan"
+	IssueOrphanedJoin         = "orphaned_join"
+)
+
+// auditActionReconcile is the AuditLog action of reconciliation fixes.
+const auditActionReconcile = "reconcile"
+
+// Discrepancy is one inconsistency found by the reconciliation. Expected
+// and Actual are the available copies or licenses, where they apply.
+type Discrepancy struct {
+	TenantID  uint
+	Kind      string
+	ModelType string
+	ModelID   uint
+	Expected  int
+	Actual    int
+	Detail    string
+	Fixed     bool
+}
+
+// ReconcileReport is the outcome of a reconciliation run.
+type ReconcileReport struct {
+	Books             int
+	LicensePools      int
+	BranchInventories int
+	Discrepancies     []Discrepancy
+}
+
+// Fixed counts the discrepancies that were repaired.
+func (r *ReconcileReport) Fixed() int {
+	n := 0
+	for _, d := range r.Discrepancies {
+		if d.Fixed {
+			n++
+		}
+	}
+	return n
+}
+
+// Reconciler checks stock counters against the loans they are derived
+// from: a print book has Copies minus its open loans available, a branch
+// its Copies of the book minus the open loans lent there, and a license
+// pool Concurrent minus its open loans. Copies returned at another branch
+// and still travelling back count as neither. It also finds open loans of
+// books or members that no longer exist and association rows pointing at
+// missing records. Books whose counter is fixed are evicted from cache.
+type Reconciler struct {
+	db    *gorm.DB
+	cache *bookCache
+}
+
+// Reconcile checks every tenant and, when fix is set, repairs what it finds
+// and records each repair in the audit log. Open orphaned loans are closed
+// before the counters are recomputed, so that they no longer hold a copy.
+func (r *Reconciler) Reconcile(ctx context.Context, fix bool) (*ReconcileReport, error) {
+	report := &ReconcileReport{}
+	err := forEachTenant(ctx, r.db, func(ctx context.Context) error {
+		return r.reconcileTenant(ctx, report, fix)
+	})
+	if joinErr := r.reconcileJoins(withAllTenants(ctx), report, fix); joinErr != nil {
+		err = errors.Join(err, joinErr)
+	}
+	return report, err
+}
+
+// ReconcileTenant checks the tenant of ctx only, leaving association rows
+// alone since they are not kept per tenant.
+func (r *Reconciler) ReconcileTenant(ctx context.Context, fix bool) (*ReconcileReport, error) {
+	report := &ReconcileReport{}
+	return report, r.reconcileTenant(ctx, report, fix)
+}
+
+// reconcileTenant checks loans, books and license pools of the tenant of
+// ctx.
+func (r *Reconciler) reconcileTenant(ctx context.Context, report *ReconcileReport, fix bool) error {
+	tenantID := tenantFromContext(ctx)
+	db := r.db.WithContext(ctx)
+
+	// Loans with no member (legacy loans and staff checkouts) are valid.
+	// The subqueries are not tenant-scoped, so they match the loan's
+	// tenant explicitly.
+	var orphans []BookLoan
+	err := db.Where("NOT returned").
+		Where(`(NOT EXISTS (SELECT 1 FROM books WHERE books.id = book_loans.book_id AND books.tenant_id = book_loans.tenant_id)
+			OR (book_loans.member_id <> 0 AND NOT EXISTS (SELECT 1 FROM users WHERE users.id = book_loans.member_id AND users.tenant_id = book_loans.tenant_id)))`).
+		Order("id").Find(&orphans).Error
+	if err != nil {
+		return fmt.Errorf("failed to find orphaned loans: %w", err)
+	}
+	for _, l := range orphans {
+		d := Discrepancy{TenantID: tenantID, Kind: IssueOrphanedLoan, ModelType: "book_loans", ModelID: l.ID,
+			Detail: fmt.Sprintf("open loan of book %d to member %d, one of which does not exist", l.BookID, l.MemberID)}
+		if fix {
+			if err := r.closeOrphanedLoan(ctx, l.ID, d.Detail); err != nil {
+				return err
+			}
+			d.Fixed = true
+		}
+		report.Discrepancies = append(report.Discrepancies, d)
+	}
+
+	var books []struct {
+		ID        uint
+		ISBN      string
+		Copies    int
+		Available int
+		OnLoan    int
+		InTransit int
+	}
+	err = db.Model(&Book{}).
+		Select("books.id, books.isbn, books.copies, books.available, COUNT(book_loans.id) AS on_loan, " + inTransitReturnsSQL + " AS in_transit").
+		Joins("LEFT JOIN book_loans ON book_loans.book_id = books.id AND NOT book_loans.returned AND book_loans.license_pool_id IS NULL").
+		Group("books.id").Order("books.id").Scan(&books).Error
+	if err != nil {
+		return fmt.Errorf("failed to count open loans: %w", err)
+	}
+	report.Books += len(books)
+	var fixed []string
+	defer func() {
+		if len(fixed) > 0 {
+			r.cache.invalidate(ctx, fixed...)
+		}
+	}()
+	for _, b := range books {
+		expected := max(b.Copies-b.OnLoan-b.InTransit, 0)
+		d := Discrepancy{TenantID: tenantID, ModelType: "books", ModelID: b.ID, Expected: expected, Actual: b.Available,
+			Detail: fmt.Sprintf("ISBN %s: %d copies, %d on loan, %d returning from another branch, %d available", b.ISBN, b.Copies, b.OnLoan, b.InTransit, b.Available)}
+		switch {
+		case b.OnLoan > b.Copies:
+			d.Kind = IssueOverLent
+		case b.Available < 0:
+			d.Kind = IssueNegativeAvailable
+		case b.Available > b.Copies:
+			d.Kind = IssueAvailableAboveCopies
+		case b.Available != expected:
+			d.Kind = IssueAvailableMismatch
+		default:
+			continue
+		}
+		if fix {
+			if d.Fixed, err = r.fixCounter(ctx, &Book{}, "books", "copies", b.ID, "license_pool_id IS NULL AND book_id = ?", "book_id = ?"); err != nil {
+				return err
+			}
+			if d.Fixed {
+				fixed = append(fixed, b.ISBN)
+			}
+		}
+		report.Discrepancies = append(report.Discrepancies, d)
+	}
+
+	// The subqueries are not tenant-scoped, so they match the tenant
+	// explicitly.
+	var inventories []struct {
+		ID        uint
+		BranchID  uint
+		BookID    uint
+		Copies    int
+		Available int
+		OnLoan    int
+		InTransit int
+	}
+	err = db.Model(&BranchInventory{}).
+		Select(`branch_inventories.id, branch_inventories.branch_id, branch_inventories.book_id, branch_inventories.copies, branch_inventories.available,
+			(SELECT COUNT(*) FROM book_loans WHERE book_loans.book_id = branch_inventories.book_id AND book_loans.branch_id = branch_inventories.branch_id
+				AND book_loans.tenant_id = branch_inventories.tenant_id AND NOT book_loans.returned AND book_loans.license_pool_id IS NULL) AS on_loan,
+			(SELECT COALESCE(SUM(transfer_requests.quantity), 0) FROM transfer_requests WHERE transfer_requests.book_id = branch_inventories.book_id
+				AND transfer_requests.to_branch_id = branch_inventories.branch_id AND transfer_requests.tenant_id = branch_inventories.tenant_id
+				AND transfer_requests.status = 'in_transit' AND transfer_requests.reason = 'return') AS in_transit`).
+		Order("branch_inventories.id").Scan(&inventories).Error
+	if err != nil {
+		return fmt.Errorf("failed to count open loans per branch: %w", err)
+	}
+	report.BranchInventories += len(inventories)
+	for _, inv := range inventories {
+		expected := max(inv.Copies-inv.OnLoan-inv.InTransit, 0)
+		if inv.Available == expected {
+			continue
+		}
+		d := Discrepancy{TenantID: tenantID, Kind: IssueBranchMismatch, ModelType: "branch_inventories", ModelID: inv.ID, Expected: expected, Actual: inv.Available,
+			Detail: fmt.Sprintf("book %d at branch %d: %d copies, %d on loan, %d returning from another branch, %d available", inv.BookID, inv.BranchID, inv.Copies, inv.OnLoan, inv.InTransit, inv.Available)}
+		if fix {
+			if d.Fixed, err = r.fixCounter(ctx, &BranchInventory{}, "branch_inventories", "copies", inv.ID,
+				"license_pool_id IS NULL AND (branch_id, book_id) = (SELECT branch_id, book_id FROM branch_inventories WHERE id = ?)",
+				"(to_branch_id, book_id) = (SELECT branch_id, book_id FROM branch_inventories WHERE id = ?)"); err != nil {
+				return err
+			}
+		}
+		report.Discrepancies = append(report.Discrepancies, d)
+	}
+
+	var pools []struct {
+		ID         uint
+		BookID     uint
+		Concurrent int
+		Available  int
+		OnLoan     int
+	}
+	err = db.Model(&LicensePool{}).
+		Select("license_pools.id, license_pools.book_id, license_pools.concurrent, license_pools.available, COUNT(book_loans.id) AS on_loan").
+		Joins("LEFT JOIN book_loans ON book_loans.license_pool_id = license_pools.id AND NOT book_loans.returned").
+		Group("license_pools.id").Order("license_pools.id").Scan(&pools).Error
+	if err != nil {
+		return fmt.Errorf("failed to count open digital loans: %w", err)
+	}
+	report.LicensePools += len(pools)
+	for _, p := range pools {
+		expected := max(p.Concurrent-p.OnLoan, 0)
+		if p.Available == expected {
+			continue
+		}
+		d := Discrepancy{TenantID: tenantID, Kind: IssueLicensePoolMismatch, ModelType: "license_pools", ModelID: p.ID, Expected: expected, Actual: p.Available,
+			Detail: fmt.Sprintf("book %d: %d concurrent licenses, %d on loan, %d available", p.BookID, p.Concurrent, p.OnLoan, p.Available)}
+		if fix {
+			if d.Fixed, err = r.fixCounter(ctx, &LicensePool{}, "license_pools", "concurrent", p.ID, "license_pool_id = ?", ""); err != nil {
+				return err
+			}
+		}
+		report.Discrepancies = append(report.Discrepancies, d)
+	}
+	return nil
+}
+
+// fixCounter sets the available column of the book, branch inventory or
+// license pool with the given ID to its total column minus its open loans,
+// found with loanFilter, and, unless transitFilter is empty, minus the
+// copies returned at another branch and not yet back, found with
+// transitFilter. It reports whether the column changed. The row is locked
+// first, so checkouts in flight are counted. An over-lent record stays at
+// zero until loans are returned.
+func (r *Reconciler) fixCounter(ctx context.Context, model interface{}, table, total string, id uint, loanFilter, transitFilter string) (changed bool, err error) {
+	err = r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var row struct {
+			Total     int
+			Available int
+		}
+		err := tx.Model(model).Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select(total+" AS total, available").Where("id = ?", id).Take(&row).Error
+		if err != nil {
+			return fmt.Errorf("failed to lock record %d: %w", id, err)
+		}
+		var onLoan int64
+		if err := tx.Model(&BookLoan{}).Where("NOT returned").Where(loanFilter, id).Count(&onLoan).Error; err != nil {
+			return fmt.Errorf("failed to count open loans: %w", err)
+		}
+		var travelling int64
+		if transitFilter != "" {
+			if err := tx.Model(&TransferRequest{}).
+				Where("status = ? AND reason = ?", TransferInTransit, TransferReturn).Where(transitFilter, id).
+				Select("COALESCE(SUM(quantity), 0)").Scan(&travelling).Error; err != nil {
+				return fmt.Errorf("failed to count copies in transit: %w", err)
+			}
+		}
+		expected := max(row.Total-int(onLoan)-int(travelling), 0)
+		if expected == row.Available {
+			return nil
+		}
+		if err := tx.Model(model).Where("id = ?", id).UpdateColumn("available", expected).Error; err != nil {
+			return fmt.Errorf("failed to update available: %w", err)
+		}
+		changed = true
+		return tx.Create(&AuditLog{
+			Action:    auditActionReconcile,
+			ModelType: table,
+			ModelID:   id,
+			Details:   fmt.Sprintf("available %d -> %d (%s %d, %d on loan, %d in transit)", row.Available, expected, total, row.Total, onLoan, travelling),
+		}).Error
+	})
+	return changed, err
+}
+
+// closeOrphanedLoan marks an open loan of a missing book or member as
+// returned, without the hooks that would put a copy back.
+func (r *Reconciler) closeOrphanedLoan(ctx context.Context, id uint, detail string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		res := tx.Model(&BookLoan{}).Where("id = ? AND NOT returned", id).
+			UpdateColumns(map[string]interface{}{"returned": true, "returned_at": now})
+		if res.Error != nil {
+			return fmt.Errorf("failed to close loan %d: %w", id, res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+		return tx.Create(&AuditLog{Action: auditActionReconcile, ModelType: "book_loans", ModelID: id, Details: "closed " + detail}).Error
+	})
+}
+
+// reconcileJoins finds book_authors and book_categories rows whose book,
+// author or category is missing and, when fix is set, deletes them. The
+// join tables have no tenant column, so an author or category of another
+// tenant than the book's counts as missing.
+func (r *Reconciler) reconcileJoins(ctx context.Context, report *ReconcileReport, fix bool) error {
+	joins := []struct{ table, column, target string }{
+		{"book_authors", "author_id", "authors"},
+		{"book_categories", "category_id", "categories"},
+	}
+	db := r.db.WithContext(ctx)
+	for _, j := range joins {
+		var rows []struct {
+			BookID  uint
+			OtherID uint
+		}
+		err := db.Raw(fmt.Sprintf(`SELECT j.book_id, j.%[2]s AS other_id FROM %[1]s j
+			LEFT JOIN books ON books.id = j.book_id
+			WHERE books.id IS NULL
+			   OR NOT EXISTS (SELECT 1 FROM %[3]s t WHERE t.id = j.%[2]s AND t.tenant_id = books.tenant_id)
+			ORDER BY j.book_id, j.%[2]s`, j.table, j.column, j.target)).Scan(&rows).Error
+		if err != nil {
+			return fmt.Errorf("failed to find orphaned %s rows: %w", j.table, err)
+		}
+		for _, row := range rows {
+			d := Discrepancy{Kind: IssueOrphanedJoin, ModelType: j.table, ModelID: row.BookID,
+				Detail: fmt.Sprintf("book %d linked to %s %d, one of which does not exist in the book's tenant", row.BookID, j.column, row.OtherID)}
+			if fix {
+				err := db.Transaction(func(tx *gorm.DB) error {
+					if err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE book_id = ? AND %s = ?", j.table, j.column), row.BookID, row.OtherID).Error; err != nil {
+						return fmt.Errorf("failed to delete %s row: %w", j.table, err)
+					}
+					return tx.Create(&AuditLog{Action: auditActionReconcile, ModelType: j.table, ModelID: row.BookID, Details: "deleted " + d.Detail}).Error
+				})
+				if err != nil {
+					return err
+				}
+				d.Fixed = true
+			}
+			report.Discrepancies = append(report.Discrepancies, d)
+		}
+	}
+	return nil
+}
+
+// runReconcileCommand implements "reconcile": it reports discrepancies of
+// every tenant, or of the one named by -tenant, and repairs them with
+// -fix, evicting the fixed books from the cache configured through
+// CACHE_BACKEND. Without -fix, finding any is an error, so the command can
+// gate scripts.
+func runReconcileCommand(ctx context.Context, db *gorm.DB, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fix := fs.Bool("fix", false, "repair the discrepancies found and record them in the audit log")
+	tenant := fs.String("tenant", "", "slug of the tenant to check (all tenants if empty)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	r := &Reconciler{db: db}
+	if *fix {
+		cache, err := bookCacheFromEnv()
+		if err != nil {
+			return err
+		}
+		r.cache = cache
+	}
+	var report *ReconcileReport
+	var err error
+	if *tenant != "" {
+		id, resolveErr := (&TenantService{db: db}).ResolveSlug(ctx, *tenant)
+		if resolveErr != nil {
+			return resolveErr
+		}
+		report, err = r.ReconcileTenant(withTenant(ctx, id), *fix)
+	} else {
+		report, err = r.Reconcile(ctx, *fix)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	if len(report.Discrepancies) > 0 {
+		fmt.Fprintln(tw, "TENANT\tISSUE\tRECORD\tEXPECTED\tACTUAL\tFIXED\tDETAIL")
+		for _, d := range report.Discrepancies {
+			fmt.Fprintf(tw, "%d\t%s\t%s/%d\t%d\t%d\t%t\t%s\n", d.TenantID, d.Kind, d.ModelType, d.ModelID, d.Expected, d.Actual, d.Fixed, d.Detail)
+		}
+	}
+	tw.Flush()
+	fmt.Fprintf(out, "checked %d books, %d branch inventories and %d license pools: %d discrepancies, %d fixed\n",
+		report.Books, report.BranchInventories, report.LicensePools, len(report.Discrepancies), report.Fixed())
+	if err != nil {
+		return err
+	}
+	if !*fix && len(report.Discrepancies) > 0 {
+		return fmt.Errorf("found %d discrepancies; rerun with -fix to repair them", len(report.Discrepancies))
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRunReconcileCommand_Flags tests that unknown flags are rejected before
+// touching the database.
+func TestRunReconcileCommand_Flags(t *testing.T) {
+	var out bytes.Buffer
+	if err := runReconcileCommand(context.Background(), nil, []string{"-repair"}, &out); err == nil {
+		t.Errorf("expected an error for an unknown flag")
+	}
+}
+
+// TestReconciler tests that drifted counters and orphaned loans are
+// reported, fixed with audit entries and gone on the next run.
+func TestReconciler(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenant := mustCreateTenant(t, &TenantService{db: db}, "reconcile")
+	ctx := withTenant(context.Background(), tenant.ID)
+	tdb := db.WithContext(ctx)
+
+	healthy := &Book{Copies: 2}
+	mustCreateBook(t, tdb, healthy)
+	mustCreateLoan(t, tdb, &BookLoan{BookID: healthy.ID})
+
+	drifted := &Book{Copies: 3}
+	mustCreateBook(t, tdb, drifted)
+	tdb.Model(drifted).UpdateColumn("available", 5)
+
+	overLent := &Book{Copies: 2}
+	mustCreateBook(t, tdb, overLent)
+	mustCreateLoan(t, tdb, &BookLoan{BookID: overLent.ID})
+	mustCreateLoan(t, tdb, &BookLoan{BookID: overLent.ID})
+	if err := (&BookService{db: db}).UpdateBookCopies(withTenant(asRole(RoleLibrarian), tenant.ID), overLent.ISBN, 1); err != nil {
+		t.Fatalf("UpdateBookCopies: %v", err)
+	}
+
+	orphanBook := &Book{Copies: 1}
+	mustCreateBook(t, tdb, orphanBook)
+	orphan := mustCreateLoan(t, tdb, &BookLoan{BookID: orphanBook.ID, MemberID: 999999})
+
+	// Loans without a member are staff checkouts, not orphans.
+	staffBook := &Book{Copies: 1}
+	mustCreateBook(t, tdb, staffBook)
+	staffLoan := mustCreateLoan(t, tdb, &BookLoan{BookID: staffBook.ID})
+	tdb.Model(staffLoan).UpdateColumn("member_id", 0)
+
+	pool := mustCreateLicensePool(t, tdb, &LicensePool{Concurrent: 3})
+	tdb.Model(pool).UpdateColumn("available", 1)
+
+	// The healthy book's loan was not lent at the branch, so both of the
+	// branch's copies should be on its shelf.
+	branch := mustCreateBranch(t, tdb, "reconcile")
+	inv := &BranchInventory{BranchID: branch.ID, BookID: healthy.ID, Copies: 2, Available: 1}
+	if err := tdb.Create(inv).Error; err != nil {
+		t.Fatalf("failed to create branch inventory: %v", err)
+	}
+
+	cache := newLRUCache(10)
+	r := &Reconciler{db: db, cache: &bookCache{cache: cache, ttl: time.Minute}}
+	cache.Set(ctx, bookKey(ctx, drifted.ISBN), []byte(`{}`), time.Minute)
+	report, err := r.ReconcileTenant(ctx, false)
+	if err != nil {
+		t.Fatalf("ReconcileTenant: %v", err)
+	}
+	checks := []struct {
+		kind             string
+		id               uint
+		expected, actual int
+	}{
+		{IssueOrphanedLoan, orphan.ID, 0, 0},
+		{IssueAvailableAboveCopies, drifted.ID, 3, 5},
+		{IssueOverLent, overLent.ID, 0, 0},
+		{IssueBranchMismatch, inv.ID, 2, 1},
+		{IssueLicensePoolMismatch, pool.ID, 3, 1},
+	}
+	if len(report.Discrepancies) != len(checks) {
+		t.Fatalf("expected %d discrepancies, got %+v", len(checks), report.Discrepancies)
+	}
+	for i, c := range checks {
+		d := report.Discrepancies[i]
+		if d.Kind != c.kind || d.ModelID != c.id || d.Expected != c.expected || d.Actual != c.actual || d.Fixed {
+			t.Errorf("discrepancy %d = %+v, want %s of record %d, expected %d, actual %d", i, d, c.kind, c.id, c.expected, c.actual)
+		}
+	}
+
+	// Closing the orphaned loan frees its copy, which the same run then
+	// puts back on the shelf. The over-lent book cannot be repaired.
+	report, err = r.ReconcileTenant(ctx, true)
+	if err != nil {
+		t.Fatalf("ReconcileTenant with fix: %v", err)
+	}
+	if len(report.Discrepancies) != 6 || report.Fixed() != 5 {
+		t.Errorf("expected 6 discrepancies with 5 fixed, got %+v", report.Discrepancies)
+	}
+	if _, ok, _ := cache.Get(ctx, bookKey(ctx, drifted.ISBN)); ok {
+		t.Errorf("expected the fixed book to be evicted from the cache")
+	}
+	tdb.First(inv, inv.ID)
+	if inv.Available != 2 {
+		t.Errorf("branch inventory: %d available after the fix, want 2", inv.Available)
+	}
+	for _, want := range []struct {
+		id        uint
+		available int
+	}{{drifted.ID, 3}, {orphanBook.ID, 1}, {overLent.ID, 0}, {healthy.ID, 1}} {
+		var book Book
+		tdb.First(&book, want.id)
+		if book.Available != want.available {
+			t.Errorf("book %d: %d available after the fix, want %d", want.id, book.Available, want.available)
+		}
+	}
+	var loan BookLoan
+	tdb.First(&loan, orphan.ID)
+	if !loan.Returned || loan.ReturnedAt == nil {
+		t.Errorf("expected the orphaned loan to be closed")
+	}
+	tdb.First(&loan, staffLoan.ID)
+	if loan.Returned {
+		t.Errorf("expected the staff checkout to stay open")
+	}
+	var audits []AuditLog
+	tdb.Where("action = ?", auditActionReconcile).Order("id").Find(&audits)
+	if len(audits) != 5 {
+		t.Fatalf("expected an audit entry per fix, got %d", len(audits))
+	}
+	if a := audits[0]; a.TenantID != tenant.ID || a.ModelType != "book_loans" || a.ModelID != orphan.ID || !strings.HasPrefix(a.Details, "closed") {
+		t.Errorf("unexpected audit entry %+v", a)
+	}
+
+	report, err = r.ReconcileTenant(ctx, false)
+	if err != nil || len(report.Discrepancies) != 1 || report.Discrepancies[0].Kind != IssueOverLent {
+		t.Errorf("only the over-lent book should remain until a loan is returned, got %+v, %v", report.Discrepancies, err)
+	}
+}
+
+// TestReconciler_Joins tests that associations with a missing author or
+// one of another tenant are reported and deleted.
+func TestReconciler_Joins(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenants := &TenantService{db: db}
+	a := mustCreateTenant(t, tenants, "joins-a")
+	b := mustCreateTenant(t, tenants, "joins-b")
+	dbA := db.WithContext(withTenant(context.Background(), a.ID))
+	dbB := db.WithContext(withTenant(context.Background(), b.ID))
+
+	own := mustCreateAuthor(t, dbA, &Author{})
+	foreign := mustCreateAuthor(t, dbB, &Author{})
+	book := &Book{Authors: []Author{*own}}
+	mustCreateBook(t, dbA, book)
+	// The services refuse such links, so write the row directly.
+	if err := db.Exec("INSERT INTO book_authors (book_id, author_id) VALUES (?, ?)", book.ID, foreign.ID).Error; err != nil {
+		t.Fatalf("failed to link foreign author: %v", err)
+	}
+
+	r := &Reconciler{db: db}
+	report, err := r.Reconcile(context.Background(), true)
+	if err != nil {
+		t.Fatalf("Reconcile: %v", err)
+	}
+	var found bool
+	for _, d := range report.Discrepancies {
+		if d.Kind == IssueOrphanedJoin && d.ModelID == book.ID {
+			found = true
+			if !d.Fixed || !strings.Contains(d.Detail, fmt.Sprintf("author_id %d", foreign.ID)) {
+				t.Errorf("unexpected discrepancy %+v", d)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("expected the foreign author link to be reported, got %+v", report.Discrepancies)
+	}
+	var authors []uint
+	db.Table("book_authors").Where("book_id = ?", book.ID).Pluck("author_id", &authors)
+	if len(authors) != 1 || authors[0] != own.ID {
+		t.Errorf("expected only the own author linked, got %v", authors)
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrReportNotFound is returned for an unknown report name.
+var ErrReportNotFound = errors.New("report not found")
+
+// defaultReportLimit caps ranked reports when the caller sets no limit.
+const defaultReportLimit = 10
+
+// ReportRange selects loans whose LoanDate is in [From, To).
+type ReportRange struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+}
+
+// validate checks that the range is not empty.
+func (r ReportRange) validate() error {
+	if !r.From.Before(r.To) {
+		return fmt.Errorf("report range start must be before its end")
+	}
+	return nil
+}
+
+// BookLoanCount is the number of loans of one book.
+type BookLoanCount struct {
+	BookID uint   `json:"book_id"`
+	ISBN   string `json:"isbn"`
+	Title  string `json:"title"`
+	Loans  int64  `json:"loans"`
+}
+
+// MonthLoanCount is the number of loans made in one month ("2006-01").
+type MonthLoanCount struct {
+	Month string `json:"month"`
+	Loans int64  `json:"loans"`
+}
+
+// LoanDurationStats summarises how long returned loans were kept.
+type LoanDurationStats struct {
+	ReturnedLoans int64   `json:"returned_loans"`
+	AverageDays   float64 `json:"average_days"`
+}
+
+// BookTurnover is the number of loans per copy of one book.
+type BookTurnover struct {
+	BookID   uint    `json:"book_id"`
+	ISBN     string  `json:"isbn"`
+	Title    string  `json:"title"`
+	Copies   int     `json:"copies"`
+	Loans    int64   `json:"loans"`
+	Turnover float64 `json:"turnover"`
+}
+
+// CategoryLoanCount is the number of loans of books in one category.
+type CategoryLoanCount struct {
+	CategoryID uint   `json:"category_id"`
+	Name       string `json:"name"`
+	Loans      int64  `json:"loans"`
+}
+
+// ReportService computes circulation statistics from loans and books.
+// Every report requires PermViewReports and is limited to the tenant of ctx.
+type ReportService struct {
+	db           *gorm.DB
+	queryTimeout time.Duration
+}
+
+// begin authorizes the caller, validates r and applies the query timeout.
+func (s *ReportService) begin(ctx context.Context, r ReportRange) (*gorm.DB, context.CancelFunc, error) {
+	if _, err := authorize(ctx, PermViewReports); err != nil {
+		return nil, nil, err
+	}
+	if err := r.validate(); err != nil {
+		return nil, nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	return s.db.WithContext(ctx), cancel, nil
+}
+
+// loansIn returns a query over the loans made within r.
+func loansIn(db *gorm.DB, r ReportRange) *gorm.DB {
+	return db.Model(&BookLoan{}).Where("book_loans.loan_date >= ? AND book_loans.loan_date < ?", r.From, r.To)
+}
+
+// MostBorrowed returns up to limit books with the most loans in r.
+func (s *ReportService) MostBorrowed(ctx context.Context, r ReportRange, limit int) (_ []BookLoanCount, err error) {
+	defer observeOperation("report_most_borrowed", time.Now(), &err)
+	db, cancel, err := s.begin(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	if limit <= 0 {
+		limit = defaultReportLimit
+	}
+
+	var rows []BookLoanCount
+	err = loansIn(db, r).
+		Select("books.id AS book_id, books.isbn, books.title, COUNT(*) AS loans").
+		Joins("JOIN books ON books.id = book_loans.book_id").
+		Group("books.id, books.isbn, books.title").
+		Order("loans DESC, books.title").
+		Limit(limit).
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute most borrowed books: %w", err)
+	}
+	return rows, nil
+}
+
+// LoansPerMonth returns the number of loans made in each month of r that
+// had any loans, in chronological order.
+func (s *ReportService) LoansPerMonth(ctx context.Context, r ReportRange) (_ []MonthLoanCount, err error) {
+	defer observeOperation("report_loans_per_month", time.Now(), &err)
+	db, cancel, err := s.begin(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	var rows []MonthLoanCount
+	err = loansIn(db, r).
+		Select("to_char(date_trunc('month', book_loans.loan_date), 'YYYY-MM') AS month, COUNT(*) AS loans").
+		Group("month").
+		Order("month").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute loans per month: %w", err)
+	}
+	return rows, nil
+}
+
+// AverageLoanDuration returns how long, on average, loans made in r were
+// kept. Loans not yet returned are left out.
+func (s *ReportService) AverageLoanDuration(ctx context.Context, r ReportRange) (_ *LoanDurationStats, err error) {
+	defer observeOperation("report_average_loan_duration", time.Now(), &err)
+	db, cancel, err := s.begin(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	var stats LoanDurationStats
+	err = loansIn(db, r).
+		Select("COUNT(*) AS returned_loans, COALESCE(AVG(EXTRACT(EPOCH FROM book_loans.returned_at - book_loans.loan_date)) / 86400, 0) AS average_days").
+		Where("book_loans.returned_at IS NOT NULL").
+		Scan(&stats).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute average loan duration: %w", err)
+	}
+	return &stats, nil
+}
+
+// Turnover returns the loans per copy of every book in r, highest first.
+func (s *ReportService) Turnover(ctx context.Context, r ReportRange) (_ []BookTurnover, err error) {
+	defer observeOperation("report_turnover", time.Now(), &err)
+	db, cancel, err := s.begin(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	var rows []BookTurnover
+	err = db.Model(&Book{}).
+		Select("books.id AS book_id, books.isbn, books.title, books.copies, COUNT(l.id) AS loans").
+		Joins("LEFT JOIN book_loans l ON l.book_id = books.id AND l.loan_date >= ? AND l.loan_date < ?", r.From, r.To).
+		Group("books.id, books.isbn, books.title, books.copies").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute turnover: %w", err)
+	}
+	for i := range rows {
+		if rows[i].Copies > 0 {
+			rows[i].Turnover = float64(rows[i].Loans) / float64(rows[i].Copies)
+		}
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		if rows[i].Turnover != rows[j].Turnover {
+			return rows[i].Turnover > rows[j].Turnover
+		}
+		return rows[i].Title < rows[j].Title
+	})
+	return rows, nil
+}
+
+// NeverBorrowed returns the books with no loans made in r.
+func (s *ReportService) NeverBorrowed(ctx context.Context, r ReportRange) (_ []BookLoanCount, err error) {
+	defer observeOperation("report_never_borrowed", time.Now(), &err)
+	db, cancel, err := s.begin(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	var rows []BookLoanCount
+	err = db.Model(&Book{}).
+		Select("books.id AS book_id, books.isbn, books.title, 0 AS loans").
+		Where("NOT EXISTS (SELECT 1 FROM book_loans l WHERE l.book_id = books.id AND l.loan_date >= ? AND l.loan_date < ?)", r.From, r.To).
+		Order("books.title").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find never borrowed books: %w", err)
+	}
+	return rows, nil
+}
+
+// CategoryPopularity returns the number of loans in r of books in each
+// category, most popular first. A book in several categories counts for each.
+func (s *ReportService) CategoryPopularity(ctx context.Context, r ReportRange) (_ []CategoryLoanCount, err error) {
+	defer observeOperation("report_category_popularity", time.Now(), &err)
+	db, cancel, err := s.begin(ctx, r)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	var rows []CategoryLoanCount
+	err = loansIn(db, r).
+		Select("c.id AS category_id, c.name, COUNT(*) AS loans").
+		Joins("JOIN book_categories bc ON bc.book_id = book_loans.book_id").
+		Joins("JOIN categories c ON c.id = bc.category_id").
+		Group("c.id, c.name").
+		Order("loans DESC, c.name").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute category popularity: %w", err)
+	}
+	return rows, nil
+}
+
+// Report runs the report with the given name. limit only applies to
+// ranked reports.
+func (s *ReportService) Report(ctx context.Context, name string, r ReportRange, limit int) (any, error) {
+	switch name {
+	case "most-borrowed":
+		return s.MostBorrowed(ctx, r, limit)
+	case "loans-per-month":
+		return s.LoansPerMonth(ctx, r)
+	case "average-loan-duration":
+		stats, err := s.AverageLoanDuration(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		return []LoanDurationStats{*stats}, nil
+	case "turnover":
+		return s.Turnover(ctx, r)
+	case "never-borrowed":
+		return s.NeverBorrowed(ctx, r)
+	case "category-popularity":
+		return s.CategoryPopularity(ctx, r)
+	case "license-utilization":
+		return s.LicenseUtilization(ctx, r)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrReportNotFound, name)
+	}
+}
+
+// writeCSV writes rows, a slice of structs, as CSV with a header row made
+// of the struct fields' JSON names.
+func writeCSV(w io.Writer, rows any) error {
+	v := reflect.ValueOf(rows)
+	if v.Kind() != reflect.Slice || v.Type().Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("cannot write %T as CSV", rows)
+	}
+	typ := v.Type().Elem()
+	cw := csv.NewWriter(w)
+
+	header := make([]string, typ.NumField())
+	for i := range header {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		if name == "" {
+			name = typ.Field(i).Name
+		}
+		header[i] = name
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	record := make([]string, typ.NumField())
+	for i := 0; i < v.Len(); i++ {
+		row := v.Index(i)
+		for j := range record {
+			switch f := row.Field(j); f.Kind() {
+			case reflect.Float32, reflect.Float64:
+				record[j] = strconv.FormatFloat(f.Float(), 'f', 2, 64)
+			default:
+				record[j] = fmt.Sprint(f.Interface())
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// parseReportRange reads the from and to query parameters (YYYY-MM-DD,
+// to inclusive). They default to the twelve months up to today.
+func parseReportRange(q map[string][]string) (ReportRange, error) {
+	get := func(k string) string {
+		if v := q[k]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	r := ReportRange{From: today.AddDate(-1, 0, 1), To: today.AddDate(0, 0, 1)}
+	if v := get("from"); v != "" {
+		from, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			return r, fmt.Errorf("invalid from date %q", v)
+		}
+		r.From = from
+	}
+	if v := get("to"); v != "" {
+		to, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			return r, fmt.Errorf("invalid to date %q", v)
+		}
+		r.To = to.AddDate(0, 0, 1)
+	}
+	return r, r.validate()
+}
+
+// handleReport serves GET /reports/{name}?from=&to=&limit=&format=csv|json.
+func (s *apiServer) handleReport(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	rng, err := parseReportRange(q)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	name := r.PathValue("name")
+
+	rows, err := s.reports.Report(r.Context(), name, rng, limit)
+	if err != nil {
+		if errors.Is(err, ErrReportNotFound) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		writeError(w, err)
+		return
+	}
+
+	if q.Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, name))
+		_ = writeCSV(w, rows)
+		return
+	}
+	writeJSON(w, http.StatusOK, struct {
+		Report string      `json:"report"`
+		Range  ReportRange `json:"range"`
+		Rows   any         `json:"rows"`
+	}{name, rng, rows})
+}
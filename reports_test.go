@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// TestWriteCSV tests CSV output of report rows.
+func TestWriteCSV(t *testing.T) {
+	var buf bytes.Buffer
+	rows := []BookTurnover{{BookID: 1, ISBN: "9780000000001", Title: "A, B", Copies: 3, Loans: 1, Turnover: 1.0 / 3}}
+	if err := writeCSV(&buf, rows); err != nil {
+		t.Fatalf("writeCSV: %v", err)
+	}
+	want := "book_id,isbn,title,copies,loans,turnover\n1,9780000000001,\"A, B\",3,1,0.33\n"
+	if buf.String() != want {
+		t.Errorf("got %q want %q", buf.String(), want)
+	}
+	if err := writeCSV(&buf, 42); err == nil {
+		t.Errorf("expected error for non-slice rows")
+	}
+}
+
+// TestParseReportRange tests the from/to query parameters.
+func TestParseReportRange(t *testing.T) {
+	r, err := parseReportRange(url.Values{"from": {"2024-01-01"}, "to": {"2024-01-31"}})
+	if err != nil {
+		t.Fatalf("parseReportRange: %v", err)
+	}
+	if !r.From.Equal(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)) || !r.To.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected range %+v", r)
+	}
+	if _, err := parseReportRange(url.Values{"from": {"2024-02-01"}, "to": {"2024-01-01"}}); err == nil {
+		t.Errorf("expected error for reversed range")
+	}
+	if _, err := parseReportRange(url.Values{"from": {"yesterday"}}); err == nil {
+		t.Errorf("expected error for invalid date")
+	}
+}
+
+// TestReportService_CirculationReports tests every report against a small tenant.
+func TestReportService_CirculationReports(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenant := mustCreateTenant(t, &TenantService{db: db}, "reports")
+	ctx := withTenant(asRole(RoleLibrarian), tenant.ID)
+	tdb := db.WithContext(ctx)
+	svc := &ReportService{db: db}
+
+	fiction := Category{Name: "Fiction"}
+	alpha := &Book{ISBN: "9782222222221", Title: "Alpha", Copies: 2, Categories: []Category{fiction}}
+	beta := &Book{ISBN: "9782222222222", Title: "Beta", Copies: 1}
+	gamma := &Book{ISBN: "9782222222223", Title: "Gamma", Copies: 1}
+	for _, b := range []*Book{alpha, beta, gamma} {
+		mustCreateBook(t, tdb, b)
+	}
+
+	day := func(m time.Month, d int) time.Time { return time.Date(2024, m, d, 12, 0, 0, 0, time.UTC) }
+	ret := func(tm time.Time) *time.Time { return &tm }
+	loans := []BookLoan{
+		{BookID: alpha.ID, MemberID: 1, LoanDate: day(1, 10), DueDate: day(1, 20), Returned: true, ReturnedAt: ret(day(1, 14))},
+		{BookID: alpha.ID, MemberID: 2, LoanDate: day(1, 15), DueDate: day(1, 25), Returned: true, ReturnedAt: ret(day(1, 21))},
+		{BookID: beta.ID, MemberID: 1, LoanDate: day(2, 3), DueDate: day(2, 13)},
+	}
+	for i := range loans {
+		if err := tdb.Create(&loans[i]).Error; err != nil {
+			t.Fatalf("failed to create loan: %v", err)
+		}
+	}
+	r := ReportRange{From: day(1, 1).Truncate(24 * time.Hour), To: day(3, 1).Truncate(24 * time.Hour)}
+
+	most, err := svc.MostBorrowed(ctx, r, 1)
+	if err != nil || len(most) != 1 || most[0].Title != "Alpha" || most[0].Loans != 2 {
+		t.Errorf("MostBorrowed: got %+v, %v", most, err)
+	}
+
+	months, err := svc.LoansPerMonth(ctx, r)
+	if err != nil || len(months) != 2 || months[0] != (MonthLoanCount{"2024-01", 2}) || months[1] != (MonthLoanCount{"2024-02", 1}) {
+		t.Errorf("LoansPerMonth: got %+v, %v", months, err)
+	}
+
+	stats, err := svc.AverageLoanDuration(ctx, r)
+	if err != nil || stats.ReturnedLoans != 2 || stats.AverageDays != 5 {
+		t.Errorf("AverageLoanDuration: got %+v, %v", stats, err)
+	}
+
+	turnover, err := svc.Turnover(ctx, r)
+	if err != nil || len(turnover) != 3 || turnover[0].Title != "Alpha" || turnover[0].Turnover != 1 || turnover[2].Title != "Gamma" {
+		t.Errorf("Turnover: got %+v, %v", turnover, err)
+	}
+
+	never, err := svc.NeverBorrowed(ctx, r)
+	if err != nil || len(never) != 1 || never[0].Title != "Gamma" {
+		t.Errorf("NeverBorrowed: got %+v, %v", never, err)
+	}
+
+	cats, err := svc.CategoryPopularity(ctx, r)
+	if err != nil || len(cats) != 1 || cats[0].Name != "Fiction" || cats[0].Loans != 2 {
+		t.Errorf("CategoryPopularity: got %+v, %v", cats, err)
+	}
+
+	if _, err := svc.Report(ctx, "unknown", r, 0); !errors.Is(err, ErrReportNotFound) {
+		t.Errorf("expected ErrReportNotFound, got %v", err)
+	}
+	if _, err := svc.MostBorrowed(withTenant(asRole(RoleMember), tenant.ID), r, 0); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected members to be forbidden, got %v", err)
+	}
+}
@@ -0,0 +1,418 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrJobLocked is returned when another instance is already running a job.
+var ErrJobLocked = errors.New("job is already running")
+
+// JobStatus is the outcome of a job run.
+type JobStatus string
+
+// Job run statuses.
+const (
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+)
+
+// Job run triggers.
+const (
+	triggerSchedule = "schedule"
+	triggerManual   = "manual"
+)
+
+// jobRunRetention is how long job run history is kept by the prune-job-runs job.
+const jobRunRetention = 90 * 24 * time.Hour
+
+// cronDescriptors maps the supported shorthand schedules to cron expressions.
+var cronDescriptors = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+// cronSchedule is a parsed five-field cron expression (minute, hour, day of
+// month, month, day of week). Each field is a bit set of allowed values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	domAny, dowAny                bool
+}
+
+// parseCron parses a cron expression such as "*/15 8-18 * * 1-5" or one of
+// the @hourly, @daily, @weekly, @monthly and @yearly shorthands.
+func parseCron(expr string) (*cronSchedule, error) {
+	if d, ok := cronDescriptors[expr]; ok {
+		expr = d
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields", expr)
+	}
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, err
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, err
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, err
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, err
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, err
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1 // 7 is Sunday too
+	}
+	s.domAny = fields[2] == "*"
+	s.dowAny = fields[4] == "*"
+	return &s, nil
+}
+
+// parseCronField parses a comma-separated list of "*", "n", "a-b" items,
+// each optionally followed by "/step", into a bit set.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(item, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepStr)
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in cron field %q", field)
+			}
+			step = n
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			a, b, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(a); err != nil {
+				return 0, fmt.Errorf("invalid value in cron field %q", field)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(b); err != nil {
+					return 0, fmt.Errorf("invalid range in cron field %q", field)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("cron field %q out of range %d-%d", field, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// matchesDay reports whether t falls on an allowed day. As in cron, when
+// both day of month and day of week are restricted either may match.
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// Next returns the first time after t matching the schedule, or the zero
+// time if none exists within five years.
+func (c *cronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case c.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case c.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case c.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Job is a named unit of periodic maintenance work.
+type Job struct {
+	Name     string
+	Schedule string
+	Run      func(ctx context.Context) error
+	schedule *cronSchedule
+}
+
+// JobRun records one execution of a job. Scheduled runs are unique per job
+// and scheduled time, so that a run missed by one instance is not repeated
+// by another; manual runs have no scheduled time.
+type JobRun struct {
+	ID           uint       `gorm:"primaryKey"`
+	Job          string     `gorm:"uniqueIndex:idx_job_runs_schedule;size:100;not null"`
+	ScheduledFor *time.Time `gorm:"uniqueIndex:idx_job_runs_schedule"`
+	Trigger      string     `gorm:"size:20;not null"`
+	Status       JobStatus  `gorm:"type:varchar(20);not null"`
+	Error        string
+	StartedAt    time.Time `gorm:"index"`
+	FinishedAt   *time.Time
+}
+
+// Scheduler runs registered jobs on their cron schedules. Each run holds a
+// Postgres advisory lock for the job, so only one instance of a deployment
+// runs a given job at a time.
+type Scheduler struct {
+	db   *gorm.DB
+	jobs map[string]*Job
+}
+
+// newScheduler returns a scheduler without jobs.
+func newScheduler(db *gorm.DB) *Scheduler {
+	return &Scheduler{db: db, jobs: map[string]*Job{}}
+}
+
+// Register adds a job. The schedule may be overridden with the
+// JOB_SCHEDULE_<NAME> environment variable, e.g. JOB_SCHEDULE_MARK_OVERDUE.
+func (s *Scheduler) Register(name, schedule string, run func(ctx context.Context) error) error {
+	envKey := "JOB_SCHEDULE_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	if v := os.Getenv(envKey); v != "" {
+		schedule = v
+	}
+	cron, err := parseCron(schedule)
+	if err != nil {
+		return fmt.Errorf("job %s: %w", name, err)
+	}
+	if _, exists := s.jobs[name]; exists {
+		return fmt.Errorf("job %s already registered", name)
+	}
+	s.jobs[name] = &Job{Name: name, Schedule: schedule, Run: run, schedule: cron}
+	return nil
+}
+
+// Jobs returns the registered jobs sorted by name.
+func (s *Scheduler) Jobs() []*Job {
+	jobs := make([]*Job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Name < jobs[k].Name })
+	return jobs
+}
+
+// Start runs every job on its schedule until ctx is done.
+func (s *Scheduler) Start(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, job := range s.jobs {
+		wg.Add(1)
+		go func(job *Job) {
+			defer wg.Done()
+			s.loop(ctx, job)
+		}(job)
+	}
+	wg.Wait()
+}
+
+// loop waits for each scheduled time of job and runs it.
+func (s *Scheduler) loop(ctx context.Context, job *Job) {
+	for {
+		next := job.schedule.Next(time.Now())
+		if next.IsZero() {
+			slog.ErrorContext(ctx, "job schedule never fires", "job", job.Name, "schedule", job.Schedule)
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if _, err := s.run(ctx, job, &next, triggerSchedule); err != nil && !errors.Is(err, ErrJobLocked) {
+			slog.ErrorContext(ctx, "scheduled job failed", "job", job.Name, "error", err)
+		}
+	}
+}
+
+// RunJob runs the named job immediately and returns its run record.
+func (s *Scheduler) RunJob(ctx context.Context, name string) (*JobRun, error) {
+	job, ok := s.jobs[name]
+	if !ok {
+		return nil, fmt.Errorf("job %s not found", name)
+	}
+	return s.run(ctx, job, nil, triggerManual)
+}
+
+// jobLockKey derives the advisory lock key of a job from its name.
+func jobLockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("library:job:" + name))
+	return int64(h.Sum64())
+}
+
+// run executes job while holding its advisory lock and records the run.
+// It returns ErrJobLocked if another session holds the lock, and a nil run
+// if the scheduled time was already handled by another instance.
+func (s *Scheduler) run(ctx context.Context, job *Job, scheduledFor *time.Time, trigger string) (_ *JobRun, err error) {
+	defer observeOperation("job_"+strings.ReplaceAll(job.Name, "-", "_"), time.Now(), &err)
+
+	sqlDB, err := s.db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get database instance: %w", err)
+	}
+	// Advisory locks belong to a session, so lock and unlock on one connection.
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	key := jobLockKey(job.Name)
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&locked); err != nil {
+		return nil, fmt.Errorf("failed to acquire job lock: %w", err)
+	}
+	if !locked {
+		return nil, ErrJobLocked
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", key); err != nil {
+			slog.ErrorContext(ctx, "failed to release job lock", "job", job.Name, "error", err)
+		}
+	}()
+
+	db := s.db.WithContext(ctx)
+	jr := &JobRun{Job: job.Name, ScheduledFor: scheduledFor, Trigger: trigger, Status: JobRunning, StartedAt: time.Now()}
+	res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(jr)
+	if res.Error != nil {
+		return nil, fmt.Errorf("failed to record job run: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil
+	}
+
+	jobCtx := withPrincipal(withRequestID(ctx, newRequestID()), systemPrincipal)
+	slog.InfoContext(jobCtx, "job started", "job", job.Name, "trigger", trigger)
+	runErr := runJobFunc(jobCtx, job)
+
+	finished := time.Now()
+	jr.FinishedAt = &finished
+	jr.Status = JobSucceeded
+	if runErr != nil {
+		jr.Status, jr.Error = JobFailed, runErr.Error()
+	}
+	if err := s.db.WithContext(context.WithoutCancel(ctx)).Save(jr).Error; err != nil {
+		return jr, fmt.Errorf("failed to record job result: %w", err)
+	}
+	slog.InfoContext(jobCtx, "job finished", "job", job.Name, "status", jr.Status, "duration", finished.Sub(jr.StartedAt))
+	return jr, runErr
+}
+
+// runJobFunc calls job.Run, turning a panic into an error.
+func runJobFunc(ctx context.Context, job *Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return job.Run(ctx)
+}
+
+// History returns the most recent runs of the named job, newest first.
+func (s *Scheduler) History(ctx context.Context, name string, limit int) ([]JobRun, error) {
+	var runs []JobRun
+	if err := s.db.WithContext(ctx).Where("job = ?", name).Order("started_at DESC").Limit(limit).Find(&runs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list job runs: %w", err)
+	}
+	return runs, nil
+}
+
+// pruneJobRuns deletes job run history older than jobRunRetention.
+func (s *Scheduler) pruneJobRuns(ctx context.Context) error {
+	res := s.db.WithContext(ctx).Where("started_at < ?", time.Now().Add(-jobRunRetention)).Delete(&JobRun{})
+	if res.Error != nil {
+		return fmt.Errorf("failed to prune job runs: %w", res.Error)
+	}
+	slog.InfoContext(ctx, "pruned job runs", "count", res.RowsAffected)
+	return nil
+}
+
+// registerMaintenanceJobs registers the built-in jobs. send-reminders is
+// only registered when notifications has channels configured, and
+// accrue-fines only when loans has a fine policy.
+func registerMaintenanceJobs(s *Scheduler, loans *LoanService, notifications *NotificationService, recommendations *RecommendationService) error {
+	if err := s.Register("mark-overdue", "*/15 * * * *", func(ctx context.Context) error {
+		return forEachTenant(ctx, s.db, func(ctx context.Context) error {
+			n, err := loans.MarkOverdue(ctx, time.Now())
+			if n > 0 {
+				slog.InfoContext(ctx, "loans marked overdue", "count", n)
+			}
+			return err
+		})
+	}); err != nil {
+		return err
+	}
+	if loans.fines.PerDayCents > 0 {
+		if err := s.Register("accrue-fines", "10 0 * * *", func(ctx context.Context) error {
+			return forEachTenant(ctx, s.db, func(ctx context.Context) error {
+				n, err := loans.AccrueFines(ctx, time.Now())
+				if n > 0 {
+					slog.InfoContext(ctx, "fines accrued", "count", n)
+				}
+				return err
+			})
+		}); err != nil {
+			return err
+		}
+	}
+	if notifications != nil && len(notifications.channels) > 0 {
+		if err := s.Register("send-reminders", "@hourly", func(ctx context.Context) error {
+			n, err := notifications.SendReminders(ctx, time.Now())
+			if n > 0 {
+				slog.InfoContext(ctx, "reminders sent", "count", n)
+			}
+			return err
+		}); err != nil {
+			return err
+		}
+	}
+	if err := s.Register("compute-recommendations", "30 3 * * *", func(ctx context.Context) error {
+		return forEachTenant(ctx, s.db, recommendations.Recompute)
+	}); err != nil {
+		return err
+	}
+	return s.Register("prune-job-runs", "@daily", s.pruneJobRuns)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestParseCron_Invalid tests rejection of malformed cron expressions.
+func TestParseCron_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("expected error for %q", expr)
+		}
+	}
+}
+
+// TestCronSchedule_Next tests next fire times for common schedules.
+func TestCronSchedule_Next(t *testing.T) {
+	from := time.Date(2024, 1, 31, 10, 7, 30, 0, time.UTC) // a Wednesday
+	cases := []struct {
+		expr string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, 1, 31, 10, 15, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"30 9 * * 1-5", time.Date(2024, 2, 1, 9, 30, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2024, 2, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 12 29 2 *", time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC)},
+		{"0 0 13 * 5", time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC)},
+		{"0,30 8 1,15 * *", time.Date(2024, 2, 1, 8, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		s, err := parseCron(c.expr)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", c.expr, err)
+		}
+		if got := s.Next(from); !got.Equal(c.want) {
+			t.Errorf("%q: got %v want %v", c.expr, got, c.want)
+		}
+	}
+
+	s, _ := parseCron("0 0 30 2 *")
+	if got := s.Next(from); !got.IsZero() {
+		t.Errorf("February 30th should never fire, got %v", got)
+	}
+}
+
+// TestScheduler_RunJob tests manual runs, run history and the job lock.
+func TestScheduler_RunJob(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	s := newScheduler(db)
+	ctx := context.Background()
+
+	name := "test-job-" + time.Now().Format("150405.000000")
+	calls := 0
+	if err := s.Register(name, "@daily", func(ctx context.Context) error {
+		calls++
+		if calls == 2 {
+			return errors.New("boom")
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	if run, err := s.RunJob(ctx, name); err != nil || run.Status != JobSucceeded || run.FinishedAt == nil {
+		t.Fatalf("first run: got %+v, %v", run, err)
+	}
+	if run, err := s.RunJob(ctx, name); err == nil || run.Status != JobFailed || run.Error != "boom" {
+		t.Fatalf("second run should fail: got %+v, %v", run, err)
+	}
+
+	// Hold the job lock from another session, as a second instance would.
+	sqlDB, _ := db.DB()
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		t.Fatalf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", jobLockKey(name)); err != nil {
+		t.Fatalf("failed to take lock: %v", err)
+	}
+	if _, err := s.RunJob(ctx, name); !errors.Is(err, ErrJobLocked) {
+		t.Errorf("expected ErrJobLocked, got %v", err)
+	}
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", jobLockKey(name)); err != nil {
+		t.Fatalf("failed to release lock: %v", err)
+	}
+
+	runs, err := s.History(ctx, name, 10)
+	if err != nil || len(runs) != 2 || calls != 2 {
+		t.Errorf("expected 2 recorded runs and 2 calls, got %d runs, %d calls, err %v", len(runs), calls, err)
+	}
+
+	var out bytes.Buffer
+	if err := runCommand(ctx, db, s, []string{"job", "history", name}, &out); err != nil || !strings.Contains(out.String(), "boom") {
+		t.Errorf("job history output %q, err %v", out.String(), err)
+	}
+}
+
+// TestScheduler_ScheduledRunOnce tests that a scheduled time is only run once across instances.
+func TestScheduler_ScheduledRunOnce(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+	name := "test-once-" + time.Now().Format("150405.000000")
+
+	calls := 0
+	a, b := newScheduler(db), newScheduler(db)
+	for _, s := range []*Scheduler{a, b} {
+		if err := s.Register(name, "* * * * *", func(ctx context.Context) error { calls++; return nil }); err != nil {
+			t.Fatalf("Register: %v", err)
+		}
+	}
+
+	at := time.Now().Truncate(time.Minute)
+	if run, err := a.run(ctx, a.jobs[name], &at, triggerSchedule); err != nil || run == nil {
+		t.Fatalf("instance a: got %+v, %v", run, err)
+	}
+	if run, err := b.run(ctx, b.jobs[name], &at, triggerSchedule); err != nil || run != nil {
+		t.Fatalf("instance b should skip the handled time: got %+v, %v", run, err)
+	}
+	if calls != 1 {
+		t.Errorf("expected the job to run once, ran %d times", calls)
+	}
+}
+
+// TestLoanService_MarkOverdue tests flagging of overdue loans.
+func TestLoanService_MarkOverdue(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	svc := &LoanService{db: db}
+
+	book := &Book{ISBN: "9782121212121", Title: "Late", Copies: 2}
+	mustCreateBook(t, db, book)
+	now := time.Now()
+	late := BookLoan{BookID: book.ID, MemberID: 1, LoanDate: now.Add(-10 * 24 * time.Hour), DueDate: now.Add(-24 * time.Hour)}
+	onTime := BookLoan{BookID: book.ID, MemberID: 1, LoanDate: now, DueDate: now.Add(24 * time.Hour)}
+	for _, l := range []*BookLoan{&late, &onTime} {
+		if err := db.Create(l).Error; err != nil {
+			t.Fatalf("failed to create loan: %v", err)
+		}
+	}
+
+	if _, err := svc.MarkOverdue(asRole(RoleMember), now); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected members to be forbidden, got %v", err)
+	}
+	if _, err := svc.MarkOverdue(asRole(RoleLibrarian), now); err != nil {
+		t.Fatalf("MarkOverdue: %v", err)
+	}
+	var got BookLoan
+	db.First(&got, late.ID)
+	if !got.Overdue {
+		t.Errorf("late loan should be flagged overdue")
+	}
+	db.First(&got, onTime.ID)
+	if got.Overdue {
+		t.Errorf("loan due tomorrow should not be flagged overdue")
+	}
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// SeedConfig sets the size and random seed of a generated dataset. The
+// same seed and sizes produce the same data; dates are relative to Now.
+type SeedConfig struct {
+	Seed       uint64
+	Publishers int
+	Authors    int
+	Categories int
+	Books      int
+	Members    int
+	Loans      int
+	Reviews    int
+	// Password is given to every generated member.
+	Password string
+	Now      time.Time
+}
+
+// defaultSeedConfig is a small library, enough to click through the API.
+var defaultSeedConfig = SeedConfig{
+	Seed:       1,
+	Publishers: 20,
+	Authors:    150,
+	Categories: 12,
+	Books:      500,
+	Members:    100,
+	Loans:      2000,
+	Reviews:    800,
+	Password:   "library-member",
+}
+
+// seedBatchSize is the number of rows inserted per statement.
+const seedBatchSize = 500
+
+// SeedResult counts the rows created by seedDatabase.
+type SeedResult struct {
+	Publishers, Authors, Categories, Books, Members, Loans, ActiveLoans, Reviews int
+}
+
+// Word lists the generated names are drawn from.
+var (
+	seedFirstNames = []string{"Ada", "Alan", "Grace", "Edsger", "Barbara", "Donald", "Frances", "Ken", "Margaret", "Dennis", "Radia", "Niklaus", "Hedy", "John", "Katherine", "Tim", "Sophie", "Linus", "Karen", "Leslie"}
+	seedLastNames  = []string{"Lovelace", "Turing", "Hopper", "Dijkstra", "Liskov", "Knuth", "Allen", "Thompson", "Hamilton", "Ritchie", "Perlman", "Wirth", "Lamarr", "Backus", "Johnson", "Berners-Lee", "Wilson", "Torvalds", "Jones", "Lamport"}
+	seedTitleWords = []string{"Silent", "River", "Garden", "Machine", "Shadow", "Northern", "Glass", "Letters", "Winter", "City", "Forgotten", "Light", "Atlas", "Harbor", "Engine", "Stone", "Empire", "Orchard", "Signal", "Voyage"}
+	seedCategories = []string{"Fiction", "Science", "History", "Biography", "Poetry", "Travel", "Philosophy", "Computing", "Mathematics", "Art", "Children", "Mystery", "Fantasy", "Economics", "Cooking", "Music"}
+	seedPublishers = []string{"House", "Press", "Books", "Publishing", "& Sons", "Editions"}
+	seedCities     = []string{"London", "New York", "Berlin", "Toronto", "Sydney", "Madrid", "Oslo", "Lisbon", "Dublin", "Chicago"}
+	seedComments   = []string{"Loved it.", "Couldn't put it down.", "Slow start, great ending.", "Not for me.", "A classic.", "Well researched.", "Recommended to the whole book club.", "Too long."}
+)
+
+// isbn13CheckDigit returns the check digit of the first 12 digits of an
+// ISBN-13: digits are weighted 1 and 3 alternately and the check digit
+// brings the sum to a multiple of 10.
+func isbn13CheckDigit(digits string) byte {
+	sum := 0
+	for i, d := range digits[:12] {
+		n := int(d - '0')
+		if i%2 == 1 {
+			n *= 3
+		}
+		sum += n
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// seeder draws the generated data from one random source, so that the
+// order of generation decides the dataset.
+type seeder struct {
+	rng *rand.Rand
+}
+
+// pick returns a random element of words.
+func (s *seeder) pick(words []string) string {
+	return words[s.rng.IntN(len(words))]
+}
+
+// isbn returns a random valid ISBN-13 that is not in seen.
+func (s *seeder) isbn(seen map[string]bool) string {
+	for {
+		prefix := "978"
+		if s.rng.IntN(10) == 0 {
+			prefix = "979"
+		}
+		digits := fmt.Sprintf("%s%09d", prefix, s.rng.IntN(1_000_000_000))
+		isbn := digits + string(isbn13CheckDigit(digits))
+		if !seen[isbn] {
+			seen[isbn] = true
+			return isbn
+		}
+	}
+}
+
+// rating returns a review rating skewed towards good reviews.
+func (s *seeder) rating() int {
+	return []int{1, 2, 3, 3, 4, 4, 4, 5, 5, 5}[s.rng.IntN(10)]
+}
+
+// seedDatabase generates a dataset of the size given by cfg into the tenant
+// of ctx, in one transaction. The tenant must not have books yet. Rows are
+// inserted directly, so no domain events are emitted for them.
+func seedDatabase(ctx context.Context, db *gorm.DB, cfg SeedConfig) (*SeedResult, error) {
+	if cfg.Now.IsZero() {
+		cfg.Now = time.Now().UTC().Truncate(24 * time.Hour)
+	}
+	if len(cfg.Password) < minPasswordLength {
+		return nil, fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	if cfg.Publishers < 1 && cfg.Books > 0 {
+		return nil, errors.New("books need at least one publisher")
+	}
+	if (cfg.Loans > 0 || cfg.Reviews > 0) && (cfg.Books < 1 || cfg.Members < 1) {
+		return nil, errors.New("loans and reviews need at least one book and one member")
+	}
+	var existing int64
+	if err := db.WithContext(ctx).Model(&Book{}).Count(&existing).Error; err != nil {
+		return nil, fmt.Errorf("failed to count books: %w", err)
+	}
+	if existing > 0 {
+		return nil, fmt.Errorf("tenant already has %d books; seed an empty tenant", existing)
+	}
+	// One hash for every member keeps large datasets fast to generate.
+	hash, err := bcrypt.GenerateFromPassword([]byte(cfg.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	s := &seeder{rng: rand.New(rand.NewPCG(cfg.Seed, cfg.Seed))}
+	result := &SeedResult{}
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		publishers := make([]Publisher, cfg.Publishers)
+		for i := range publishers {
+			publishers[i] = Publisher{
+				Name:    fmt.Sprintf("%s %s", s.pick(seedLastNames), s.pick(seedPublishers)),
+				Address: fmt.Sprintf("%d %s Street, %s", 1+s.rng.IntN(200), s.pick(seedTitleWords), s.pick(seedCities)),
+			}
+		}
+		if err := createSeedRows(tx, publishers); err != nil {
+			return fmt.Errorf("failed to create publishers: %w", err)
+		}
+
+		authors := make([]Author, cfg.Authors)
+		for i := range authors {
+			authors[i] = Author{
+				Name:      fmt.Sprintf("%s %s", s.pick(seedFirstNames), s.pick(seedLastNames)),
+				Biography: fmt.Sprintf("Writes about %s.", strings.ToLower(s.pick(seedCategories))),
+				BirthYear: 1900 + s.rng.IntN(90),
+			}
+		}
+		if err := createSeedRows(tx, authors); err != nil {
+			return fmt.Errorf("failed to create authors: %w", err)
+		}
+
+		categories := make([]Category, min(cfg.Categories, len(seedCategories)))
+		for i, n := range s.rng.Perm(len(seedCategories))[:len(categories)] {
+			categories[i] = Category{Name: seedCategories[n]}
+		}
+		if err := createSeedRows(tx, categories); err != nil {
+			return fmt.Errorf("failed to create categories: %w", err)
+		}
+
+		seen := map[string]bool{}
+		books := make([]Book, cfg.Books)
+		for i := range books {
+			b := Book{
+				ISBN:            s.isbn(seen),
+				Title:           fmt.Sprintf("The %s %s", s.pick(seedTitleWords), s.pick(seedTitleWords)),
+				PublicationYear: 1950 + s.rng.IntN(cfg.Now.Year()-1949),
+				Copies:          1 + s.rng.IntN(5),
+				PublisherID:     publishers[s.rng.IntN(len(publishers))].ID,
+			}
+			if len(authors) > 0 {
+				first, second := s.rng.IntN(len(authors)), s.rng.IntN(len(authors))
+				b.Authors = []Author{authors[first]}
+				if second != first && s.rng.IntN(4) == 0 {
+					b.Authors = append(b.Authors, authors[second])
+				}
+			}
+			if len(categories) > 0 {
+				b.Categories = []Category{categories[s.rng.IntN(len(categories))]}
+			}
+			books[i] = b
+		}
+		if err := createSeedRows(tx, books); err != nil {
+			return fmt.Errorf("failed to create books: %w", err)
+		}
+
+		members := make([]User, cfg.Members)
+		for i := range members {
+			first, last := s.pick(seedFirstNames), s.pick(seedLastNames)
+			members[i] = User{
+				Username:     fmt.Sprintf("member%04d", i+1),
+				Email:        fmt.Sprintf("%s.%s.%d@example.com", strings.ToLower(first), strings.ToLower(last), i+1),
+				PasswordHash: string(hash),
+				Role:         RoleMember,
+			}
+		}
+		if err := createSeedRows(tx, members); err != nil {
+			return fmt.Errorf("failed to create members: %w", err)
+		}
+
+		// Loans start during the past year. Loans still running keep a
+		// copy of their book, as long as one is left; the others were
+		// returned, a few of them late, or are overdue.
+		loans := make([]BookLoan, cfg.Loans)
+		onLoan := map[int]int{}
+		for i := range loans {
+			n := s.rng.IntN(len(books))
+			book := books[n]
+			loanDate := cfg.Now.Add(-time.Duration(s.rng.IntN(365*24)) * time.Hour)
+			l := BookLoan{
+				BookID:   book.ID,
+				MemberID: members[s.rng.IntN(len(members))].ID,
+				LoanDate: loanDate,
+				DueDate:  loanDate.AddDate(0, 0, 7*(1+s.rng.IntN(4))),
+			}
+			running := l.DueDate.After(cfg.Now) || s.rng.IntN(20) == 0
+			if running && onLoan[n] < book.Copies {
+				onLoan[n]++
+				l.Overdue = l.DueDate.Before(cfg.Now)
+				result.ActiveLoans++
+			} else {
+				returnedAt := l.LoanDate.Add(time.Duration(1+s.rng.IntN(int(l.DueDate.Sub(l.LoanDate).Hours())+72)) * time.Hour)
+				if returnedAt.After(cfg.Now) {
+					returnedAt = cfg.Now
+				}
+				l.Returned, l.ReturnedAt = true, &returnedAt
+			}
+			loans[i] = l
+		}
+		// The loan hooks take a copy per loan; availability is set below
+		// instead.
+		if err := createSeedRows(tx.Session(&gorm.Session{SkipHooks: true}), loans); err != nil {
+			return fmt.Errorf("failed to create loans: %w", err)
+		}
+		for n, count := range onLoan {
+			if err := tx.Model(&books[n]).UpdateColumn("available", books[n].Copies-count).Error; err != nil {
+				return fmt.Errorf("failed to update available copies: %w", err)
+			}
+		}
+
+		reviews := make([]Review, cfg.Reviews)
+		for i := range reviews {
+			reviews[i] = Review{
+				Rating:     s.rating(),
+				Comment:    s.pick(seedComments),
+				CustomerID: members[s.rng.IntN(len(members))].ID,
+				ProductID:  books[s.rng.IntN(len(books))].ID,
+			}
+		}
+		if err := createSeedRows(tx, reviews); err != nil {
+			return fmt.Errorf("failed to create reviews: %w", err)
+		}
+
+		*result = SeedResult{
+			Publishers:  len(publishers),
+			Authors:     len(authors),
+			Categories:  len(categories),
+			Books:       len(books),
+			Members:     len(members),
+			Loans:       len(loans),
+			ActiveLoans: result.ActiveLoans,
+			Reviews:     len(reviews),
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// createSeedRows inserts rows in batches of seedBatchSize.
+func createSeedRows[T any](tx *gorm.DB, rows []T) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	return tx.CreateInBatches(rows, seedBatchSize).Error
+}
+
+// runSeedCommand implements "seed": it parses the dataset size from args
+// and seeds the tenant named by -tenant, or the default tenant.
+func runSeedCommand(ctx context.Context, db *gorm.DB, args []string, out io.Writer) error {
+	cfg := defaultSeedConfig
+	fs := flag.NewFlagSet("seed", flag.ContinueOnError)
+	fs.SetOutput(out)
+	tenant := fs.String("tenant", "", "slug of the tenant to seed (default tenant if empty)")
+	fs.Uint64Var(&cfg.Seed, "seed", cfg.Seed, "random seed; the same seed generates the same data")
+	fs.IntVar(&cfg.Publishers, "publishers", cfg.Publishers, "number of publishers")
+	fs.IntVar(&cfg.Authors, "authors", cfg.Authors, "number of authors")
+	fs.IntVar(&cfg.Categories, "categories", cfg.Categories, "number of categories (at most "+strconv.Itoa(len(seedCategories))+")")
+	fs.IntVar(&cfg.Books, "books", cfg.Books, "number of books")
+	fs.IntVar(&cfg.Members, "members", cfg.Members, "number of members")
+	fs.IntVar(&cfg.Loans, "loans", cfg.Loans, "number of loans")
+	fs.IntVar(&cfg.Reviews, "reviews", cfg.Reviews, "number of reviews")
+	fs.StringVar(&cfg.Password, "password", cfg.Password, "password of every generated member")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	for _, n := range []int{cfg.Publishers, cfg.Authors, cfg.Categories, cfg.Books, cfg.Members, cfg.Loans, cfg.Reviews} {
+		if n < 0 {
+			return errors.New("sizes must not be negative")
+		}
+	}
+
+	if *tenant != "" {
+		id, err := (&TenantService{db: db}).ResolveSlug(ctx, *tenant)
+		if err != nil {
+			return err
+		}
+		ctx = withTenant(ctx, id)
+	}
+	start := time.Now()
+	r, err := seedDatabase(ctx, db, cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "seeded %d publishers, %d authors, %d categories, %d books, %d members, %d loans (%d active) and %d reviews in %s\n",
+		r.Publishers, r.Authors, r.Categories, r.Books, r.Members, r.Loans, r.ActiveLoans, r.Reviews, time.Since(start).Round(time.Millisecond))
+	return nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/rand/v2"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestISBN13CheckDigit tests check digits of published ISBNs and of
+// generated ones.
+func TestISBN13CheckDigit(t *testing.T) {
+	for _, isbn := range []string{"9780306406157", "9781861972712", "9780134190440", "9791032305690"} {
+		if got := isbn13CheckDigit(isbn); got != isbn[12] {
+			t.Errorf("isbn13CheckDigit(%s) = %c, want %c", isbn, got, isbn[12])
+		}
+	}
+
+	s := &seeder{rng: rand.New(rand.NewPCG(1, 1))}
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		isbn := s.isbn(seen)
+		if len(isbn) != 13 || isbn13CheckDigit(isbn) != isbn[12] || !strings.HasPrefix(isbn, "97") {
+			t.Fatalf("invalid generated ISBN %q", isbn)
+		}
+	}
+	if len(seen) != 1000 {
+		t.Errorf("expected 1000 distinct ISBNs, got %d", len(seen))
+	}
+}
+
+// TestRunSeedCommand_Flags tests that invalid sizes are rejected before
+// touching the database.
+func TestRunSeedCommand_Flags(t *testing.T) {
+	var out bytes.Buffer
+	if err := runSeedCommand(context.Background(), nil, []string{"-books", "-1"}, &out); err == nil {
+		t.Errorf("expected an error for a negative size")
+	}
+	if err := runSeedCommand(context.Background(), nil, []string{"-colour", "red"}, &out); err == nil {
+		t.Errorf("expected an error for an unknown flag")
+	}
+}
+
+// TestSeedDatabase tests that the same seed generates the same data in
+// two tenants, that availability matches the running loans and that a
+// seeded tenant is not seeded twice.
+func TestSeedDatabase(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenants := &TenantService{db: db}
+	cfg := SeedConfig{Seed: 42, Publishers: 3, Authors: 10, Categories: 4, Books: 30, Members: 8, Loans: 120, Reviews: 40, Password: "seeded-password", Now: time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)}
+
+	var catalogues [2][]Book
+	for i := range catalogues {
+		ctx := withTenant(context.Background(), mustCreateTenant(t, tenants, "seed").ID)
+		r, err := seedDatabase(ctx, db, cfg)
+		if err != nil {
+			t.Fatalf("seedDatabase: %v", err)
+		}
+		if r.Books != 30 || r.Members != 8 || r.Loans != 120 || r.Reviews != 40 || r.ActiveLoans == 0 {
+			t.Errorf("unexpected result %+v", r)
+		}
+		db.WithContext(ctx).Preload("Authors").Order("isbn").Find(&catalogues[i])
+
+		var loans []BookLoan
+		db.WithContext(ctx).Where("NOT returned").Find(&loans)
+		onLoan := map[uint]int{}
+		for _, l := range loans {
+			onLoan[l.BookID]++
+		}
+		for _, b := range catalogues[i] {
+			if isbn13CheckDigit(b.ISBN) != b.ISBN[12] || len(b.Authors) == 0 {
+				t.Errorf("invalid book %s with %d authors", b.ISBN, len(b.Authors))
+			}
+			if b.Available != b.Copies-onLoan[b.ID] || b.Available < 0 {
+				t.Errorf("book %s: %d available of %d copies with %d on loan", b.ISBN, b.Available, b.Copies, onLoan[b.ID])
+			}
+		}
+
+		if _, err := seedDatabase(ctx, db, cfg); err == nil {
+			t.Errorf("expected seeding a non-empty tenant to fail")
+		}
+	}
+
+	if len(catalogues[0]) != len(catalogues[1]) {
+		t.Fatalf("catalogues differ in size: %d and %d", len(catalogues[0]), len(catalogues[1]))
+	}
+	for i := range catalogues[0] {
+		a, b := catalogues[0][i], catalogues[1][i]
+		if a.ISBN != b.ISBN || a.Title != b.Title || a.Copies != b.Copies || a.Available != b.Available {
+			t.Errorf("book %d differs between seeds: %+v and %+v", i, a, b)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/graph-gophers/graphql-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"gorm.io/gorm"
+)
+
+// defaultHTTPAddr is the listen address used when HTTP_ADDR is unset.
+const defaultHTTPAddr = ":8080"
+
+// defaultMetricsAddr is the listen address of the metrics server used when
+// METRICS_ADDR is unset.
+const defaultMetricsAddr = ":8081"
+
+// apiServer exposes the application over HTTP.
+type apiServer struct {
+	db       *gorm.DB
+	tenants  *TenantService
+	auth     *AuthService
+	books    *BookService
+	loans    *LoanService
+	reports  *ReportService
+	registry *prometheus.Registry
+
+	recommendations *RecommendationService
+	attachments     *AttachmentService
+	licenses        *LicenseService
+	schema          *graphql.Schema
+	webhooks        *WebhookService
+	limits          *rateLimits
+	stocktakes      *StocktakeService
+	acquisitions    *AcquisitionService
+	branches        *BranchService
+}
+
+// routes returns the HTTP handler with all endpoints registered.
+func (s *apiServer) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+	mux.HandleFunc("GET /reports/{name}", s.handleReport)
+	mux.HandleFunc("POST /users", s.handleCreateUser)
+	mux.HandleFunc("POST /users/{id}/tokens", s.handleIssueToken)
+	mux.HandleFunc("GET /users/{id}/tokens", s.handleListTokens)
+	mux.HandleFunc("DELETE /tokens/{id}", s.handleRevokeToken)
+	mux.HandleFunc("GET /books", s.handleListBooks)
+	mux.HandleFunc("GET /books/{isbn}", s.handleFindBook)
+	mux.HandleFunc("GET /books/{isbn}/preview", s.handlePreviewBook)
+	mux.HandleFunc("GET /books/{id}/also-borrowed", s.handleAlsoBorrowed)
+	mux.HandleFunc("GET /members/{id}/recommendations", s.handleMemberRecommendations)
+	mux.HandleFunc("POST /books/{id}/attachments", s.handleUploadAttachment)
+	mux.HandleFunc("GET /books/{id}/attachments", s.handleListAttachments)
+	mux.HandleFunc("GET /attachments/{id}", s.handleDownloadAttachment)
+	mux.HandleFunc("DELETE /attachments/{id}", s.handleDeleteAttachment)
+	mux.HandleFunc("POST /books/{id}/licenses", s.handleAddLicensePool)
+	mux.HandleFunc("GET /books/{id}/licenses", s.handleListLicensePools)
+	mux.HandleFunc("POST /loans", s.handleCheckoutBook)
+	mux.HandleFunc("POST /loans/{id}/return", s.handleReturnBook)
+	mux.HandleFunc("POST /branches", s.handleCreateBranch)
+	mux.HandleFunc("GET /branches", s.handleListBranches)
+	mux.HandleFunc("PUT /branches/{id}/inventory/{book_id}", s.handleSetBranchInventory)
+	mux.HandleFunc("GET /branches/{id}/transfers", s.handleListBranchTransfers)
+	mux.HandleFunc("POST /transfers", s.handleRequestTransfer)
+	mux.HandleFunc("POST /transfers/{id}/{action}", s.handleAdvanceTransfer)
+	mux.HandleFunc("POST /webhooks", s.handleCreateWebhook)
+	mux.HandleFunc("GET /webhooks", s.handleListWebhooks)
+	mux.HandleFunc("DELETE /webhooks/{id}", s.handleDeleteWebhook)
+	mux.HandleFunc("GET /webhooks/dead-letters", s.handleListWebhookDeadLetters)
+	mux.HandleFunc("POST /webhooks/deliveries/{id}/replay", s.handleReplayWebhookDelivery)
+	mux.HandleFunc("POST /stocktakes", s.handleStartStocktake)
+	mux.HandleFunc("GET /stocktakes/{id}", s.handleGetStocktake)
+	mux.HandleFunc("POST /stocktakes/{id}/scans", s.handleRecordStocktakeScans)
+	mux.HandleFunc("GET /stocktakes/{id}/report", s.handleStocktakeReport)
+	mux.HandleFunc("POST /stocktakes/{id}/close", s.handleCloseStocktake)
+	mux.HandleFunc("POST /vendors", s.handleCreateVendor)
+	mux.HandleFunc("GET /vendors", s.handleListVendors)
+	mux.HandleFunc("POST /funds", s.handleCreateFund)
+	mux.HandleFunc("GET /funds", s.handleListFunds)
+	mux.HandleFunc("POST /purchase-orders", s.handleCreatePurchaseOrder)
+	mux.HandleFunc("GET /purchase-orders", s.handleListPurchaseOrders)
+	mux.HandleFunc("GET /purchase-orders/{id}", s.handleGetPurchaseOrder)
+	mux.HandleFunc("POST /purchase-orders/{id}/place", s.handlePlacePurchaseOrder)
+	mux.HandleFunc("POST /purchase-orders/{id}/cancel", s.handleCancelPurchaseOrder)
+	mux.HandleFunc("POST /purchase-orders/{id}/receipts", s.handleReceiveShipment)
+	mux.HandleFunc("GET /books/{id}/costs", s.handleBookCosts)
+
+	if s.schema != nil {
+		mux.Handle("POST /graphql", graphqlHandler(s.db, s.schema))
+	}
+
+	var handler http.Handler = s.limits.middleware(mux)
+	if s.auth != nil {
+		handler = s.limits.authMiddleware(s.auth.middleware(handler))
+	}
+	if s.tenants != nil {
+		handler = s.tenants.middleware(handler)
+	}
+	return requestIDMiddleware(handler)
+}
+
+// metricsRoutes returns the handler of the internal metrics server. The
+// metrics cover every tenant, so they are not served with the API, where
+// any caller could read them.
+func (s *apiServer) metricsRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+// requestIDMiddleware stores the X-Request-ID header (or a fresh ID) in the
+// request context so that it is attached to every log record of the request.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if id == "" {
+			id = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(withRequestID(r.Context(), id)))
+	})
+}
+
+// handleListBooks serves GET /books?offset=&limit=.
+func (s *apiServer) handleListBooks(w http.ResponseWriter, r *http.Request) {
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	books, err := s.books.ListBooks(r.Context(), offset, limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, books)
+}
+
+// handleFindBook serves GET /books/{isbn}.
+func (s *apiServer) handleFindBook(w http.ResponseWriter, r *http.Request) {
+	book, err := s.books.FindBook(r.Context(), r.PathValue("isbn"))
+	if errors.Is(err, ErrBookNotFound) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, book)
+}
+
+// writeJSON writes v as a JSON response with the given status code.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err as a JSON error response, mapping authentication
+// and authorization failures to 401 and 403.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		status = http.StatusUnauthorized
+	case errors.Is(err, ErrForbidden):
+		status = http.StatusForbidden
+	case errors.Is(err, ErrUnknownReference):
+		status = http.StatusUnprocessableEntity
+	}
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// httpAddrFromEnv returns the listen address from HTTP_ADDR.
+func httpAddrFromEnv() string {
+	if v := os.Getenv("HTTP_ADDR"); v != "" {
+		return v
+	}
+	return defaultHTTPAddr
+}
+
+// metricsAddrFromEnv returns the metrics listen address from METRICS_ADDR.
+// The metrics server is disabled when METRICS_ADDR is "off".
+func metricsAddrFromEnv() string {
+	if v := os.Getenv("METRICS_ADDR"); v != "" {
+		return v
+	}
+	return defaultMetricsAddr
+}
+
+// runHTTPServer serves handler on addr until ctx is cancelled and then
+// shuts the server down, giving in-flight requests time to complete.
+func runHTTPServer(ctx context.Context, addr string, handler http.Handler) error {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		slog.InfoContext(ctx, "http server listening", "addr", addr)
+		errc <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("http server failed: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("http server shutdown failed: %w", err)
+	}
+	if err := <-errc; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("http server failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,585 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxStocktakeScans bounds the codes accepted in one scan submission.
+const maxStocktakeScans = 5000
+
+// auditActionMarkLost is the AuditLog action of copies written off by a
+// stocktake.
+const auditActionMarkLost = "mark_lost"
+
+// ErrStocktakeNotFound is returned for unknown stocktake sessions.
+var ErrStocktakeNotFound = errors.New("stocktake not found")
+
+// ErrStocktakeClosed is returned when scanning into or closing a session
+// that is already closed.
+var ErrStocktakeClosed = errors.New("stocktake is closed")
+
+// ErrInvalidStocktake is returned for malformed sessions and scans.
+var ErrInvalidStocktake = errors.New("invalid stocktake")
+
+// StocktakeStatus is the state of a stocktake session.
+type StocktakeStatus string
+
+// Stocktake states. Scans are only accepted while a session is open.
+const (
+	StocktakeOpen   StocktakeStatus = "open"
+	StocktakeClosed StocktakeStatus = "closed"
+)
+
+// StocktakeSession is a physical inventory count of the whole library or,
+// when BranchID is set, of one branch. Report holds the final report once
+// the session is closed.
+type StocktakeSession struct {
+	ID         uint            `gorm:"primaryKey" json:"id"`
+	TenantID   uint            `gorm:"index;not null;default:0" json:"-"`
+	BranchID   uint            `gorm:"index" json:"branch_id,omitempty"`
+	Status     StocktakeStatus `gorm:"type:varchar(20);not null;default:'open'" json:"status"`
+	Note       string          `gorm:"type:text" json:"note,omitempty"`
+	StartedBy  uint            `json:"started_by"`
+	StartedAt  time.Time       `gorm:"autoCreateTime" json:"started_at"`
+	ClosedAt   *time.Time      `json:"closed_at,omitempty"`
+	MarkedLost bool            `gorm:"not null;default:false" json:"marked_lost"`
+	Report     json.RawMessage `gorm:"type:jsonb" json:"-"`
+}
+
+// StocktakeScan is one copy scanned during a session. Code is the scanned
+// ISBN, which is also the EAN-13 barcode printed on the book.
+type StocktakeScan struct {
+	ID        uint   `gorm:"primaryKey"`
+	TenantID  uint   `gorm:"index;not null;default:0"`
+	SessionID uint   `gorm:"index;not null"`
+	Code      string `gorm:"size:20;not null"`
+	ScannedBy uint
+	ScannedAt time.Time `gorm:"autoCreateTime"`
+}
+
+// Stocktake item states.
+const (
+	StocktakeMissing   = "missing"
+	StocktakeExtra     = "extra"
+	StocktakeMisplaced = "misplaced"
+	StocktakeUnknown   = "unknown"
+)
+
+// StocktakeItem is a book whose scanned count differs from the count
+// expected on the shelf. Misplaced books belong to other branches; unknown
+// codes match no book in the catalogue. Recount is set for books lent or
+// returned since the session started, whose shelf may have changed after
+// it was scanned.
+type StocktakeItem struct {
+	Status   string `json:"status"`
+	BookID   uint   `json:"book_id,omitempty"`
+	Code     string `json:"code"`
+	Title    string `json:"title,omitempty"`
+	Expected int    `json:"expected"`
+	Scanned  int    `json:"scanned"`
+	Recount  bool   `json:"recount,omitempty"`
+}
+
+// StocktakeReport compares a session's scans with the expected stock.
+type StocktakeReport struct {
+	SessionID   uint            `json:"session_id"`
+	BranchID    uint            `json:"branch_id,omitempty"`
+	Status      StocktakeStatus `json:"status"`
+	GeneratedAt time.Time       `json:"generated_at"`
+	Expected    int             `json:"expected"`
+	Scanned     int             `json:"scanned"`
+	Missing     int             `json:"missing"`
+	Recount     int             `json:"recount"`
+	Extra       int             `json:"extra"`
+	Misplaced   int             `json:"misplaced"`
+	Unknown     int             `json:"unknown"`
+	MarkedLost  bool            `json:"marked_lost"`
+	Items       []StocktakeItem `json:"items"`
+}
+
+// StocktakeService runs physical inventory counts. Staff open a session,
+// submit the codes they scan and close it, optionally writing missing
+// copies off as lost. All methods require PermManageCatalog.
+type StocktakeService struct {
+	db           *gorm.DB
+	queryTimeout time.Duration
+	cache        *bookCache
+}
+
+// StartSession opens a stocktake of the branch, or of the whole library
+// when branchID is zero.
+func (s *StocktakeService) StartSession(ctx context.Context, branchID uint, note string) (_ *StocktakeSession, err error) {
+	defer observeOperation("start_stocktake", time.Now(), &err)
+	u, err := authorize(ctx, PermManageCatalog)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	db := s.db.WithContext(ctx)
+	if branchID != 0 {
+		if err := db.Select("id").First(&Branch{}, branchID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, fmt.Errorf("%w: unknown branch %d", ErrInvalidStocktake, branchID)
+			}
+			return nil, fmt.Errorf("error finding branch: %w", err)
+		}
+	}
+	session := &StocktakeSession{BranchID: branchID, Note: note, StartedBy: u.ID, Status: StocktakeOpen}
+	if err := db.Create(session).Error; err != nil {
+		return nil, fmt.Errorf("failed to start stocktake: %w", err)
+	}
+	return session, nil
+}
+
+// GetSession returns the session with the given ID.
+func (s *StocktakeService) GetSession(ctx context.Context, id uint) (_ *StocktakeSession, err error) {
+	defer observeOperation("get_stocktake", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageCatalog); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+	return findStocktake(s.db.WithContext(ctx), id, "")
+}
+
+// RecordScans adds one scanned copy per code, ignoring hyphens and spaces,
+// to an open session and returns the number recorded. A code scanned
+// twice counts two copies.
+func (s *StocktakeService) RecordScans(ctx context.Context, id uint, codes []string) (_ int, err error) {
+	defer observeOperation("record_stocktake_scans", time.Now(), &err)
+	u, err := authorize(ctx, PermManageCatalog)
+	if err != nil {
+		return 0, err
+	}
+	if len(codes) == 0 || len(codes) > maxStocktakeScans {
+		return 0, fmt.Errorf("%w: submit between 1 and %d codes", ErrInvalidStocktake, maxStocktakeScans)
+	}
+	scans := make([]StocktakeScan, len(codes))
+	for i, code := range codes {
+		code = normalizeISBN(code)
+		if code == "" || len(code) > 20 {
+			return 0, fmt.Errorf("%w: malformed code %q", ErrInvalidStocktake, codes[i])
+		}
+		scans[i] = StocktakeScan{SessionID: id, Code: code, ScannedBy: u.ID}
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	// The shared lock keeps the session from being closed while the scans
+	// are written.
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		session, err := findStocktake(tx, id, "SHARE")
+		if err != nil {
+			return err
+		}
+		if session.Status != StocktakeOpen {
+			return ErrStocktakeClosed
+		}
+		if err := tx.CreateInBatches(scans, 500).Error; err != nil {
+			return fmt.Errorf("failed to record scans: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(scans), nil
+}
+
+// Report compares the scans of a session with the stock expected on the
+// shelves. Open sessions are compared with the current stock; closed ones
+// return the report made when they were closed.
+func (s *StocktakeService) Report(ctx context.Context, id uint) (_ *StocktakeReport, err error) {
+	defer observeOperation("stocktake_report", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageCatalog); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	db := s.db.WithContext(ctx)
+	session, err := findStocktake(db, id, "")
+	if err != nil {
+		return nil, err
+	}
+	if session.Status == StocktakeClosed {
+		var report StocktakeReport
+		if err := json.Unmarshal(session.Report, &report); err != nil {
+			return nil, fmt.Errorf("failed to decode stocktake report: %w", err)
+		}
+		return &report, nil
+	}
+	return buildStocktakeReport(db, session)
+}
+
+// Close ends a session and keeps its final report. With markLost, the
+// copies reported missing are removed from the stock of their books, and
+// of the branch for branch sessions, with an audit entry per book. Books
+// marked for recount are left alone, since a loan or return during the
+// count may explain the difference.
+func (s *StocktakeService) Close(ctx context.Context, id uint, markLost bool) (_ *StocktakeReport, err error) {
+	defer observeOperation("close_stocktake", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageCatalog); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var report *StocktakeReport
+	var changed []string
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		session, err := findStocktake(tx, id, "UPDATE")
+		if err != nil {
+			return err
+		}
+		if session.Status != StocktakeOpen {
+			return ErrStocktakeClosed
+		}
+		if report, err = buildStocktakeReport(tx, session); err != nil {
+			return err
+		}
+		if markLost {
+			if changed, err = markStocktakeLost(tx, session, report); err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		report.Status, report.MarkedLost = StocktakeClosed, markLost
+		encoded, err := json.Marshal(report)
+		if err != nil {
+			return fmt.Errorf("failed to encode stocktake report: %w", err)
+		}
+		return tx.Model(session).Updates(map[string]interface{}{
+			"status": StocktakeClosed, "closed_at": now, "marked_lost": markLost, "report": encoded,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.cache.invalidate(ctx, changed...)
+	return report, nil
+}
+
+// findStocktake loads a session, locking it with the given strength unless
+// lock is empty.
+func findStocktake(db *gorm.DB, id uint, lock string) (*StocktakeSession, error) {
+	if lock != "" {
+		db = db.Clauses(clause.Locking{Strength: lock})
+	}
+	var session StocktakeSession
+	if err := db.First(&session, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: %d", ErrStocktakeNotFound, id)
+		}
+		return nil, fmt.Errorf("error finding stocktake: %w", err)
+	}
+	return &session, nil
+}
+
+// stockedBook is a print book with the number of its copies expected on
+// the shelves being counted.
+type stockedBook struct {
+	ID       uint
+	ISBN     string
+	Title    string
+	Expected int
+}
+
+// buildStocktakeReport compares the scans of session with the expected
+// stock: for the whole library the copies of each print book that are not
+// on loan or travelling back from a return at another branch, for a branch
+// the copies available at that branch. Scanned books without stock at the
+// branch but stocked at another are misplaced. The expected stock is the
+// current one, so differences in books lent or returned since the session
+// started are marked for recount.
+func buildStocktakeReport(db *gorm.DB, session *StocktakeSession) (*StocktakeReport, error) {
+	var counts []struct {
+		Code  string
+		Count int
+	}
+	if err := db.Model(&StocktakeScan{}).Select("code, COUNT(*) AS count").
+		Where("session_id = ?", session.ID).Group("code").Scan(&counts).Error; err != nil {
+		return nil, fmt.Errorf("failed to count scans: %w", err)
+	}
+	scanned := map[string]int{}
+	codes := make([]string, 0, len(counts))
+	for _, c := range counts {
+		scanned[c.Code] = c.Count
+		codes = append(codes, c.Code)
+	}
+
+	var stock []stockedBook
+	var err error
+	if session.BranchID == 0 {
+		err = db.Model(&Book{}).
+			Select("books.id, books.isbn, books.title, GREATEST(books.copies - COUNT(book_loans.id) - "+inTransitReturnsSQL+", 0) AS expected").
+			Joins("LEFT JOIN book_loans ON book_loans.book_id = books.id AND NOT book_loans.returned AND book_loans.license_pool_id IS NULL").
+			Where("books.format = ?", FormatPrint).Group("books.id").Scan(&stock).Error
+	} else {
+		err = db.Model(&Book{}).
+			Select("books.id, books.isbn, books.title, branch_inventories.available AS expected").
+			Joins("JOIN branch_inventories ON branch_inventories.book_id = books.id AND branch_inventories.branch_id = ? AND branch_inventories.copies > 0", session.BranchID).
+			Scan(&stock).Error
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load expected stock: %w", err)
+	}
+
+	var catalogued []Book
+	if len(codes) > 0 {
+		if err := db.Select("id, isbn, title").Where("isbn IN ?", codes).Find(&catalogued).Error; err != nil {
+			return nil, fmt.Errorf("failed to look up scanned books: %w", err)
+		}
+	}
+	var active []uint
+	if err := db.Model(&BookLoan{}).Distinct("book_id").
+		Where("license_pool_id IS NULL AND (loan_date >= ? OR returned_at >= ?)", session.StartedAt, session.StartedAt).
+		Pluck("book_id", &active).Error; err != nil {
+		return nil, fmt.Errorf("failed to look up loans during the stocktake: %w", err)
+	}
+	recount := map[uint]bool{}
+	for _, id := range active {
+		recount[id] = true
+	}
+
+	stockedElsewhere := map[uint]bool{}
+	if session.BranchID != 0 && len(catalogued) > 0 {
+		ids := make([]uint, len(catalogued))
+		for i, b := range catalogued {
+			ids[i] = b.ID
+		}
+		var elsewhere []uint
+		if err := db.Model(&BranchInventory{}).Distinct("book_id").
+			Where("book_id IN ? AND branch_id <> ? AND copies > 0", ids, session.BranchID).
+			Pluck("book_id", &elsewhere).Error; err != nil {
+			return nil, fmt.Errorf("failed to look up other branches: %w", err)
+		}
+		for _, id := range elsewhere {
+			stockedElsewhere[id] = true
+		}
+	}
+
+	report := &StocktakeReport{SessionID: session.ID, BranchID: session.BranchID, Status: session.Status, GeneratedAt: time.Now(), Items: []StocktakeItem{}}
+	stocked := map[string]bool{}
+	for _, b := range stock {
+		stocked[b.ISBN] = true
+		n := scanned[b.ISBN]
+		report.Expected += b.Expected
+		item := StocktakeItem{BookID: b.ID, Code: b.ISBN, Title: b.Title, Expected: b.Expected, Scanned: n, Recount: recount[b.ID]}
+		switch {
+		case n < b.Expected:
+			item.Status = StocktakeMissing
+			report.Missing += b.Expected - n
+			if item.Recount {
+				report.Recount += b.Expected - n
+			}
+		case n > b.Expected:
+			item.Status = StocktakeExtra
+			report.Extra += n - b.Expected
+		default:
+			continue
+		}
+		report.Items = append(report.Items, item)
+	}
+	known := map[string]Book{}
+	for _, b := range catalogued {
+		known[b.ISBN] = b
+	}
+	for _, c := range counts {
+		report.Scanned += c.Count
+		if stocked[c.Code] {
+			continue
+		}
+		item := StocktakeItem{Code: c.Code, Scanned: c.Count}
+		b, ok := known[c.Code]
+		switch {
+		case !ok:
+			item.Status = StocktakeUnknown
+			report.Unknown += c.Count
+		case stockedElsewhere[b.ID]:
+			item.Status, item.BookID, item.Title = StocktakeMisplaced, b.ID, b.Title
+			report.Misplaced += c.Count
+		default:
+			item.Status, item.BookID, item.Title = StocktakeExtra, b.ID, b.Title
+			report.Extra += c.Count
+		}
+		report.Items = append(report.Items, item)
+	}
+	sort.Slice(report.Items, func(i, j int) bool {
+		if report.Items[i].Status != report.Items[j].Status {
+			return report.Items[i].Status < report.Items[j].Status
+		}
+		return report.Items[i].Code < report.Items[j].Code
+	})
+	return report, nil
+}
+
+// markStocktakeLost removes the missing copies in report that need no
+// recount from stock and returns the ISBNs of the books changed.
+func markStocktakeLost(tx *gorm.DB, session *StocktakeSession, report *StocktakeReport) ([]string, error) {
+	var changed []string
+	for _, item := range report.Items {
+		if item.Status != StocktakeMissing || item.Recount {
+			continue
+		}
+		lost := item.Expected - item.Scanned
+		if session.BranchID != 0 {
+			if err := tx.Model(&BranchInventory{}).
+				Where("branch_id = ? AND book_id = ?", session.BranchID, item.BookID).
+				Updates(map[string]interface{}{
+					"copies":    gorm.Expr("copies - ?", lost),
+					"available": gorm.Expr("GREATEST(available - ?, 0)", lost),
+				}).Error; err != nil {
+				return nil, fmt.Errorf("failed to update branch inventory: %w", err)
+			}
+		}
+		var book Book
+		if err := tx.Model(&book).Clauses(clause.Returning{}).Where("id = ?", item.BookID).
+			UpdateColumns(map[string]interface{}{
+				"copies":    gorm.Expr("GREATEST(copies - ?, 0)", lost),
+				"available": gorm.Expr("GREATEST(available - ?, 0)", lost),
+			}).Error; err != nil {
+			return nil, fmt.Errorf("failed to write off copies: %w", err)
+		}
+		audit := &AuditLog{
+			Action:    auditActionMarkLost,
+			ModelType: "books",
+			ModelID:   item.BookID,
+			Details:   fmt.Sprintf("%d copies lost in stocktake %d", lost, session.ID),
+		}
+		if err := tx.Create(audit).Error; err != nil {
+			return nil, fmt.Errorf("failed to record audit entry: %w", err)
+		}
+		if err := emitEvent(tx, EventBookCopiesChanged, book.ID, newBookEvent(&book)); err != nil {
+			return nil, err
+		}
+		changed = append(changed, item.Code)
+	}
+	return changed, nil
+}
+
+// writeStocktakeError maps stocktake errors to HTTP statuses.
+func writeStocktakeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrStocktakeNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrStocktakeClosed):
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	case errors.Is(err, ErrInvalidStocktake):
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	default:
+		writeError(w, err)
+	}
+}
+
+// stocktakeID parses the {id} path value, writing 400 when it is invalid.
+func stocktakeID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid stocktake id"})
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// handleStartStocktake serves POST /stocktakes.
+func (s *apiServer) handleStartStocktake(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		BranchID uint   `json:"branch_id"`
+		Note     string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid stocktake"})
+		return
+	}
+	session, err := s.stocktakes.StartSession(r.Context(), req.BranchID, req.Note)
+	if err != nil {
+		writeStocktakeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, session)
+}
+
+// handleGetStocktake serves GET /stocktakes/{id}.
+func (s *apiServer) handleGetStocktake(w http.ResponseWriter, r *http.Request) {
+	id, ok := stocktakeID(w, r)
+	if !ok {
+		return
+	}
+	session, err := s.stocktakes.GetSession(r.Context(), id)
+	if err != nil {
+		writeStocktakeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, session)
+}
+
+// handleRecordStocktakeScans serves POST /stocktakes/{id}/scans.
+func (s *apiServer) handleRecordStocktakeScans(w http.ResponseWriter, r *http.Request) {
+	id, ok := stocktakeID(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		Codes []string `json:"codes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid scans"})
+		return
+	}
+	n, err := s.stocktakes.RecordScans(r.Context(), id, req.Codes)
+	if err != nil {
+		writeStocktakeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"recorded": n})
+}
+
+// handleStocktakeReport serves GET /stocktakes/{id}/report.
+func (s *apiServer) handleStocktakeReport(w http.ResponseWriter, r *http.Request) {
+	id, ok := stocktakeID(w, r)
+	if !ok {
+		return
+	}
+	report, err := s.stocktakes.Report(r.Context(), id)
+	if err != nil {
+		writeStocktakeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// handleCloseStocktake serves POST /stocktakes/{id}/close.
+func (s *apiServer) handleCloseStocktake(w http.ResponseWriter, r *http.Request) {
+	id, ok := stocktakeID(w, r)
+	if !ok {
+		return
+	}
+	var req struct {
+		MarkLost bool `json:"mark_lost"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid request"})
+			return
+		}
+	}
+	report, err := s.stocktakes.Close(r.Context(), id, req.MarkLost)
+	if err != nil {
+		writeStocktakeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
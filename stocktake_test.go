@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRecordScans_Validation tests the checks made before a submission
+// reaches the database.
+func TestRecordScans_Validation(t *testing.T) {
+	svc := &StocktakeService{}
+	ctx := asRole(RoleLibrarian)
+	if _, err := svc.RecordScans(asRole(RoleMember), 1, []string{"9780000000001"}); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden for a member, got %v", err)
+	}
+	if _, err := svc.RecordScans(ctx, 1, nil); !errors.Is(err, ErrInvalidStocktake) {
+		t.Errorf("expected ErrInvalidStocktake for no codes, got %v", err)
+	}
+	if _, err := svc.RecordScans(ctx, 1, []string{"978-0", " - "}); !errors.Is(err, ErrInvalidStocktake) {
+		t.Errorf("expected ErrInvalidStocktake for a blank code, got %v", err)
+	}
+	if _, err := svc.RecordScans(ctx, 1, make([]string, maxStocktakeScans+1)); !errors.Is(err, ErrInvalidStocktake) {
+		t.Errorf("expected ErrInvalidStocktake for too many codes, got %v", err)
+	}
+}
+
+// TestWriteStocktakeError tests the mapping of stocktake errors to HTTP
+// statuses.
+func TestWriteStocktakeError(t *testing.T) {
+	tests := map[error]int{
+		fmt.Errorf("%w: 7", ErrStocktakeNotFound):       http.StatusNotFound,
+		ErrStocktakeClosed:                              http.StatusConflict,
+		fmt.Errorf("%w: no codes", ErrInvalidStocktake): http.StatusUnprocessableEntity,
+		fmt.Errorf("%w: role guest", ErrForbidden):      http.StatusForbidden,
+		errors.New("connection refused"):                http.StatusInternalServerError,
+	}
+	for err, want := range tests {
+		rec := httptest.NewRecorder()
+		writeStocktakeError(rec, err)
+		if rec.Code != want {
+			t.Errorf("writeStocktakeError(%v) = %d, want %d", err, rec.Code, want)
+		}
+	}
+}
+
+// TestStocktake tests a library-wide and a branch stocktake: missing,
+// extra, unknown and misplaced items, writing off lost copies and the
+// frozen report of a closed session.
+func TestStocktake(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenant := mustCreateTenant(t, &TenantService{db: db}, "stocktake")
+	ctx := withTenant(asRole(RoleLibrarian), tenant.ID)
+	tdb := db.WithContext(ctx)
+	svc := &StocktakeService{db: db}
+
+	lent := &Book{Copies: 3}
+	mustCreateBook(t, tdb, lent)
+	mustCreateLoan(t, tdb, &BookLoan{BookID: lent.ID})
+	lost := &Book{Copies: 2}
+	mustCreateBook(t, tdb, lost)
+	surplus := &Book{Copies: 1}
+	mustCreateBook(t, tdb, surplus)
+	mustCreateLicensePool(t, tdb, &LicensePool{})
+	returning := &Book{Copies: 2}
+	mustCreateBook(t, tdb, returning)
+	returningLoan := mustCreateLoan(t, tdb, &BookLoan{BookID: returning.ID})
+
+	session, err := svc.StartSession(ctx, 0, "annual count")
+	if err != nil {
+		t.Fatalf("StartSession: %v", err)
+	}
+	codes := []string{lent.ISBN, lent.ISBN, lost.ISBN, surplus.ISBN, surplus.ISBN, returning.ISBN, "978-1-23456-789-7"}
+	if n, err := svc.RecordScans(ctx, session.ID, codes); err != nil || n != len(codes) {
+		t.Fatalf("RecordScans = %d, %v", n, err)
+	}
+	// The loan is returned after its shelf was counted, so the returned
+	// copy is expected but was not scanned.
+	if err := tdb.Model(returningLoan).Updates(map[string]interface{}{"returned": true, "returned_at": time.Now()}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := svc.Report(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+	if report.Expected != 7 || report.Scanned != 7 || report.Missing != 2 || report.Recount != 1 || report.Extra != 1 || report.Unknown != 1 || len(report.Items) != 4 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	want := []StocktakeItem{
+		{Status: StocktakeExtra, BookID: surplus.ID, Code: surplus.ISBN, Title: surplus.Title, Expected: 1, Scanned: 2},
+		{Status: StocktakeMissing, BookID: lost.ID, Code: lost.ISBN, Title: lost.Title, Expected: 2, Scanned: 1},
+		{Status: StocktakeMissing, BookID: returning.ID, Code: returning.ISBN, Title: returning.Title, Expected: 2, Scanned: 1, Recount: true},
+		{Status: StocktakeUnknown, Code: "9781234567897", Scanned: 1},
+	}
+	for i := range want {
+		if i < len(report.Items) && report.Items[i] != want[i] {
+			t.Errorf("item %d = %+v, want %+v", i, report.Items[i], want[i])
+		}
+	}
+
+	closed, err := svc.Close(ctx, session.ID, true)
+	if err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if closed.Status != StocktakeClosed || !closed.MarkedLost || closed.Missing != 2 || closed.Recount != 1 {
+		t.Errorf("unexpected closing report %+v", closed)
+	}
+	var book Book
+	tdb.First(&book, lost.ID)
+	if book.Copies != 1 || book.Available != 1 {
+		t.Errorf("expected one copy written off, got %d copies, %d available", book.Copies, book.Available)
+	}
+	if tdb.First(&book, returning.ID); book.Copies != 2 {
+		t.Errorf("a book to recount must not be written off, got %d copies", book.Copies)
+	}
+	var audit AuditLog
+	if err := tdb.Where("action = ? AND model_id = ?", auditActionMarkLost, lost.ID).First(&audit).Error; err != nil || !strings.Contains(audit.Details, "1 copies lost") {
+		t.Errorf("expected an audit entry for the lost copy, got %+v, %v", audit, err)
+	}
+	var events int64
+	tdb.Model(&OutboxEvent{}).Where("type = ? AND aggregate_id = ?", EventBookCopiesChanged, lost.ID).Count(&events)
+	if events != 1 {
+		t.Errorf("expected a %s event, got %d", EventBookCopiesChanged, events)
+	}
+
+	if _, err := svc.RecordScans(ctx, session.ID, []string{lost.ISBN}); !errors.Is(err, ErrStocktakeClosed) {
+		t.Errorf("expected ErrStocktakeClosed when scanning into a closed session, got %v", err)
+	}
+	if _, err := svc.Close(ctx, session.ID, true); !errors.Is(err, ErrStocktakeClosed) {
+		t.Errorf("expected ErrStocktakeClosed when closing twice, got %v", err)
+	}
+	frozen, err := svc.Report(ctx, session.ID)
+	if err != nil || frozen.Missing != 2 || !frozen.MarkedLost || len(frozen.Items) != 4 {
+		t.Errorf("expected the report made at closing, got %+v, %v", frozen, err)
+	}
+
+	// A branch counts only its own stock; books stocked at another branch
+	// are misplaced.
+	branches := &BranchService{db: db}
+	here, there := mustCreateBranch(t, tdb, "here"), mustCreateBranch(t, tdb, "there")
+	if err := branches.SetInventory(ctx, here.ID, lent.ID, 1); err != nil {
+		t.Fatalf("SetInventory: %v", err)
+	}
+	if err := branches.SetInventory(ctx, there.ID, surplus.ID, 1); err != nil {
+		t.Fatalf("SetInventory: %v", err)
+	}
+	if _, err := svc.StartSession(ctx, 999999, ""); !errors.Is(err, ErrInvalidStocktake) {
+		t.Errorf("expected ErrInvalidStocktake for an unknown branch, got %v", err)
+	}
+	branchSession, err := svc.StartSession(ctx, here.ID, "")
+	if err != nil {
+		t.Fatalf("StartSession: %v", err)
+	}
+	if _, err := svc.RecordScans(ctx, branchSession.ID, []string{lent.ISBN, surplus.ISBN}); err != nil {
+		t.Fatalf("RecordScans: %v", err)
+	}
+	report, err = svc.Report(ctx, branchSession.ID)
+	if err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+	if report.Expected != 1 || report.Missing != 0 || report.Misplaced != 1 || len(report.Items) != 1 || report.Items[0].BookID != surplus.ID {
+		t.Errorf("unexpected branch report %+v", report)
+	}
+
+	if _, err := svc.StartSession(context.Background(), 0, ""); !errors.Is(err, ErrUnauthenticated) {
+		t.Errorf("expected ErrUnauthenticated without a principal, got %v", err)
+	}
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"text/tabwriter"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// defaultTenantID is the tenant used when the context names none, so a
+// single-library deployment works without configuring tenants.
+const defaultTenantID uint = 0
+
+// tenantHeader carries the tenant slug on HTTP requests.
+const tenantHeader = "X-Tenant"
+
+// tenantSlugPattern is the form of tenant slugs, which travel in headers.
+var tenantSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,49}$`)
+
+// ErrInvalidTenant is returned for tenants that cannot be created as given.
+var ErrInvalidTenant = errors.New("invalid tenant")
+
+// Tenant is an independent library hosted in this deployment.
+type Tenant struct {
+	ID        uint   `gorm:"primaryKey"`
+	Slug      string `gorm:"uniqueIndex;not null;size:50"`
+	Name      string `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+// withTenant returns a copy of ctx scoped to the given tenant.
+func withTenant(ctx context.Context, tenantID uint) context.Context {
+	return context.WithValue(ctx, tenantKey, tenantID)
+}
+
+// tenantFromContext returns the tenant ctx is scoped to, or defaultTenantID.
+func tenantFromContext(ctx context.Context) uint {
+	if ctx == nil {
+		return defaultTenantID
+	}
+	if id, ok := ctx.Value(tenantKey).(uint); ok {
+		return id
+	}
+	return defaultTenantID
+}
+
+// withAllTenants returns a copy of ctx whose queries are not scoped to a
+// tenant. It is meant for deployment-wide maintenance such as metrics and
+// must never be derived from a request context.
+func withAllTenants(ctx context.Context) context.Context {
+	return context.WithValue(ctx, allTenantsKey, true)
+}
+
+// forEachTenant calls fn once for the default tenant and once for every
+// registered tenant, with ctx scoped accordingly. Errors are collected so
+// one failing tenant does not stop the others.
+func forEachTenant(ctx context.Context, db *gorm.DB, fn func(ctx context.Context) error) error {
+	var ids []uint
+	if err := db.WithContext(ctx).Model(&Tenant{}).Order("id").Pluck("id", &ids).Error; err != nil {
+		return fmt.Errorf("failed to list tenants: %w", err)
+	}
+	var errs []error
+	for _, id := range append([]uint{defaultTenantID}, ids...) {
+		if err := fn(withTenant(ctx, id)); err != nil {
+			errs = append(errs, fmt.Errorf("tenant %d: %w", id, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// tenantField returns the TenantID field of the statement's model and the
+// tenant to scope it to. ok is false for models without a TenantID and for
+// statements running under withAllTenants.
+func tenantField(db *gorm.DB) (field *schema.Field, tenantID uint, ok bool) {
+	if db.Statement.Schema == nil {
+		return nil, 0, false
+	}
+	field = db.Statement.Schema.LookUpField("TenantID")
+	if field == nil {
+		return nil, 0, false
+	}
+	ctx := db.Statement.Context
+	if all, _ := ctx.Value(allTenantsKey).(bool); all {
+		return nil, 0, false
+	}
+	return field, tenantFromContext(ctx), true
+}
+
+// scopeToTenant restricts queries, updates and deletes of tenant-aware
+// models to the tenant of the statement context.
+func scopeToTenant(db *gorm.DB) {
+	field, tenantID, ok := tenantField(db)
+	if !ok {
+		return
+	}
+	db.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: db.Statement.Table, Name: field.DBName}, Value: tenantID},
+	}})
+}
+
+// stampTenant sets TenantID on records being created to the tenant of the
+// statement context, overriding any value set by the caller.
+func stampTenant(db *gorm.DB) {
+	field, tenantID, ok := tenantField(db)
+	if !ok {
+		return
+	}
+	ctx := db.Statement.Context
+	rv := db.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if err := field.Set(ctx, reflect.Indirect(rv.Index(i)), tenantID); err != nil {
+				_ = db.AddError(err)
+				return
+			}
+		}
+	case reflect.Struct:
+		if err := field.Set(ctx, rv, tenantID); err != nil {
+			_ = db.AddError(err)
+		}
+	}
+}
+
+// ErrUnknownReference is returned when a client refers by ID to a row that
+// does not exist in its tenant.
+var ErrUnknownReference = errors.New("unknown reference")
+
+// checkTenantRefs checks that every nonzero ID names a row of model in the
+// tenant of db's statement context. The tenant scope only filters the
+// table a statement is on, so IDs a client links a new row to (a book's
+// publisher or authors, a loan's member) must be checked before saving.
+func checkTenantRefs(db *gorm.DB, model interface{}, name string, ids ...uint) error {
+	var want []uint
+	for _, id := range ids {
+		if id != 0 {
+			want = append(want, id)
+		}
+	}
+	if len(want) == 0 {
+		return nil
+	}
+	var found []uint
+	if err := db.Model(model).Where("id IN ?", want).Pluck("id", &found).Error; err != nil {
+		return fmt.Errorf("error finding %s: %w", name, err)
+	}
+	exists := make(map[uint]bool, len(found))
+	for _, id := range found {
+		exists[id] = true
+	}
+	for _, id := range want {
+		if !exists[id] {
+			return fmt.Errorf("%w: %s %d", ErrUnknownReference, name, id)
+		}
+	}
+	return nil
+}
+
+// registerTenantScope installs the callbacks that keep every statement on
+// a model with a TenantID field within one tenant. Raw and Exec statements
+// bypass the callbacks, and joined tables are not filtered either, so such
+// SQL names the tenant itself: recommendation signals filter on tenant_id
+// explicitly, and reconciliation compares the tenant_id of each side.
+func registerTenantScope(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").Register("tenant:stamp", stampTenant); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").Register("tenant:scope", scopeToTenant); err != nil {
+		return err
+	}
+	if err := cb.Row().Before("gorm:row").Register("tenant:scope", scopeToTenant); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("tenant:scope", scopeToTenant); err != nil {
+		return err
+	}
+	return cb.Delete().Before("gorm:delete").Register("tenant:scope", scopeToTenant)
+}
+
+// TenantService manages the libraries hosted in the deployment.
+type TenantService struct {
+	db           *gorm.DB
+	queryTimeout time.Duration
+}
+
+// CreateTenant registers a new library. Only administrators of the default
+// tenant, who operate the deployment, may create tenants.
+func (s *TenantService) CreateTenant(ctx context.Context, slug, name string) (*Tenant, error) {
+	if _, err := authorize(ctx, PermManageUsers); err != nil {
+		return nil, err
+	}
+	if tenantFromContext(ctx) != defaultTenantID {
+		return nil, fmt.Errorf("%w: tenants are managed from the default tenant", ErrForbidden)
+	}
+	if !tenantSlugPattern.MatchString(slug) || name == "" {
+		return nil, fmt.Errorf("%w: a slug of lowercase letters, digits and hyphens and a name are required", ErrInvalidTenant)
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	tenant := &Tenant{Slug: slug, Name: name}
+	if err := s.db.WithContext(ctx).Create(tenant).Error; err != nil {
+		return nil, fmt.Errorf("failed to create tenant: %w", err)
+	}
+	return tenant, nil
+}
+
+// ResolveSlug returns the ID of the tenant with the given slug.
+func (s *TenantService) ResolveSlug(ctx context.Context, slug string) (uint, error) {
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var tenant Tenant
+	if err := s.db.WithContext(ctx).Where("slug = ?", slug).First(&tenant).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, fmt.Errorf("tenant not found")
+		}
+		return 0, fmt.Errorf("error finding tenant: %w", err)
+	}
+	return tenant.ID, nil
+}
+
+// middleware scopes the request to the tenant named by the X-Tenant header.
+// Requests without the header use the default tenant.
+func (s *TenantService) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		slug := r.Header.Get(tenantHeader)
+		if slug == "" {
+			next.ServeHTTP(w, r.WithContext(withTenant(r.Context(), defaultTenantID)))
+			return
+		}
+		id, err := s.ResolveSlug(r.Context(), slug)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(withTenant(r.Context(), id)))
+	})
+}
+
+// runTenantCommand implements "tenant create" and "tenant list". A tenant
+// starts without users, so create can add its first administrator with
+// -admin, whose password is read from TENANT_ADMIN_PASSWORD.
+func runTenantCommand(ctx context.Context, db *gorm.DB, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing tenant subcommand\n%s", commandUsage)
+	}
+	ctx = withPrincipal(ctx, systemPrincipal)
+	switch args[0] {
+	case "create":
+		fs := flag.NewFlagSet("tenant create", flag.ContinueOnError)
+		fs.SetOutput(out)
+		admin := fs.String("admin", "", "username of the tenant's first administrator")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 2 {
+			return fmt.Errorf("usage: tenant create [-admin <username>] <slug> <name>")
+		}
+		password := os.Getenv("TENANT_ADMIN_PASSWORD")
+		if *admin != "" && len(password) < minPasswordLength {
+			return fmt.Errorf("TENANT_ADMIN_PASSWORD must be set to at least %d characters with -admin", minPasswordLength)
+		}
+		tenant, err := (&TenantService{db: db}).CreateTenant(ctx, fs.Arg(0), fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "created tenant %s (%d)\n", tenant.Slug, tenant.ID)
+		if *admin != "" {
+			if _, err := (&AuthService{db: db}).CreateUser(withTenant(ctx, tenant.ID), *admin, "", password, RoleAdmin); err != nil {
+				return err
+			}
+			fmt.Fprintf(out, "created admin %s\n", *admin)
+		}
+		return nil
+	case "list":
+		var tenants []Tenant
+		if err := db.WithContext(ctx).Order("slug").Find(&tenants).Error; err != nil {
+			return fmt.Errorf("failed to list tenants: %w", err)
+		}
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		defer tw.Flush()
+		fmt.Fprintln(tw, "ID\tSLUG\tNAME\tCREATED")
+		for _, t := range tenants {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", t.ID, t.Slug, t.Name, t.CreatedAt.Format(time.RFC3339))
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown tenant subcommand %q\n%s", args[0], commandUsage)
+	}
+}
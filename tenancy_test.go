@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mustCreateTenant creates a tenant with a unique slug derived from prefix.
+func mustCreateTenant(t *testing.T, svc *TenantService, prefix string) *Tenant {
+	t.Helper()
+	slug := fmt.Sprintf("%s-%d", prefix, time.Now().UnixNano())
+	tenant, err := svc.CreateTenant(withPrincipal(context.Background(), systemPrincipal), slug, prefix)
+	if err != nil {
+		t.Fatalf("failed to create tenant: %v", err)
+	}
+	return tenant
+}
+
+// TestTenantScope_IsolatesBooks tests that the same ISBN can live in two tenants without either seeing the other's copy.
+func TestTenantScope_IsolatesBooks(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenants := &TenantService{db: db}
+	books := &BookService{db: db}
+
+	a := mustCreateTenant(t, tenants, "alpha")
+	b := mustCreateTenant(t, tenants, "beta")
+	ctxA := withTenant(asRole(RoleLibrarian), a.ID)
+	ctxB := withTenant(asRole(RoleLibrarian), b.ID)
+
+	pubA := Publisher{Name: "Alpha Press"}
+	if err := db.WithContext(ctxA).Create(&pubA).Error; err != nil {
+		t.Fatalf("create publisher: %v", err)
+	}
+	if pubA.TenantID != a.ID {
+		t.Fatalf("publisher not stamped with tenant, got %d want %d", pubA.TenantID, a.ID)
+	}
+
+	if err := books.AddBook(ctxA, &Book{ISBN: "9782020202020", Title: "Alpha Copy", PublisherID: pubA.ID, Copies: 1}); err != nil {
+		t.Fatalf("AddBook in tenant A: %v", err)
+	}
+	pubB := Publisher{Name: "Beta Press"}
+	if err := db.WithContext(ctxB).Create(&pubB).Error; err != nil {
+		t.Fatalf("create publisher: %v", err)
+	}
+	if err := books.AddBook(ctxB, &Book{ISBN: "9782020202020", Title: "Beta Copy", PublisherID: pubB.ID, Copies: 2}); err != nil {
+		t.Fatalf("same ISBN in tenant B should be allowed: %v", err)
+	}
+
+	got, err := books.FindBook(ctxA, "9782020202020")
+	if err != nil || got.Title != "Alpha Copy" {
+		t.Fatalf("FindBook in tenant A: got %+v, %v", got, err)
+	}
+	if err := books.RemoveBook(ctxB, "9782020202020"); err != nil {
+		t.Fatalf("RemoveBook in tenant B: %v", err)
+	}
+	if _, err := books.FindBook(ctxB, "9782020202020"); err == nil {
+		t.Errorf("book should be gone from tenant B")
+	}
+	if _, err := books.FindBook(ctxA, "9782020202020"); err != nil {
+		t.Errorf("removing in tenant B must not affect tenant A: %v", err)
+	}
+	if _, err := books.FindBook(asRole(RoleGuest), "9782020202020"); err == nil {
+		t.Errorf("default tenant must not see tenant A's book")
+	}
+}
+
+// TestAuthorize_RejectsForeignTenantUser tests that a stored user cannot act in another tenant.
+func TestAuthorize_RejectsForeignTenantUser(t *testing.T) {
+	user := &User{ID: 7, TenantID: 1, Role: RoleLibrarian}
+
+	if _, err := authorize(withTenant(withPrincipal(context.Background(), user), 1), PermManageCatalog); err != nil {
+		t.Errorf("user should be authorized in its own tenant: %v", err)
+	}
+	if _, err := authorize(withTenant(withPrincipal(context.Background(), user), 2), PermManageCatalog); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected ErrForbidden in another tenant, got %v", err)
+	}
+}
+
+// TestTenantMiddleware tests resolution of the X-Tenant header.
+func TestTenantMiddleware(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenants := &TenantService{db: db}
+	tenant := mustCreateTenant(t, tenants, "gamma")
+
+	var seen uint
+	handler := tenants.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = tenantFromContext(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(tenantHeader, tenant.Slug)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || seen != tenant.ID {
+		t.Errorf("expected tenant %d, got code=%d tenant=%d", tenant.ID, rec.Code, seen)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(tenantHeader, "does-not-exist")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown tenant, got %d", rec.Code)
+	}
+}
+
+// TestTenantScope_ForeignReferences tests that books and loans cannot be
+// linked to rows of another tenant by ID.
+func TestTenantScope_ForeignReferences(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenants := &TenantService{db: db}
+	a := mustCreateTenant(t, tenants, "refs-a")
+	b := mustCreateTenant(t, tenants, "refs-b")
+	ctxA := withTenant(asRole(RoleLibrarian), a.ID)
+	dbA, dbB := db.WithContext(ctxA), db.WithContext(withTenant(context.Background(), b.ID))
+	books := &BookService{db: db}
+	loans := &LoanService{db: db}
+
+	foreignPublisher := mustCreatePublisher(t, dbB, &Publisher{})
+	foreignAuthor := mustCreateAuthor(t, dbB, &Author{})
+	foreignCategory := mustCreateCategory(t, dbB, &Category{})
+	author := mustCreateAuthor(t, dbA, &Author{})
+	for name, book := range map[string]*Book{
+		"publisher": {PublisherID: foreignPublisher.ID},
+		"author":    {Authors: []Author{*author, *foreignAuthor}},
+		"category":  {Categories: []Category{*foreignCategory}},
+	} {
+		book.ISBN, book.Title = testISBN(), "Borrowed Links"
+		if err := books.AddBook(ctxA, book); !errors.Is(err, ErrUnknownReference) {
+			t.Errorf("%s of another tenant: expected ErrUnknownReference, got %v", name, err)
+		}
+	}
+	book := &Book{ISBN: testISBN(), Title: "Own Links", Copies: 1, Authors: []Author{*author}}
+	if err := books.AddBook(ctxA, book); err != nil {
+		t.Fatalf("AddBook with own author: %v", err)
+	}
+
+	foreignMember := mustCreateMember(t, dbB)
+	if _, err := loans.CheckoutBook(ctxA, foreignMember.ID, book.ID, time.Now().Add(24*time.Hour)); !errors.Is(err, ErrUnknownReference) {
+		t.Errorf("member of another tenant: expected ErrUnknownReference, got %v", err)
+	}
+	if _, err := loans.CheckoutBook(ctxA, mustCreateMember(t, dbA).ID, book.ID, time.Now().Add(24*time.Hour)); err != nil {
+		t.Errorf("CheckoutBook with own member: %v", err)
+	}
+}
+
+// TestRunTenantCommand_Usage tests the argument checks made before
+// touching the database.
+func TestRunTenantCommand_Usage(t *testing.T) {
+	t.Setenv("TENANT_ADMIN_PASSWORD", "")
+	for _, args := range [][]string{
+		{"tenant"},
+		{"tenant", "rename"},
+		{"tenant", "create", "only-slug"},
+		{"tenant", "create", "-admin", "root", "branch-library", "Branch Library"},
+	} {
+		if err := runCommand(context.Background(), nil, nil, args, io.Discard); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+	if _, err := (&TenantService{}).CreateTenant(withPrincipal(context.Background(), systemPrincipal), "Not A Slug", "x"); !errors.Is(err, ErrInvalidTenant) {
+		t.Errorf("expected ErrInvalidTenant, got %v", err)
+	}
+}
+
+// TestRunTenantCommand tests provisioning a tenant with its first admin
+// from the command line and reaching it through X-Tenant.
+func TestRunTenantCommand(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	t.Setenv("TENANT_ADMIN_PASSWORD", "tenant admin password")
+	slug := fmt.Sprintf("cli-%d", time.Now().UnixNano())
+
+	var out strings.Builder
+	if err := runCommand(context.Background(), db, nil, []string{"tenant", "create", "-admin", "head", slug, "Central Library"}, &out); err != nil {
+		t.Fatalf("tenant create: %v", err)
+	}
+	if !strings.Contains(out.String(), "created admin head") {
+		t.Errorf("unexpected output %q", out.String())
+	}
+	out.Reset()
+	if err := runCommand(context.Background(), db, nil, []string{"tenant", "list"}, &out); err != nil || !strings.Contains(out.String(), slug) {
+		t.Errorf("tenant list = %q, %v", out.String(), err)
+	}
+	if err := runCommand(context.Background(), db, nil, []string{"tenant", "create", slug, "Again"}, io.Discard); err == nil {
+		t.Errorf("expected a duplicate slug to fail")
+	}
+
+	tenants := &TenantService{db: db}
+	auth := &AuthService{db: db}
+	handler := tenants.middleware(auth.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := (&AuthService{db: db}).CreateUser(r.Context(), "clerk", "", "clerk password", RoleLibrarian); err != nil {
+			writeError(w, err)
+		}
+	})))
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set(tenantHeader, slug)
+	req.SetBasicAuth("head", "tenant admin password")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected the tenant admin to create users in its tenant, got %d %s", rec.Code, rec.Body)
+	}
+}
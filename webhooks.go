@@ -0,0 +1,585 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Webhook delivery defaults.
+const (
+	defaultWebhookMaxAttempts = 8
+	webhookBatchSize          = 50
+	webhookBaseBackoff        = 30 * time.Second
+	webhookMaxBackoff         = 6 * time.Hour
+	webhookTimeout            = 10 * time.Second
+	// webhookLease is how long claimed deliveries are hidden from other
+	// workers; it covers a full batch of requests that all time out.
+	webhookLease = webhookBatchSize * webhookTimeout
+)
+
+// ErrWebhookNotFound is returned for unknown subscriptions and deliveries.
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// ErrInvalidWebhook is returned for subscriptions with a malformed URL or
+// an unknown event type.
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+// errWebhookAddress is returned when a webhook URL resolves to an address
+// that is not on the public internet.
+var errWebhookAddress = errors.New("webhook address is not public")
+
+// webhookEventTypes are the domain events partners can subscribe to.
+var webhookEventTypes = []string{EventBookAdded, EventBookCopiesChanged, EventLoanCreated, EventLoanReturned, EventLoanOverdue}
+
+// WebhookSubscription asks for the events of the given types to be posted
+// to URL. Every request is signed with Secret, which is only returned when
+// the subscription is created.
+type WebhookSubscription struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	TenantID   uint      `gorm:"index;not null;default:0" json:"-"`
+	URL        string    `gorm:"size:500;not null" json:"url"`
+	EventTypes []string  `gorm:"serializer:json;type:jsonb;not null" json:"event_types"`
+	Secret     string    `gorm:"size:100;not null" json:"secret,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// WebhookStatus is the state of a webhook delivery.
+type WebhookStatus string
+
+// Webhook delivery states. A delivery is retried while pending and moved
+// to the dead-letter list once it has failed too often.
+const (
+	WebhookPending   WebhookStatus = "pending"
+	WebhookDelivered WebhookStatus = "delivered"
+	WebhookDead      WebhookStatus = "dead"
+)
+
+// WebhookDelivery is one event to be posted to one subscription. Body is
+// the exact JSON that is signed and sent, so replays are byte-identical.
+type WebhookDelivery struct {
+	ID             uint            `gorm:"primaryKey" json:"id"`
+	TenantID       uint            `gorm:"index;not null;default:0" json:"-"`
+	SubscriptionID uint            `gorm:"uniqueIndex:idx_webhook_delivery_once;not null" json:"subscription_id"`
+	EventID        uint            `gorm:"uniqueIndex:idx_webhook_delivery_once;not null" json:"event_id"`
+	EventType      string          `gorm:"size:50;not null" json:"event_type"`
+	Body           json.RawMessage `gorm:"type:jsonb;not null" json:"body"`
+	Status         WebhookStatus   `gorm:"type:varchar(20);not null;default:'pending';index" json:"status"`
+	Attempts       int             `gorm:"not null;default:0" json:"attempts"`
+	NextAttemptAt  time.Time       `gorm:"index:idx_webhook_due,where:status = 'pending'" json:"next_attempt_at"`
+	ResponseStatus int             `json:"response_status,omitempty"`
+	LastError      string          `gorm:"size:500" json:"last_error,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty"`
+}
+
+// signWebhook returns the X-Webhook-Signature value for body sent at the
+// given Unix time: the hex HMAC-SHA256 of "<timestamp>.<body>" keyed with
+// the subscription secret. Including the timestamp lets receivers reject
+// replayed requests.
+func signWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookBackoff returns the delay before retrying a delivery that has
+// failed attempts times: 30 seconds, doubling up to webhookMaxBackoff.
+func webhookBackoff(attempts int) time.Duration {
+	if attempts > 20 {
+		return webhookMaxBackoff
+	}
+	return min(webhookBaseBackoff<<max(attempts-1, 0), webhookMaxBackoff)
+}
+
+// nonPublicPrefixes are the address ranges besides loopback, link-local,
+// multicast and private ones (see publicAddr) that webhooks must not reach:
+// "this network", carrier-grade NAT, IETF protocol assignments, benchmarking
+// and reserved.
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// publicAddr reports whether ip is a unicast address on the public
+// internet, so that partners cannot point webhooks at the database, cloud
+// metadata services or other hosts inside the deployment.
+func publicAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsGlobalUnicast() || ip.IsPrivate() {
+		return false
+	}
+	for _, p := range nonPublicPrefixes {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// newWebhookClient returns the HTTP client webhooks are sent with. Its
+// dialer refuses non-public addresses after name resolution, so host names
+// that resolve, or later re-resolve, to internal addresses are blocked too.
+// It ignores proxy settings and does not follow redirects.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip, err := netip.ParseAddr(host)
+			if err != nil || !publicAddr(ip) {
+				return fmt.Errorf("%w: %s", errWebhookAddress, host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// WebhookService manages the webhook subscriptions of a tenant and
+// delivers their events.
+type WebhookService struct {
+	db           *gorm.DB
+	queryTimeout time.Duration
+	client       *http.Client
+	maxAttempts  int
+	// allowPrivateHosts lets tests subscribe servers on the loopback
+	// interface.
+	allowPrivateHosts bool
+}
+
+// newWebhookService returns a WebhookService with the default client and
+// retry limit.
+func newWebhookService(db *gorm.DB, queryTimeout time.Duration) *WebhookService {
+	return &WebhookService{
+		db:           db,
+		queryTimeout: queryTimeout,
+		client:       newWebhookClient(),
+		maxAttempts:  defaultWebhookMaxAttempts,
+	}
+}
+
+// CreateSubscription subscribes rawURL to the given event types. The URL
+// must be https and must not name a local or private host; host names are
+// checked again on every delivery. A random secret is generated when
+// secret is empty. Requires PermManageWebhooks.
+func (s *WebhookService) CreateSubscription(ctx context.Context, rawURL string, eventTypes []string, secret string) (_ *WebhookSubscription, err error) {
+	defer observeOperation("create_webhook", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageWebhooks); err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" || u.Hostname() == "" {
+		return nil, fmt.Errorf("%w: URL must be an absolute https URL", ErrInvalidWebhook)
+	}
+	if !s.allowPrivateHosts {
+		host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+		if ip, err := netip.ParseAddr(host); (err == nil && !publicAddr(ip)) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+			return nil, fmt.Errorf("%w: URL must not point to a local or private host", ErrInvalidWebhook)
+		}
+	}
+	if len(eventTypes) == 0 {
+		return nil, fmt.Errorf("%w: no event types", ErrInvalidWebhook)
+	}
+	for _, t := range eventTypes {
+		if !slices.Contains(webhookEventTypes, t) {
+			return nil, fmt.Errorf("%w: unknown event type %q", ErrInvalidWebhook, t)
+		}
+	}
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, fmt.Errorf("failed to generate secret: %w", err)
+		}
+		secret = hex.EncodeToString(b)
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	sub := &WebhookSubscription{URL: rawURL, EventTypes: eventTypes, Secret: secret}
+	if err := s.db.WithContext(ctx).Create(sub).Error; err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %w", err)
+	}
+	return sub, nil
+}
+
+// ListSubscriptions returns the tenant's subscriptions without their
+// secrets. Requires PermManageWebhooks.
+func (s *WebhookService) ListSubscriptions(ctx context.Context) (_ []WebhookSubscription, err error) {
+	defer observeOperation("list_webhooks", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageWebhooks); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	var subs []WebhookSubscription
+	if err := s.db.WithContext(ctx).Order("id").Find(&subs).Error; err != nil {
+		return nil, fmt.Errorf("failed to list webhooks: %w", err)
+	}
+	for i := range subs {
+		subs[i].Secret = ""
+	}
+	return subs, nil
+}
+
+// DeleteSubscription removes a subscription and its deliveries.
+// Requires PermManageWebhooks.
+func (s *WebhookService) DeleteSubscription(ctx context.Context, id uint) (err error) {
+	defer observeOperation("delete_webhook", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageWebhooks); err != nil {
+		return err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		res := tx.Delete(&WebhookSubscription{}, id)
+		if res.Error != nil {
+			return fmt.Errorf("failed to delete webhook: %w", res.Error)
+		}
+		if res.RowsAffected == 0 {
+			return ErrWebhookNotFound
+		}
+		if err := tx.Where("subscription_id = ?", id).Delete(&WebhookDelivery{}).Error; err != nil {
+			return fmt.Errorf("failed to delete webhook deliveries: %w", err)
+		}
+		return nil
+	})
+}
+
+// ListDeadLetters returns up to limit deliveries that were given up on,
+// most recent first. Requires PermManageWebhooks.
+func (s *WebhookService) ListDeadLetters(ctx context.Context, limit int) (_ []WebhookDelivery, err error) {
+	defer observeOperation("list_webhook_dead_letters", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageWebhooks); err != nil {
+		return nil, err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+	if limit <= 0 {
+		limit = 100
+	}
+
+	var deliveries []WebhookDelivery
+	if err := s.db.WithContext(ctx).Where("status = ?", WebhookDead).Order("id DESC").Limit(limit).Find(&deliveries).Error; err != nil {
+		return nil, fmt.Errorf("failed to list dead letters: %w", err)
+	}
+	return deliveries, nil
+}
+
+// ReplayDelivery queues a dead or delivered delivery to be sent again with
+// a fresh retry budget. Requires PermManageWebhooks.
+func (s *WebhookService) ReplayDelivery(ctx context.Context, id uint) (err error) {
+	defer observeOperation("replay_webhook", time.Now(), &err)
+	if _, err := authorize(ctx, PermManageWebhooks); err != nil {
+		return err
+	}
+	ctx, cancel := withQueryTimeout(ctx, s.queryTimeout)
+	defer cancel()
+
+	res := s.db.WithContext(ctx).Model(&WebhookDelivery{}).
+		Where("id = ? AND status <> ?", id, WebhookPending).
+		Updates(map[string]interface{}{"status": WebhookPending, "attempts": 0, "next_attempt_at": time.Now(), "last_error": ""})
+	if res.Error != nil {
+		return fmt.Errorf("failed to replay webhook: %w", res.Error)
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("%w: no finished delivery %d", ErrWebhookNotFound, id)
+	}
+	return nil
+}
+
+// DeliverDue posts the pending deliveries of every tenant that are due at
+// now and returns the number it handled. The batch is claimed and leased in
+// a short transaction, so no row lock is held while partners are called.
+// Failed deliveries are retried with exponential backoff and marked dead
+// after maxAttempts attempts; deliveries whose subscription is gone are
+// marked dead straight away.
+func (s *WebhookService) DeliverDue(ctx context.Context, now time.Time) (int, error) {
+	ctx = withAllTenants(ctx)
+	deliveries, err := s.claimDue(ctx, now)
+	if err != nil || len(deliveries) == 0 {
+		return 0, err
+	}
+	subIDs := make([]uint, len(deliveries))
+	for i, d := range deliveries {
+		subIDs[i] = d.SubscriptionID
+	}
+	var subs []WebhookSubscription
+	if err := s.db.WithContext(ctx).Where("id IN ?", subIDs).Find(&subs).Error; err != nil {
+		return 0, fmt.Errorf("failed to load webhooks: %w", err)
+	}
+	byID := make(map[uint]*WebhookSubscription, len(subs))
+	for i := range subs {
+		byID[subs[i].ID] = &subs[i]
+	}
+
+	postCtx, cancel := context.WithTimeout(ctx, webhookLease)
+	defer cancel()
+	for _, d := range deliveries {
+		var updates map[string]interface{}
+		if sub, ok := byID[d.SubscriptionID]; ok {
+			updates = s.attempt(postCtx, sub, d, now)
+		} else {
+			updates = map[string]interface{}{"status": WebhookDead, "last_error": "subscription was deleted"}
+		}
+		if err := s.db.WithContext(ctx).Model(&WebhookDelivery{}).Where("id = ?", d.ID).Updates(updates).Error; err != nil {
+			return 0, fmt.Errorf("failed to update webhook delivery %d: %w", d.ID, err)
+		}
+	}
+	return len(deliveries), nil
+}
+
+// claimDue locks up to a batch of due deliveries, skipping those locked by
+// other workers, and moves their next attempt webhookLease ahead. Should
+// the worker stop before recording the outcome, they are retried once the
+// lease runs out.
+func (s *WebhookService) claimDue(ctx context.Context, now time.Time) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status = ? AND next_attempt_at <= ?", WebhookPending, now).
+			Order("id").Limit(webhookBatchSize).Find(&deliveries).Error; err != nil {
+			return fmt.Errorf("failed to claim webhook deliveries: %w", err)
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+		ids := make([]uint, len(deliveries))
+		for i, d := range deliveries {
+			ids[i] = d.ID
+		}
+		if err := tx.Model(&WebhookDelivery{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(webhookLease)).Error; err != nil {
+			return fmt.Errorf("failed to lease webhook deliveries: %w", err)
+		}
+		return nil
+	})
+	return deliveries, err
+}
+
+// attempt posts d to sub and returns the column updates recording the
+// outcome.
+func (s *WebhookService) attempt(ctx context.Context, sub *WebhookSubscription, d WebhookDelivery, now time.Time) map[string]interface{} {
+	updates := map[string]interface{}{"attempts": d.Attempts + 1}
+	status, err := s.post(ctx, sub, d, now)
+	updates["response_status"] = status
+	if err == nil {
+		updates["status"] = WebhookDelivered
+		updates["delivered_at"] = now
+		updates["last_error"] = ""
+		return updates
+	}
+
+	msg := err.Error()
+	if len(msg) > 500 {
+		msg = msg[:500]
+	}
+	updates["last_error"] = msg
+	if d.Attempts+1 >= s.maxAttempts {
+		updates["status"] = WebhookDead
+		slog.WarnContext(ctx, "webhook delivery moved to dead letters", "delivery_id", d.ID, "url", sub.URL, "error", err)
+	} else {
+		updates["next_attempt_at"] = now.Add(webhookBackoff(d.Attempts + 1))
+	}
+	return updates
+}
+
+// post sends one signed request and returns the response status.
+func (s *WebhookService) post(ctx context.Context, sub *WebhookSubscription, d WebhookDelivery, now time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(d.Body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to build webhook request: %w", err)
+	}
+	ts := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "library-webhooks/1")
+	req.Header.Set("X-Webhook-Event", d.EventType)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatUint(uint64(d.ID), 10))
+	req.Header.Set("X-Webhook-Timestamp", strconv.FormatInt(ts, 10))
+	req.Header.Set("X-Webhook-Signature", signWebhook(sub.Secret, ts, d.Body))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookFanout is the EventSink that turns each domain event into a
+// delivery for every subscription of its tenant that wants it. Events the
+// outbox relays twice produce each delivery only once.
+type webhookFanout struct {
+	db *gorm.DB
+}
+
+// Name implements EventSink.
+func (f *webhookFanout) Name() string { return "webhooks" }
+
+// Publish implements EventSink.
+func (f *webhookFanout) Publish(ctx context.Context, e OutboxEvent) error {
+	ctx = withAllTenants(ctx)
+	var subs []WebhookSubscription
+	if err := f.db.WithContext(ctx).Where("tenant_id = ?", e.TenantID).Find(&subs).Error; err != nil {
+		return fmt.Errorf("failed to load webhooks: %w", err)
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	var deliveries []WebhookDelivery
+	for _, sub := range subs {
+		if slices.Contains(sub.EventTypes, e.Type) {
+			deliveries = append(deliveries, WebhookDelivery{
+				TenantID:       e.TenantID,
+				SubscriptionID: sub.ID,
+				EventID:        e.ID,
+				EventType:      e.Type,
+				Body:           body,
+				Status:         WebhookPending,
+				NextAttemptAt:  time.Now(),
+			})
+		}
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	if err := f.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).Create(&deliveries).Error; err != nil {
+		return fmt.Errorf("failed to queue webhook deliveries: %w", err)
+	}
+	return nil
+}
+
+// registerWebhookJobs registers the job that sends due webhook deliveries.
+func registerWebhookJobs(s *Scheduler, webhooks *WebhookService) error {
+	return s.Register("deliver-webhooks", "* * * * *", func(ctx context.Context) error {
+		for {
+			n, err := webhooks.DeliverDue(ctx, time.Now())
+			if n > 0 {
+				slog.InfoContext(ctx, "webhooks delivered", "count", n)
+			}
+			if err != nil || n < webhookBatchSize {
+				return err
+			}
+		}
+	})
+}
+
+// handleCreateWebhook serves POST /webhooks.
+func (s *apiServer) handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL        string   `json:"url"`
+		EventTypes []string `json:"event_types"`
+		Secret     string   `json:"secret"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid webhook"})
+		return
+	}
+	sub, err := s.webhooks.CreateSubscription(r.Context(), req.URL, req.EventTypes, req.Secret)
+	switch {
+	case errors.Is(err, ErrInvalidWebhook):
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+	case err != nil:
+		writeError(w, err)
+	default:
+		writeJSON(w, http.StatusCreated, sub)
+	}
+}
+
+// handleListWebhooks serves GET /webhooks.
+func (s *apiServer) handleListWebhooks(w http.ResponseWriter, r *http.Request) {
+	subs, err := s.webhooks.ListSubscriptions(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, subs)
+}
+
+// handleDeleteWebhook serves DELETE /webhooks/{id}.
+func (s *apiServer) handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid webhook id"})
+		return
+	}
+	if err := s.webhooks.DeleteSubscription(r.Context(), uint(id)); err != nil {
+		if errors.Is(err, ErrWebhookNotFound) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListWebhookDeadLetters serves GET /webhooks/dead-letters?limit=.
+func (s *apiServer) handleListWebhookDeadLetters(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	deliveries, err := s.webhooks.ListDeadLetters(r.Context(), limit)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// handleReplayWebhookDelivery serves POST /webhooks/deliveries/{id}/replay.
+func (s *apiServer) handleReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid delivery id"})
+		return
+	}
+	if err := s.webhooks.ReplayDelivery(r.Context(), uint(id)); err != nil {
+		if errors.Is(err, ErrWebhookNotFound) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestSignWebhook tests the signature against an independent HMAC.
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"id":1}`)
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte("1700000000." + string(body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := signWebhook("s3cret", 1700000000, body); got != want {
+		t.Errorf("signWebhook = %s, want %s", got, want)
+	}
+	if signWebhook("other", 1700000000, body) == want || signWebhook("s3cret", 1700000001, body) == want {
+		t.Errorf("signature should depend on secret and timestamp")
+	}
+}
+
+// TestWebhookBackoff tests that retry delays double up to the maximum.
+func TestWebhookBackoff(t *testing.T) {
+	for attempts, want := range map[int]time.Duration{1: 30 * time.Second, 3: 2 * time.Minute, 20: webhookMaxBackoff, 50: webhookMaxBackoff} {
+		if got := webhookBackoff(attempts); got != want {
+			t.Errorf("webhookBackoff(%d) = %v, want %v", attempts, got, want)
+		}
+	}
+}
+
+// TestCreateSubscription_Validation tests that invalid subscriptions are
+// rejected before reaching the database.
+func TestCreateSubscription_Validation(t *testing.T) {
+	svc := &WebhookService{}
+	admin := asRole(RoleAdmin)
+	if _, err := svc.CreateSubscription(asRole(RoleLibrarian), "https://example.com", []string{EventLoanOverdue}, ""); !errors.Is(err, ErrForbidden) {
+		t.Errorf("librarian: expected ErrForbidden, got %v", err)
+	}
+	for _, tc := range []struct {
+		url    string
+		events []string
+	}{
+		{"ftp://example.com/hook", []string{EventLoanOverdue}},
+		{"http://example.com/hook", []string{EventLoanOverdue}},
+		{"/relative", []string{EventLoanOverdue}},
+		{"https://127.0.0.1/hook", []string{EventLoanOverdue}},
+		{"https://169.254.169.254/latest/meta-data", []string{EventLoanOverdue}},
+		{"https://10.1.2.3/hook", []string{EventLoanOverdue}},
+		{"https://[::1]:8443/hook", []string{EventLoanOverdue}},
+		{"https://localhost:8443/hook", []string{EventLoanOverdue}},
+		{"https://example.com/hook", nil},
+		{"https://example.com/hook", []string{"hold.ready.soon"}},
+	} {
+		if _, err := svc.CreateSubscription(admin, tc.url, tc.events, ""); !errors.Is(err, ErrInvalidWebhook) {
+			t.Errorf("CreateSubscription(%q, %v): expected ErrInvalidWebhook, got %v", tc.url, tc.events, err)
+		}
+	}
+}
+
+// TestPublicAddr tests which addresses webhooks may be sent to.
+func TestPublicAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":        true,
+		"2606:4700::1111":      true,
+		"127.0.0.1":            false,
+		"10.0.0.5":             false,
+		"172.20.1.1":           false,
+		"192.168.1.1":          false,
+		"169.254.169.254":      false,
+		"100.100.100.200":      false,
+		"0.0.0.0":              false,
+		"224.0.0.1":            false,
+		"::1":                  false,
+		"fd00::1":              false,
+		"fe80::1":              false,
+		"::ffff:127.0.0.1":     false,
+		"::ffff:93.184.216.34": true,
+	} {
+		if got := publicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("publicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+// TestWebhookClient tests that the webhook client refuses to connect to
+// local servers and does not follow redirects.
+func TestWebhookClient(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+	_, err := newWebhookClient().Post(srv.URL, "application/json", strings.NewReader("{}"))
+	if !errors.Is(err, errWebhookAddress) {
+		t.Errorf("expected errWebhookAddress, got %v", err)
+	}
+
+	// The test server's own transport skips the address check.
+	client := newWebhookClient()
+	redirect := httptest.NewTLSServer(http.RedirectHandler("https://169.254.169.254/", http.StatusFound))
+	defer redirect.Close()
+	client.Transport = redirect.Client().Transport
+	resp, err := client.Get(redirect.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Errorf("expected the redirect to be returned, got status %d", resp.StatusCode)
+	}
+}
+
+// TestWebhookService_Delivery tests fan-out from the outbox, signed
+// delivery, retries, dead letters and replay.
+func TestWebhookService_Delivery(t *testing.T) {
+	db, cleanup := newTestDB(t)
+	defer cleanup()
+	tenant := mustCreateTenant(t, &TenantService{db: db}, "webhooks")
+	ctx := withTenant(asRole(RoleAdmin), tenant.ID)
+
+	var failing atomic.Bool
+	var received atomic.Int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		ts, _ := strconv.ParseInt(r.Header.Get("X-Webhook-Timestamp"), 10, 64)
+		if r.Header.Get("X-Webhook-Signature") != signWebhook("partner-secret", ts, body) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received.Add(1)
+	}))
+	defer srv.Close()
+
+	webhooks := newWebhookService(db, 0)
+	webhooks.maxAttempts = 2
+	webhooks.allowPrivateHosts = true
+	webhooks.client = srv.Client()
+	sub, err := webhooks.CreateSubscription(ctx, srv.URL, []string{EventLoanOverdue}, "partner-secret")
+	if err != nil {
+		t.Fatalf("CreateSubscription: %v", err)
+	}
+	if subs, _ := webhooks.ListSubscriptions(ctx); len(subs) != 1 || subs[0].Secret != "" {
+		t.Errorf("expected one subscription without secret, got %+v", subs)
+	}
+
+	book := &Book{ISBN: "9787272727272", Title: "Late", Copies: 2}
+	mustCreateBook(t, db.WithContext(ctx), book)
+	loans := &LoanService{db: db}
+	member := mustCreateMember(t, db.WithContext(ctx))
+	if _, err := loans.CheckoutBook(ctx, member.ID, book.ID, time.Now().Add(-time.Hour)); err != nil {
+		t.Fatalf("CheckoutBook: %v", err)
+	}
+	if n, err := loans.MarkOverdue(ctx, time.Now()); err != nil || n != 1 {
+		t.Fatalf("MarkOverdue = %d, %v", n, err)
+	}
+
+	// Relay the tenant's events twice to the fan-out; only the overdue event
+	// matches and it is queued once.
+	fanout := &webhookFanout{db: db}
+	var events []OutboxEvent
+	db.WithContext(ctx).Order("id").Find(&events)
+	for range 2 {
+		for _, e := range events {
+			if err := fanout.Publish(context.Background(), e); err != nil {
+				t.Fatalf("Publish: %v", err)
+			}
+		}
+	}
+	var deliveries []WebhookDelivery
+	db.WithContext(ctx).Find(&deliveries)
+	if len(deliveries) != 1 || deliveries[0].EventType != EventLoanOverdue || deliveries[0].SubscriptionID != sub.ID {
+		t.Fatalf("expected one overdue delivery, got %+v", deliveries)
+	}
+	id := deliveries[0].ID
+
+	reload := func() WebhookDelivery {
+		var d WebhookDelivery
+		db.WithContext(ctx).First(&d, id)
+		return d
+	}
+	failing.Store(true)
+	now := time.Now()
+	// A claimed delivery is leased: another worker does not see it, and it
+	// is taken up again when the lease runs out.
+	if claimed, err := webhooks.claimDue(withAllTenants(context.Background()), now); err != nil || len(claimed) != 1 {
+		t.Fatalf("claimDue = %d deliveries, %v", len(claimed), err)
+	}
+	if n, err := webhooks.DeliverDue(context.Background(), now); err != nil || n != 0 {
+		t.Errorf("DeliverDue during the lease = %d, %v", n, err)
+	}
+	now = now.Add(webhookLease)
+	webhooks.DeliverDue(context.Background(), now)
+	if d := reload(); d.Status != WebhookPending || d.Attempts != 1 || d.ResponseStatus != http.StatusServiceUnavailable {
+		t.Errorf("expected a scheduled retry, got %+v", d)
+	}
+	webhooks.DeliverDue(context.Background(), now.Add(time.Hour))
+	if d := reload(); d.Status != WebhookDead {
+		t.Errorf("expected a dead letter after max attempts, got %+v", d)
+	}
+	if dead, _ := webhooks.ListDeadLetters(ctx, 0); len(dead) != 1 || dead[0].ID != id {
+		t.Errorf("expected the delivery in the dead letters, got %+v", dead)
+	}
+
+	failing.Store(false)
+	if err := webhooks.ReplayDelivery(ctx, id); err != nil {
+		t.Fatalf("ReplayDelivery: %v", err)
+	}
+	webhooks.DeliverDue(context.Background(), time.Now().Add(time.Second))
+	if d := reload(); d.Status != WebhookDelivered || d.DeliveredAt == nil || received.Load() != 1 {
+		t.Errorf("expected a delivered replay, got %+v (received %d)", d, received.Load())
+	}
+	if err := webhooks.ReplayDelivery(withTenant(asRole(RoleAdmin), defaultTenantID), id); !errors.Is(err, ErrWebhookNotFound) {
+		t.Errorf("other tenants must not replay the delivery, got %v", err)
+	}
+
+	// A delivery whose subscription is gone is dead-lettered rather than
+	// left pending.
+	orphan := WebhookDelivery{TenantID: tenant.ID, SubscriptionID: sub.ID + 1000, EventID: events[0].ID, EventType: EventLoanOverdue, Body: deliveries[0].Body, Status: WebhookPending, NextAttemptAt: time.Now()}
+	if err := db.WithContext(ctx).Create(&orphan).Error; err != nil {
+		t.Fatal(err)
+	}
+	if n, err := webhooks.DeliverDue(context.Background(), time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Errorf("DeliverDue = %d, %v", n, err)
+	}
+	db.WithContext(ctx).First(&orphan, orphan.ID)
+	if orphan.Status != WebhookDead || received.Load() != 1 {
+		t.Errorf("expected a dead orphaned delivery, got %+v (received %d)", orphan, received.Load())
+	}
+}